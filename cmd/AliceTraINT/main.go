@@ -11,6 +11,7 @@ import (
 	"github.com/mytkom/AliceTraINT/internal/db/migrate"
 	"github.com/mytkom/AliceTraINT/internal/db/repository"
 	"github.com/mytkom/AliceTraINT/internal/middleware"
+	"github.com/mytkom/AliceTraINT/internal/service"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -43,6 +44,23 @@ func main() {
 	logMw := middleware.NewLogMw()
 	handler := middleware.Chain(r, recoverMw, logMw)
 
+	// training machines with grid certificates connect over TLS
+	if cfg.MachineAuth.TLSPort != "" {
+		tlsConfig, err := service.NewMachineTLSConfig(cfg.MachineAuth, cfg.JalienCertCADir)
+		if err != nil {
+			log.Fatalf("Failed to configure machine TLS listener: %v", err)
+		}
+		tlsServer := &http.Server{
+			Addr:      fmt.Sprintf(":%s", cfg.MachineAuth.TLSPort),
+			Handler:   handler,
+			TLSConfig: tlsConfig,
+		}
+		go func() {
+			log.Printf("Starting machine TLS server on %s\n", tlsServer.Addr)
+			log.Fatal(tlsServer.ListenAndServeTLS("", ""))
+		}()
+	}
+
 	portString := fmt.Sprintf(":%s", cfg.Port)
 	log.Printf("Starting server on %s\n", portString)
 	log.Fatal(http.ListenAndServe(portString, handler))
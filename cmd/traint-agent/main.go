@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/agent"
+)
+
+type config struct {
+	baseURL   string
+	machineID uint
+	secretKey string
+	certPath  string
+	keyPath   string
+	caDir     string
+
+	workDir           string
+	trainer           string
+	benchmark         string
+	pollWait          time.Duration
+	heartbeatInterval time.Duration
+	logFlushInterval  time.Duration
+	telemetryInterval time.Duration
+	chunkSizeMB       int64
+	once              bool
+	setState          string
+}
+
+func main() {
+	cfg, err := parseFlags()
+	if err != nil {
+		log.Fatalf("invalid arguments: %v", err)
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+}
+
+func parseFlags() (*config, error) {
+	cfg := &config{}
+
+	var machineID uint64
+	if id := os.Getenv("MACHINE_ID"); id != "" {
+		var err error
+		if machineID, err = strconv.ParseUint(id, 10, 32); err != nil {
+			return nil, errors.New("$MACHINE_ID must be a number")
+		}
+	}
+
+	flag.StringVar(&cfg.baseURL, "url", os.Getenv("ALICETRAINT_BASE_URL"), "AliceTraINT base URL (default from $ALICETRAINT_BASE_URL)")
+	flag.UintVar(&cfg.machineID, "machine-id", uint(machineID), "Training machine ID (default from $MACHINE_ID)")
+	flag.StringVar(&cfg.secretKey, "secret", os.Getenv("MACHINE_SECRET_KEY"), "Training machine secret key (default from $MACHINE_SECRET_KEY)")
+	flag.StringVar(&cfg.certPath, "cert", os.Getenv("MACHINE_CERT_PATH"), "Grid certificate used instead of the secret key (default from $MACHINE_CERT_PATH)")
+	flag.StringVar(&cfg.keyPath, "key", os.Getenv("MACHINE_KEY_PATH"), "Key of the grid certificate (default from $MACHINE_KEY_PATH)")
+	flag.StringVar(&cfg.caDir, "ca-dir", os.Getenv("JALIEN_CERT_CA_DIR"), "Directory of grid CA certificates verifying the server (default from $JALIEN_CERT_CA_DIR)")
+	flag.StringVar(&cfg.workDir, "work-dir", "traint-work", "Directory for task configurations, AOD lists, logs and results")
+	flag.StringVar(&cfg.trainer, "trainer", "", "Trainer command run with sh -c in the task directory")
+	flag.StringVar(&cfg.benchmark, "benchmark", "", "Optional benchmark command run after a successful training")
+	flag.DurationVar(&cfg.pollWait, "poll-wait", 60*time.Second, "How long to wait for a task in a single query")
+	flag.DurationVar(&cfg.heartbeatInterval, "heartbeat-interval", 60*time.Second, "Interval of lease renewals, keep it below the lease duration")
+	flag.DurationVar(&cfg.logFlushInterval, "log-flush-interval", 5*time.Second, "Interval of trainer log streaming")
+	flag.DurationVar(&cfg.telemetryInterval, "telemetry-interval", 60*time.Second, "Interval of machine health reports, 0 disables them")
+	flag.Int64Var(&cfg.chunkSizeMB, "chunk-size-mb", 8, "Size of result upload chunks in megabytes")
+	flag.BoolVar(&cfg.once, "once", false, "Exit after the first processed task")
+	flag.StringVar(&cfg.setState, "set-state", "", "Set the machine state to active, draining or disabled and exit")
+
+	flag.Parse()
+
+	if cfg.baseURL == "" {
+		return nil, errors.New("flag --url is required")
+	}
+	if cfg.machineID == 0 {
+		return nil, errors.New("flag --machine-id is required")
+	}
+	if cfg.certPath != "" && cfg.keyPath == "" {
+		return nil, errors.New("flag --key is required with --cert")
+	}
+	if cfg.secretKey == "" && cfg.certPath == "" {
+		return nil, errors.New("flag --secret or --cert is required")
+	}
+	if cfg.setState != "" {
+		return cfg, nil
+	}
+	if cfg.trainer == "" {
+		return nil, errors.New("flag --trainer is required")
+	}
+	if cfg.chunkSizeMB <= 0 {
+		return nil, errors.New("flag --chunk-size-mb must be > 0")
+	}
+
+	return cfg, nil
+}
+
+func run(cfg *config) error {
+	if cfg.setState != "" {
+		return setState(cfg)
+	}
+
+	if err := os.MkdirAll(cfg.workDir, 0o755); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	a := agent.New(client, agent.Config{
+		WorkDir:           cfg.workDir,
+		TrainerCommand:    cfg.trainer,
+		BenchmarkCommand:  cfg.benchmark,
+		PollWait:          cfg.pollWait,
+		HeartbeatInterval: cfg.heartbeatInterval,
+		LogFlushInterval:  cfg.logFlushInterval,
+		TelemetryInterval: cfg.telemetryInterval,
+		UploadChunkSize:   cfg.chunkSizeMB << 20,
+		Once:              cfg.once,
+	})
+
+	log.Printf("training machine %d polling %s", cfg.machineID, cfg.baseURL)
+	return a.Run(ctx)
+}
+
+// setState drains, disables or activates the machine, e.g. before a reboot
+// wait until it reports drained.
+func setState(cfg *config) error {
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	state, err := client.SetState(context.Background(), cfg.setState)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("training machine %d is %s, running tasks: %v, drained: %t", cfg.machineID, state.State, state.RunningTaskIds, state.Drained)
+	return nil
+}
+
+func newClient(cfg *config) (*agent.Client, error) {
+	if cfg.certPath != "" {
+		return agent.NewCertificateClient(cfg.baseURL, cfg.machineID, cfg.certPath, cfg.keyPath, cfg.caDir)
+	}
+	return agent.NewClient(cfg.baseURL, cfg.machineID, cfg.secretKey), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	appconfig "github.com/mytkom/AliceTraINT/internal/config"
+	"github.com/mytkom/AliceTraINT/internal/db/migrate"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/mytkom/AliceTraINT/internal/environment"
+	"github.com/mytkom/AliceTraINT/internal/handler"
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/mytkom/AliceTraINT/internal/simulator"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type config struct {
+	url        string
+	db         string
+	sqlitePath string
+	timeout    time.Duration
+	sim        simulator.Config
+}
+
+func main() {
+	cfg, err := parseFlags()
+	if err != nil {
+		log.Fatalf("invalid arguments: %v", err)
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+}
+
+func parseFlags() (*config, error) {
+	cfg := &config{}
+
+	flag.StringVar(&cfg.url, "url", "", "Base URL of the AliceTraINT instance sharing the database; an in-process instance is started when empty")
+	flag.StringVar(&cfg.db, "db", "sqlite", "Database of the instance: sqlite or postgres (configured by DB_* environment variables)")
+	flag.StringVar(&cfg.sqlitePath, "sqlite-path", "traint-sim.db", "SQLite database file")
+	flag.DurationVar(&cfg.timeout, "timeout", 10*time.Minute, "Maximum duration of the simulation")
+	flag.IntVar(&cfg.sim.Machines, "machines", 100, "Number of virtual training machines")
+	flag.IntVar(&cfg.sim.Tasks, "tasks", 500, "Number of queued training tasks")
+	flag.DurationVar(&cfg.sim.MinDuration, "min-duration", 100*time.Millisecond, "Minimal simulated training duration")
+	flag.DurationVar(&cfg.sim.MaxDuration, "max-duration", 2*time.Second, "Maximal simulated training duration")
+	flag.Float64Var(&cfg.sim.FailureRate, "failure-rate", 0.1, "Probability of a task attempt failing")
+	flag.Float64Var(&cfg.sim.UploadRate, "upload-rate", 0.2, "Probability of a completed task uploading a result")
+	flag.Int64Var(&cfg.sim.UploadSize, "upload-size", 64<<10, "Size of uploaded results in bytes")
+	flag.DurationVar(&cfg.sim.PollWait, "poll-wait", 5*time.Second, "Long polling wait of task queries, 0 disables long polling")
+	flag.DurationVar(&cfg.sim.HeartbeatInterval, "heartbeat-interval", time.Second, "Interval of lease renewals")
+	flag.Int64Var(&cfg.sim.Seed, "seed", 1, "Seed of the randomised durations, failures and uploads")
+
+	flag.Parse()
+
+	if cfg.db != "sqlite" && cfg.db != "postgres" {
+		return nil, errors.New("flag --db must be sqlite or postgres")
+	}
+	if cfg.sim.Machines <= 0 {
+		return nil, errors.New("flag --machines must be > 0")
+	}
+	if cfg.sim.Tasks < 0 {
+		return nil, errors.New("flag --tasks must be >= 0")
+	}
+	if cfg.sim.FailureRate < 0 || cfg.sim.FailureRate > 1 || cfg.sim.UploadRate < 0 || cfg.sim.UploadRate > 1 {
+		return nil, errors.New("flags --failure-rate and --upload-rate must be between 0 and 1")
+	}
+	if cfg.sim.UploadSize <= 0 {
+		return nil, errors.New("flag --upload-size must be > 0")
+	}
+
+	return cfg, nil
+}
+
+// newQueueRouter serves the queue routes only, the rest of the application
+// needs CERN SSO which is not available to the simulator.
+func newQueueRouter(cfg *appconfig.Config, repoContext *repository.RepositoryContext) *http.ServeMux {
+	mux := http.NewServeMux()
+	env := environment.NewEnv(repoContext, nil, nil, cfg)
+
+	hasher := service.NewArgon2Hasher()
+	nnArch := service.NewNNArchService(cfg.NNArchPath)
+	fileService := service.NewLocalFileService(cfg.DataDirPath)
+	taskNotifier := service.NewTaskNotifier()
+
+	queueService := service.NewQueueService(fileService, repoContext, hasher, cfg.Queue, service.NewMachineSessions(cfg.MachineAuth), nnArch, taskNotifier)
+	go queueService.RunMaintenance(context.Background(), time.Duration(cfg.Queue.ReaperIntervalSeconds)*time.Second)
+
+	handler.InitQueueRoutes(mux, env, queueService)
+	return mux
+}
+
+func run(cfg *config) error {
+	appCfg := appconfig.LoadConfig()
+
+	var dialector gorm.Dialector
+	if cfg.db == "postgres" {
+		dialector = postgres.Open(appCfg.Database.ConnectionString())
+	} else {
+		// WAL and busy timeout let concurrent requests wait for the lock
+		dialector = sqlite.Open(fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=10000", cfg.sqlitePath))
+	}
+	gormDB, err := gorm.Open(dialector, &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Warn),
+		TranslateError: true,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot open database: %w", err)
+	}
+	if cfg.db == "sqlite" && cfg.url == "" {
+		// SQLite has a single writer, one connection serializes the requests
+		// of the in-process instance instead of failing them as locked
+		sqlDB, err := gormDB.DB()
+		if err != nil {
+			return err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+	migrate.MigrateDB(gormDB)
+	repoContext := repository.NewRepositoryContext(gormDB)
+
+	if cfg.url == "" {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		//nolint:errcheck
+		go http.Serve(listener, newQueueRouter(appCfg, repoContext))
+		cfg.url = "http://" + listener.Addr().String()
+		log.Printf("in-process instance listening on %s", cfg.url)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	log.Printf("simulating %d training machines processing %d tasks", cfg.sim.Machines, cfg.sim.Tasks)
+	sim := simulator.New(repoContext, service.NewArgon2Hasher(), cfg.url, cfg.sim)
+	report, err := sim.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	return report.Print(os.Stdout)
+}
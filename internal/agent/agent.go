@@ -0,0 +1,375 @@
+// Package agent implements the reference training machine agent. It leases
+// training tasks from AliceTraINT, runs a trainer command for each of them and
+// reports status, logs and results back to the server.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+const (
+	ConfigFileName   = "config.json"
+	AODListFileName  = "aod_files.txt"
+	TrainerLogName   = "trainer.log"
+	logExcerptSize   = 64 << 10
+	retryQueryDelay  = 5 * time.Second
+	defaultChunkSize = 8 << 20
+)
+
+type Config struct {
+	// WorkDir holds a directory per task with its configuration, AOD list,
+	// trainer log and results
+	WorkDir string
+	// TrainerCommand is run with sh -c in the task directory
+	TrainerCommand string
+	// BenchmarkCommand is optional, it runs after a successful training
+	BenchmarkCommand  string
+	PollWait          time.Duration
+	PollInterval      time.Duration
+	HeartbeatInterval time.Duration
+	LogFlushInterval  time.Duration
+	// TelemetryInterval is the period of health reports, zero disables them
+	TelemetryInterval time.Duration
+	UploadChunkSize   int64
+	// Once stops the agent after the first processed task
+	Once bool
+}
+
+type Agent struct {
+	client *Client
+	cfg    Config
+}
+
+func New(client *Client, cfg Config) *Agent {
+	if cfg.UploadChunkSize <= 0 {
+		cfg.UploadChunkSize = defaultChunkSize
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = retryQueryDelay
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = time.Minute
+	}
+	if cfg.LogFlushInterval <= 0 {
+		cfg.LogFlushInterval = 5 * time.Second
+	}
+	return &Agent{client: client, cfg: cfg}
+}
+
+// Run leases and processes tasks until the context is cancelled. While the
+// machine is draining or disabled it keeps polling without running anything.
+func (a *Agent) Run(ctx context.Context) error {
+	if a.cfg.TelemetryInterval > 0 {
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		telemetryCtx, stopTelemetry := context.WithCancel(ctx)
+		defer stopTelemetry()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.reportTelemetry(telemetryCtx)
+		}()
+	}
+
+	idle := false
+	for {
+		task, err := a.client.QueryTask(ctx, a.cfg.PollWait)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, ErrNotAcceptingTasks) {
+			if !idle {
+				log.Printf("not leasing tasks: %v", err)
+				idle = true
+			}
+			if !sleep(ctx, a.cfg.PollInterval) {
+				return nil
+			}
+			continue
+		}
+		idle = false
+		if err != nil {
+			log.Printf("cannot query task: %v", err)
+			if !sleep(ctx, retryQueryDelay) {
+				return nil
+			}
+			continue
+		}
+		if task == nil {
+			if a.cfg.PollWait == 0 && !sleep(ctx, a.cfg.PollInterval) {
+				return nil
+			}
+			continue
+		}
+
+		if err := a.RunTask(ctx, task); err != nil {
+			logf(task.ID, "%v", err)
+		}
+		if a.cfg.Once {
+			return nil
+		}
+	}
+}
+
+func logf(taskID uint, format string, args ...interface{}) {
+	log.Printf("task %d: %s", taskID, fmt.Sprintf(format, args...))
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// taskRun is the state shared by goroutines watching a running task.
+type taskRun struct {
+	task    *Task
+	dir     string
+	aborted atomic.Bool
+	cancel  context.CancelFunc
+}
+
+func (tr *taskRun) abort(abort bool) {
+	if abort && !tr.aborted.Swap(true) {
+		logf(tr.task.ID, "cancelled by the server")
+		tr.cancel()
+	}
+}
+
+// RunTask processes a leased task from preparing its directory to reporting
+// the final status.
+func (a *Agent) RunTask(ctx context.Context, task *Task) error {
+	// commands run in the task directory, so paths given to them are absolute
+	dir, err := filepath.Abs(filepath.Join(a.cfg.WorkDir, fmt.Sprintf("task-%d", task.ID)))
+	if err != nil {
+		return err
+	}
+	if err := prepareTaskDir(dir, task); err != nil {
+		a.fail(ctx, task.ID, "agent_setup_failed", err.Error(), "")
+		return err
+	}
+
+	abort, err := a.client.UpdateStatus(ctx, task.ID, models.Training, nil)
+	if err != nil {
+		return fmt.Errorf("cannot report training status: %w", err)
+	}
+	if abort {
+		return nil
+	}
+
+	logPath := filepath.Join(dir, TrainerLogName)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		a.fail(ctx, task.ID, "agent_setup_failed", err.Error(), "")
+		return err
+	}
+	//nolint:errcheck
+	defer logFile.Close()
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tr := &taskRun{task: task, dir: dir, cancel: cancel}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.heartbeat(taskCtx, tr)
+	}()
+
+	streamer := newLogStreamer(a.client, task.ID, logPath)
+	streamCtx, stopStreaming := context.WithCancel(taskCtx)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tr.abort(streamer.run(streamCtx, a.cfg.LogFlushInterval))
+	}()
+
+	// stop watching goroutines and send the rest of the log before the
+	// final status, the server accepts logs of running tasks only
+	finish := func() {
+		stopStreaming()
+		cancel()
+		wg.Wait()
+		if !tr.aborted.Load() {
+			abort, err := streamer.flush(ctx)
+			if err != nil {
+				logf(task.ID, "cannot stream log: %v", err)
+			}
+			tr.abort(abort)
+		}
+	}
+
+	// results of parent tasks are fetched under the lease, heartbeats keep
+	// it alive during long downloads
+	downloadErr := a.downloadParentResults(taskCtx, tr)
+
+	// the trainer is stopped by the agent itself after the maximum wall time,
+	// the server would fail the task anyway
+	cmdCtx := taskCtx
+	if task.MaxWallTimeSeconds > 0 {
+		var cancelWallTime context.CancelFunc
+		cmdCtx, cancelWallTime = context.WithTimeout(taskCtx, time.Duration(task.MaxWallTimeSeconds)*time.Second)
+		defer cancelWallTime()
+	}
+
+	var commandErr error
+	if downloadErr == nil && !tr.aborted.Load() {
+		commandErr = a.runCommand(cmdCtx, tr, a.cfg.TrainerCommand, logFile)
+	}
+	if downloadErr == nil && commandErr == nil && !tr.aborted.Load() {
+		abort, err = a.client.UpdateStatus(taskCtx, task.ID, models.Benchmarking, nil)
+		tr.abort(abort)
+		if err == nil && a.cfg.BenchmarkCommand != "" && !tr.aborted.Load() {
+			if commandErr = a.runCommand(cmdCtx, tr, a.cfg.BenchmarkCommand, logFile); commandErr != nil {
+				commandErr = fmt.Errorf("benchmark: %w", commandErr)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("cannot report benchmarking status: %w", err)
+		}
+	}
+	if downloadErr == nil && commandErr == nil && err == nil && !tr.aborted.Load() {
+		err = a.uploadResults(taskCtx, tr, logPath)
+	}
+	finish()
+
+	switch {
+	case tr.aborted.Load():
+		return nil
+	case ctx.Err() != nil:
+		// the agent context is done, give the last report a moment on its own
+		reportCtx, cancelReport := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelReport()
+		a.fail(reportCtx, task.ID, "agent_stopped", "agent was stopped during the task", streamer.tail())
+		return ctx.Err()
+	case downloadErr != nil:
+		a.fail(ctx, task.ID, "download_failed", downloadErr.Error(), "")
+		return downloadErr
+	case commandErr != nil && errors.Is(cmdCtx.Err(), context.DeadlineExceeded):
+		message := fmt.Sprintf("attempt exceeded the maximum wall time of %ds", task.MaxWallTimeSeconds)
+		a.fail(ctx, task.ID, models.FailureCodeWallTimeExceeded, message, streamer.tail())
+		return errors.New(message)
+	case commandErr != nil:
+		a.fail(ctx, task.ID, "trainer_failed", commandErr.Error(), streamer.tail())
+		return commandErr
+	case err != nil:
+		a.fail(ctx, task.ID, "upload_failed", err.Error(), streamer.tail())
+		return err
+	}
+
+	if _, err := a.client.UpdateStatus(ctx, task.ID, models.Completed, nil); err != nil {
+		return fmt.Errorf("cannot report completed status: %w", err)
+	}
+	logf(task.ID, "completed")
+	return nil
+}
+
+func prepareTaskDir(dir string, task *Task) error {
+	// previous attempts of the task may have left their files behind
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	config := task.Configuration
+	if len(config) == 0 {
+		config = []byte("null")
+	}
+	if err := os.WriteFile(filepath.Join(dir, ConfigFileName), config, 0o644); err != nil {
+		return err
+	}
+
+	var aods strings.Builder
+	for _, aod := range task.AODFiles {
+		aods.WriteString(aod.Path)
+		aods.WriteString("\n")
+	}
+	return os.WriteFile(filepath.Join(dir, AODListFileName), []byte(aods.String()), 0o644)
+}
+
+// runCommand runs the command in the task directory with the task details
+// exposed through TRAINT_* environment variables.
+func (a *Agent) runCommand(ctx context.Context, tr *taskRun, command string, logFile *os.File) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = tr.dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("TRAINT_TASK_ID=%d", tr.task.ID),
+		"TRAINT_WORK_DIR="+tr.dir,
+		"TRAINT_CONFIG="+filepath.Join(tr.dir, ConfigFileName),
+		"TRAINT_AOD_LIST="+filepath.Join(tr.dir, AODListFileName),
+		"TRAINT_PARENTS_DIR="+filepath.Join(tr.dir, ParentsDirName),
+	)
+	cmd.WaitDelay = 10 * time.Second
+
+	return cmd.Run()
+}
+
+func (a *Agent) heartbeat(ctx context.Context, tr *taskRun) {
+	ticker := time.NewTicker(a.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			abort, err := a.client.Heartbeat(ctx, tr.task.ID)
+			if err != nil {
+				if ctx.Err() == nil {
+					logf(tr.task.ID, "heartbeat failed: %v", err)
+				}
+				continue
+			}
+			tr.abort(abort)
+		}
+	}
+}
+
+// uploadResults uploads the expected ONNX files and the trainer log.
+func (a *Agent) uploadResults(ctx context.Context, tr *taskRun, logPath string) error {
+	for _, name := range tr.task.ExpectedOnnx {
+		path := filepath.Join(tr.dir, name)
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("expected result %s: %w", name, err)
+		}
+		if err := a.upload(ctx, tr.task.ID, path, name, models.Onnx); err != nil {
+			return fmt.Errorf("cannot upload %s: %w", name, err)
+		}
+	}
+
+	if err := a.upload(ctx, tr.task.ID, logPath, TrainerLogName, models.Log); err != nil {
+		return fmt.Errorf("cannot upload trainer log: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) fail(ctx context.Context, taskID uint, code, message, excerpt string) {
+	failure := &models.TaskFailure{Code: code, Message: message, LogExcerpt: excerpt}
+	if _, err := a.client.UpdateStatus(ctx, taskID, models.Failed, failure); err != nil {
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != 409 {
+			logf(taskID, "cannot report failure: %v", err)
+		}
+	}
+}
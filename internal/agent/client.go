@@ -0,0 +1,386 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/jalien"
+)
+
+// Task is a training task leased to the machine.
+type Task struct {
+	ID             uint
+	AODFiles       []jalien.AODFile
+	Configuration  json.RawMessage
+	LeaseExpiresAt *time.Time
+	// MaxWallTimeSeconds limits the duration of the attempt, zero means no
+	// limit
+	MaxWallTimeSeconds uint
+	// ParentResults are result files of the tasks this task depends on
+	ParentResults []ParentResult
+	// ExpectedOnnx lists local names of the ONNX results the trainer writes
+	// to the task directory
+	ExpectedOnnx []string
+}
+
+// ParentResult is a result file of a parent task available for download.
+type ParentResult struct {
+	ID             uint
+	TrainingTaskId uint
+	Name           string
+	Type           models.TrainingTaskResultType
+	FileName       string
+	Size           uint64
+	SHA256         string
+}
+
+// MachineState is the state of the training machine as seen by the server,
+// a drained machine finished its tasks after it was set to draining.
+type MachineState struct {
+	State          string
+	Idle           bool
+	Drained        bool
+	RunningTaskIds []uint
+}
+
+// ErrNotAcceptingTasks is returned by QueryTask when the machine is draining
+// or disabled.
+var ErrNotAcceptingTasks = errors.New("training machine does not accept new tasks")
+
+// Upload is the state of a resumable upload, Offset is where the next chunk
+// starts.
+type Upload struct {
+	ID     uint
+	Offset int64
+	Size   int64
+}
+
+// APIError is returned for responses with an unexpected status code.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// sessionRenewMargin is how long before its expiry the session token is
+// renewed, so that it does not expire during a request.
+const sessionRenewMargin = time.Minute
+
+type session struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Client calls the queue API of AliceTraINT on behalf of a training machine.
+// The secret key is exchanged for a short-lived session token sent with
+// the requests instead of it.
+type Client struct {
+	BaseURL   string
+	MachineID uint
+	SecretKey string
+	HTTP      *http.Client
+
+	// Certificate is set when the machine authenticates with a client
+	// certificate configured in HTTP instead of the secret key
+	Certificate bool
+
+	mu      sync.Mutex
+	session *session
+}
+
+func NewClient(baseURL string, machineID uint, secretKey string) *Client {
+	return &Client{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		MachineID: machineID,
+		SecretKey: secretKey,
+		HTTP:      &http.Client{},
+	}
+}
+
+// NewCertificateClient authenticates the machine with a grid certificate,
+// the server certificate is verified against the grid CAs in caDir or
+// the well-known locations.
+func NewCertificateClient(baseURL string, machineID uint, certPath, keyPath, caDir string) (*Client, error) {
+	clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rootCAs, err := jalien.LoadRootCAs(caDir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewClient(baseURL, machineID, "")
+	c.Certificate = true
+	c.HTTP = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{clientCert},
+				RootCAs:      rootCAs,
+			},
+		},
+	}
+	return c, nil
+}
+
+// sessionToken returns the session token, exchanging the secret key for
+// a new one when there is none or it is about to expire.
+func (c *Client) sessionToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != nil && time.Until(c.session.ExpiresAt) > sessionRenewMargin {
+		return c.session.Token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/training-machines/%d/session", c.BaseURL, c.MachineID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Secret-Id", c.SecretKey)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return "", &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	var s session
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return "", err
+	}
+	c.session = &s
+	return s.Token, nil
+}
+
+// send authorizes and sends the request, a session token rejected by
+// the server is dropped so the next request gets a new one.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.Certificate {
+		return c.HTTP.Do(req)
+	}
+
+	token, err := c.sessionToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.HTTP.Do(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		c.mu.Lock()
+		if c.session != nil && c.session.Token == token {
+			c.session = nil
+		}
+		c.mu.Unlock()
+	}
+	return resp, err
+}
+
+// do sends the request and decodes the JSON response into out when it is
+// not nil. Responses other than 2xx are returned as *APIError.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, "application/json", bytes.NewReader(body), out)
+}
+
+// QueryTask leases a task to the machine waiting up to wait for one to be
+// queued. It returns nil when no task is available.
+func (c *Client) QueryTask(ctx context.Context, wait time.Duration) (*Task, error) {
+	path := fmt.Sprintf("/training-machines/%d/training-task", c.MachineID)
+	if wait > 0 {
+		path += fmt.Sprintf("?wait=%d", int(wait.Seconds()))
+	}
+
+	var task Task
+	err := c.do(ctx, http.MethodGet, path, "", nil, &task)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusConflict {
+		return nil, fmt.Errorf("%w: %s", ErrNotAcceptingTasks, apiErr.Message)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// GetState returns the machine state and tasks it still runs.
+func (c *Client) GetState(ctx context.Context) (*MachineState, error) {
+	var state MachineState
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/training-machines/%d/state", c.MachineID), "", nil, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// SetState sets the machine state to active, draining or disabled.
+func (c *Client) SetState(ctx context.Context, state string) (*MachineState, error) {
+	body := struct{ State string }{state}
+
+	var resp MachineState
+	if err := c.doJSON(ctx, http.MethodPut, fmt.Sprintf("/training-machines/%d/state", c.MachineID), body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ReportTelemetry sends a health report of the machine.
+func (c *Client) ReportTelemetry(ctx context.Context, telemetry *Telemetry) error {
+	return c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/training-machines/%d/telemetry", c.MachineID), telemetry, nil)
+}
+
+// UpdateStatus reports the task status and returns whether the task should
+// be aborted.
+func (c *Client) UpdateStatus(ctx context.Context, taskID uint, status models.TrainingTaskStatus, failure *models.TaskFailure) (bool, error) {
+	body := struct {
+		Status       models.TrainingTaskStatus
+		ErrorCode    string `json:",omitempty"`
+		ErrorMessage string `json:",omitempty"`
+		LogExcerpt   string `json:",omitempty"`
+	}{Status: status}
+	if failure != nil {
+		body.ErrorCode = failure.Code
+		body.ErrorMessage = failure.Message
+		body.LogExcerpt = failure.LogExcerpt
+	}
+
+	var resp struct{ Abort bool }
+	err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/training-tasks/%d/status", taskID), body, &resp)
+	return resp.Abort, err
+}
+
+// Heartbeat renews the lease of the task and returns whether the task should
+// be aborted.
+func (c *Client) Heartbeat(ctx context.Context, taskID uint) (bool, error) {
+	var resp struct{ Abort bool }
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/training-tasks/%d/heartbeat", taskID), "", nil, &resp)
+	return resp.Abort, err
+}
+
+// AppendLog streams a log chunk starting at the offset and returns the log
+// size stored by the server.
+func (c *Client) AppendLog(ctx context.Context, taskID uint, offset int64, data []byte) (int64, bool, error) {
+	var resp struct {
+		Offset int64
+		Abort  bool
+	}
+	path := fmt.Sprintf("/training-tasks/%d/logs?offset=%d", taskID, offset)
+	err := c.do(ctx, http.MethodPost, path, "text/plain", bytes.NewReader(data), &resp)
+	return resp.Offset, resp.Abort, err
+}
+
+func (c *Client) InitiateUpload(ctx context.Context, taskID uint, name, fileName string, resultType models.TrainingTaskResultType, size int64) (*Upload, error) {
+	body := struct {
+		Name     string
+		Type     models.TrainingTaskResultType
+		FileName string
+		Size     int64
+	}{name, resultType, fileName, size}
+
+	var upload Upload
+	if err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/training-tasks/%d/uploads", taskID), body, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (c *Client) GetUpload(ctx context.Context, taskID, uploadID uint) (*Upload, error) {
+	var upload Upload
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/training-tasks/%d/uploads/%d", taskID, uploadID), "", nil, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (c *Client) WriteUploadChunk(ctx context.Context, taskID, uploadID uint, offset int64, chunk io.Reader) (*Upload, error) {
+	path := fmt.Sprintf("/training-tasks/%d/uploads/%d?offset=%s", taskID, uploadID, url.QueryEscape(fmt.Sprint(offset)))
+
+	var upload Upload
+	if err := c.do(ctx, http.MethodPut, path, "application/octet-stream", chunk, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (c *Client) FinalizeUpload(ctx context.Context, taskID, uploadID uint, checksum string) error {
+	body := struct{ SHA256 string }{checksum}
+	return c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/training-tasks/%d/uploads/%d/finalize", taskID, uploadID), body, nil)
+}
+
+// DownloadParentResult writes the result file of a parent task to w.
+func (c *Client) DownloadParentResult(ctx context.Context, taskID, resultID uint, w io.Writer) error {
+	path := fmt.Sprintf("/training-tasks/%d/parent-results/%d", taskID, resultID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package agent
+
+// diskFree is not supported on this platform.
+func diskFree(dir string) uint64 {
+	return 0
+}
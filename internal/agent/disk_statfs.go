@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package agent
+
+import "syscall"
+
+// diskFree returns space available to the agent on the file system of dir.
+func diskFree(dir string) uint64 {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize)
+}
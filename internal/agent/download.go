@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ParentsDirName is the directory of the task holding result files of its
+// parent tasks, in a subdirectory task-<id> per parent.
+const ParentsDirName = "parents"
+
+// downloadParentResults stores result files of the parent tasks in the task
+// directory and verifies their checksums.
+func (a *Agent) downloadParentResults(ctx context.Context, tr *taskRun) error {
+	for _, result := range tr.task.ParentResults {
+		dir := filepath.Join(tr.dir, ParentsDirName, fmt.Sprintf("task-%d", result.TrainingTaskId))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, filepath.Base(result.FileName))
+		if err := a.download(ctx, tr.task.ID, result, path); err != nil {
+			return fmt.Errorf("cannot download %s of task %d: %w", result.Name, result.TrainingTaskId, err)
+		}
+	}
+	return nil
+}
+
+func (a *Agent) download(ctx context.Context, taskID uint, result ParentResult, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer file.Close()
+
+	hash := sha256.New()
+	if err := a.client.DownloadParentResult(ctx, taskID, result.ID, io.MultiWriter(file, hash)); err != nil {
+		return err
+	}
+
+	if checksum := hex.EncodeToString(hash.Sum(nil)); result.SHA256 != "" && checksum != result.SHA256 {
+		return fmt.Errorf("checksum mismatch, expected %s, got %s", result.SHA256, checksum)
+	}
+	return file.Close()
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+// logStreamer sends bytes appended to the trainer log file to the server.
+type logStreamer struct {
+	client *Client
+	taskID uint
+	path   string
+
+	mu     sync.Mutex
+	offset int64
+}
+
+func newLogStreamer(client *Client, taskID uint, path string) *logStreamer {
+	return &logStreamer{client: client, taskID: taskID, path: path}
+}
+
+// run flushes the log every interval until the context is cancelled and
+// returns whether the server asked to abort the task.
+func (ls *logStreamer) run(ctx context.Context, interval time.Duration) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			abort, err := ls.flush(ctx)
+			if abort {
+				return true
+			}
+			if err != nil && ctx.Err() == nil {
+				logf(ls.taskID, "cannot stream log: %v", err)
+			}
+		}
+	}
+}
+
+// flush sends everything written to the log since the last flush.
+func (ls *logStreamer) flush(ctx context.Context) (bool, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	file, err := os.Open(ls.path)
+	if err != nil {
+		return false, err
+	}
+	//nolint:errcheck
+	defer file.Close()
+
+	buf := make([]byte, models.MaxLogChunkSize)
+	for {
+		n, err := file.ReadAt(buf, ls.offset)
+		if n == 0 {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+
+		offset, abort, err := ls.client.AppendLog(ctx, ls.taskID, ls.offset, buf[:n])
+		if err != nil || abort {
+			return abort, err
+		}
+		// the server reports its log size, which may be ahead of us when a
+		// previous request was stored but its response got lost
+		if offset <= ls.offset {
+			return false, nil
+		}
+		ls.offset = offset
+	}
+}
+
+// tail returns the end of the log to be reported with a failure.
+func (ls *logStreamer) tail() string {
+	file, err := os.Open(ls.path)
+	if err != nil {
+		return ""
+	}
+	//nolint:errcheck
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ""
+	}
+	start := max(info.Size()-logExcerptSize, 0)
+	data := make([]byte, info.Size()-start)
+	if _, err := file.ReadAt(data, start); err != nil && err != io.EOF {
+		return ""
+	}
+	return strings.ToValidUTF8(string(data), "")
+}
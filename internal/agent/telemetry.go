@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the agent reported in telemetry, it is set at build time with
+// -ldflags "-X github.com/mytkom/AliceTraINT/internal/agent.Version=v1.2.3".
+var Version = "dev"
+
+const gpuQueryTimeout = 10 * time.Second
+
+// Telemetry is a health report of the machine. Values which cannot be read
+// on the machine are left zero, GPUPercent is nil without an NVIDIA GPU.
+type Telemetry struct {
+	CPUPercent       float64
+	GPUPercent       *float64 `json:",omitempty"`
+	MemoryUsedBytes  uint64
+	MemoryTotalBytes uint64
+	DiskFreeBytes    uint64
+	AgentVersion     string
+	DriverVersion    string
+}
+
+// telemetryCollector reads utilisation from /proc, CPU utilisation is
+// computed since the previous collection.
+type telemetryCollector struct {
+	workDir   string
+	prevIdle  uint64
+	prevTotal uint64
+}
+
+func (a *Agent) reportTelemetry(ctx context.Context) {
+	collector := &telemetryCollector{workDir: a.cfg.WorkDir}
+	// the first CPU sample only sets the baseline
+	collector.cpuPercent()
+
+	for sleep(ctx, a.cfg.TelemetryInterval) {
+		if err := a.client.ReportTelemetry(ctx, collector.collect(ctx)); err != nil && ctx.Err() == nil {
+			log.Printf("cannot report telemetry: %v", err)
+		}
+	}
+}
+
+func (tc *telemetryCollector) collect(ctx context.Context) *Telemetry {
+	telemetry := &Telemetry{
+		CPUPercent:    tc.cpuPercent(),
+		DiskFreeBytes: diskFree(tc.workDir),
+		AgentVersion:  Version,
+	}
+	telemetry.MemoryUsedBytes, telemetry.MemoryTotalBytes = memoryUsage()
+	telemetry.GPUPercent, telemetry.DriverVersion = gpuUsage(ctx)
+	return telemetry
+}
+
+// cpuPercent returns utilisation of all CPUs from the aggregate line of
+// /proc/stat, time spent waiting for I/O counts as idle.
+func (tc *telemetryCollector) cpuPercent() float64 {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[0] != "cpu" {
+		return 0
+	}
+
+	var idle, total uint64
+	// user nice system idle iowait irq softirq steal, guest time is
+	// already included in user
+	for i, field := range fields[1:min(len(fields), 9)] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0
+		}
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+
+	idleDelta, totalDelta := idle-tc.prevIdle, total-tc.prevTotal
+	tc.prevIdle, tc.prevTotal = idle, total
+	if totalDelta == 0 || idleDelta > totalDelta {
+		return 0
+	}
+	return float64(totalDelta-idleDelta) / float64(totalDelta) * 100
+}
+
+// memoryUsage returns used and total memory from /proc/meminfo, memory
+// available for reclaim does not count as used.
+func memoryUsage() (uint64, uint64) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0
+	}
+	//nolint:errcheck
+	defer file.Close()
+
+	var total, available uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = value << 10
+		case "MemAvailable:":
+			available = value << 10
+		}
+	}
+	if available > total {
+		return 0, total
+	}
+	return total - available, total
+}
+
+// gpuUsage returns mean utilisation of NVIDIA GPUs and the driver version
+// reported by nvidia-smi, nil when it is not installed or fails.
+func gpuUsage(ctx context.Context) (*float64, string) {
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return nil, ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gpuQueryTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=utilization.gpu,driver_version", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, ""
+	}
+
+	var sum float64
+	var count int
+	var driver string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		utilization, version, ok := strings.Cut(line, ",")
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(utilization), 64)
+		if err != nil {
+			continue
+		}
+		sum += value
+		count++
+		driver = strings.TrimSpace(version)
+	}
+	if count == 0 {
+		return nil, driver
+	}
+	mean := sum / float64(count)
+	return &mean, driver
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+const maxChunkRetries = 3
+
+// upload sends the file in chunks through a resumable upload. A failed chunk
+// is retried from the offset reported by the server.
+func (a *Agent) upload(ctx context.Context, taskID uint, path, name string, resultType models.TrainingTaskResultType) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	upload, err := a.client.InitiateUpload(ctx, taskID, name, filepath.Base(path), resultType, info.Size())
+	if err != nil {
+		return err
+	}
+
+	retries := 0
+	for upload.Offset < upload.Size {
+		chunkSize := min(a.cfg.UploadChunkSize, upload.Size-upload.Offset)
+		chunk := io.NewSectionReader(file, upload.Offset, chunkSize)
+
+		next, err := a.client.WriteUploadChunk(ctx, taskID, upload.ID, upload.Offset, chunk)
+		if err == nil {
+			upload = next
+			retries = 0
+			continue
+		}
+
+		retries++
+		if retries > maxChunkRetries || ctx.Err() != nil {
+			return err
+		}
+		logf(taskID, "upload of %s failed at offset %d, resuming: %v", name, upload.Offset, err)
+		if upload, err = a.client.GetUpload(ctx, taskID, upload.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := a.client.FinalizeUpload(ctx, taskID, upload.ID, checksum); err != nil {
+		return fmt.Errorf("cannot finalize upload: %w", err)
+	}
+	return nil
+}
@@ -75,7 +75,7 @@ func uploadFile(filename, url string, fileReader io.Reader, val, valEnd uint64,
 
 	_, err = io.Copy(part, fileReader)
 	if err != nil {
-		return fmt.Errorf("failed to copy file content: %v", err)
+		return fmt.Errorf("failed to copy file content: %w", err)
 	}
 
 	err = writer.Close()
@@ -24,6 +24,37 @@ type Config struct {
 	DataDirPath          string
 	NNArchPath           string
 	DocsDirPath          string
+	Queue                QueueConfig
+	MachineAuth          MachineAuthConfig
+}
+
+type QueueConfig struct {
+	LeaseSeconds           uint
+	MaxLostLeases          uint
+	ReaperIntervalSeconds  uint
+	SchedulingPolicy       string
+	PriorityAgingMinutes   uint
+	FairShareWindowHours   uint
+	LongPollMaxSeconds     uint
+	LongPollRecheckSeconds uint
+	// CandidateLimit is the number of queued tasks fetched at once by
+	// policies ordering them in the database
+	CandidateLimit uint
+	// UploadTTLHours is how long an upload receiving no chunks is kept
+	UploadTTLHours uint
+}
+
+type MachineAuthConfig struct {
+	// SecretGraceMinutes is how long a rotated secret key stays valid
+	SecretGraceMinutes uint
+	// SessionTTLMinutes is how long a session token of a machine is valid
+	SessionTTLMinutes uint
+	// SessionSigningKey signs session tokens, a random key is used when empty
+	SessionSigningKey string
+	// TLSPort enables a TLS listener accepting grid certificates of machines
+	TLSPort     string
+	TLSCertPath string
+	TLSKeyPath  string
 }
 
 type DatabaseConfig struct {
@@ -68,6 +99,26 @@ func LoadConfig() *Config {
 		DataDirPath:          getEnv("ALICETRAINT_DATA_DIR_PATH", "data"),
 		NNArchPath:           getEnv("ALICETRAINT_NN_ARCH_DIR", "web/nn_architectures/proposed.json"),
 		DocsDirPath:          getEnv("ALICETRAINT_DOCS_DIR_PATH", "docs"),
+		Queue: QueueConfig{
+			LeaseSeconds:           getEnvAsUint("ALICETRAINT_QUEUE_LEASE_SECONDS", 300),
+			MaxLostLeases:          getEnvAsUint("ALICETRAINT_QUEUE_MAX_LOST_LEASES", 3),
+			ReaperIntervalSeconds:  getEnvAsUint("ALICETRAINT_QUEUE_REAPER_INTERVAL_SECONDS", 60),
+			SchedulingPolicy:       getEnv("ALICETRAINT_QUEUE_SCHEDULING_POLICY", "fifo"),
+			PriorityAgingMinutes:   getEnvAsUint("ALICETRAINT_QUEUE_PRIORITY_AGING_MINUTES", 60),
+			FairShareWindowHours:   getEnvAsUint("ALICETRAINT_QUEUE_FAIR_SHARE_WINDOW_HOURS", 168),
+			LongPollMaxSeconds:     getEnvAsUint("ALICETRAINT_QUEUE_LONG_POLL_MAX_SECONDS", 60),
+			LongPollRecheckSeconds: getEnvAsUint("ALICETRAINT_QUEUE_LONG_POLL_RECHECK_SECONDS", 5),
+			CandidateLimit:         getEnvAsUint("ALICETRAINT_QUEUE_CANDIDATE_LIMIT", 200),
+			UploadTTLHours:         getEnvAsUint("ALICETRAINT_QUEUE_UPLOAD_TTL_HOURS", 24),
+		},
+		MachineAuth: MachineAuthConfig{
+			SecretGraceMinutes: getEnvAsUint("ALICETRAINT_MACHINE_SECRET_GRACE_MINUTES", 1440),
+			SessionTTLMinutes:  getEnvAsUint("ALICETRAINT_MACHINE_SESSION_TTL_MINUTES", 15),
+			SessionSigningKey:  getEnv("ALICETRAINT_MACHINE_SESSION_KEY", ""),
+			TLSPort:            getEnv("ALICETRAINT_MACHINE_TLS_PORT", ""),
+			TLSCertPath:        getEnv("ALICETRAINT_TLS_CERT_PATH", ""),
+			TLSKeyPath:         getEnv("ALICETRAINT_TLS_KEY_PATH", ""),
+		},
 	}
 }
 
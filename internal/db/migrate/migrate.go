@@ -16,8 +16,16 @@ func MigrateDB(db *gorm.DB) {
 		&models.TrainingDataset{},
 		&models.TrainingTask{},
 		&models.TrainingMachine{},
+		&models.TrainingMachineTelemetry{},
 		&models.TrainingTaskResult{},
 		&models.File{},
+		&models.TrainingTaskStatusEvent{},
+		&models.TrainingTaskAttempt{},
+		&models.TrainingTaskMetric{},
+		&models.TrainingTaskLogChunk{},
+		&models.TrainingTaskUpload{},
+		&models.TrainingTaskDependency{},
+		&models.Sweep{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
@@ -7,4 +7,6 @@ type File struct {
 	Path string
 	Name string
 	Size uint64
+	// SHA256 is the hex encoded digest of the file computed when it was stored
+	SHA256 string `gorm:"type:varchar(64)"`
 }
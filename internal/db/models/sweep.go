@@ -0,0 +1,35 @@
+package models
+
+import "gorm.io/gorm"
+
+// Sweep methods of sampling hyperparameters
+const (
+	SweepGrid           = "grid"
+	SweepRandom         = "random"
+	SweepLatinHypercube = "lhs"
+)
+
+var SweepMethods = []string{SweepGrid, SweepRandom, SweepLatinHypercube}
+
+// Sweep is a set of training tasks on a single dataset whose configurations
+// sample selected hyperparameters within their declared ranges.
+type Sweep struct {
+	gorm.Model
+	Name              string `gorm:"type:varchar(255);not null;uniqueIndex"`
+	UserId            uint
+	User              User
+	TrainingDatasetId uint `gorm:"not null"`
+	TrainingDataset   TrainingDataset
+	Method            string `gorm:"type:varchar(16);not null"`
+	// Samples is the number of tasks for random and lhs sampling and the
+	// number of values per field for the grid
+	Samples uint
+	Seed    int64
+	Fields  []string `gorm:"serializer:json"`
+	// Configuration holds values of fields which are not swept
+	Configuration interface{} `gorm:"serializer:json"`
+	// Metric and Maximize are the defaults used to rank tasks of the sweep
+	Metric        string `gorm:"type:varchar(64)"`
+	Maximize      bool
+	TrainingTasks []TrainingTask
+}
@@ -6,6 +6,92 @@ import (
 	"gorm.io/gorm"
 )
 
+// TrainingMachineState controls whether the training machine is given new
+// tasks, the zero value is Active.
+type TrainingMachineState uint
+
+const (
+	MachineActive TrainingMachineState = iota
+	// MachineDraining finishes running tasks, but is not given new ones
+	MachineDraining
+	// MachineDisabled is not given any tasks, e.g. during maintenance
+	MachineDisabled
+)
+
+var TrainingMachineStates = []TrainingMachineState{
+	MachineActive,
+	MachineDraining,
+	MachineDisabled,
+}
+
+func (s TrainingMachineState) String() string {
+	switch s {
+	case MachineActive:
+		return "active"
+	case MachineDraining:
+		return "draining"
+	case MachineDisabled:
+		return "disabled"
+	default:
+		return "unknown"
+	}
+}
+
+// AcceptsTasks reports whether new tasks may be assigned to the machine.
+func (s TrainingMachineState) AcceptsTasks() bool {
+	return s == MachineActive
+}
+
+// returns tailwind color suffix and this classes should be included in tailwind's safelist
+func (s TrainingMachineState) Color() string {
+	switch s {
+	case MachineActive:
+		return "green-600"
+	case MachineDraining:
+		return "yellow-600"
+	default:
+		return "gray-500"
+	}
+}
+
+// MachineHealth is derived from the time the machine last reported.
+type MachineHealth uint
+
+const (
+	MachineOnline MachineHealth = iota
+	// MachineStale missed a few reports, but may still be working
+	MachineStale
+	MachineOffline
+)
+
+const (
+	MachineStaleAfter   = 3 * time.Minute
+	MachineOfflineAfter = 10 * time.Minute
+)
+
+func (h MachineHealth) String() string {
+	switch h {
+	case MachineOnline:
+		return "online"
+	case MachineStale:
+		return "stale"
+	default:
+		return "offline"
+	}
+}
+
+// returns tailwind color suffix and this classes should be included in tailwind's safelist
+func (h MachineHealth) Color() string {
+	switch h {
+	case MachineOnline:
+		return "green-600"
+	case MachineStale:
+		return "yellow-600"
+	default:
+		return "red-400"
+	}
+}
+
 type TrainingMachine struct {
 	gorm.Model
 	Name            string `gorm:"type:varchar(255);not null;uniqueIndex"`
@@ -13,4 +99,50 @@ type TrainingMachine struct {
 	SecretKeyHashed string
 	UserId          uint
 	User            User
+	Labels          map[string]string    `gorm:"serializer:json"`
+	Pool            string               `gorm:"type:varchar(255);index"`
+	State           TrainingMachineState `gorm:"type:smallint;not null;default:0"`
+	// SecretKeyRotatedAt is nil until the secret key is rotated for the first time
+	SecretKeyRotatedAt  *time.Time
+	SecretKeyLastUsedAt *time.Time
+	// PreviousSecretKeyHashed is the key replaced by the last rotation, it is
+	// accepted until PreviousSecretKeyExpiresAt
+	PreviousSecretKeyHashed     string
+	PreviousSecretKeyExpiresAt  *time.Time
+	PreviousSecretKeyLastUsedAt *time.Time
+	// CertificateSubject binds the machine to a grid certificate, e.g.
+	// "/DC=ch/DC=cern/OU=computers/CN=node.cern.ch". A bound machine
+	// authenticates with the certificate only.
+	CertificateSubject string `gorm:"type:varchar(512)"`
+	// LastTelemetryAt is nil until the machine reports telemetry
+	LastTelemetryAt *time.Time
+	AgentVersion    string `gorm:"type:varchar(64)"`
+	DriverVersion   string `gorm:"type:varchar(64)"`
+}
+
+// PreviousSecretKeyValid reports whether the previous secret key is still
+// accepted at the given time.
+func (tm TrainingMachine) PreviousSecretKeyValid(at time.Time) bool {
+	return tm.PreviousSecretKeyHashed != "" && tm.PreviousSecretKeyExpiresAt != nil && at.Before(*tm.PreviousSecretKeyExpiresAt)
+}
+
+// LastSeenAt is the time of the last telemetry report, or of the last
+// authorized request of machines which never reported telemetry.
+func (tm TrainingMachine) LastSeenAt() time.Time {
+	if tm.LastTelemetryAt != nil {
+		return *tm.LastTelemetryAt
+	}
+	return tm.LastActivityAt
+}
+
+// Health reports whether the machine was seen recently at the given time.
+func (tm TrainingMachine) Health(at time.Time) MachineHealth {
+	switch since := at.Sub(tm.LastSeenAt()); {
+	case since < MachineStaleAfter:
+		return MachineOnline
+	case since < MachineOfflineAfter:
+		return MachineStale
+	default:
+		return MachineOffline
+	}
 }
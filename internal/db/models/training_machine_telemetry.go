@@ -0,0 +1,18 @@
+package models
+
+import "gorm.io/gorm"
+
+// TrainingMachineTelemetry is a health report of the training machine, it
+// was taken at CreatedAt.
+type TrainingMachineTelemetry struct {
+	gorm.Model
+	TrainingMachineId uint `gorm:"index"`
+	CPUPercent        float64
+	// GPUPercent is nil on machines without a supported GPU
+	GPUPercent       *float64
+	MemoryUsedBytes  uint64
+	MemoryTotalBytes uint64
+	DiskFreeBytes    uint64
+	AgentVersion     string `gorm:"type:varchar(64)"`
+	DriverVersion    string `gorm:"type:varchar(64)"`
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"database/sql/driver"
 	"fmt"
+	"slices"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -16,8 +18,20 @@ const (
 	Benchmarking
 	Completed
 	Uploaded
+	Cancelled
 )
 
+// TrainingTaskStatuses lists all statuses in the order of task progress.
+var TrainingTaskStatuses = []TrainingTaskStatus{
+	Queued,
+	Training,
+	Benchmarking,
+	Completed,
+	Uploaded,
+	Failed,
+	Cancelled,
+}
+
 func (s *TrainingTaskStatus) Scan(value interface{}) error {
 	val, ok := value.(int64)
 	if !ok {
@@ -28,7 +42,7 @@ func (s *TrainingTaskStatus) Scan(value interface{}) error {
 }
 
 func (s TrainingTaskStatus) Value() (driver.Value, error) {
-	if s < Failed || s > Uploaded {
+	if s < Failed || s > Cancelled {
 		return nil, fmt.Errorf("bad status")
 	}
 
@@ -49,19 +63,59 @@ func (s TrainingTaskStatus) String() string {
 		return "Uploaded"
 	case Failed:
 		return "Failed"
+	case Cancelled:
+		return "Cancelled"
 	default:
 		return "Unknown"
 	}
 }
 
 func (s TrainingTaskStatus) IsCompleted() bool {
-	return s >= Completed
+	return s == Completed || s == Uploaded
 }
 
 func (s TrainingTaskStatus) IsUploaded() bool {
 	return s == Uploaded
 }
 
+func (s TrainingTaskStatus) IsFailed() bool {
+	return s == Failed
+}
+
+func (s TrainingTaskStatus) IsCancelled() bool {
+	return s == Cancelled
+}
+
+// IsCancellable reports whether the user may still cancel the task.
+func (s TrainingTaskStatus) IsCancellable() bool {
+	return s.CanTransitionTo(Cancelled)
+}
+
+// IsRunning reports whether the task is held by a training machine under a lease.
+func (s TrainingTaskStatus) IsRunning() bool {
+	return s == Training || s == Benchmarking
+}
+
+// statusTransitions lists every legal status change of a training task.
+// Queued is re-entered only when a lease expires or a failed attempt is
+// retried, Uploaded is set only by the CCDB upload and Cancelled only by user.
+var statusTransitions = map[TrainingTaskStatus][]TrainingTaskStatus{
+	Failed:       {Queued},
+	Queued:       {Training, Failed, Cancelled},
+	Training:     {Benchmarking, Failed, Queued, Cancelled},
+	Benchmarking: {Completed, Failed, Queued, Cancelled},
+	Completed:    {Uploaded},
+}
+
+// NextStatuses returns statuses the task may move to from the current one.
+func (s TrainingTaskStatus) NextStatuses() []TrainingTaskStatus {
+	return statusTransitions[s]
+}
+
+func (s TrainingTaskStatus) CanTransitionTo(next TrainingTaskStatus) bool {
+	return slices.Contains(statusTransitions[s], next)
+}
+
 // returns tailwind color suffix and this classes should be included in tailwind's safelist
 func (s TrainingTaskStatus) Color() string {
 	switch s {
@@ -77,11 +131,43 @@ func (s TrainingTaskStatus) Color() string {
 		return "green-400"
 	case Failed:
 		return "red-400"
+	case Cancelled:
+		return "gray-500"
 	default:
 		return "gray-400"
 	}
 }
 
+// Priority of a task is chosen by its user from a small range, so no task
+// can overtake the whole queue by far.
+const (
+	MinTaskPriority = -10
+	MaxTaskPriority = 10
+)
+
+// Failure codes set by the queue and the training machine agent themselves,
+// other codes are chosen by the trainer.
+const (
+	// FailureCodeLeaseExpired is set on tasks failed because their training
+	// machine stopped sending heartbeats.
+	FailureCodeLeaseExpired = "lease_expired"
+	// FailureCodeWallTimeExceeded is set on tasks failed because their
+	// attempt ran longer than the maximum wall time, by the queue or by the
+	// agent.
+	FailureCodeWallTimeExceeded = "wall_time_exceeded"
+	// FailureCodeDependencyFailed is set on tasks which can never be
+	// scheduled, because one of their parent tasks failed or was cancelled.
+	FailureCodeDependencyFailed = "dependency_failed"
+)
+
+// TaskFailure describes why a training task failed, as reported by the
+// training machine or by the queue.
+type TaskFailure struct {
+	Code       string `gorm:"type:varchar(64);index"`
+	Message    string `gorm:"type:text"`
+	LogExcerpt string `gorm:"type:text"`
+}
+
 type TrainingTask struct {
 	gorm.Model
 	Name                string             `gorm:"type:varchar(255);not null;uniqueIndex:idx_unique_name_for_dataset;index"`
@@ -94,4 +180,37 @@ type TrainingTask struct {
 	TrainingMachineId   *uint
 	TrainingMachine     TrainingMachine
 	Configuration       interface{} `gorm:"serializer:json"`
+	LeaseExpiresAt      *time.Time
+	LostLeases          uint
+	Priority            int
+	AssignedAt          *time.Time
+	FinishedAt          *time.Time
+	Requirements        []string `gorm:"serializer:json"`
+	// PinnedTrainingMachineId restricts the task to a single training machine
+	PinnedTrainingMachineId *uint
+	// MachinePool restricts the task to training machines of the named pool
+	MachinePool string      `gorm:"type:varchar(255)"`
+	Failure     TaskFailure `gorm:"embedded;embeddedPrefix:failure_"`
+	// MaxAttempts and RetryBackoffSeconds override the architecture retry
+	// policy when non-zero
+	MaxAttempts         uint
+	RetryBackoffSeconds uint
+	// MaxWallTimeSeconds limits the duration of a single attempt, it
+	// overrides the architecture default when non-zero
+	MaxWallTimeSeconds uint
+	// Retries counts failed attempts which were requeued, it is also the
+	// index of the current attempt
+	Retries uint
+	// RetryAt delays scheduling of a requeued attempt
+	RetryAt *time.Time
+	// Dependencies list parent tasks which must be completed before the task
+	// is scheduled
+	Dependencies []TrainingTaskDependency
+	// SweepId is set for tasks created by a hyperparameter sweep
+	SweepId *uint `gorm:"index"`
+}
+
+// Attempt returns the 1-based number of the current attempt.
+func (t TrainingTask) Attempt() uint {
+	return t.Retries + 1
 }
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TrainingTaskAttempt archives a failed attempt of a training task which
+// was retried. Results of the attempt are linked by their Retry index.
+type TrainingTaskAttempt struct {
+	gorm.Model
+	TrainingTaskId    uint `gorm:"index"`
+	Retry             uint
+	TrainingMachineId *uint
+	TrainingMachine   *TrainingMachine
+	AssignedAt        *time.Time
+	FinishedAt        *time.Time
+	Failure           TaskFailure `gorm:"embedded;embeddedPrefix:failure_"`
+}
+
+// Number returns the 1-based number of the attempt.
+func (a TrainingTaskAttempt) Number() uint {
+	return a.Retry + 1
+}
@@ -0,0 +1,11 @@
+package models
+
+import "gorm.io/gorm"
+
+// TrainingTaskDependency makes the training task wait for its parent task,
+// the task is scheduled only after all its parents are completed.
+type TrainingTaskDependency struct {
+	gorm.Model
+	TrainingTaskId uint `gorm:"uniqueIndex:idx_unique_training_task_dependency;not null"`
+	ParentId       uint `gorm:"uniqueIndex:idx_unique_training_task_dependency;index;not null"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "gorm.io/gorm"
+
+// MaxLogChunkSize is the largest log chunk accepted in a single request.
+const MaxLogChunkSize = 1 << 20
+
+// TrainingTaskLogChunk is a part of the log streamed by the training machine
+// while the task is running. Chunks of one attempt form a contiguous log and
+// Offset is the position of the first byte of Data in it.
+type TrainingTaskLogChunk struct {
+	gorm.Model
+	TrainingTaskId uint `gorm:"uniqueIndex:idx_training_task_log_chunk"`
+	// Retry is the index of the task attempt which streamed the chunk
+	Retry  uint  `gorm:"uniqueIndex:idx_training_task_log_chunk"`
+	Offset int64 `gorm:"uniqueIndex:idx_training_task_log_chunk"`
+	Size   int64
+	Data   []byte
+}
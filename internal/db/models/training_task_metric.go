@@ -0,0 +1,16 @@
+package models
+
+import "gorm.io/gorm"
+
+// TrainingTaskMetric is a single value of a named metric, e.g. loss or
+// learning rate, reported by the training machine at a training step.
+type TrainingTaskMetric struct {
+	gorm.Model
+	TrainingTaskId uint `gorm:"index:idx_training_task_metric"`
+	// Retry is the index of the task attempt which reported the metric
+	Retry uint `gorm:"index:idx_training_task_metric"`
+	Step  uint
+	Epoch *uint
+	Name  string `gorm:"type:varchar(64)"`
+	Value float64
+}
@@ -37,4 +37,6 @@ type TrainingTaskResult struct {
 	File           File
 	TrainingTaskId uint
 	TrainingTask   TrainingTask
+	// Retry is the index of the task attempt which produced the result
+	Retry uint
 }
@@ -0,0 +1,29 @@
+package models
+
+import "gorm.io/gorm"
+
+// StatusEventSource tells what performed the status change.
+type StatusEventSource string
+
+const (
+	// user action in the web application
+	StatusSourceUser StatusEventSource = "user"
+	// training machine report or task pickup
+	StatusSourceMachine StatusEventSource = "machine"
+	// queue housekeeping, e.g. expired lease
+	StatusSourceQueue StatusEventSource = "queue"
+)
+
+// TrainingTaskStatusEvent records a single accepted status change of a
+// training task, CreatedAt is the time of the transition.
+type TrainingTaskStatusEvent struct {
+	gorm.Model
+	TrainingTaskId    uint                `gorm:"index;not null"`
+	FromStatus        *TrainingTaskStatus `gorm:"type:smallint"`
+	ToStatus          TrainingTaskStatus  `gorm:"type:smallint"`
+	Source            StatusEventSource   `gorm:"type:varchar(32)"`
+	UserId            *uint
+	User              *User
+	TrainingMachineId *uint
+	TrainingMachine   *TrainingMachine
+}
@@ -0,0 +1,28 @@
+package models
+
+import "gorm.io/gorm"
+
+// TrainingTaskUpload tracks a resumable upload of a large training task
+// result. It becomes a TrainingTaskResult once all chunks are received and
+// the upload is finalized.
+type TrainingTaskUpload struct {
+	gorm.Model
+	TrainingTaskId uint `gorm:"index"`
+	// Retry is the index of the task attempt which started the upload
+	Retry       uint
+	Name        string
+	Description string
+	Type        TrainingTaskResultType
+	FileName    string
+	// Size is the declared size of the whole file in bytes
+	Size int64
+	// Received is the number of bytes stored from the beginning of the file
+	Received int64
+	// PartialPath is the location of the file while it is being uploaded
+	PartialPath string
+}
+
+// IsComplete reports whether all declared bytes were received.
+func (u TrainingTaskUpload) IsComplete() bool {
+	return u.Received == u.Size
+}
@@ -3,19 +3,35 @@ package repository
 import "gorm.io/gorm"
 
 type RepositoryContext struct {
-	User               UserRepository
-	TrainingMachine    TrainingMachineRepository
-	TrainingDataset    TrainingDatasetRepository
-	TrainingTask       TrainingTaskRepository
-	TrainingTaskResult TrainingTaskResultRepository
+	User                     UserRepository
+	TrainingMachine          TrainingMachineRepository
+	TrainingMachineTelemetry TrainingMachineTelemetryRepository
+	TrainingDataset          TrainingDatasetRepository
+	TrainingTask             TrainingTaskRepository
+	TrainingTaskResult       TrainingTaskResultRepository
+	TrainingTaskStatusEvent  TrainingTaskStatusEventRepository
+	TrainingTaskAttempt      TrainingTaskAttemptRepository
+	TrainingTaskMetric       TrainingTaskMetricRepository
+	TrainingTaskLogChunk     TrainingTaskLogChunkRepository
+	TrainingTaskUpload       TrainingTaskUploadRepository
+	TrainingTaskDependency   TrainingTaskDependencyRepository
+	Sweep                    SweepRepository
 }
 
 func NewRepositoryContext(db *gorm.DB) *RepositoryContext {
 	return &RepositoryContext{
-		User:               NewUserRepository(db),
-		TrainingMachine:    NewTrainingMachineRepository(db),
-		TrainingDataset:    NewTrainingDatasetRepository(db),
-		TrainingTask:       NewTrainingTaskRepository(db),
-		TrainingTaskResult: NewTrainingTaskResultRepository(db),
+		User:                     NewUserRepository(db),
+		TrainingMachine:          NewTrainingMachineRepository(db),
+		TrainingMachineTelemetry: NewTrainingMachineTelemetryRepository(db),
+		TrainingDataset:          NewTrainingDatasetRepository(db),
+		TrainingTask:             NewTrainingTaskRepository(db),
+		TrainingTaskResult:       NewTrainingTaskResultRepository(db),
+		TrainingTaskStatusEvent:  NewTrainingTaskStatusEventRepository(db),
+		TrainingTaskAttempt:      NewTrainingTaskAttemptRepository(db),
+		TrainingTaskMetric:       NewTrainingTaskMetricRepository(db),
+		TrainingTaskLogChunk:     NewTrainingTaskLogChunkRepository(db),
+		TrainingTaskUpload:       NewTrainingTaskUploadRepository(db),
+		TrainingTaskDependency:   NewTrainingTaskDependencyRepository(db),
+		Sweep:                    NewSweepRepository(db),
 	}
 }
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type SweepRepository interface {
+	Create(sweep *models.Sweep) error
+	GetByID(id uint) (*models.Sweep, error)
+	GetAll() ([]models.Sweep, error)
+	GetAllUser(userId uint) ([]models.Sweep, error)
+}
+
+type sweepRepository struct {
+	db *gorm.DB
+}
+
+func NewSweepRepository(db *gorm.DB) SweepRepository {
+	return &sweepRepository{db: db}
+}
+
+// Create inserts the sweep together with its training tasks.
+func (r *sweepRepository) Create(sweep *models.Sweep) error {
+	return r.db.Create(sweep).Error
+}
+
+func (r *sweepRepository) withDependencies() *gorm.DB {
+	return r.db.
+		Preload("TrainingDataset", func(db *gorm.DB) *gorm.DB {
+			return db.Unscoped()
+		}).
+		Joins("User")
+}
+
+func (r *sweepRepository) GetByID(id uint) (*models.Sweep, error) {
+	var sweep models.Sweep
+	err := r.withDependencies().
+		Preload("TrainingTasks", func(db *gorm.DB) *gorm.DB {
+			return db.Order("\"training_tasks\".\"id\" asc")
+		}).
+		First(&sweep, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sweep, nil
+}
+
+func (r *sweepRepository) GetAll() ([]models.Sweep, error) {
+	var sweeps []models.Sweep
+	if err := r.withDependencies().Order("\"sweeps\".\"created_at\" desc").Find(&sweeps).Error; err != nil {
+		return nil, err
+	}
+	return sweeps, nil
+}
+
+func (r *sweepRepository) GetAllUser(userId uint) ([]models.Sweep, error) {
+	var sweeps []models.Sweep
+	if err := r.withDependencies().Order("\"sweeps\".\"created_at\" desc").Find(&sweeps, r.db.Where(&models.Sweep{UserId: userId})).Error; err != nil {
+		return nil, err
+	}
+	return sweeps, nil
+}
+
+type MockSweepRepository struct {
+	mock.Mock
+}
+
+func NewMockSweepRepository() *MockSweepRepository {
+	return &MockSweepRepository{}
+}
+
+func (m *MockSweepRepository) Create(sweep *models.Sweep) error {
+	args := m.Called(sweep)
+	return args.Error(0)
+}
+
+func (m *MockSweepRepository) GetByID(id uint) (*models.Sweep, error) {
+	args := m.Called(id)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.Sweep), args.Error(1)
+}
+
+func (m *MockSweepRepository) GetAll() ([]models.Sweep, error) {
+	args := m.Called()
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.Sweep), args.Error(1)
+}
+
+func (m *MockSweepRepository) GetAllUser(userId uint) ([]models.Sweep, error) {
+	args := m.Called(userId)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.Sweep), args.Error(1)
+}
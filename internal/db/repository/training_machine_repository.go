@@ -12,6 +12,8 @@ type TrainingMachineRepository interface {
 	GetAll() ([]models.TrainingMachine, error)
 	GetAllUser(userId uint) ([]models.TrainingMachine, error)
 	Update(tm *models.TrainingMachine) error
+	UpdateColumns(id uint, expected map[string]interface{}, columns map[string]interface{}) (bool, error)
+	Touch(id uint, columns map[string]interface{}) error
 	Delete(userId uint, id uint) error
 }
 
@@ -59,6 +61,28 @@ func (r *trainingMachineRepository) Update(tm *models.TrainingMachine) error {
 	return r.db.Save(tm).Error
 }
 
+// UpdateColumns writes only the given columns of the machine, so concurrent
+// writers of other columns are not overwritten. When expected is not empty,
+// the row is updated only if it still holds the expected values; it returns
+// false when it does not.
+func (r *trainingMachineRepository) UpdateColumns(id uint, expected map[string]interface{}, columns map[string]interface{}) (bool, error) {
+	query := r.db.Model(&models.TrainingMachine{}).Where("\"id\" = ?", id)
+	if len(expected) > 0 {
+		query = query.Where(expected)
+	}
+	result := query.Updates(columns)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Touch records activity timestamps of the machine, UpdatedAt is left as is
+// so it still tells when the machine was last changed.
+func (r *trainingMachineRepository) Touch(id uint, columns map[string]interface{}) error {
+	return r.db.Model(&models.TrainingMachine{}).Where("\"id\" = ?", id).UpdateColumns(columns).Error
+}
+
 func (r *trainingMachineRepository) Delete(userId uint, id uint) error {
 	return r.db.Where("\"user_id\" = ?", userId).Delete(&models.TrainingMachine{}, id).Error
 }
@@ -101,6 +125,16 @@ func (m *MockTrainingMachineRepository) Update(tm *models.TrainingMachine) error
 	return args.Error(0)
 }
 
+func (m *MockTrainingMachineRepository) UpdateColumns(id uint, expected map[string]interface{}, columns map[string]interface{}) (bool, error) {
+	args := m.Called(id, expected, columns)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTrainingMachineRepository) Touch(id uint, columns map[string]interface{}) error {
+	args := m.Called(id, columns)
+	return args.Error(0)
+}
+
 func (m *MockTrainingMachineRepository) Delete(userId uint, id uint) error {
 	args := m.Called(userId, id)
 	return args.Error(0)
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type TrainingMachineTelemetryRepository interface {
+	Create(telemetry *models.TrainingMachineTelemetry) error
+	GetSince(machineId uint, since time.Time) ([]models.TrainingMachineTelemetry, error)
+	DeleteBefore(machineId uint, before time.Time) error
+}
+
+type trainingMachineTelemetryRepository struct {
+	db *gorm.DB
+}
+
+func NewTrainingMachineTelemetryRepository(db *gorm.DB) TrainingMachineTelemetryRepository {
+	return &trainingMachineTelemetryRepository{db: db}
+}
+
+func (r *trainingMachineTelemetryRepository) Create(telemetry *models.TrainingMachineTelemetry) error {
+	return r.db.Create(telemetry).Error
+}
+
+// GetSince returns reports of the machine taken after since, oldest first.
+func (r *trainingMachineTelemetryRepository) GetSince(machineId uint, since time.Time) ([]models.TrainingMachineTelemetry, error) {
+	var telemetry []models.TrainingMachineTelemetry
+	err := r.db.Where("\"training_machine_id\" = ? AND \"created_at\" > ?", machineId, since).
+		Order("\"created_at\" asc, \"id\" asc").
+		Find(&telemetry).Error
+	if err != nil {
+		return nil, err
+	}
+	return telemetry, nil
+}
+
+// DeleteBefore permanently removes reports of the machine taken before the
+// given time.
+func (r *trainingMachineTelemetryRepository) DeleteBefore(machineId uint, before time.Time) error {
+	return r.db.Unscoped().
+		Where("\"training_machine_id\" = ? AND \"created_at\" < ?", machineId, before).
+		Delete(&models.TrainingMachineTelemetry{}).Error
+}
+
+type MockTrainingMachineTelemetryRepository struct {
+	mock.Mock
+}
+
+func NewMockTrainingMachineTelemetryRepository() *MockTrainingMachineTelemetryRepository {
+	return &MockTrainingMachineTelemetryRepository{}
+}
+
+func (m *MockTrainingMachineTelemetryRepository) Create(telemetry *models.TrainingMachineTelemetry) error {
+	args := m.Called(telemetry)
+	return args.Error(0)
+}
+
+func (m *MockTrainingMachineTelemetryRepository) GetSince(machineId uint, since time.Time) ([]models.TrainingMachineTelemetry, error) {
+	args := m.Called(machineId, since)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingMachineTelemetry), args.Error(1)
+}
+
+func (m *MockTrainingMachineTelemetryRepository) DeleteBefore(machineId uint, before time.Time) error {
+	args := m.Called(machineId, before)
+	return args.Error(0)
+}
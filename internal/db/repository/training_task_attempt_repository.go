@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type TrainingTaskAttemptRepository interface {
+	Create(attempt *models.TrainingTaskAttempt) error
+	GetAll(taskId uint) ([]models.TrainingTaskAttempt, error)
+}
+
+type trainingTaskAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewTrainingTaskAttemptRepository(db *gorm.DB) TrainingTaskAttemptRepository {
+	return &trainingTaskAttemptRepository{db: db}
+}
+
+func (r *trainingTaskAttemptRepository) Create(attempt *models.TrainingTaskAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+func (r *trainingTaskAttemptRepository) GetAll(taskId uint) ([]models.TrainingTaskAttempt, error) {
+	var attempts []models.TrainingTaskAttempt
+	err := r.db.Joins("TrainingMachine").
+		Where("\"training_task_attempts\".\"training_task_id\" = ?", taskId).
+		Order("\"training_task_attempts\".\"retry\" asc").
+		Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+type MockTrainingTaskAttemptRepository struct {
+	mock.Mock
+}
+
+func NewMockTrainingTaskAttemptRepository() *MockTrainingTaskAttemptRepository {
+	return &MockTrainingTaskAttemptRepository{}
+}
+
+func (m *MockTrainingTaskAttemptRepository) Create(attempt *models.TrainingTaskAttempt) error {
+	args := m.Called(attempt)
+	return args.Error(0)
+}
+
+func (m *MockTrainingTaskAttemptRepository) GetAll(taskId uint) ([]models.TrainingTaskAttempt, error) {
+	args := m.Called(taskId)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTaskAttempt), args.Error(1)
+}
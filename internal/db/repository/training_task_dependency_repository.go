@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type TrainingTaskDependencyRepository interface {
+	GetParents(taskId uint) ([]models.TrainingTask, error)
+	GetChildren(taskId uint) ([]models.TrainingTask, error)
+}
+
+type trainingTaskDependencyRepository struct {
+	db *gorm.DB
+}
+
+func NewTrainingTaskDependencyRepository(db *gorm.DB) TrainingTaskDependencyRepository {
+	return &trainingTaskDependencyRepository{db: db}
+}
+
+// related returns tasks joined by the dependency column to the task matched
+// by the other column, oldest first.
+func (r *trainingTaskDependencyRepository) related(joinColumn, matchColumn string, taskId uint) ([]models.TrainingTask, error) {
+	var trainingTasks []models.TrainingTask
+	err := r.db.
+		Joins("JOIN \"training_task_dependencies\" ON \"training_task_dependencies\".\""+joinColumn+"\" = \"training_tasks\".\"id\" AND \"training_task_dependencies\".\"deleted_at\" IS NULL").
+		Where("\"training_task_dependencies\".\""+matchColumn+"\" = ?", taskId).
+		Order("\"training_tasks\".\"created_at\" asc").
+		Find(&trainingTasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return trainingTasks, nil
+}
+
+func (r *trainingTaskDependencyRepository) GetParents(taskId uint) ([]models.TrainingTask, error) {
+	return r.related("parent_id", "training_task_id", taskId)
+}
+
+func (r *trainingTaskDependencyRepository) GetChildren(taskId uint) ([]models.TrainingTask, error) {
+	return r.related("training_task_id", "parent_id", taskId)
+}
+
+type MockTrainingTaskDependencyRepository struct {
+	mock.Mock
+}
+
+func NewMockTrainingTaskDependencyRepository() *MockTrainingTaskDependencyRepository {
+	return &MockTrainingTaskDependencyRepository{}
+}
+
+func (m *MockTrainingTaskDependencyRepository) GetParents(taskId uint) ([]models.TrainingTask, error) {
+	args := m.Called(taskId)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTask), args.Error(1)
+}
+
+func (m *MockTrainingTaskDependencyRepository) GetChildren(taskId uint) ([]models.TrainingTask, error) {
+	args := m.Called(taskId)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTask), args.Error(1)
+}
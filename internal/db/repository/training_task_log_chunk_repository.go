@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TrainingTaskLogChunkRepository interface {
+	Create(chunk *models.TrainingTaskLogChunk) error
+	CreateIfAbsent(chunk *models.TrainingTaskLogChunk) (bool, error)
+	GetSize(taskId uint, retry uint) (int64, error)
+	GetFrom(taskId uint, retry uint, offset int64, limit int) ([]models.TrainingTaskLogChunk, error)
+}
+
+type trainingTaskLogChunkRepository struct {
+	db *gorm.DB
+}
+
+func NewTrainingTaskLogChunkRepository(db *gorm.DB) TrainingTaskLogChunkRepository {
+	return &trainingTaskLogChunkRepository{db: db}
+}
+
+func (r *trainingTaskLogChunkRepository) Create(chunk *models.TrainingTaskLogChunk) error {
+	return r.db.Create(chunk).Error
+}
+
+// CreateIfAbsent stores the chunk unless a chunk of the same task attempt
+// at the same offset exists, it returns false in that case.
+func (r *trainingTaskLogChunkRepository) CreateIfAbsent(chunk *models.TrainingTaskLogChunk) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(chunk)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// GetSize returns the number of bytes streamed during the given task attempt.
+func (r *trainingTaskLogChunkRepository) GetSize(taskId uint, retry uint) (int64, error) {
+	var size int64
+	err := r.db.Model(&models.TrainingTaskLogChunk{}).
+		Select("COALESCE(MAX(\"offset\" + \"size\"), 0)").
+		Where("\"training_task_id\" = ? AND \"retry\" = ?", taskId, retry).
+		Scan(&size).Error
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// GetFrom returns at most limit chunks of the given task attempt containing
+// bytes at or after the offset, ordered by offset.
+func (r *trainingTaskLogChunkRepository) GetFrom(taskId uint, retry uint, offset int64, limit int) ([]models.TrainingTaskLogChunk, error) {
+	var chunks []models.TrainingTaskLogChunk
+	err := r.db.Where("\"training_task_id\" = ? AND \"retry\" = ? AND \"offset\" + \"size\" > ?", taskId, retry, offset).
+		Order("\"offset\" asc").
+		Limit(limit).
+		Find(&chunks).Error
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+type MockTrainingTaskLogChunkRepository struct {
+	mock.Mock
+}
+
+func NewMockTrainingTaskLogChunkRepository() *MockTrainingTaskLogChunkRepository {
+	return &MockTrainingTaskLogChunkRepository{}
+}
+
+func (m *MockTrainingTaskLogChunkRepository) Create(chunk *models.TrainingTaskLogChunk) error {
+	args := m.Called(chunk)
+	return args.Error(0)
+}
+
+func (m *MockTrainingTaskLogChunkRepository) CreateIfAbsent(chunk *models.TrainingTaskLogChunk) (bool, error) {
+	args := m.Called(chunk)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTrainingTaskLogChunkRepository) GetSize(taskId uint, retry uint) (int64, error) {
+	args := m.Called(taskId, retry)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockTrainingTaskLogChunkRepository) GetFrom(taskId uint, retry uint, offset int64, limit int) ([]models.TrainingTaskLogChunk, error) {
+	args := m.Called(taskId, retry, offset, limit)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTaskLogChunk), args.Error(1)
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type TrainingTaskMetricRepository interface {
+	Create(metrics []models.TrainingTaskMetric) error
+	GetAll(taskId uint, retry uint) ([]models.TrainingTaskMetric, error)
+}
+
+type trainingTaskMetricRepository struct {
+	db *gorm.DB
+}
+
+func NewTrainingTaskMetricRepository(db *gorm.DB) TrainingTaskMetricRepository {
+	return &trainingTaskMetricRepository{db: db}
+}
+
+func (r *trainingTaskMetricRepository) Create(metrics []models.TrainingTaskMetric) error {
+	return r.db.Create(&metrics).Error
+}
+
+// GetAll returns metrics of the given task attempt ordered by training step.
+func (r *trainingTaskMetricRepository) GetAll(taskId uint, retry uint) ([]models.TrainingTaskMetric, error) {
+	var metrics []models.TrainingTaskMetric
+	err := r.db.Where("\"training_task_id\" = ? AND \"retry\" = ?", taskId, retry).
+		Order("\"step\" asc, \"id\" asc").
+		Find(&metrics).Error
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+type MockTrainingTaskMetricRepository struct {
+	mock.Mock
+}
+
+func NewMockTrainingTaskMetricRepository() *MockTrainingTaskMetricRepository {
+	return &MockTrainingTaskMetricRepository{}
+}
+
+func (m *MockTrainingTaskMetricRepository) Create(metrics []models.TrainingTaskMetric) error {
+	args := m.Called(metrics)
+	return args.Error(0)
+}
+
+func (m *MockTrainingTaskMetricRepository) GetAll(taskId uint, retry uint) ([]models.TrainingTaskMetric, error) {
+	args := m.Called(taskId, retry)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTaskMetric), args.Error(1)
+}
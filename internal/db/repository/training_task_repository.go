@@ -1,18 +1,60 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/mytkom/AliceTraINT/internal/db/models"
 	"github.com/stretchr/testify/mock"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// TrainingTaskFilter narrows down listed training tasks, zero values match
+// every task.
+type TrainingTaskFilter struct {
+	Status      *models.TrainingTaskStatus
+	FailureCode string
+}
+
+func (f TrainingTaskFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.Status != nil {
+		db = db.Where("\"training_tasks\".\"status\" = ?", *f.Status)
+	}
+	if f.FailureCode != "" {
+		db = db.Where("\"training_tasks\".\"failure_code\" = ?", f.FailureCode)
+	}
+	return db
+}
+
+// QueuedFilter selects queued tasks offered to a training machine, so the
+// scheduling policy orders a bounded set of candidates.
+type QueuedFilter struct {
+	// TrainingMachineId excludes tasks pinned to other machines
+	TrainingMachineId uint
+	// Now excludes tasks whose retry is scheduled later
+	Now time.Time
+	// ByPriority returns tasks of higher priority first, oldest first otherwise
+	ByPriority bool
+	// Limit bounds the number of returned tasks, zero means no limit
+	Limit int
+	// Offset skips the tasks of the previous pages
+	Offset int
+}
+
 type TrainingTaskRepository interface {
 	Create(trainingTask *models.TrainingTask) error
 	GetByID(id uint) (*models.TrainingTask, error)
-	GetAll() ([]models.TrainingTask, error)
-	GetAllUser(userId uint) ([]models.TrainingTask, error)
-	GetFirstQueued() (*models.TrainingTask, error)
+	GetAll(filter TrainingTaskFilter) ([]models.TrainingTask, error)
+	GetAllUser(userId uint, filter TrainingTaskFilter) ([]models.TrainingTask, error)
+	GetFailureCodes() ([]string, error)
+	GetQueued(filter QueuedFilter) ([]models.TrainingTask, error)
+	Claim(id uint, tmID uint, leaseExpiresAt time.Time) (bool, error)
+	GetAssignedSince(since time.Time) ([]models.TrainingTask, error)
+	GetWithExpiredLease(now time.Time) ([]models.TrainingTask, error)
+	GetRunning() ([]models.TrainingTask, error)
 	Update(trainingTask *models.TrainingTask) error
+	UpdateStatus(id uint, from models.TrainingTaskStatus, columns map[string]interface{}) (bool, error)
+	UpdateExpired(id uint, from models.TrainingTaskStatus, now time.Time, columns map[string]interface{}) (bool, error)
 	Delete(userId uint, id uint) error
 }
 
@@ -44,35 +86,173 @@ func (r *trainingTaskRepository) GetByID(id uint) (*models.TrainingTask, error)
 	return &trainingTask, nil
 }
 
-func (r *trainingTaskRepository) GetFirstQueued() (*models.TrainingTask, error) {
-	var trainingTask models.TrainingTask
-	if err := r.withDependencies().Where("\"status\" = ?", models.Queued).Order("\"training_tasks\".\"created_at\" asc").First(&trainingTask).Error; err != nil {
+// GetQueued returns queued tasks whose parent tasks are all completed and
+// which the filter selects. Associations of the tasks are not loaded.
+func (r *trainingTaskRepository) GetQueued(filter QueuedFilter) ([]models.TrainingTask, error) {
+	query := r.db.Where("\"status\" = ?", models.Queued).
+		Where(parentsCompleted, []models.TrainingTaskStatus{models.Completed, models.Uploaded}).
+		Where("\"pinned_training_machine_id\" IS NULL OR \"pinned_training_machine_id\" = ?", filter.TrainingMachineId).
+		Where("\"retry_at\" IS NULL OR \"retry_at\" <= ?", filter.Now)
+	if filter.ByPriority {
+		query = query.Order("\"training_tasks\".\"priority\" desc")
+	}
+	query = query.Order("\"training_tasks\".\"created_at\" asc").Order("\"training_tasks\".\"id\" asc")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit).Offset(filter.Offset)
+	}
+
+	var trainingTasks []models.TrainingTask
+	if err := query.Find(&trainingTasks).Error; err != nil {
 		return nil, err
 	}
+	return trainingTasks, nil
+}
 
-	return &trainingTask, nil
+// parentsCompleted excludes tasks with a parent task which is not completed.
+const parentsCompleted = `NOT EXISTS (SELECT 1 FROM "training_task_dependencies"
+	JOIN "training_tasks" AS "parents" ON "parents"."id" = "training_task_dependencies"."parent_id"
+	WHERE "training_task_dependencies"."training_task_id" = "training_tasks"."id"
+	AND "training_task_dependencies"."deleted_at" IS NULL
+	AND "parents"."status" NOT IN ?)`
+
+// machineActive excludes claims by a training machine which is not active,
+// so a machine drained or disabled while polling gets no further task.
+const machineActive = `EXISTS (SELECT 1 FROM "training_machines"
+	WHERE "training_machines"."id" = ? AND "training_machines"."state" = ?
+	AND "training_machines"."deleted_at" IS NULL)`
+
+// Claim atomically assigns a queued task to the training machine. It returns
+// false if the task has been claimed by another machine in the meantime or
+// the machine is no longer active.
+// On PostgreSQL the row is locked with SKIP LOCKED semantics, so concurrent
+// claims do not wait for each other. SQLite serializes writers, so the
+// conditional update alone is enough there.
+func (r *trainingTaskRepository) Claim(id uint, tmID uint, leaseExpiresAt time.Time) (bool, error) {
+	claimed := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			var lockedIds []uint
+			if err := tx.Model(&models.TrainingTask{}).
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("\"id\" = ? AND \"status\" = ?", id, models.Queued).
+				Pluck("id", &lockedIds).Error; err != nil {
+				return err
+			}
+			if len(lockedIds) == 0 {
+				return nil
+			}
+		}
+
+		result := tx.Model(&models.TrainingTask{}).
+			Where("\"id\" = ? AND \"status\" = ?", id, models.Queued).
+			Where(machineActive, tmID, models.MachineActive).
+			Updates(map[string]interface{}{
+				"status":              models.Training,
+				"training_machine_id": tmID,
+				"lease_expires_at":    leaseExpiresAt,
+				"assigned_at":         time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		claimed = result.RowsAffected == 1
+		return nil
+	})
+
+	return claimed, err
 }
 
-func (r *trainingTaskRepository) GetAll() ([]models.TrainingTask, error) {
+// GetAssignedSince returns tasks which occupied a training machine at any
+// point after the given time, including the ones still running.
+func (r *trainingTaskRepository) GetAssignedSince(since time.Time) ([]models.TrainingTask, error) {
 	var trainingTasks []models.TrainingTask
-	if err := r.withDependencies().Order("\"training_tasks\".\"created_at\" desc").Find(&trainingTasks).Error; err != nil {
+	if err := r.db.Where("\"assigned_at\" IS NOT NULL AND (\"finished_at\" IS NULL OR \"finished_at\" > ?)", since).Find(&trainingTasks).Error; err != nil {
 		return nil, err
 	}
 	return trainingTasks, nil
 }
 
-func (r *trainingTaskRepository) GetAllUser(userId uint) ([]models.TrainingTask, error) {
+// leaseExpired matches tasks whose lease expired, a running task without
+// a lease is never renewed, so it is treated as expired too.
+const leaseExpired = `"lease_expires_at" < ? OR "lease_expires_at" IS NULL`
+
+func (r *trainingTaskRepository) GetWithExpiredLease(now time.Time) ([]models.TrainingTask, error) {
 	var trainingTasks []models.TrainingTask
-	if err := r.withDependencies().Order("\"training_tasks\".\"created_at\" desc").Find(&trainingTasks, r.db.Where(&models.TrainingTask{UserId: userId})).Error; err != nil {
+	if err := r.db.Where("\"status\" IN ?", []models.TrainingTaskStatus{models.Training, models.Benchmarking}).Where(leaseExpired, now).Find(&trainingTasks).Error; err != nil {
 		return nil, err
 	}
 	return trainingTasks, nil
 }
 
+// GetRunning returns tasks held by training machines.
+func (r *trainingTaskRepository) GetRunning() ([]models.TrainingTask, error) {
+	var trainingTasks []models.TrainingTask
+	if err := r.db.Where("\"status\" IN ?", []models.TrainingTaskStatus{models.Training, models.Benchmarking}).Find(&trainingTasks).Error; err != nil {
+		return nil, err
+	}
+	return trainingTasks, nil
+}
+
+func (r *trainingTaskRepository) GetAll(filter TrainingTaskFilter) ([]models.TrainingTask, error) {
+	var trainingTasks []models.TrainingTask
+	if err := filter.apply(r.withDependencies()).Order("\"training_tasks\".\"created_at\" desc").Find(&trainingTasks).Error; err != nil {
+		return nil, err
+	}
+	return trainingTasks, nil
+}
+
+func (r *trainingTaskRepository) GetAllUser(userId uint, filter TrainingTaskFilter) ([]models.TrainingTask, error) {
+	var trainingTasks []models.TrainingTask
+	if err := filter.apply(r.withDependencies()).Order("\"training_tasks\".\"created_at\" desc").Find(&trainingTasks, r.db.Where(&models.TrainingTask{UserId: userId})).Error; err != nil {
+		return nil, err
+	}
+	return trainingTasks, nil
+}
+
+// GetFailureCodes returns distinct failure codes of failed tasks.
+func (r *trainingTaskRepository) GetFailureCodes() ([]string, error) {
+	var codes []string
+	if err := r.db.Model(&models.TrainingTask{}).
+		Where("\"failure_code\" <> ''").
+		Distinct("failure_code").
+		Order("\"failure_code\"").
+		Pluck("failure_code", &codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
 func (r *trainingTaskRepository) Update(trainingTask *models.TrainingTask) error {
 	return r.db.Save(trainingTask).Error
 }
 
+// UpdateStatus writes only the given columns of the task and only if it is
+// still in the status it was read in. It returns false when the status was
+// changed in the meantime, so e.g. a cancellation is never overwritten.
+func (r *trainingTaskRepository) UpdateStatus(id uint, from models.TrainingTaskStatus, columns map[string]interface{}) (bool, error) {
+	result := r.db.Model(&models.TrainingTask{}).
+		Where("\"id\" = ? AND \"status\" = ?", id, from).
+		Updates(columns)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// UpdateExpired is UpdateStatus of a running task whose lease expired, it
+// returns false when the machine renewed the lease in the meantime.
+func (r *trainingTaskRepository) UpdateExpired(id uint, from models.TrainingTaskStatus, now time.Time, columns map[string]interface{}) (bool, error) {
+	result := r.db.Model(&models.TrainingTask{}).
+		Where("\"id\" = ? AND \"status\" = ?", id, from).
+		Where(leaseExpired, now).
+		Updates(columns)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
 func (r *trainingTaskRepository) Delete(userId uint, id uint) error {
 	return r.db.Where("\"user_id\" = ?", userId).Delete(&models.TrainingTask{}, id).Error
 }
@@ -90,8 +270,8 @@ func (m *MockTrainingTaskRepository) Create(trainingTask *models.TrainingTask) e
 	return args.Error(0)
 }
 
-func (m *MockTrainingTaskRepository) GetAll() ([]models.TrainingTask, error) {
-	args := m.Called()
+func (m *MockTrainingTaskRepository) GetAll(filter TrainingTaskFilter) ([]models.TrainingTask, error) {
+	args := m.Called(filter)
 
 	if args.Error(1) != nil {
 		return nil, args.Error(1)
@@ -100,8 +280,8 @@ func (m *MockTrainingTaskRepository) GetAll() ([]models.TrainingTask, error) {
 	return args.Get(0).([]models.TrainingTask), args.Error(1)
 }
 
-func (m *MockTrainingTaskRepository) GetAllUser(userId uint) ([]models.TrainingTask, error) {
-	args := m.Called(userId)
+func (m *MockTrainingTaskRepository) GetAllUser(userId uint, filter TrainingTaskFilter) ([]models.TrainingTask, error) {
+	args := m.Called(userId, filter)
 
 	if args.Error(1) != nil {
 		return nil, args.Error(1)
@@ -110,6 +290,16 @@ func (m *MockTrainingTaskRepository) GetAllUser(userId uint) ([]models.TrainingT
 	return args.Get(0).([]models.TrainingTask), args.Error(1)
 }
 
+func (m *MockTrainingTaskRepository) GetFailureCodes() ([]string, error) {
+	args := m.Called()
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]string), args.Error(1)
+}
+
 func (m *MockTrainingTaskRepository) GetByID(id uint) (*models.TrainingTask, error) {
 	args := m.Called(id)
 
@@ -119,14 +309,50 @@ func (m *MockTrainingTaskRepository) GetByID(id uint) (*models.TrainingTask, err
 
 	return args.Get(0).(*models.TrainingTask), args.Error(1)
 }
-func (m *MockTrainingTaskRepository) GetFirstQueued() (*models.TrainingTask, error) {
+
+func (m *MockTrainingTaskRepository) GetQueued(filter QueuedFilter) ([]models.TrainingTask, error) {
+	args := m.Called(filter)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTask), args.Error(1)
+}
+
+func (m *MockTrainingTaskRepository) Claim(id uint, tmID uint, leaseExpiresAt time.Time) (bool, error) {
+	args := m.Called(id, tmID, leaseExpiresAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTrainingTaskRepository) GetAssignedSince(since time.Time) ([]models.TrainingTask, error) {
+	args := m.Called(since)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTask), args.Error(1)
+}
+
+func (m *MockTrainingTaskRepository) GetWithExpiredLease(now time.Time) ([]models.TrainingTask, error) {
+	args := m.Called(now)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTask), args.Error(1)
+}
+
+func (m *MockTrainingTaskRepository) GetRunning() ([]models.TrainingTask, error) {
 	args := m.Called()
 
 	if args.Error(1) != nil {
 		return nil, args.Error(1)
 	}
 
-	return args.Get(0).(*models.TrainingTask), args.Error(1)
+	return args.Get(0).([]models.TrainingTask), args.Error(1)
 }
 
 func (m *MockTrainingTaskRepository) Update(trainingTask *models.TrainingTask) error {
@@ -134,6 +360,16 @@ func (m *MockTrainingTaskRepository) Update(trainingTask *models.TrainingTask) e
 	return args.Error(0)
 }
 
+func (m *MockTrainingTaskRepository) UpdateStatus(id uint, from models.TrainingTaskStatus, columns map[string]interface{}) (bool, error) {
+	args := m.Called(id, from, columns)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTrainingTaskRepository) UpdateExpired(id uint, from models.TrainingTaskStatus, now time.Time, columns map[string]interface{}) (bool, error) {
+	args := m.Called(id, from, now, columns)
+	return args.Bool(0), args.Error(1)
+}
+
 func (m *MockTrainingTaskRepository) Delete(userId uint, id uint) error {
 	args := m.Called(userId, id)
 	return args.Error(0)
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type TrainingTaskStatusEventRepository interface {
+	Create(event *models.TrainingTaskStatusEvent) error
+	GetAll(taskId uint) ([]models.TrainingTaskStatusEvent, error)
+	GetSince(since time.Time) ([]models.TrainingTaskStatusEvent, error)
+}
+
+type trainingTaskStatusEventRepository struct {
+	db *gorm.DB
+}
+
+func NewTrainingTaskStatusEventRepository(db *gorm.DB) TrainingTaskStatusEventRepository {
+	return &trainingTaskStatusEventRepository{db: db}
+}
+
+func (r *trainingTaskStatusEventRepository) Create(event *models.TrainingTaskStatusEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *trainingTaskStatusEventRepository) GetAll(taskId uint) ([]models.TrainingTaskStatusEvent, error) {
+	var events []models.TrainingTaskStatusEvent
+	err := r.db.Joins("User").Joins("TrainingMachine").
+		Where("\"training_task_status_events\".\"training_task_id\" = ?", taskId).
+		Order("\"training_task_status_events\".\"created_at\" asc, \"training_task_status_events\".\"id\" asc").
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetSince returns events recorded since the given time grouped by training task.
+func (r *trainingTaskStatusEventRepository) GetSince(since time.Time) ([]models.TrainingTaskStatusEvent, error) {
+	var events []models.TrainingTaskStatusEvent
+	err := r.db.Where("\"created_at\" >= ?", since).
+		Order("\"training_task_id\" asc, \"created_at\" asc, \"id\" asc").
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+type MockTrainingTaskStatusEventRepository struct {
+	mock.Mock
+}
+
+func NewMockTrainingTaskStatusEventRepository() *MockTrainingTaskStatusEventRepository {
+	return &MockTrainingTaskStatusEventRepository{}
+}
+
+func (m *MockTrainingTaskStatusEventRepository) Create(event *models.TrainingTaskStatusEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
+
+func (m *MockTrainingTaskStatusEventRepository) GetAll(taskId uint) ([]models.TrainingTaskStatusEvent, error) {
+	args := m.Called(taskId)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTaskStatusEvent), args.Error(1)
+}
+
+func (m *MockTrainingTaskStatusEventRepository) GetSince(since time.Time) ([]models.TrainingTaskStatusEvent, error) {
+	args := m.Called(since)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.TrainingTaskStatusEvent), args.Error(1)
+}
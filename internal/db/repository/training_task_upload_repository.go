@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type TrainingTaskUploadRepository interface {
+	Create(upload *models.TrainingTaskUpload) error
+	GetByID(id uint) (*models.TrainingTaskUpload, error)
+	GetStale(before time.Time) ([]models.TrainingTaskUpload, error)
+	Update(upload *models.TrainingTaskUpload) error
+	UpdateReceived(id uint, expected, received int64) (bool, error)
+	Touch(id uint) (bool, error)
+	Delete(id uint) error
+	DeleteStale(id uint, before time.Time) (bool, error)
+}
+
+type trainingTaskUploadRepository struct {
+	db *gorm.DB
+}
+
+func NewTrainingTaskUploadRepository(db *gorm.DB) TrainingTaskUploadRepository {
+	return &trainingTaskUploadRepository{db: db}
+}
+
+func (r *trainingTaskUploadRepository) Create(upload *models.TrainingTaskUpload) error {
+	return r.db.Create(upload).Error
+}
+
+func (r *trainingTaskUploadRepository) GetByID(id uint) (*models.TrainingTaskUpload, error) {
+	var upload models.TrainingTaskUpload
+	if err := r.db.First(&upload, id).Error; err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// GetStale returns uploads which received no chunk since the given time.
+func (r *trainingTaskUploadRepository) GetStale(before time.Time) ([]models.TrainingTaskUpload, error) {
+	var uploads []models.TrainingTaskUpload
+	if err := r.db.Where("\"updated_at\" < ?", before).Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+func (r *trainingTaskUploadRepository) Update(upload *models.TrainingTaskUpload) error {
+	return r.db.Save(upload).Error
+}
+
+// UpdateReceived moves the offset of the upload only if it still is the
+// expected one, it returns false when a concurrent chunk moved it first.
+func (r *trainingTaskUploadRepository) UpdateReceived(id uint, expected, received int64) (bool, error) {
+	result := r.db.Model(&models.TrainingTaskUpload{}).
+		Where("\"id\" = ? AND \"received\" = ?", id, expected).
+		Update("received", received)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Touch marks the upload as used now, so it does not expire. It returns
+// false when the upload has been deleted.
+func (r *trainingTaskUploadRepository) Touch(id uint) (bool, error) {
+	result := r.db.Model(&models.TrainingTaskUpload{}).
+		Where("\"id\" = ?", id).
+		Update("updated_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *trainingTaskUploadRepository) Delete(id uint) error {
+	return r.db.Delete(&models.TrainingTaskUpload{}, id).Error
+}
+
+// DeleteStale deletes the upload only if it still was not used since the
+// given time, it returns false when it was used in the meantime.
+func (r *trainingTaskUploadRepository) DeleteStale(id uint, before time.Time) (bool, error) {
+	result := r.db.Where("\"updated_at\" < ?", before).Delete(&models.TrainingTaskUpload{}, id)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+type MockTrainingTaskUploadRepository struct {
+	mock.Mock
+}
+
+func NewMockTrainingTaskUploadRepository() *MockTrainingTaskUploadRepository {
+	return &MockTrainingTaskUploadRepository{}
+}
+
+func (m *MockTrainingTaskUploadRepository) Create(upload *models.TrainingTaskUpload) error {
+	args := m.Called(upload)
+	return args.Error(0)
+}
+
+func (m *MockTrainingTaskUploadRepository) GetByID(id uint) (*models.TrainingTaskUpload, error) {
+	args := m.Called(id)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.TrainingTaskUpload), args.Error(1)
+}
+
+func (m *MockTrainingTaskUploadRepository) GetStale(before time.Time) ([]models.TrainingTaskUpload, error) {
+	args := m.Called(before)
+	return args.Get(0).([]models.TrainingTaskUpload), args.Error(1)
+}
+
+func (m *MockTrainingTaskUploadRepository) Update(upload *models.TrainingTaskUpload) error {
+	args := m.Called(upload)
+	return args.Error(0)
+}
+
+func (m *MockTrainingTaskUploadRepository) UpdateReceived(id uint, expected, received int64) (bool, error) {
+	args := m.Called(id, expected, received)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTrainingTaskUploadRepository) Touch(id uint) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTrainingTaskUploadRepository) Delete(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockTrainingTaskUploadRepository) DeleteStale(id uint, before time.Time) (bool, error) {
+	args := m.Called(id, before)
+	return args.Bool(0), args.Error(1)
+}
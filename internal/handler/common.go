@@ -1,8 +1,10 @@
 package handler
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/mytkom/AliceTraINT/internal/service"
 	"github.com/mytkom/AliceTraINT/internal/utils"
@@ -39,6 +41,10 @@ func handleServiceError(w http.ResponseWriter, r *http.Request, err error) {
 		writeError(w, r, http.StatusNotFound, err.Error(), err)
 	case *service.ErrHandlerValidation:
 		writeError(w, r, http.StatusUnprocessableEntity, err.Error(), err)
+	case *service.ErrHandlerConflict:
+		writeError(w, r, http.StatusConflict, err.Error(), err)
+	case *service.ErrHandlerGone:
+		writeError(w, r, http.StatusGone, err.Error(), err)
 	case *service.ErrExternalServiceTimeout:
 		writeError(w, r, http.StatusServiceUnavailable, err.Error(), err)
 	default:
@@ -46,3 +52,69 @@ func handleServiceError(w http.ResponseWriter, r *http.Request, err error) {
 		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
 	}
 }
+
+// parseOptionalID decodes an optional id sent by forms either as a number
+// or as a string, where null and empty string mean no id.
+func parseOptionalID(raw json.RawMessage) (*uint, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		text = string(raw)
+	}
+	if text == "" {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseUint(text, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	result := uint(id)
+	return &result, nil
+}
+
+// parseIDList decodes ids sent by forms, a multiple select sends a single
+// value when one option is selected and an array otherwise.
+func parseIDList(raw json.RawMessage) ([]uint, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		items = []json.RawMessage{raw}
+	}
+
+	var ids []uint
+	for _, item := range items {
+		id, err := parseOptionalID(item)
+		if err != nil {
+			return nil, err
+		}
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	}
+	return ids, nil
+}
+
+// parseStringList decodes values of a multiple select, which sends a single
+// value when one option is selected and an array otherwise.
+func parseStringList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var values []string
+	if err := json.Unmarshal(raw, &values); err == nil {
+		return values, nil
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, nil
+	}
+	return []string{value}, nil
+}
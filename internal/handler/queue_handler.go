@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mytkom/AliceTraINT/internal/db/models"
 	"github.com/mytkom/AliceTraINT/internal/environment"
@@ -32,6 +35,19 @@ func (qh *QueueHandler) parseId(r *http.Request) (uint, error) {
 	return uint(id), nil
 }
 
+// authorizeMachine authenticates the machine by a verified client
+// certificate, a session token in the Authorization header, or by its
+// secret key in the Secret-Id header.
+func (qh *QueueHandler) authorizeMachine(r *http.Request, tmId uint) (*models.TrainingMachine, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return qh.QueueService.AuthorizeMachineCertificate(jalien.SubjectDN(r.TLS.VerifiedChains[0][0]), tmId)
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return qh.QueueService.AuthorizeMachineSession(token, tmId)
+	}
+	return qh.QueueService.AuthorizeTrainingMachine(r.Header.Get("Secret-Id"), tmId)
+}
+
 func (qh *QueueHandler) trainingMachineFromPath(r *http.Request) (*models.TrainingMachine, *models.TrainingTask, error) {
 	ttId, err := qh.parseId(r)
 	if err != nil {
@@ -47,7 +63,7 @@ func (qh *QueueHandler) trainingMachineFromPath(r *http.Request) (*models.Traini
 		return nil, nil, errors.New(errMsgUnauthorizedMachine)
 	}
 
-	tm, err := qh.QueueService.AuthorizeTrainingMachine(r.Header.Get("Secret-Id"), *tt.TrainingMachineId)
+	tm, err := qh.authorizeMachine(r, *tt.TrainingMachineId)
 	if err != nil {
 		return nil, nil, errors.New(errMsgUnauthorizedMachine)
 	}
@@ -58,6 +74,10 @@ func (qh *QueueHandler) trainingMachineFromPath(r *http.Request) (*models.Traini
 func (qh *QueueHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 	type Body struct {
 		Status models.TrainingTaskStatus
+		// optional details of Failed status
+		ErrorCode    string
+		ErrorMessage string
+		LogExcerpt   string
 	}
 
 	_, tt, err := qh.trainingMachineFromPath(r)
@@ -73,12 +93,54 @@ func (qh *QueueHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := qh.QueueService.UpdateTrainingTaskStatus(tt.ID, bodyDecoded.Status); err != nil {
-		writeError(w, r, http.StatusUnprocessableEntity, "cannot update training task status", err)
+	var failure *models.TaskFailure
+	if bodyDecoded.ErrorCode != "" || bodyDecoded.ErrorMessage != "" || bodyDecoded.LogExcerpt != "" {
+		failure = &models.TaskFailure{
+			Code:       bodyDecoded.ErrorCode,
+			Message:    bodyDecoded.ErrorMessage,
+			LogExcerpt: bodyDecoded.LogExcerpt,
+		}
+	}
+
+	tt, err = qh.QueueService.UpdateTrainingTaskStatus(tt.ID, bodyDecoded.Status, failure)
+	if err != nil {
+		handleServiceError(w, r, err)
 		return
 	}
 
+	// Abort tells the machine that the task was cancelled and should be stopped
+	response := struct {
+		Abort bool
+	}{
+		Abort: tt.Status.IsCancelled(),
+	}
+
 	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot encode response", err)
+		return
+	}
+}
+
+// CreateSession exchanges the secret key of the machine for a session token
+// used in the Authorization header of further requests.
+func (qh *QueueHandler) CreateSession(w http.ResponseWriter, r *http.Request) {
+	tmId, err := qh.parseId(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad training machine id", err)
+		return
+	}
+
+	session, err := qh.QueueService.CreateMachineSession(r.Header.Get("Secret-Id"), tmId)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUnauthorizedMachine, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(session); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot encode response", err)
+	}
 }
 
 func (qh *QueueHandler) QueryTask(w http.ResponseWriter, r *http.Request) {
@@ -88,26 +150,341 @@ func (qh *QueueHandler) QueryTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tm, err := qh.QueueService.AuthorizeTrainingMachine(r.Header.Get("Secret-Id"), uint(tmId))
+	tm, err := qh.authorizeMachine(r, tmId)
 	if err != nil {
 		writeError(w, r, http.StatusUnauthorized, errMsgUnauthorizedMachine, err)
 		return
 	}
 
-	tt, err := qh.QueueService.AssignTaskToMachine(tm.ID)
+	// optional long polling, machine waits up to the given number of seconds
+	var wait uint64
+	if waitStr := r.URL.Query().Get("wait"); waitStr != "" {
+		wait, err = strconv.ParseUint(waitStr, 10, 32)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "bad wait duration", err)
+			return
+		}
+	}
+
+	// agents keep polling while the machine is drained or disabled
+	if !tm.State.AcceptsTasks() {
+		writeError(w, r, http.StatusConflict, fmt.Sprintf("training machine is %s", tm.State), nil)
+		return
+	}
+
+	var tt *models.TrainingTask
+	if wait > 0 {
+		tt, err = qh.QueueService.WaitForTask(r.Context(), tm, time.Duration(wait)*time.Second)
+	} else {
+		tt, err = qh.QueueService.AssignTaskToMachine(tm)
+	}
 	if err != nil {
 		writeError(w, r, http.StatusNotFound, "no training task available", err)
 		return
 	}
 
+	parentResults, err := qh.QueueService.GetParentResults(tt.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot list results of parent tasks", err)
+		return
+	}
+
+	// agents terminate the trainer themselves after the maximum wall time,
+	// zero means no limit
 	response := struct {
-		ID            uint
-		AODFiles      []jalien.AODFile
-		Configuration interface{}
+		ID                 uint
+		AODFiles           []jalien.AODFile
+		Configuration      interface{}
+		LeaseExpiresAt     *time.Time
+		MaxWallTimeSeconds uint
+		ParentResults      []service.ParentResult
+		ExpectedOnnx       []string
 	}{
-		ID:            tt.ID,
-		AODFiles:      tt.TrainingDataset.AODFiles,
-		Configuration: tt.Configuration,
+		ID:                 tt.ID,
+		AODFiles:           tt.TrainingDataset.AODFiles,
+		Configuration:      tt.Configuration,
+		LeaseExpiresAt:     tt.LeaseExpiresAt,
+		MaxWallTimeSeconds: uint(qh.QueueService.MaxWallTime(tt) / time.Second),
+		ParentResults:      parentResults,
+		ExpectedOnnx:       qh.QueueService.ExpectedOnnx(),
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot encode response", err)
+		return
+	}
+}
+
+func (qh *QueueHandler) UpdateLabels(w http.ResponseWriter, r *http.Request) {
+	type Body struct {
+		Labels map[string]string
+	}
+
+	tmId, err := qh.parseId(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad training machine id", err)
+		return
+	}
+
+	tm, err := qh.authorizeMachine(r, tmId)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUnauthorizedMachine, err)
+		return
+	}
+
+	var bodyDecoded Body
+	if err := json.NewDecoder(r.Body).Decode(&bodyDecoded); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "bad labels format", err)
+		return
+	}
+
+	if err := qh.QueueService.UpdateMachineLabels(tm, bodyDecoded.Labels); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// machineStateResponse tells the training machine its state and whether it
+// still runs any task.
+type machineStateResponse struct {
+	State          string
+	Idle           bool
+	Drained        bool
+	RunningTaskIds []uint
+}
+
+func newMachineStateResponse(activity *service.MachineActivity) machineStateResponse {
+	response := machineStateResponse{
+		State:          activity.State.String(),
+		Idle:           activity.IsIdle(),
+		Drained:        activity.IsDrained(),
+		RunningTaskIds: []uint{},
+	}
+	for _, tt := range activity.RunningTasks {
+		response.RunningTaskIds = append(response.RunningTaskIds, tt.ID)
+	}
+	return response
+}
+
+func (qh *QueueHandler) GetState(w http.ResponseWriter, r *http.Request) {
+	tmId, err := qh.parseId(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad training machine id", err)
+		return
+	}
+
+	tm, err := qh.authorizeMachine(r, tmId)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUnauthorizedMachine, err)
+		return
+	}
+
+	activity, err := qh.QueueService.GetMachineActivity(tm)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(newMachineStateResponse(activity)); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot encode response", err)
+		return
+	}
+}
+
+// UpdateState lets the training machine drain or disable itself using its
+// own credentials, e.g. from a maintenance script.
+func (qh *QueueHandler) UpdateState(w http.ResponseWriter, r *http.Request) {
+	type Body struct {
+		State string
+	}
+
+	tmId, err := qh.parseId(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad training machine id", err)
+		return
+	}
+
+	tm, err := qh.authorizeMachine(r, tmId)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUnauthorizedMachine, err)
+		return
+	}
+
+	var bodyDecoded Body
+	if err := json.NewDecoder(r.Body).Decode(&bodyDecoded); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "bad state format", err)
+		return
+	}
+
+	state, err := service.ParseMachineState(bodyDecoded.State)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	activity, err := qh.QueueService.SetMachineState(tm, state)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(newMachineStateResponse(activity)); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot encode response", err)
+		return
+	}
+}
+
+// ReportTelemetry records a health report of the training machine, it is
+// sent periodically regardless of running tasks.
+func (qh *QueueHandler) ReportTelemetry(w http.ResponseWriter, r *http.Request) {
+	type Body struct {
+		CPUPercent       float64
+		GPUPercent       *float64
+		MemoryUsedBytes  uint64
+		MemoryTotalBytes uint64
+		DiskFreeBytes    uint64
+		AgentVersion     string
+		DriverVersion    string
+	}
+
+	tmId, err := qh.parseId(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad training machine id", err)
+		return
+	}
+
+	tm, err := qh.authorizeMachine(r, tmId)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUnauthorizedMachine, err)
+		return
+	}
+
+	var bodyDecoded Body
+	if err := json.NewDecoder(r.Body).Decode(&bodyDecoded); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "bad telemetry format", err)
+		return
+	}
+
+	err = qh.QueueService.RecordTelemetry(tm, &models.TrainingMachineTelemetry{
+		CPUPercent:       bodyDecoded.CPUPercent,
+		GPUPercent:       bodyDecoded.GPUPercent,
+		MemoryUsedBytes:  bodyDecoded.MemoryUsedBytes,
+		MemoryTotalBytes: bodyDecoded.MemoryTotalBytes,
+		DiskFreeBytes:    bodyDecoded.DiskFreeBytes,
+		AgentVersion:     bodyDecoded.AgentVersion,
+		DriverVersion:    bodyDecoded.DriverVersion,
+	})
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (qh *QueueHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	_, tt, err := qh.trainingMachineFromPath(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	}
+
+	tt, err = qh.QueueService.RenewLease(tt.ID)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	response := struct {
+		LeaseExpiresAt *time.Time
+		Abort          bool
+	}{
+		LeaseExpiresAt: tt.LeaseExpiresAt,
+		Abort:          tt.Status.IsCancelled(),
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot encode response", err)
+		return
+	}
+}
+
+func (qh *QueueHandler) ReportMetrics(w http.ResponseWriter, r *http.Request) {
+	type Body struct {
+		Points []service.MetricPoint
+	}
+
+	_, tt, err := qh.trainingMachineFromPath(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	}
+
+	var bodyDecoded Body
+	if err := json.NewDecoder(r.Body).Decode(&bodyDecoded); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "bad metrics format", err)
+		return
+	}
+
+	tt, err = qh.QueueService.RecordMetrics(tt.ID, bodyDecoded.Points)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Abort bool
+	}{
+		Abort: tt.Status.IsCancelled(),
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot encode response", err)
+		return
+	}
+}
+
+// AppendLog accepts a raw log chunk starting at the offset given in the query.
+// The response carries the log size, which is the offset of the next chunk;
+// an empty chunk can be sent to learn it when resuming.
+func (qh *QueueHandler) AppendLog(w http.ResponseWriter, r *http.Request) {
+	_, tt, err := qh.trainingMachineFromPath(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad log offset", err)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, models.MaxLogChunkSize+1))
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "cannot read log chunk", err)
+		return
+	}
+
+	tt, size, err := qh.QueueService.AppendLog(tt.ID, offset, data)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Offset int64
+		Abort  bool
+	}{
+		Offset: size,
+		Abort:  tt.Status.IsCancelled(),
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -144,8 +521,14 @@ func (qh *QueueHandler) CreateTrainingTaskResult(w http.ResponseWriter, r *http.
 		r.Form.Get("name"),
 		r.Form.Get("description"),
 		r.Form.Get("file-type"),
+		r.Form.Get("sha256"),
 	)
 	if err != nil {
+		var validationErr *service.ErrHandlerValidation
+		if errors.As(err, &validationErr) {
+			handleServiceError(w, r, validationErr)
+			return
+		}
 		writeError(w, r, http.StatusUnprocessableEntity, "cannot create training task result", err)
 		return
 	}
@@ -158,13 +541,209 @@ func (qh *QueueHandler) CreateTrainingTaskResult(w http.ResponseWriter, r *http.
 	}
 }
 
-func InitQueueRoutes(mux *http.ServeMux, env *environment.Env, fileService service.IFileService, hasher service.Hasher) {
+func (qh *QueueHandler) parseUploadId(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(r.PathValue("uploadId"), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing upload id: %s", err.Error())
+	}
+
+	return uint(id), nil
+}
+
+// writeUploadState tells the machine how many bytes of the upload are stored,
+// Offset is where the next chunk starts.
+func writeUploadState(w http.ResponseWriter, r *http.Request, status int, upload *models.TrainingTaskUpload) {
+	response := struct {
+		ID     uint
+		Offset int64
+		Size   int64
+	}{
+		ID:     upload.ID,
+		Offset: upload.Received,
+		Size:   upload.Size,
+	}
+
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cannot encode response", err)
+		return
+	}
+}
+
+func (qh *QueueHandler) InitiateUpload(w http.ResponseWriter, r *http.Request) {
+	type Body struct {
+		Name        string
+		Description string
+		Type        models.TrainingTaskResultType
+		FileName    string
+		Size        int64
+	}
+
+	_, tt, err := qh.trainingMachineFromPath(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	}
+
+	var bodyDecoded Body
+	if err := json.NewDecoder(r.Body).Decode(&bodyDecoded); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "bad upload format", err)
+		return
+	}
+
+	upload := &models.TrainingTaskUpload{
+		Name:        bodyDecoded.Name,
+		Description: bodyDecoded.Description,
+		Type:        bodyDecoded.Type,
+		FileName:    bodyDecoded.FileName,
+		Size:        bodyDecoded.Size,
+	}
+	if err := qh.QueueService.InitiateUpload(tt.ID, upload); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	writeUploadState(w, r, http.StatusCreated, upload)
+}
+
+func (qh *QueueHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	_, tt, err := qh.trainingMachineFromPath(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	}
+
+	uploadId, err := qh.parseUploadId(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad upload id", err)
+		return
+	}
+
+	upload, err := qh.QueueService.GetUpload(tt.ID, uploadId)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	writeUploadState(w, r, http.StatusOK, upload)
+}
+
+// WriteUploadChunk streams the raw request body into the upload at the offset
+// given in the query.
+func (qh *QueueHandler) WriteUploadChunk(w http.ResponseWriter, r *http.Request) {
+	_, tt, err := qh.trainingMachineFromPath(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	}
+
+	uploadId, err := qh.parseUploadId(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad upload id", err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad upload offset", err)
+		return
+	}
+
+	upload, err := qh.QueueService.WriteUploadChunk(tt.ID, uploadId, offset, r.Body)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	writeUploadState(w, r, http.StatusOK, upload)
+}
+
+func (qh *QueueHandler) FinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	type Body struct {
+		SHA256 string
+	}
+
+	_, tt, err := qh.trainingMachineFromPath(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	}
+
+	uploadId, err := qh.parseUploadId(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad upload id", err)
+		return
+	}
+
+	var bodyDecoded Body
+	if err := json.NewDecoder(r.Body).Decode(&bodyDecoded); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "bad checksum format", err)
+		return
+	}
+
+	ttr, err := qh.QueueService.FinalizeUpload(tt.ID, uploadId, bodyDecoded.SHA256)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(ttr); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+		return
+	}
+}
+
+// DownloadParentResult streams a result file of one of the task's parents.
+func (qh *QueueHandler) DownloadParentResult(w http.ResponseWriter, r *http.Request) {
+	_, tt, err := qh.trainingMachineFromPath(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	}
+
+	resultId, err := strconv.ParseUint(r.PathValue("resultId"), 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "bad result id", err)
+		return
+	}
+
+	result, f, closeFile, err := qh.QueueService.OpenParentResult(tt.ID, uint(resultId))
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+	defer closeFile(f)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatUint(result.File.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	//nolint:errcheck
+	io.Copy(w, f)
+}
+
+// InitQueueRoutes shares the queue service with background jobs, so session
+// tokens are signed by the same key everywhere in the process.
+func InitQueueRoutes(mux *http.ServeMux, env *environment.Env, queueService *service.QueueService) {
 	qh := &QueueHandler{
 		Env:          env,
-		QueueService: service.NewQueueService(fileService, env.RepositoryContext, hasher),
+		QueueService: queueService,
 	}
 
 	mux.Handle("POST /training-tasks/{id}/status", http.HandlerFunc(qh.UpdateStatus))
+	mux.Handle("POST /training-tasks/{id}/heartbeat", http.HandlerFunc(qh.Heartbeat))
+	mux.Handle("POST /training-tasks/{id}/metrics", http.HandlerFunc(qh.ReportMetrics))
+	mux.Handle("POST /training-tasks/{id}/logs", http.HandlerFunc(qh.AppendLog))
+	mux.Handle("POST /training-machines/{id}/session", http.HandlerFunc(qh.CreateSession))
 	mux.Handle("GET /training-machines/{id}/training-task", http.HandlerFunc(qh.QueryTask))
+	mux.Handle("PUT /training-machines/{id}/labels", http.HandlerFunc(qh.UpdateLabels))
+	mux.Handle("GET /training-machines/{id}/state", http.HandlerFunc(qh.GetState))
+	mux.Handle("PUT /training-machines/{id}/state", http.HandlerFunc(qh.UpdateState))
+	mux.Handle("POST /training-machines/{id}/telemetry", http.HandlerFunc(qh.ReportTelemetry))
 	mux.Handle("POST /training-tasks/{id}/training-task-results", http.HandlerFunc(qh.CreateTrainingTaskResult))
+	mux.Handle("POST /training-tasks/{id}/uploads", http.HandlerFunc(qh.InitiateUpload))
+	mux.Handle("GET /training-tasks/{id}/uploads/{uploadId}", http.HandlerFunc(qh.GetUpload))
+	mux.Handle("PUT /training-tasks/{id}/uploads/{uploadId}", http.HandlerFunc(qh.WriteUploadChunk))
+	mux.Handle("POST /training-tasks/{id}/uploads/{uploadId}/finalize", http.HandlerFunc(qh.FinalizeUpload))
+	mux.Handle("GET /training-tasks/{id}/parent-results/{resultId}", http.HandlerFunc(qh.DownloadParentResult))
 }
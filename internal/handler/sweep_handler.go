@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/environment"
+	"github.com/mytkom/AliceTraINT/internal/middleware"
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/mytkom/AliceTraINT/internal/utils"
+)
+
+type SweepHandler struct {
+	*environment.Env
+	Service service.ISweepService
+}
+
+func NewSweepHandler(env *environment.Env, sweepService service.ISweepService) *SweepHandler {
+	return &SweepHandler{
+		Env:     env,
+		Service: sweepService,
+	}
+}
+
+func (h *SweepHandler) Index(w http.ResponseWriter, r *http.Request) {
+	type TemplateData struct {
+		Title string
+	}
+
+	err := h.ExecuteTemplate(w, "sweeps_index", TemplateData{
+		Title: "Hyperparameter Sweeps",
+	})
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+		return
+	}
+}
+
+func (h *SweepHandler) List(w http.ResponseWriter, r *http.Request) {
+	type TemplateData struct {
+		Sweeps []models.Sweep
+	}
+
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+
+	sweeps, err := h.Service.GetAll(user.ID, utils.IsUserScoped(r))
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	err = h.ExecuteTemplate(w, "sweeps_list", TemplateData{
+		Sweeps: sweeps,
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+	}
+}
+
+// Show renders tasks of the sweep ranked by the metric and order given
+// in the query, the sweep defaults are used when they are missing.
+func (h *SweepHandler) Show(w http.ResponseWriter, r *http.Request) {
+	type TemplateData struct {
+		Title   string
+		Ranking *service.SweepRanking
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid sweep id", err)
+		return
+	}
+
+	var maximize *bool
+	switch order := r.URL.Query().Get("order"); order {
+	case "":
+	case "min", "max":
+		value := order == "max"
+		maximize = &value
+	default:
+		writeError(w, r, http.StatusUnprocessableEntity, "order must be min or max", nil)
+		return
+	}
+
+	ranking, err := h.Service.GetRanking(uint(id), r.URL.Query().Get("metric"), maximize)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	err = h.ExecuteTemplate(w, "sweeps_show", TemplateData{
+		Title:   "Hyperparameter Sweep",
+		Ranking: ranking,
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+	}
+}
+
+func (h *SweepHandler) New(w http.ResponseWriter, r *http.Request) {
+	type TemplateData struct {
+		Title            string
+		TrainingDatasets []models.TrainingDataset
+		FieldConfigs     service.NNFieldConfigs
+		MaxTasks         uint
+	}
+
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+
+	helpers, err := h.Service.GetHelpers(user.ID)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	err = h.ExecuteTemplate(w, "sweeps_new", TemplateData{
+		Title:            "Create New Hyperparameter Sweep!",
+		TrainingDatasets: helpers.TrainingDatasets,
+		FieldConfigs:     helpers.FieldConfigs,
+		MaxTasks:         helpers.MaxTasks,
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+	}
+}
+
+func (h *SweepHandler) Create(w http.ResponseWriter, r *http.Request) {
+	// form sends swept fields as a multiple select and the ranking order
+	// as "min" or "max"
+	var body struct {
+		models.Sweep
+		Fields json.RawMessage
+		Order  string
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid request payload", err)
+		return
+	}
+	sweep := body.Sweep
+	sweep.Fields, err = parseStringList(body.Fields)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid request payload", err)
+		return
+	}
+	sweep.Maximize = body.Order == "max"
+
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+	sweep.UserId = user.ID
+
+	err = h.Service.Create(&sweep)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	utils.HTMXRedirect(w, fmt.Sprintf("/sweeps/%d", sweep.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func InitSweepRoutes(mux *http.ServeMux, env *environment.Env, nnArch service.INNArchService, notifier *service.TaskNotifier) {
+	prefix := "sweeps"
+
+	sweepService := service.NewSweepService(env.RepositoryContext, nnArch, notifier)
+	sh := NewSweepHandler(env, sweepService)
+
+	authMw := middleware.NewAuthMw(env.IAuthService, true)
+	validateHtmxMw := middleware.NewValidateHTMXMw()
+	blockHtmxMw := middleware.NewBlockHTMXMw()
+
+	mux.Handle(fmt.Sprintf("GET /%s", prefix), middleware.Chain(
+		http.HandlerFunc(sh.Index),
+		blockHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("GET /%s/{id}", prefix), middleware.Chain(
+		http.HandlerFunc(sh.Show),
+		blockHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("GET /%s/list", prefix), middleware.Chain(
+		http.HandlerFunc(sh.List),
+		validateHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("GET /%s/new", prefix), middleware.Chain(
+		http.HandlerFunc(sh.New),
+		blockHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("POST /%s", prefix), middleware.Chain(
+		http.HandlerFunc(sh.Create),
+		validateHtmxMw,
+		authMw,
+	))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/mytkom/AliceTraINT/internal/db/models"
 	"github.com/mytkom/AliceTraINT/internal/environment"
@@ -43,6 +44,7 @@ func (h *TrainingMachineHandler) Index(w http.ResponseWriter, r *http.Request) {
 func (h *TrainingMachineHandler) List(w http.ResponseWriter, r *http.Request) {
 	type TemplateData struct {
 		TrainingMachines []models.TrainingMachine
+		Now              time.Time
 	}
 
 	user, ok := middleware.GetLoggedUser(r)
@@ -59,6 +61,7 @@ func (h *TrainingMachineHandler) List(w http.ResponseWriter, r *http.Request) {
 
 	err = h.ExecuteTemplate(w, "training-machines_list", TemplateData{
 		TrainingMachines: trainingMachines,
+		Now:              time.Now(),
 	})
 	if err != nil {
 		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
@@ -69,6 +72,9 @@ func (h *TrainingMachineHandler) Show(w http.ResponseWriter, r *http.Request) {
 	type TemplateData struct {
 		Title           string
 		TrainingMachine models.TrainingMachine
+		Activity        *service.MachineActivity
+		States          []models.TrainingMachineState
+		Now             time.Time
 	}
 
 	idStr := r.PathValue("id")
@@ -84,9 +90,18 @@ func (h *TrainingMachineHandler) Show(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	activity, err := h.Service.GetActivity(trainingMachine)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
 	err = h.ExecuteTemplate(w, "training-machines_show", TemplateData{
 		Title:           "Training Machine",
 		TrainingMachine: *trainingMachine,
+		Activity:        activity,
+		States:          models.TrainingMachineStates,
+		Now:             time.Now(),
 	})
 
 	if err != nil {
@@ -94,6 +109,28 @@ func (h *TrainingMachineHandler) Show(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Telemetry renders health of the machine and charts of its recent
+// telemetry, the show page polls it.
+func (h *TrainingMachineHandler) Telemetry(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid training machine id", err)
+		return
+	}
+
+	telemetry, err := h.Service.GetTelemetry(uint(id))
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	err = h.ExecuteTemplate(w, "training-machines_telemetry", telemetry)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+	}
+}
+
 func (h *TrainingMachineHandler) New(w http.ResponseWriter, r *http.Request) {
 	type TemplateData struct {
 		Title string
@@ -114,12 +151,23 @@ func (h *TrainingMachineHandler) Create(w http.ResponseWriter, r *http.Request)
 		SecretKey string
 	}
 
-	var trainingMachine models.TrainingMachine
-	err := json.NewDecoder(r.Body).Decode(&trainingMachine)
+	// form sends labels as a single comma separated field
+	var body struct {
+		models.TrainingMachine
+		Labels string
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		writeError(w, r, http.StatusBadRequest, "invalid request payload", err)
 		return
 	}
+	trainingMachine := body.TrainingMachine
+
+	trainingMachine.Labels, err = service.ParseLabels(body.Labels)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
 
 	user, ok := middleware.GetLoggedUser(r)
 	if !ok || user == nil {
@@ -144,6 +192,137 @@ func (h *TrainingMachineHandler) Create(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (h *TrainingMachineHandler) UpdateState(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		State string
+	}
+
+	trainingMachineId, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid training machine id", err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid request payload", err)
+		return
+	}
+
+	state, err := service.ParseMachineState(body.State)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+
+	_, err = h.Service.SetState(user.ID, uint(trainingMachineId), state)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	utils.HTMXRefresh(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// RotateSecret shows the new secret key once, as Create does.
+func (h *TrainingMachineHandler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	type TemplateData struct {
+		ID                 uint
+		SecretKey          string
+		PreviousValidUntil *time.Time
+	}
+
+	trainingMachineId, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid training machine id", err)
+		return
+	}
+
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+
+	trainingMachine, secretKey, err := h.Service.RotateSecret(user.ID, uint(trainingMachineId))
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	err = h.ExecuteTemplate(w, "training-machines_show-secret", TemplateData{
+		ID:                 trainingMachine.ID,
+		SecretKey:          secretKey,
+		PreviousValidUntil: trainingMachine.PreviousSecretKeyExpiresAt,
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+	}
+}
+
+func (h *TrainingMachineHandler) RevokePreviousSecret(w http.ResponseWriter, r *http.Request) {
+	trainingMachineId, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid training machine id", err)
+		return
+	}
+
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+
+	err = h.Service.RevokePreviousSecret(user.ID, uint(trainingMachineId))
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	utils.HTMXRefresh(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TrainingMachineHandler) UpdateCertificate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Subject string
+	}
+
+	trainingMachineId, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid training machine id", err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid request payload", err)
+		return
+	}
+
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+
+	err = h.Service.SetCertificateSubject(user.ID, uint(trainingMachineId), body.Subject)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	utils.HTMXRefresh(w)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *TrainingMachineHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	trainingMachineId, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
 
@@ -170,7 +349,7 @@ func (h *TrainingMachineHandler) Delete(w http.ResponseWriter, r *http.Request)
 func InitTrainingMachineRoutes(mux *http.ServeMux, env *environment.Env, hasher service.Hasher) {
 	prefix := "training-machines"
 
-	tmService := service.NewTrainingMachineService(env.RepositoryContext, hasher)
+	tmService := service.NewTrainingMachineService(env.RepositoryContext, hasher, env.Config.MachineAuth)
 	tmh := NewTrainingMachineHandler(env, tmService)
 	authMw := middleware.NewAuthMw(env.IAuthService, true)
 	validateHtmxMw := middleware.NewValidateHTMXMw()
@@ -188,6 +367,12 @@ func InitTrainingMachineRoutes(mux *http.ServeMux, env *environment.Env, hasher
 		authMw,
 	))
 
+	mux.Handle(fmt.Sprintf("GET /%s/{id}/telemetry", prefix), middleware.Chain(
+		http.HandlerFunc(tmh.Telemetry),
+		validateHtmxMw,
+		authMw,
+	))
+
 	mux.Handle(fmt.Sprintf("GET /%s/list", prefix), middleware.Chain(
 		http.HandlerFunc(tmh.List),
 		validateHtmxMw,
@@ -206,6 +391,30 @@ func InitTrainingMachineRoutes(mux *http.ServeMux, env *environment.Env, hasher
 		authMw,
 	))
 
+	mux.Handle(fmt.Sprintf("POST /%s/{id}/state", prefix), middleware.Chain(
+		http.HandlerFunc(tmh.UpdateState),
+		validateHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("POST /%s/{id}/secret", prefix), middleware.Chain(
+		http.HandlerFunc(tmh.RotateSecret),
+		validateHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("DELETE /%s/{id}/secret/previous", prefix), middleware.Chain(
+		http.HandlerFunc(tmh.RevokePreviousSecret),
+		validateHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("POST /%s/{id}/certificate", prefix), middleware.Chain(
+		http.HandlerFunc(tmh.UpdateCertificate),
+		validateHtmxMw,
+		authMw,
+	))
+
 	mux.Handle(fmt.Sprintf("DELETE /%s/{id}", prefix), middleware.Chain(
 		http.HandlerFunc(tmh.Delete),
 		validateHtmxMw,
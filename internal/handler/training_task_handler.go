@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
 	"github.com/mytkom/AliceTraINT/internal/environment"
 	"github.com/mytkom/AliceTraINT/internal/middleware"
 	"github.com/mytkom/AliceTraINT/internal/service"
@@ -27,11 +28,21 @@ func NewTrainingTaskHandler(env *environment.Env, ttService service.ITrainingTas
 
 func (h *TrainingTaskHandler) Index(w http.ResponseWriter, r *http.Request) {
 	type TemplateData struct {
-		Title string
+		Title        string
+		Statuses     []models.TrainingTaskStatus
+		FailureCodes []string
 	}
 
-	err := h.ExecuteTemplate(w, "training-tasks_index", TemplateData{
-		Title: "Training Tasks",
+	failureCodes, err := h.Service.GetFailureCodes()
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	err = h.ExecuteTemplate(w, "training-tasks_index", TemplateData{
+		Title:        "Training Tasks",
+		Statuses:     models.TrainingTaskStatuses,
+		FailureCodes: failureCodes,
 	})
 
 	if err != nil {
@@ -51,7 +62,13 @@ func (h *TrainingTaskHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	trainingTasks, err := h.Service.GetAll(user.ID, utils.IsUserScoped(r))
+	filter, err := parseTrainingTaskFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid training task filter", err)
+		return
+	}
+
+	trainingTasks, err := h.Service.GetAll(user.ID, utils.IsUserScoped(r), filter)
 	if err != nil {
 		handleServiceError(w, r, err)
 		return
@@ -66,6 +83,23 @@ func (h *TrainingTaskHandler) List(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func parseTrainingTaskFilter(r *http.Request) (repository.TrainingTaskFilter, error) {
+	filter := repository.TrainingTaskFilter{
+		FailureCode: r.URL.Query().Get("failureCode"),
+	}
+
+	if statusStr := r.URL.Query().Get("status"); statusStr != "" {
+		status, err := strconv.ParseUint(statusStr, 10, 8)
+		if err != nil {
+			return filter, err
+		}
+		trainingTaskStatus := models.TrainingTaskStatus(status)
+		filter.Status = &trainingTaskStatus
+	}
+
+	return filter, nil
+}
+
 func (h *TrainingTaskHandler) UploadToCCDB(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
@@ -74,7 +108,37 @@ func (h *TrainingTaskHandler) UploadToCCDB(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = h.Service.UploadOnnxResults(uint(id))
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+
+	err = h.Service.UploadOnnxResults(uint(id), user.ID)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	utils.HTMXRefresh(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TrainingTaskHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid training task id", err)
+		return
+	}
+
+	user, ok := middleware.GetLoggedUser(r)
+	if !ok || user == nil {
+		writeError(w, r, http.StatusUnauthorized, errMsgUserUnauthorized, nil)
+		return
+	}
+
+	err = h.Service.Cancel(uint(id), user.ID)
 	if err != nil {
 		handleServiceError(w, r, err)
 		return
@@ -91,6 +155,10 @@ func (h *TrainingTaskHandler) Show(w http.ResponseWriter, r *http.Request) {
 		ImageFiles   []models.TrainingTaskResult
 		OnnxFiles    []models.TrainingTaskResult
 		LogFiles     []models.TrainingTaskResult
+		Scheduling   *service.SchedulingDiagnosis
+		Timeline     []service.StatusTimelineEntry
+		Dependencies *service.TaskDependencies
+		Attempts     []service.AttemptWithResults
 	}
 
 	idStr := r.PathValue("id")
@@ -112,6 +180,10 @@ func (h *TrainingTaskHandler) Show(w http.ResponseWriter, r *http.Request) {
 		ImageFiles:   tt.ImageFiles,
 		OnnxFiles:    tt.OnnxFiles,
 		LogFiles:     tt.LogFiles,
+		Scheduling:   tt.Scheduling,
+		Timeline:     tt.Timeline,
+		Dependencies: tt.Dependencies,
+		Attempts:     tt.PreviousAttempts,
 	})
 
 	if err != nil {
@@ -119,11 +191,110 @@ func (h *TrainingTaskHandler) Show(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *TrainingTaskHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid training task id", err)
+		return
+	}
+
+	metrics, err := h.Service.GetMetricCharts(uint(id))
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	err = h.ExecuteTemplate(w, "training-tasks_metrics", metrics)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+	}
+}
+
+// Logs renders the log viewer or, when offset is given, the log streamed
+// after it to be appended to the viewer.
+func (h *TrainingTaskHandler) Logs(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid training task id", err)
+		return
+	}
+
+	var offset *int64
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		parsed, err := strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || parsed < 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, "invalid log offset", err)
+			return
+		}
+		offset = &parsed
+	}
+
+	log, err := h.Service.GetLog(uint(id), offset)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	templateName := "training-tasks_logs"
+	if offset != nil {
+		templateName = "training-tasks_logs-append"
+	}
+
+	err = h.ExecuteTemplate(w, templateName, log)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+	}
+}
+
+const (
+	defaultStatsDays uint = 14
+	maxStatsDays     uint = 90
+)
+
+func (h *TrainingTaskHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	type TemplateData struct {
+		Title string
+		Days  uint
+		Stats *service.TimeInStateStats
+	}
+
+	days := defaultStatsDays
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		parsed, err := strconv.ParseUint(daysStr, 10, 32)
+		if err != nil || parsed == 0 || uint(parsed) > maxStatsDays {
+			writeError(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), err)
+			return
+		}
+		days = uint(parsed)
+	}
+
+	stats, err := h.Service.GetTimeInStateStats(days)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	err = h.ExecuteTemplate(w, "training-tasks_stats", TemplateData{
+		Title: "Training Tasks Statistics",
+		Days:  days,
+		Stats: stats,
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
+	}
+}
+
 func (h *TrainingTaskHandler) New(w http.ResponseWriter, r *http.Request) {
 	type TemplateData struct {
-		Title            string
-		TrainingDatasets []models.TrainingDataset
-		FieldConfigs     service.NNFieldConfigs
+		Title              string
+		TrainingDatasets   []models.TrainingDataset
+		TrainingMachines   []models.TrainingMachine
+		ParentCandidates   []models.TrainingTask
+		FieldConfigs       service.NNFieldConfigs
+		RetryPolicy        service.NNRetryPolicy
+		MaxWallTimeSeconds uint
 	}
 
 	user, ok := middleware.GetLoggedUser(r)
@@ -139,9 +310,13 @@ func (h *TrainingTaskHandler) New(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.ExecuteTemplate(w, "training-tasks_new", TemplateData{
-		Title:            "Create New Training Task!",
-		TrainingDatasets: ttHelpers.TrainingDatasets,
-		FieldConfigs:     ttHelpers.FieldConfigs,
+		Title:              "Create New Training Task!",
+		TrainingDatasets:   ttHelpers.TrainingDatasets,
+		TrainingMachines:   ttHelpers.TrainingMachines,
+		ParentCandidates:   ttHelpers.ParentCandidates,
+		FieldConfigs:       ttHelpers.FieldConfigs,
+		RetryPolicy:        ttHelpers.RetryPolicy,
+		MaxWallTimeSeconds: ttHelpers.MaxWallTimeSeconds,
 	})
 	if err != nil {
 		writeError(w, r, http.StatusInternalServerError, "unexpected internal server error", err)
@@ -150,12 +325,35 @@ func (h *TrainingTaskHandler) New(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *TrainingTaskHandler) Create(w http.ResponseWriter, r *http.Request) {
-	var trainingTask models.TrainingTask
-	err := json.NewDecoder(r.Body).Decode(&trainingTask)
+	// form sends requirements as a single comma separated field and
+	// an empty string when the task is not pinned to any machine
+	var body struct {
+		models.TrainingTask
+		Requirements            string
+		PinnedTrainingMachineId json.RawMessage
+		DependsOn               json.RawMessage
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid request payload", err)
+		return
+	}
+	trainingTask := body.TrainingTask
+	trainingTask.Requirements = service.SplitList(body.Requirements)
+	trainingTask.PinnedTrainingMachineId, err = parseOptionalID(body.PinnedTrainingMachineId)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid request payload", err)
+		return
+	}
+	parentIds, err := parseIDList(body.DependsOn)
 	if err != nil {
 		writeError(w, r, http.StatusBadRequest, "invalid request payload", err)
 		return
 	}
+	trainingTask.Dependencies = nil
+	for _, parentId := range parentIds {
+		trainingTask.Dependencies = append(trainingTask.Dependencies, models.TrainingTaskDependency{ParentId: parentId})
+	}
 
 	user, ok := middleware.GetLoggedUser(r)
 	if !ok || user == nil {
@@ -174,10 +372,10 @@ func (h *TrainingTaskHandler) Create(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
-func InitTrainingTaskRoutes(mux *http.ServeMux, env *environment.Env, ccdbService service.ICCDBService, jalienService service.IJAliEnService, fileService service.IFileService, nnArch service.INNArchService) {
+func InitTrainingTaskRoutes(mux *http.ServeMux, env *environment.Env, ccdbService service.ICCDBService, jalienService service.IJAliEnService, fileService service.IFileService, nnArch service.INNArchService, notifier *service.TaskNotifier) {
 	prefix := "training-tasks"
 
-	ttService := service.NewTrainingTaskService(env.RepositoryContext, ccdbService, jalienService, fileService, nnArch)
+	ttService := service.NewTrainingTaskService(env.RepositoryContext, ccdbService, jalienService, fileService, nnArch, notifier)
 	tjh := NewTrainingTaskHandler(env, ttService)
 
 	authMw := middleware.NewAuthMw(env.IAuthService, true)
@@ -202,6 +400,24 @@ func InitTrainingTaskRoutes(mux *http.ServeMux, env *environment.Env, ccdbServic
 		authMw,
 	))
 
+	mux.Handle(fmt.Sprintf("GET /%s/{id}/metrics", prefix), middleware.Chain(
+		http.HandlerFunc(tjh.Metrics),
+		validateHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("GET /%s/{id}/logs", prefix), middleware.Chain(
+		http.HandlerFunc(tjh.Logs),
+		validateHtmxMw,
+		authMw,
+	))
+
+	mux.Handle(fmt.Sprintf("GET /%s/stats", prefix), middleware.Chain(
+		http.HandlerFunc(tjh.Stats),
+		blockHtmxMw,
+		authMw,
+	))
+
 	mux.Handle(fmt.Sprintf("GET /%s/new", prefix), middleware.Chain(
 		http.HandlerFunc(tjh.New),
 		blockHtmxMw,
@@ -219,4 +435,10 @@ func InitTrainingTaskRoutes(mux *http.ServeMux, env *environment.Env, ccdbServic
 		validateHtmxMw,
 		authMw,
 	))
+
+	mux.Handle(fmt.Sprintf("POST /%s/{id}/cancel", prefix), middleware.Chain(
+		http.HandlerFunc(tjh.Cancel),
+		validateHtmxMw,
+		authMw,
+	))
 }
@@ -54,7 +54,7 @@ func NewClient(host, port, certPath, keyPath, certDir string, timeoutSeconds uin
 		return nil, err
 	}
 
-	rootCAs, err := loadRootCAs(certDir)
+	rootCAs, err := LoadRootCAs(certDir)
 	if err != nil {
 		return nil, err
 	}
@@ -78,9 +78,9 @@ func NewClient(host, port, certPath, keyPath, certDir string, timeoutSeconds uin
 	}, nil
 }
 
-// loadRootCAs attempts to build a CertPool based on well-known grid CA
-// locations. If none are available, the system cert pool is used.
-func loadRootCAs(certDir string) (*x509.CertPool, error) {
+// LoadGridCAs builds a CertPool from the first grid CA directory found in
+// the well-known locations, it fails when there is none.
+func LoadGridCAs(certDir string) (*x509.CertPool, error) {
 	// 0) If CERT dir is explicitly set, use it
 	if certDir != "" {
 		if pool, ok := loadCertPoolFromDir(certDir); ok {
@@ -115,6 +115,16 @@ func loadRootCAs(certDir string) (*x509.CertPool, error) {
 		}
 	}
 
+	return nil, errors.New("no grid CA certificates found")
+}
+
+// LoadRootCAs attempts to build a CertPool based on well-known grid CA
+// locations. If none are available, the system cert pool is used.
+func LoadRootCAs(certDir string) (*x509.CertPool, error) {
+	if pool, err := LoadGridCAs(certDir); err == nil {
+		return pool, nil
+	}
+
 	// 3) Fallback to system roots.
 	sysPool, err := x509.SystemCertPool()
 	if err != nil {
@@ -0,0 +1,49 @@
+package jalien
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+)
+
+// short names of subject attributes as printed by OpenSSL
+var attributeNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+// SubjectDN formats the certificate subject in the one-line form used by
+// the grid, e.g. "/DC=ch/DC=cern/OU=Users/CN=jdoe".
+func SubjectDN(cert *x509.Certificate) string {
+	var rdns pkix.RDNSequence
+	if _, err := asn1.Unmarshal(cert.RawSubject, &rdns); err != nil {
+		rdns = cert.Subject.ToRDNSequence()
+	}
+
+	var b strings.Builder
+	for _, rdn := range rdns {
+		for i, atv := range rdn {
+			if i == 0 {
+				b.WriteByte('/')
+			} else {
+				b.WriteByte('+')
+			}
+			name, ok := attributeNames[atv.Type.String()]
+			if !ok {
+				name = atv.Type.String()
+			}
+			fmt.Fprintf(&b, "%s=%v", name, atv.Value)
+		}
+	}
+	return b.String()
+}
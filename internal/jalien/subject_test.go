@@ -0,0 +1,27 @@
+package jalien
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestSubjectDN(t *testing.T) {
+	dc := asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
+	rdns := pkix.RDNSequence{
+		{{Type: dc, Value: "ch"}},
+		{{Type: dc, Value: "cern"}},
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Users"}},
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "jdoe"}},
+	}
+	raw, err := asn1.Marshal(rdns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := SubjectDN(&x509.Certificate{RawSubject: raw})
+	if want := "/DC=ch/DC=cern/OU=Users/CN=jdoe"; got != want {
+		t.Fatalf("SubjectDN() = %q, want %q", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -34,6 +35,13 @@ func NewRouter(cfg *config.Config, repoContext *repository.RepositoryContext, au
 	fileService := service.NewLocalFileService(cfg.DataDirPath)
 	fsData := http.FileServer(http.Dir("data"))
 
+	// wakes up training machines waiting for a task
+	taskNotifier := service.NewTaskNotifier()
+
+	// background jobs
+	queueService := service.NewQueueService(fileService, repoContext, hasher, cfg.Queue, service.NewMachineSessions(cfg.MachineAuth), nnArch, taskNotifier)
+	go queueService.RunMaintenance(context.Background(), time.Duration(cfg.Queue.ReaperIntervalSeconds)*time.Second)
+
 	// routes
 	mux.Handle("GET /static/", http.StripPrefix("/static/", fs))
 	mux.Handle("GET /docs/static/", http.StripPrefix("/docs/static/", docsFs))
@@ -45,9 +53,10 @@ func NewRouter(cfg *config.Config, repoContext *repository.RepositoryContext, au
 	handler.InitLandingRoutes(mux, env)
 	handler.InitDocsRoutes(mux, env)
 	handler.InitTrainingDatasetRoutes(mux, env, jalienService, jalienCache)
-	handler.InitTrainingTaskRoutes(mux, env, ccdbService, jalienService, fileService, nnArch)
+	handler.InitTrainingTaskRoutes(mux, env, ccdbService, jalienService, fileService, nnArch, taskNotifier)
 	handler.InitTrainingMachineRoutes(mux, env, hasher)
-	handler.InitQueueRoutes(mux, env, fileService, hasher)
+	handler.InitQueueRoutes(mux, env, queueService)
+	handler.InitSweepRoutes(mux, env, nnArch, taskNotifier)
 
 	return mux
 }
@@ -1,12 +1,17 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mytkom/AliceTraINT/internal/db/models"
@@ -14,10 +19,19 @@ import (
 )
 
 type IFileService interface {
-	SaveFile(file multipart.File, handler *multipart.FileHeader) (*models.File, error)
+	SaveFile(file multipart.File, handler *multipart.FileHeader, expectedSHA256 string) (*models.File, error)
 	OpenFile(filepath string) (io.ReadCloser, func(io.ReadCloser), error)
+	CreatePartialFile() (string, error)
+	WritePartialFile(path string, offset int64, r io.Reader) (int64, error)
+	FinalizePartialFile(path, fileName, expectedSHA256 string) (*models.File, error)
+	RemovePartialFile(path string) error
 }
 
+// partialUploadsDir keeps files of unfinished resumable uploads.
+const partialUploadsDir = "partial"
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 type LocalFileService struct {
 	BasePath string
 }
@@ -28,7 +42,9 @@ func NewLocalFileService(basePath string) *LocalFileService {
 	}
 }
 
-func (l *LocalFileService) SaveFile(file multipart.File, handler *multipart.FileHeader) (*models.File, error) {
+// SaveFile stores the uploaded file and computes its SHA-256 digest. When
+// expectedSHA256 is given, a file with a different digest is rejected.
+func (l *LocalFileService) SaveFile(file multipart.File, handler *multipart.FileHeader, expectedSHA256 string) (*models.File, error) {
 	//nolint:errcheck
 	defer file.Close()
 
@@ -36,40 +52,141 @@ func (l *LocalFileService) SaveFile(file multipart.File, handler *multipart.File
 	log.Printf("File size: %+v\n", handler.Size)
 	log.Printf("File header: %+v\n", handler.Header)
 
+	tempFile, err := l.createDatedFile(handler.Filename)
+	if err != nil {
+		return nil, err
+	}
+	//nolint:errcheck
+	defer tempFile.Close()
+
+	// stream the file to disk, it may be too large to be kept in memory
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tempFile, hash), file); err != nil {
+		return nil, err
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if expectedSHA256 != "" && !strings.EqualFold(digest, expectedSHA256) {
+		//nolint:errcheck
+		os.Remove(tempFile.Name())
+		return nil, ErrChecksumMismatch
+	}
+
+	fileModel := &models.File{
+		Name:   handler.Filename,
+		Path:   filePath(tempFile.Name()),
+		Size:   uint64(handler.Size),
+		SHA256: digest,
+	}
+
+	return fileModel, nil
+}
+
+// createDatedFile creates an empty file with a unique name derived from
+// fileName in the directory of today's uploads.
+func (l *LocalFileService) createDatedFile(fileName string) (*os.File, error) {
 	today := time.Now().Format("2006-01-02")
 	tempFolderPath := filepath.Join(l.BasePath, today)
 	if err := os.MkdirAll(tempFolderPath, os.ModePerm); err != nil {
 		return nil, err
 	}
 
-	ext := filepath.Ext(handler.Filename)
-	tempFileName := fmt.Sprintf("upload-%s-*%s", handler.Filename[:len(handler.Filename)-len(ext)], ext)
+	ext := filepath.Ext(fileName)
+	tempFileName := fmt.Sprintf("upload-%s-*%s", fileName[:len(fileName)-len(ext)], ext)
+
+	return os.CreateTemp(tempFolderPath, tempFileName)
+}
+
+// filePath returns the path under which the stored file is served.
+func filePath(name string) string {
+	return fmt.Sprintf("/%s/%s", filepath.Dir(name), filepath.Base(name))
+}
+
+// CreatePartialFile creates an empty file receiving chunks of a resumable
+// upload and returns its path.
+func (l *LocalFileService) CreatePartialFile() (string, error) {
+	partialFolderPath := filepath.Join(l.BasePath, partialUploadsDir)
+	if err := os.MkdirAll(partialFolderPath, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	partialFile, err := os.CreateTemp(partialFolderPath, "partial-*")
+	if err != nil {
+		return "", err
+	}
+
+	return partialFile.Name(), partialFile.Close()
+}
+
+// WritePartialFile streams r into the partial file starting at the offset
+// and returns the number of bytes written, also when writing fails midway.
+func (l *LocalFileService) WritePartialFile(path string, offset int64, r io.Reader) (int64, error) {
+	partialFile, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	//nolint:errcheck
+	defer partialFile.Close()
+
+	if _, err := partialFile.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(partialFile, r)
+	if err != nil {
+		return written, err
+	}
 
-	tempFile, err := os.CreateTemp(tempFolderPath, tempFileName)
+	return written, partialFile.Sync()
+}
+
+// FinalizePartialFile verifies the SHA-256 digest of a completely uploaded
+// partial file and moves it among other stored files.
+func (l *LocalFileService) FinalizePartialFile(path, fileName, expectedSHA256 string) (*models.File, error) {
+	partialFile, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	//nolint:errcheck
-	defer tempFile.Close()
+	defer partialFile.Close()
 
-	fileBytes, err := io.ReadAll(file)
+	hash := sha256.New()
+	size, err := io.Copy(hash, partialFile)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = tempFile.Write(fileBytes)
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(digest, expectedSHA256) {
+		return nil, ErrChecksumMismatch
+	}
+
+	destFile, err := l.createDatedFile(fileName)
 	if err != nil {
 		return nil, err
 	}
+	//nolint:errcheck
+	destFile.Close()
 
-	filename := filepath.Base(tempFile.Name())
-	fileModel := &models.File{
-		Name: handler.Filename,
-		Path: fmt.Sprintf("/%s/%s", tempFolderPath, filename),
-		Size: uint64(handler.Size),
+	if err := os.Rename(path, destFile.Name()); err != nil {
+		return nil, err
 	}
 
-	return fileModel, nil
+	return &models.File{
+		Name:   fileName,
+		Path:   filePath(destFile.Name()),
+		Size:   uint64(size),
+		SHA256: digest,
+	}, nil
+}
+
+// RemovePartialFile deletes the file of an abandoned upload, a file which
+// is already gone is not an error.
+func (l *LocalFileService) RemovePartialFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 
 func (l *LocalFileService) OpenFile(filepath string) (io.ReadCloser, func(io.ReadCloser), error) {
@@ -84,6 +201,29 @@ func (l *LocalFileService) OpenFile(filepath string) (io.ReadCloser, func(io.Rea
 	}, nil
 }
 
+// checksumReader computes the SHA-256 digest of the file while it is read
+// and returns ErrChecksumMismatch instead of io.EOF when the digest differs
+// from the one computed when the file was stored, so the reader never sees
+// a complete file which was modified.
+type checksumReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func newChecksumReader(r io.Reader, expectedSHA256 string) *checksumReader {
+	return &checksumReader{r: r, hash: sha256.New(), expected: expectedSHA256}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && !strings.EqualFold(hex.EncodeToString(c.hash.Sum(nil)), c.expected) {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
+
 type MockFileService struct {
 	mock.Mock
 }
@@ -92,8 +232,8 @@ func NewMockFileService() *MockFileService {
 	return &MockFileService{}
 }
 
-func (m *MockFileService) SaveFile(file multipart.File, handler *multipart.FileHeader) (*models.File, error) {
-	args := m.Called(file, handler)
+func (m *MockFileService) SaveFile(file multipart.File, handler *multipart.FileHeader, expectedSHA256 string) (*models.File, error) {
+	args := m.Called(file, handler, expectedSHA256)
 	if args.Get(0) != nil {
 		return args.Get(0).(*models.File), args.Error(1)
 	}
@@ -107,3 +247,26 @@ func (m *MockFileService) OpenFile(filepath string) (io.ReadCloser, func(io.Read
 	}
 	return nil, nil, args.Error(2)
 }
+
+func (m *MockFileService) CreatePartialFile() (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockFileService) WritePartialFile(path string, offset int64, r io.Reader) (int64, error) {
+	args := m.Called(path, offset, r)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockFileService) RemovePartialFile(path string) error {
+	args := m.Called(path)
+	return args.Error(0)
+}
+
+func (m *MockFileService) FinalizePartialFile(path, fileName, expectedSHA256 string) (*models.File, error) {
+	args := m.Called(path, fileName, expectedSHA256)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.File), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
@@ -46,6 +46,26 @@ func (e *ErrHandlerValidation) Error() string {
 	return fmt.Sprintf("%s %s", e.Field, e.Msg)
 }
 
+type ErrHandlerConflict struct {
+	Resource string
+	Msg      string
+}
+
+func (e *ErrHandlerConflict) Error() string {
+	return fmt.Sprintf("%s %s", e.Resource, e.Msg)
+}
+
+// ErrHandlerGone reports a resource which existed, but was removed for good,
+// e.g. after it expired.
+type ErrHandlerGone struct {
+	Resource string
+	Msg      string
+}
+
+func (e *ErrHandlerGone) Error() string {
+	return fmt.Sprintf("%s %s", e.Resource, e.Msg)
+}
+
 var (
 	errInternalServerError = errors.New("unexpected internal server error")
 )
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mytkom/AliceTraINT/internal/config"
+	"github.com/mytkom/AliceTraINT/internal/jalien"
+)
+
+var errCertificateRequired = errors.New("machine must authenticate with its certificate")
+
+// NewMachineTLSConfig configures the TLS listener of training machines,
+// client certificates are optional and verified against the grid CAs. It
+// fails without grid CAs rather than trusting public CAs, any of which could
+// issue a certificate with a bound subject.
+func NewMachineTLSConfig(cfg config.MachineAuthConfig, caDir string) (*tls.Config, error) {
+	serverCert, err := tls.LoadX509KeyPair(cfg.TLSCertPath, cfg.TLSKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAs, err := jalien.LoadGridCAs(caDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify machine certificates: %w", err)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}, nil
+}
+
+// ParseCertificateSubject validates the subject DN a machine is bound to,
+// empty subject unbinds it.
+func ParseCertificateSubject(subject string) (string, error) {
+	subject = strings.TrimSpace(subject)
+	if subject == "" {
+		return "", nil
+	}
+
+	if len(subject) > 512 || !strings.HasPrefix(subject, "/") || !strings.Contains(subject, "=") {
+		return "", &ErrHandlerValidation{
+			Field: "CertificateSubject",
+			Msg:   "must be a subject DN such as /DC=ch/DC=cern/OU=computers/CN=node.cern.ch",
+		}
+	}
+	return subject, nil
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+// Requirement is a single task requirement on a training machine label,
+// e.g. "gpu_mem_gb>=24", "cuda=12" or "site!=cern".
+type Requirement struct {
+	Label    string
+	Operator string
+	Value    string
+}
+
+var requirementRegex = regexp.MustCompile(`^\s*([A-Za-z0-9_.\-]+)\s*(>=|<=|!=|=|>|<)\s*(\S+)\s*$`)
+
+func ParseRequirement(expr string) (*Requirement, error) {
+	matches := requirementRegex.FindStringSubmatch(expr)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid requirement \"%s\", expected <label><operator><value>", expr)
+	}
+
+	req := &Requirement{
+		Label:    matches[1],
+		Operator: matches[2],
+		Value:    matches[3],
+	}
+
+	if req.isOrdering() {
+		if _, err := strconv.ParseFloat(req.Value, 64); err != nil {
+			return nil, fmt.Errorf("invalid requirement \"%s\", operator %s needs a numeric value", expr, req.Operator)
+		}
+	}
+
+	return req, nil
+}
+
+func (r *Requirement) isOrdering() bool {
+	return r.Operator != "=" && r.Operator != "!="
+}
+
+func (r *Requirement) String() string {
+	return r.Label + r.Operator + r.Value
+}
+
+// SatisfiedBy reports whether machine labels fulfill the requirement. Values
+// are compared numerically if both of them are numbers.
+func (r *Requirement) SatisfiedBy(labels map[string]string) bool {
+	value, ok := labels[r.Label]
+	if !ok {
+		return r.Operator == "!="
+	}
+
+	labelNum, labelErr := strconv.ParseFloat(value, 64)
+	reqNum, reqErr := strconv.ParseFloat(r.Value, 64)
+	numeric := labelErr == nil && reqErr == nil
+
+	switch r.Operator {
+	case "=":
+		return value == r.Value || (numeric && labelNum == reqNum)
+	case "!=":
+		return value != r.Value && !(numeric && labelNum == reqNum)
+	}
+
+	if !numeric {
+		return false
+	}
+
+	switch r.Operator {
+	case ">=":
+		return labelNum >= reqNum
+	case "<=":
+		return labelNum <= reqNum
+	case ">":
+		return labelNum > reqNum
+	case "<":
+		return labelNum < reqNum
+	default:
+		return false
+	}
+}
+
+// ValidateRequirements checks that every task requirement is well-formed.
+func ValidateRequirements(requirements []string) error {
+	for _, expr := range requirements {
+		if _, err := ParseRequirement(expr); err != nil {
+			return &ErrHandlerValidation{
+				Field: "Requirements",
+				Msg:   err.Error(),
+			}
+		}
+	}
+	return nil
+}
+
+// ParseLabels parses comma or newline separated "key=value" pairs.
+func ParseLabels(text string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range SplitList(text) {
+		key, value, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !found || key == "" || value == "" {
+			return nil, &ErrHandlerValidation{
+				Field: "Labels",
+				Msg:   fmt.Sprintf("invalid label \"%s\", expected key=value", pair),
+			}
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// SplitList splits comma or newline separated list skipping empty entries.
+func SplitList(text string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// machineBlockers returns human-readable reasons why the task cannot be
+// handed to the training machine. Empty result means the machine can run it.
+func machineBlockers(tt *models.TrainingTask, tm *models.TrainingMachine) []string {
+	var blockers []string
+
+	if !tm.State.AcceptsTasks() {
+		blockers = append(blockers, fmt.Sprintf("machine is %s", tm.State))
+	}
+
+	if tt.PinnedTrainingMachineId != nil && *tt.PinnedTrainingMachineId != tm.ID {
+		blockers = append(blockers, fmt.Sprintf("task is pinned to training machine #%d", *tt.PinnedTrainingMachineId))
+	}
+
+	if tt.MachinePool != "" && tt.MachinePool != tm.Pool {
+		if tm.Pool == "" {
+			blockers = append(blockers, fmt.Sprintf("requires pool %s, machine is not in any pool", tt.MachinePool))
+		} else {
+			blockers = append(blockers, fmt.Sprintf("requires pool %s, machine is in pool %s", tt.MachinePool, tm.Pool))
+		}
+	}
+
+	for _, expr := range tt.Requirements {
+		req, err := ParseRequirement(expr)
+		if err != nil {
+			blockers = append(blockers, err.Error())
+			continue
+		}
+
+		if !req.SatisfiedBy(tm.Labels) {
+			if value, ok := tm.Labels[req.Label]; ok {
+				blockers = append(blockers, fmt.Sprintf("requires %s, has %s=%s", req, req.Label, value))
+			} else {
+				blockers = append(blockers, fmt.Sprintf("requires %s, label missing", req))
+			}
+		}
+	}
+
+	return blockers
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/config"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+// MachineSession is a short-lived token a training machine gets in exchange
+// for its secret key and sends instead of it until the token expires.
+type MachineSession struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+var errInvalidMachineSession = errors.New("invalid or expired session token")
+
+// MachineSessions issues and verifies session tokens of training machines.
+// A token is "<machine id>.<expiry>.<key fingerprint>.<HMAC-SHA256>", so
+// verifying it needs no key derivation. The fingerprint binds it to the
+// secret key it was exchanged for, rotated or revoked keys invalidate it.
+type MachineSessions struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewMachineSessions uses the configured signing key, a random one is
+// generated when it is empty and sessions do not survive a restart. Each
+// process must use a single instance, replicas need the same configured key.
+func NewMachineSessions(cfg config.MachineAuthConfig) *MachineSessions {
+	key := []byte(cfg.SessionSigningKey)
+	if len(key) == 0 {
+		log.Println("ALICETRAINT_MACHINE_SESSION_KEY is not set, session tokens are valid only on this instance until it restarts")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("cannot generate session signing key: %s", err.Error())
+		}
+	}
+
+	return &MachineSessions{
+		key: key,
+		ttl: time.Duration(cfg.SessionTTLMinutes) * time.Minute,
+	}
+}
+
+// keyFingerprint identifies the secret key without revealing its hash.
+func keyFingerprint(secretKeyHashed string) string {
+	sum := sha256.Sum256([]byte(secretKeyHashed))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (ms *MachineSessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, ms.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue creates a session of the machine bound to the given secret key hash.
+func (ms *MachineSessions) Issue(tmID uint, secretKeyHashed string, now time.Time) *MachineSession {
+	expiresAt := now.Add(ms.ttl).Truncate(time.Second)
+	payload := fmt.Sprintf("%d.%d.%s", tmID, expiresAt.Unix(), keyFingerprint(secretKeyHashed))
+
+	return &MachineSession{
+		Token:     payload + "." + ms.sign(payload),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// Verify checks signature and expiry of the token issued to the machine and
+// returns fingerprint of the secret key it is bound to.
+func (ms *MachineSessions) Verify(token string, tmID uint, now time.Time) (string, error) {
+	idx := strings.LastIndexByte(token, '.')
+	if idx < 0 {
+		return "", errInvalidMachineSession
+	}
+	payload, signature := token[:idx], token[idx+1:]
+	if !hmac.Equal([]byte(signature), []byte(ms.sign(payload))) {
+		return "", errInvalidMachineSession
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 || parts[0] != strconv.FormatUint(uint64(tmID), 10) {
+		return "", errInvalidMachineSession
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return "", errInvalidMachineSession
+	}
+
+	return parts[2], nil
+}
+
+// boundKey returns hash of the key a session with the fingerprint is bound
+// to, if the machine still accepts it.
+func boundKey(tm *models.TrainingMachine, fingerprint string, now time.Time) (string, bool) {
+	if hmac.Equal([]byte(fingerprint), []byte(keyFingerprint(tm.SecretKeyHashed))) {
+		return tm.SecretKeyHashed, true
+	}
+	if tm.PreviousSecretKeyValid(now) &&
+		hmac.Equal([]byte(fingerprint), []byte(keyFingerprint(tm.PreviousSecretKeyHashed))) {
+		return tm.PreviousSecretKeyHashed, true
+	}
+	return "", false
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+)
+
+// MachineActivity is the state of a training machine together with tasks it
+// still runs. A draining machine reports as idle once they are finished.
+type MachineActivity struct {
+	State        models.TrainingMachineState
+	RunningTasks []models.TrainingTask
+}
+
+func (a *MachineActivity) IsIdle() bool {
+	return len(a.RunningTasks) == 0
+}
+
+// IsDrained reports whether the machine was drained and may be taken down.
+func (a *MachineActivity) IsDrained() bool {
+	return a.State == models.MachineDraining && a.IsIdle()
+}
+
+var errMachineNotAcceptingTasks = &ErrHandlerConflict{
+	Resource: "TrainingMachine",
+	Msg:      "does not accept new tasks in its state",
+}
+
+// ParseMachineState parses a state name as reported by String.
+func ParseMachineState(name string) (models.TrainingMachineState, error) {
+	var names []string
+	for _, state := range models.TrainingMachineStates {
+		if state.String() == strings.TrimSpace(name) {
+			return state, nil
+		}
+		names = append(names, state.String())
+	}
+	return 0, &ErrHandlerValidation{
+		Field: "State",
+		Msg:   fmt.Sprintf("must be one of: %s", strings.Join(names, ", ")),
+	}
+}
+
+func machineActivity(repo *repository.RepositoryContext, tm *models.TrainingMachine) (*MachineActivity, error) {
+	running, err := repo.TrainingTask.GetRunning()
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	activity := &MachineActivity{State: tm.State}
+	for _, tt := range running {
+		if tt.TrainingMachineId != nil && *tt.TrainingMachineId == tm.ID {
+			activity.RunningTasks = append(activity.RunningTasks, tt)
+		}
+	}
+	return activity, nil
+}
+
+// setMachineState changes the state of the machine, tasks it runs are not
+// affected. Only the state column is written, so it is not undone by
+// a concurrent write of other columns.
+func setMachineState(repo *repository.RepositoryContext, tm *models.TrainingMachine, state models.TrainingMachineState) (*MachineActivity, error) {
+	if tm.State != state {
+		if _, err := repo.TrainingMachine.UpdateColumns(tm.ID, nil, map[string]interface{}{"state": state}); err != nil {
+			return nil, errInternalServerError
+		}
+		tm.State = state
+	}
+	return machineActivity(repo, tm)
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+const (
+	maxVersionLength = 64
+	// telemetryRetention is how long reports are kept and charted
+	telemetryRetention = 24 * time.Hour
+)
+
+// MachineTelemetry is the health of the training machine together with
+// charts of its reports from the last telemetryRetention.
+type MachineTelemetry struct {
+	TrainingMachine *models.TrainingMachine
+	Health          models.MachineHealth
+	// Latest is nil when the machine has not reported recently
+	Latest *models.TrainingMachineTelemetry
+	From   time.Time
+	To     time.Time
+	Charts []MetricChart
+}
+
+func validatePercent(field string, value float64) error {
+	if math.IsNaN(value) || value < 0 || value > 100 {
+		return &ErrHandlerValidation{
+			Field: field,
+			Msg:   "must be between 0 and 100",
+		}
+	}
+	return nil
+}
+
+// validateBytes rejects sizes which do not fit a signed 64-bit column.
+func validateBytes(field string, value uint64) error {
+	if value > math.MaxInt64 {
+		return &ErrHandlerValidation{
+			Field: field,
+			Msg:   fmt.Sprintf("must be at most %d", int64(math.MaxInt64)),
+		}
+	}
+	return nil
+}
+
+func validateVersion(field string, version string) (string, error) {
+	version = strings.TrimSpace(version)
+	if len(version) > maxVersionLength {
+		return "", &ErrHandlerValidation{
+			Field: field,
+			Msg:   fmt.Sprintf("must have at most %d characters", maxVersionLength),
+		}
+	}
+	return version, nil
+}
+
+func validateTelemetry(telemetry *models.TrainingMachineTelemetry) error {
+	if err := validatePercent("CPUPercent", telemetry.CPUPercent); err != nil {
+		return err
+	}
+	if telemetry.GPUPercent != nil {
+		if err := validatePercent("GPUPercent", *telemetry.GPUPercent); err != nil {
+			return err
+		}
+	}
+	if err := validateBytes("MemoryTotalBytes", telemetry.MemoryTotalBytes); err != nil {
+		return err
+	}
+	if err := validateBytes("DiskFreeBytes", telemetry.DiskFreeBytes); err != nil {
+		return err
+	}
+	if telemetry.MemoryUsedBytes > telemetry.MemoryTotalBytes {
+		return &ErrHandlerValidation{
+			Field: "MemoryUsedBytes",
+			Msg:   "must not exceed MemoryTotalBytes",
+		}
+	}
+
+	var err error
+	if telemetry.AgentVersion, err = validateVersion("AgentVersion", telemetry.AgentVersion); err != nil {
+		return err
+	}
+	if telemetry.DriverVersion, err = validateVersion("DriverVersion", telemetry.DriverVersion); err != nil {
+		return err
+	}
+	return nil
+}
+
+// buildTelemetryCharts charts reports ordered by time, the x coordinate is
+// seconds since the first one. Series without any value are left out.
+func buildTelemetryCharts(reports []models.TrainingMachineTelemetry) []MetricChart {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	var cpu, gpu, memory, disk []chartValue
+	for _, report := range reports {
+		step := uint(report.CreatedAt.Sub(reports[0].CreatedAt) / time.Second)
+		cpu = append(cpu, chartValue{Step: step, Value: report.CPUPercent})
+		if report.GPUPercent != nil {
+			gpu = append(gpu, chartValue{Step: step, Value: *report.GPUPercent})
+		}
+		if report.MemoryTotalBytes > 0 {
+			memory = append(memory, chartValue{
+				Step:  step,
+				Value: float64(report.MemoryUsedBytes) / float64(report.MemoryTotalBytes) * 100,
+			})
+		}
+		disk = append(disk, chartValue{Step: step, Value: float64(report.DiskFreeBytes) / (1 << 30)})
+	}
+
+	charts := []MetricChart{buildChart("CPU %", cpu)}
+	if len(gpu) > 0 {
+		charts = append(charts, buildChart("GPU %", gpu))
+	}
+	if len(memory) > 0 {
+		charts = append(charts, buildChart("Memory %", memory))
+	}
+	return append(charts, buildChart("Free disk GB", disk))
+}
@@ -23,9 +23,21 @@ type NNConfigField struct {
 
 type NNFieldConfigs map[string]NNConfigField
 
+// NNRetryPolicy is the architecture default of automatic retries of failed
+// training tasks, tasks may override MaxAttempts and BackoffSeconds.
+type NNRetryPolicy struct {
+	MaxAttempts       uint `json:"max_attempts"`
+	BackoffSeconds    uint `json:"backoff_seconds"`
+	MaxBackoffSeconds uint `json:"max_backoff_seconds"`
+}
+
 type NNArchSpec struct {
 	FieldConfigs    NNFieldConfigs    `json:"field_configs"`
 	ExpectedResults NNExpectedResults `json:"expected_results"`
+	RetryPolicy     NNRetryPolicy     `json:"retry_policy"`
+	// MaxWallTimeSeconds is the default limit of a task attempt duration,
+	// zero means no limit
+	MaxWallTimeSeconds uint `json:"max_wall_time_seconds"`
 }
 
 func loadSpec(filename string) (*NNArchSpec, error) {
@@ -53,6 +65,8 @@ func loadSpec(filename string) (*NNArchSpec, error) {
 type INNArchService interface {
 	GetFieldConfigs() NNFieldConfigs
 	GetExpectedResults() NNExpectedResults
+	GetRetryPolicy() NNRetryPolicy
+	GetMaxWallTimeSeconds() uint
 }
 
 type NNArchService struct {
@@ -79,6 +93,14 @@ func (s *NNArchService) GetExpectedResults() NNExpectedResults {
 	return s.ExpectedResults
 }
 
+func (s *NNArchService) GetRetryPolicy() NNRetryPolicy {
+	return s.RetryPolicy
+}
+
+func (s *NNArchService) GetMaxWallTimeSeconds() uint {
+	return s.MaxWallTimeSeconds
+}
+
 type NNArchServiceInMemory struct {
 	*NNArchSpec
 }
@@ -99,3 +121,11 @@ func (s *NNArchServiceInMemory) GetFieldConfigs() NNFieldConfigs {
 func (s *NNArchServiceInMemory) GetExpectedResults() NNExpectedResults {
 	return s.ExpectedResults
 }
+
+func (s *NNArchServiceInMemory) GetRetryPolicy() NNRetryPolicy {
+	return s.RetryPolicy
+}
+
+func (s *NNArchServiceInMemory) GetMaxWallTimeSeconds() uint {
+	return s.MaxWallTimeSeconds
+}
@@ -1,96 +1,1011 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"mime/multipart"
+	"regexp"
+	"slices"
 	"strconv"
 	"time"
 
+	"github.com/mytkom/AliceTraINT/internal/config"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
 	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"gorm.io/gorm"
 )
 
 type IQueueService interface {
 	AuthorizeTrainingMachine(secretID string, tmID uint) (*models.TrainingMachine, error)
-	UpdateTrainingTaskStatus(taskID uint, status models.TrainingTaskStatus) error
-	AssignTaskToMachine(tmID uint) (*models.TrainingTask, error)
-	CreateTrainingTaskResult(ttID uint, file multipart.File, handler *multipart.FileHeader, name, description, fileType string) (*models.TrainingTaskResult, error)
+	CreateMachineSession(secretID string, tmID uint) (*MachineSession, error)
+	AuthorizeMachineSession(token string, tmID uint) (*models.TrainingMachine, error)
+	AuthorizeMachineCertificate(subject string, tmID uint) (*models.TrainingMachine, error)
+	UpdateTrainingTaskStatus(taskID uint, status models.TrainingTaskStatus, failure *models.TaskFailure) (*models.TrainingTask, error)
+	AssignTaskToMachine(tm *models.TrainingMachine) (*models.TrainingTask, error)
+	UpdateMachineLabels(tm *models.TrainingMachine, labels map[string]string) error
+	GetMachineActivity(tm *models.TrainingMachine) (*MachineActivity, error)
+	SetMachineState(tm *models.TrainingMachine, state models.TrainingMachineState) (*MachineActivity, error)
+	RecordTelemetry(tm *models.TrainingMachine, telemetry *models.TrainingMachineTelemetry) error
+	WaitForTask(ctx context.Context, tm *models.TrainingMachine, wait time.Duration) (*models.TrainingTask, error)
+	RenewLease(taskID uint) (*models.TrainingTask, error)
+	MaxWallTime(tt *models.TrainingTask) time.Duration
+	ExpectedOnnx() []string
+	RecordMetrics(taskID uint, points []MetricPoint) (*models.TrainingTask, error)
+	AppendLog(taskID uint, offset int64, data []byte) (*models.TrainingTask, int64, error)
+	CreateTrainingTaskResult(ttID uint, file multipart.File, handler *multipart.FileHeader, name, description, fileType, expectedSHA256 string) (*models.TrainingTaskResult, error)
+	InitiateUpload(ttID uint, upload *models.TrainingTaskUpload) error
+	GetUpload(ttID, uploadID uint) (*models.TrainingTaskUpload, error)
+	WriteUploadChunk(ttID, uploadID uint, offset int64, r io.Reader) (*models.TrainingTaskUpload, error)
+	FinalizeUpload(ttID, uploadID uint, checksum string) (*models.TrainingTaskResult, error)
+	GetParentResults(ttID uint) ([]ParentResult, error)
+	OpenParentResult(ttID, resultID uint) (*models.TrainingTaskResult, io.ReadCloser, func(io.ReadCloser), error)
 }
 
 type QueueService struct {
 	*repository.RepositoryContext
 	FileService IFileService
 	Hasher      Hasher
+	Config      config.QueueConfig
+	Sessions    *MachineSessions
+	Policy      SchedulingPolicy
+	NNArch      INNArchService
+	Notifier    *TaskNotifier
 }
 
-func NewQueueService(fileService IFileService, repo *repository.RepositoryContext, hasher Hasher) *QueueService {
+func NewQueueService(fileService IFileService, repo *repository.RepositoryContext, hasher Hasher, cfg config.QueueConfig, sessions *MachineSessions, nnArch INNArchService, notifier *TaskNotifier) *QueueService {
 	return &QueueService{
 		RepositoryContext: repo,
 		FileService:       fileService,
 		Hasher:            hasher,
+		Config:            cfg,
+		Sessions:          sessions,
+		NNArch:            nnArch,
+		Notifier:          notifier,
+		Policy:            NewSchedulingPolicy(cfg, repo.TrainingTask),
 	}
 }
 
+var errTaskNotRunning = &ErrHandlerConflict{
+	Resource: "TrainingTask",
+	Msg:      "is not running, its lease cannot be renewed",
+}
+
+var errNoTaskToRun = errors.New("no task to run")
+
+var errTaskStatusChanged = &ErrHandlerConflict{
+	Resource: "TrainingTask",
+	Msg:      "status was changed in the meantime",
+}
+
+func (qs *QueueService) leaseExpiration() *time.Time {
+	expiresAt := time.Now().Add(time.Duration(qs.Config.LeaseSeconds) * time.Second)
+	return &expiresAt
+}
+
 func (qs *QueueService) AuthorizeTrainingMachine(secretID string, tmID uint) (*models.TrainingMachine, error) {
+	trainingMachine, _, err := qs.authorizeSecret(secretID, tmID)
+	return trainingMachine, err
+}
+
+// authorizeSecret verifies the secret key of the machine and returns hash of
+// the key it matched, the previous one is accepted during its grace period.
+func (qs *QueueService) authorizeSecret(secretID string, tmID uint) (*models.TrainingMachine, string, error) {
 	trainingMachine, err := qs.TrainingMachine.GetByID(tmID)
+	if err != nil {
+		return nil, "", err
+	}
+	if trainingMachine.CertificateSubject != "" {
+		return nil, "", errCertificateRequired
+	}
+
+	now := time.Now()
+	keyHashed := trainingMachine.SecretKeyHashed
+	ok, err := qs.Hasher.VerifyKey(secretID, keyHashed)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !ok && trainingMachine.PreviousSecretKeyValid(now) {
+		// key replaced by a rotation is accepted during the grace period
+		keyHashed = trainingMachine.PreviousSecretKeyHashed
+		ok, err = qs.Hasher.VerifyKey(secretID, keyHashed)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if !ok {
+		return nil, "", errors.New("authorization failure")
+	}
+
+	err = qs.touchMachine(trainingMachine, keyHashed, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return trainingMachine, keyHashed, nil
+}
+
+// machineTouchInterval is how often activity of a machine is written at
+// most, well below MachineStaleAfter so its health is not affected.
+const machineTouchInterval = time.Minute
+
+func touchedRecently(at *time.Time, now time.Time) bool {
+	return at != nil && now.Sub(*at) < machineTouchInterval
+}
+
+// touchMachine records activity of the machine and use of its key, if it
+// was authorized by one. Only the timestamps are written, the machine may be
+// changed by its owner while the key is being checked. Nothing is written
+// when they were recorded less than machineTouchInterval ago.
+func (qs *QueueService) touchMachine(tm *models.TrainingMachine, keyHashed string, now time.Time) error {
+	var usedAt **time.Time
+	var usedColumn string
+	switch keyHashed {
+	case "":
+	case tm.SecretKeyHashed:
+		usedAt, usedColumn = &tm.SecretKeyLastUsedAt, "secret_key_last_used_at"
+	default:
+		usedAt, usedColumn = &tm.PreviousSecretKeyLastUsedAt, "previous_secret_key_last_used_at"
+	}
+
+	if touchedRecently(&tm.LastActivityAt, now) && (usedAt == nil || touchedRecently(*usedAt, now)) {
+		return nil
+	}
+
+	columns := map[string]interface{}{"last_activity_at": now}
+	tm.LastActivityAt = now
+	if usedAt != nil {
+		columns[usedColumn] = now
+		*usedAt = &now
+	}
+
+	err := qs.TrainingMachine.Touch(tm.ID, columns)
+	if err != nil {
+		return errors.New("machine activity timestamp error")
+	}
+	return nil
+}
+
+// CreateMachineSession exchanges the secret key of the machine for a session
+// token, so the costly key derivation runs once per session.
+func (qs *QueueService) CreateMachineSession(secretID string, tmID uint) (*MachineSession, error) {
+	trainingMachine, keyHashed, err := qs.authorizeSecret(secretID, tmID)
 	if err != nil {
 		return nil, err
 	}
 
-	ok, err := qs.Hasher.VerifyKey(secretID, trainingMachine.SecretKeyHashed)
+	return qs.Sessions.Issue(trainingMachine.ID, keyHashed, time.Now()), nil
+}
+
+// AuthorizeMachineSession authorizes the machine by its session token.
+func (qs *QueueService) AuthorizeMachineSession(token string, tmID uint) (*models.TrainingMachine, error) {
+	now := time.Now()
+	fingerprint, err := qs.Sessions.Verify(token, tmID, now)
 	if err != nil {
 		return nil, err
 	}
 
+	trainingMachine, err := qs.TrainingMachine.GetByID(tmID)
+	if err != nil {
+		return nil, err
+	}
+	if trainingMachine.CertificateSubject != "" {
+		return nil, errCertificateRequired
+	}
+
+	keyHashed, ok := boundKey(trainingMachine, fingerprint, now)
 	if !ok {
-		return nil, errors.New("authorization failure")
+		return nil, errInvalidMachineSession
 	}
 
-	trainingMachine.LastActivityAt = time.Now()
-	err = qs.TrainingMachine.Update(trainingMachine)
+	err = qs.touchMachine(trainingMachine, keyHashed, now)
 	if err != nil {
-		return nil, errors.New("machine activity timestamp error")
+		return nil, err
+	}
+
+	return trainingMachine, nil
+}
+
+// AuthorizeMachineCertificate authorizes the machine bound to the subject
+// of a verified client certificate.
+func (qs *QueueService) AuthorizeMachineCertificate(subject string, tmID uint) (*models.TrainingMachine, error) {
+	trainingMachine, err := qs.TrainingMachine.GetByID(tmID)
+	if err != nil {
+		return nil, err
+	}
+
+	if trainingMachine.CertificateSubject == "" || trainingMachine.CertificateSubject != subject {
+		return nil, errors.New("certificate is not bound to the machine")
+	}
+
+	err = qs.touchMachine(trainingMachine, "", time.Now())
+	if err != nil {
+		return nil, err
 	}
 
 	return trainingMachine, nil
 }
 
-func (qs *QueueService) UpdateTrainingTaskStatus(taskID uint, status models.TrainingTaskStatus) error {
+// UpdateTrainingTaskStatus applies status reported by the training machine,
+// failure details may be given only together with the Failed status. Reports
+// for a cancelled task are ignored, the returned task tells the machine to abort.
+func (qs *QueueService) UpdateTrainingTaskStatus(taskID uint, status models.TrainingTaskStatus, failure *models.TaskFailure) (*models.TrainingTask, error) {
+	if failure != nil && status != models.Failed {
+		return nil, &ErrHandlerValidation{
+			Field: "ErrorCode",
+			Msg:   "can be reported only with Failed status",
+		}
+	}
+
+	failure, err := normalizeFailure(failure)
+	if err != nil {
+		return nil, err
+	}
+
 	tt, err := qs.TrainingTask.GetByID(taskID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	from := tt.Status
+	if from.IsCancelled() {
+		return tt, nil
+	}
+
+	// repeated report of the current status is accepted as a heartbeat,
+	// so the machine can safely retry requests
+	if status == from && status.IsRunning() {
+		return qs.renewLease(tt)
+	}
+
+	allowed := machineNextStatuses(from)
+	if !slices.Contains(allowed, status) {
+		return nil, errIllegalTransition(from, status, allowed)
+	}
+
+	if status == models.Failed {
+		if failure == nil {
+			failure = &models.TaskFailure{}
+		}
+		err := qs.failTask(tt, *failure, models.StatusSourceMachine, nil)
+		if errors.Is(err, errTaskStatusChanged) {
+			return qs.reloadChangedTask(taskID)
+		}
+		return tt, err
 	}
 
 	tt.Status = status
-	return qs.TrainingTask.Update(tt)
+	if status.IsRunning() {
+		tt.LeaseExpiresAt = qs.leaseExpiration()
+	} else {
+		tt.LeaseExpiresAt = nil
+		qs.markFinished(tt)
+	}
+
+	updated, err := qs.TrainingTask.UpdateStatus(tt.ID, from, map[string]interface{}{
+		"status":           tt.Status,
+		"lease_expires_at": tt.LeaseExpiresAt,
+		"finished_at":      tt.FinishedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return qs.reloadChangedTask(taskID)
+	}
+
+	return tt, recordStatusChange(qs.RepositoryContext, &models.TrainingTaskStatusEvent{
+		TrainingTaskId:    tt.ID,
+		FromStatus:        &from,
+		ToStatus:          status,
+		Source:            models.StatusSourceMachine,
+		TrainingMachineId: tt.TrainingMachineId,
+	})
+}
+
+// reloadChangedTask returns the task whose status was changed since the
+// machine request read it. A cancelled task is returned as is, so the machine
+// aborts it, any other change conflicts with the request.
+func (qs *QueueService) reloadChangedTask(taskID uint) (*models.TrainingTask, error) {
+	tt, err := qs.TrainingTask.GetByID(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if tt.Status.IsCancelled() {
+		return tt, nil
+	}
+	return nil, errTaskStatusChanged
+}
+
+// renewLease extends the lease of the running task, unless its status was
+// changed since it was read.
+func (qs *QueueService) renewLease(tt *models.TrainingTask) (*models.TrainingTask, error) {
+	leaseExpiresAt := qs.leaseExpiration()
+	renewed, err := qs.TrainingTask.UpdateStatus(tt.ID, tt.Status, map[string]interface{}{"lease_expires_at": leaseExpiresAt})
+	if err != nil {
+		return nil, fmt.Errorf("cannot renew lease: %w", err)
+	}
+	if !renewed {
+		return qs.reloadChangedTask(tt.ID)
+	}
+
+	tt.LeaseExpiresAt = leaseExpiresAt
+	return tt, nil
+}
+
+func (qs *QueueService) markFinished(tt *models.TrainingTask) {
+	if tt.FinishedAt == nil && (tt.Status == models.Completed || tt.Status == models.Failed) {
+		now := time.Now()
+		tt.FinishedAt = &now
+	}
+}
+
+// failTask marks the current attempt of the task as failed and requeues the
+// task when its retry policy allows another attempt. It returns
+// errTaskStatusChanged when the status was changed since the task was read,
+// or when leaseExpiredAt is given and the lease was renewed before it.
+func (qs *QueueService) failTask(tt *models.TrainingTask, failure models.TaskFailure, source models.StatusEventSource, leaseExpiredAt *time.Time) error {
+	from := tt.Status
+	machineId := tt.TrainingMachineId
+	tt.Status = models.Failed
+	tt.Failure = failure
+	tt.LeaseExpiresAt = nil
+	qs.markFinished(tt)
+
+	columns := map[string]interface{}{
+		"status":              tt.Status,
+		"failure_code":        failure.Code,
+		"failure_message":     failure.Message,
+		"failure_log_excerpt": failure.LogExcerpt,
+		"lease_expires_at":    nil,
+		"finished_at":         tt.FinishedAt,
+		"lost_leases":         tt.LostLeases,
+	}
+	var updated bool
+	var err error
+	if leaseExpiredAt != nil {
+		updated, err = qs.TrainingTask.UpdateExpired(tt.ID, from, *leaseExpiredAt, columns)
+	} else {
+		updated, err = qs.TrainingTask.UpdateStatus(tt.ID, from, columns)
+	}
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errTaskStatusChanged
+	}
+	err = recordStatusChange(qs.RepositoryContext, &models.TrainingTaskStatusEvent{
+		TrainingTaskId:    tt.ID,
+		FromStatus:        &from,
+		ToStatus:          models.Failed,
+		Source:            source,
+		TrainingMachineId: machineId,
+	})
+	if err != nil {
+		return err
+	}
+
+	policy := qs.retryPolicy(tt)
+	if tt.Retries+1 >= policy.MaxAttempts {
+		return failDependentTasks(qs.RepositoryContext, tt)
+	}
+
+	return qs.retryTask(tt, policy)
+}
+
+// retryPolicy merges per-task overrides with the architecture defaults.
+func (qs *QueueService) retryPolicy(tt *models.TrainingTask) NNRetryPolicy {
+	var policy NNRetryPolicy
+	if qs.NNArch != nil {
+		policy = qs.NNArch.GetRetryPolicy()
+	}
+	if tt.MaxAttempts > 0 {
+		policy.MaxAttempts = tt.MaxAttempts
+	}
+	if tt.RetryBackoffSeconds > 0 {
+		policy.BackoffSeconds = tt.RetryBackoffSeconds
+	}
+	return policy
+}
+
+// MaxWallTime is the limit of the current attempt duration, the task
+// override or the architecture default. Zero means no limit.
+func (qs *QueueService) MaxWallTime(tt *models.TrainingTask) time.Duration {
+	seconds := tt.MaxWallTimeSeconds
+	if seconds == 0 && qs.NNArch != nil {
+		seconds = qs.NNArch.GetMaxWallTimeSeconds()
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ExpectedOnnx lists local names of the ONNX results the trainer has to
+// write, sent to the agent with the task so it does not need its own copy
+// of the architecture.
+func (qs *QueueService) ExpectedOnnx() []string {
+	if qs.NNArch == nil {
+		return nil
+	}
+	onnx := qs.NNArch.GetExpectedResults().Onnx
+	names := make([]string, 0, len(onnx))
+	for name := range onnx {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// retryBackoff doubles the base backoff with every retry, up to the maximum.
+func retryBackoff(policy NNRetryPolicy, retries uint) time.Duration {
+	backoff := time.Duration(policy.BackoffSeconds) * time.Second
+	limit := time.Duration(policy.MaxBackoffSeconds) * time.Second
+	for i := uint(0); i < retries && (limit == 0 || backoff < limit); i++ {
+		backoff *= 2
+	}
+	if limit > 0 && backoff > limit {
+		backoff = limit
+	}
+	return backoff
+}
+
+func (qs *QueueService) retryTask(tt *models.TrainingTask, policy NNRetryPolicy) error {
+	attempt := &models.TrainingTaskAttempt{
+		TrainingTaskId:    tt.ID,
+		Retry:             tt.Retries,
+		TrainingMachineId: tt.TrainingMachineId,
+		AssignedAt:        tt.AssignedAt,
+		FinishedAt:        tt.FinishedAt,
+		Failure:           tt.Failure,
+	}
+
+	// the failed task may be changed concurrently, e.g. cancelled, then the
+	// retry is dropped
+	retryAt := time.Now().Add(retryBackoff(policy, tt.Retries))
+	requeued, err := qs.TrainingTask.UpdateStatus(tt.ID, models.Failed, map[string]interface{}{
+		"status":              models.Queued,
+		"retries":             tt.Retries + 1,
+		"retry_at":            retryAt,
+		"training_machine_id": nil,
+		"assigned_at":         nil,
+		"finished_at":         nil,
+		"lost_leases":         0,
+		"failure_code":        "",
+		"failure_message":     "",
+		"failure_log_excerpt": "",
+	})
+	if err != nil {
+		return fmt.Errorf("cannot requeue training task %d: %w", tt.ID, err)
+	}
+	if !requeued {
+		log.Printf("training task %d changed before its retry was scheduled", tt.ID)
+		return nil
+	}
+
+	if err := qs.TrainingTaskAttempt.Create(attempt); err != nil {
+		return fmt.Errorf("cannot archive attempt of training task %d: %w", tt.ID, err)
+	}
+
+	tt.Retries++
+	tt.RetryAt = &retryAt
+	tt.Status = models.Queued
+	tt.TrainingMachineId = nil
+	tt.AssignedAt = nil
+	tt.FinishedAt = nil
+	tt.LostLeases = 0
+	tt.Failure = models.TaskFailure{}
+
+	log.Printf("training task %d failed, retry %d of %d scheduled at %s", tt.ID, tt.Retries, policy.MaxAttempts-1, retryAt.Format(time.RFC3339))
+	// wake up long-polling machines once the backoff passes
+	time.AfterFunc(time.Until(retryAt), qs.Notifier.Notify)
+
+	from := models.Failed
+	return recordStatusChange(qs.RepositoryContext, &models.TrainingTaskStatusEvent{
+		TrainingTaskId: tt.ID,
+		FromStatus:     &from,
+		ToStatus:       models.Queued,
+		Source:         models.StatusSourceQueue,
+	})
+}
+
+// AssignTaskToMachine claims the first queued task, in the order given by the
+// scheduling policy, which the training machine is able to run. Claims are
+// atomic, so a task lost to a concurrently polling machine is skipped in
+// favour of the next one. Draining and disabled machines get no tasks.
+func (qs *QueueService) AssignTaskToMachine(tm *models.TrainingMachine) (*models.TrainingTask, error) {
+	if !tm.State.AcceptsTasks() {
+		return nil, errMachineNotAcceptingTasks
+	}
+
+	now := time.Now()
+	filter := qs.candidateFilter(tm, now)
+	for {
+		queued, err := qs.TrainingTask.GetQueued(filter)
+		if err != nil {
+			return nil, fmt.Errorf("cannot query queued tasks: %w", err)
+		}
+
+		candidates, err := qs.Policy.Order(queued, now)
+		if err != nil {
+			return nil, fmt.Errorf("cannot schedule queued tasks: %w", err)
+		}
+
+		for _, candidate := range candidates {
+			if len(machineBlockers(&candidate, tm)) > 0 {
+				continue
+			}
+
+			claimed, err := qs.TrainingTask.Claim(candidate.ID, tm.ID, *qs.leaseExpiration())
+			if err != nil {
+				return nil, fmt.Errorf("cannot assign task to machine: %w", err)
+			}
+
+			if claimed {
+				err := recordStatusChange(qs.RepositoryContext, &models.TrainingTaskStatusEvent{
+					TrainingTaskId:    candidate.ID,
+					FromStatus:        &candidate.Status,
+					ToStatus:          models.Training,
+					Source:            models.StatusSourceMachine,
+					TrainingMachineId: &tm.ID,
+				})
+				if err != nil {
+					return nil, err
+				}
+				return qs.TrainingTask.GetByID(candidate.ID)
+			}
+		}
+
+		// none of the candidates can run on the machine, the next page
+		// may hold one
+		if filter.Limit == 0 || len(queued) < filter.Limit {
+			break
+		}
+		filter.Offset += filter.Limit
+	}
+
+	return nil, errNoTaskToRun
+}
+
+// candidateFilter selects queued tasks offered to the training machine.
+// Candidates are fetched in pages only when the database orders them the
+// same way as the policy, aging priorities and users' machine time are
+// known only to the policy, so it orders all queued tasks.
+func (qs *QueueService) candidateFilter(tm *models.TrainingMachine, now time.Time) repository.QueuedFilter {
+	filter := repository.QueuedFilter{TrainingMachineId: tm.ID, Now: now}
+	switch qs.Config.SchedulingPolicy {
+	case "", FIFOPolicyName:
+		filter.Limit = int(qs.Config.CandidateLimit)
+	case PriorityPolicyName:
+		if qs.Config.PriorityAgingMinutes == 0 {
+			filter.ByPriority = true
+			filter.Limit = int(qs.Config.CandidateLimit)
+		}
+	}
+	return filter
 }
 
-func (qs *QueueService) AssignTaskToMachine(tmID uint) (*models.TrainingTask, error) {
-	tt, err := qs.TrainingTask.GetFirstQueued()
+// WaitForTask long-polls the queue: it assigns a task to the training machine
+// as soon as a matching one is queued or gives up after the wait duration,
+// capped by the queue configuration. Besides notifications about newly
+// queued tasks, the queue is rechecked periodically, so tasks queued by
+// another server instance or leaving the retry backoff are picked up as well.
+// The wait ends early when the machine stops accepting tasks.
+func (qs *QueueService) WaitForTask(ctx context.Context, tm *models.TrainingMachine, wait time.Duration) (*models.TrainingTask, error) {
+	maxWait := time.Duration(qs.Config.LongPollMaxSeconds) * time.Second
+	if wait > maxWait {
+		wait = maxWait
+	}
+	recheck := time.Duration(qs.Config.LongPollRecheckSeconds) * time.Second
+	if recheck <= 0 {
+		recheck = time.Second
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+
+	for {
+		// subscribe before checking the queue, so no notification is lost
+		notified := qs.Notifier.Wait()
+
+		tt, err := qs.AssignTaskToMachine(tm)
+		if !errors.Is(err, errNoTaskToRun) {
+			return tt, err
+		}
+
+		recheckTimer := time.NewTimer(recheck)
+		select {
+		case <-ctx.Done():
+			recheckTimer.Stop()
+			return nil, errNoTaskToRun
+		case <-deadline.C:
+			recheckTimer.Stop()
+			return nil, errNoTaskToRun
+		case <-notified:
+		case <-recheckTimer.C:
+		}
+		recheckTimer.Stop()
+
+		// the machine may have been drained or disabled while waiting
+		if tm, err = qs.TrainingMachine.GetByID(tm.ID); err != nil {
+			return nil, fmt.Errorf("cannot reload training machine: %w", err)
+		}
+	}
+}
+
+// UpdateMachineLabels replaces capability labels declared by the training machine.
+func (qs *QueueService) UpdateMachineLabels(tm *models.TrainingMachine, labels map[string]string) error {
+	// column updates bypass the JSON serializer of the field
+	encoded, err := json.Marshal(labels)
 	if err != nil {
-		return nil, errors.New("no task to run")
+		return errInternalServerError
+	}
+	if _, err := qs.TrainingMachine.UpdateColumns(tm.ID, nil, map[string]interface{}{"labels": string(encoded)}); err != nil {
+		return errInternalServerError
 	}
+	tm.Labels = labels
+	return nil
+}
+
+// GetMachineActivity returns the state of the training machine and tasks it runs.
+func (qs *QueueService) GetMachineActivity(tm *models.TrainingMachine) (*MachineActivity, error) {
+	return machineActivity(qs.RepositoryContext, tm)
+}
 
-	tt.TrainingMachineId = &tmID
-	tt.Status = models.Training
+// SetMachineState lets the training machine drain or disable itself, e.g.
+// before a reboot.
+func (qs *QueueService) SetMachineState(tm *models.TrainingMachine, state models.TrainingMachineState) (*MachineActivity, error) {
+	return setMachineState(qs.RepositoryContext, tm, state)
+}
 
-	err = qs.TrainingTask.Update(tt)
+// RecordTelemetry stores a health report of the training machine and drops
+// its reports older than telemetryRetention.
+func (qs *QueueService) RecordTelemetry(tm *models.TrainingMachine, telemetry *models.TrainingMachineTelemetry) error {
+	if err := validateTelemetry(telemetry); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	telemetry.ID = 0
+	telemetry.TrainingMachineId = tm.ID
+	telemetry.CreatedAt = now
+	if err := qs.TrainingMachineTelemetry.Create(telemetry); err != nil {
+		return errInternalServerError
+	}
+
+	err := qs.TrainingMachine.Touch(tm.ID, map[string]interface{}{
+		"last_telemetry_at": now,
+		"agent_version":     telemetry.AgentVersion,
+		"driver_version":    telemetry.DriverVersion,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("cannot assign task to machine: %w", err)
+		return errInternalServerError
+	}
+	tm.LastTelemetryAt = &now
+	tm.AgentVersion = telemetry.AgentVersion
+	tm.DriverVersion = telemetry.DriverVersion
+
+	if err := qs.TrainingMachineTelemetry.DeleteBefore(tm.ID, now.Add(-telemetryRetention)); err != nil {
+		log.Printf("cannot prune telemetry of training machine %d: %s", tm.ID, err.Error())
+	}
+	return nil
+}
+
+// RenewLease extends the lease of a running task, it is called on every
+// heartbeat of the training machine holding the task. A cancelled task is
+// returned unchanged, so the machine can abort it.
+func (qs *QueueService) RenewLease(taskID uint) (*models.TrainingTask, error) {
+	tt, err := qs.TrainingTask.GetByID(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tt.Status.IsCancelled() {
+		return tt, nil
+	}
+
+	if !tt.Status.IsRunning() {
+		return nil, errTaskNotRunning
+	}
+
+	return qs.renewLease(tt)
+}
+
+// AppendLog appends data streamed from the given offset to the log of
+// the current task attempt and returns the log size. Data already stored is
+// skipped, so the machine can safely resend a chunk after a failed request,
+// also while the original request is still being processed.
+func (qs *QueueService) AppendLog(taskID uint, offset int64, data []byte) (*models.TrainingTask, int64, error) {
+	tt, err := qs.TrainingTask.GetByID(taskID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, err := qs.TrainingTaskLogChunk.GetSize(tt.ID, tt.Retries)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot read log size: %w", err)
+	}
+
+	if tt.Status.IsCancelled() {
+		return tt, size, nil
+	}
+
+	if !tt.Status.IsRunning() {
+		return nil, 0, &ErrHandlerConflict{
+			Resource: "TrainingTask",
+			Msg:      "is not running, logs cannot be streamed",
+		}
+	}
+
+	if offset < 0 {
+		return nil, 0, &ErrHandlerValidation{Field: "offset", Msg: "must not be negative"}
+	}
+	if len(data) > models.MaxLogChunkSize {
+		return nil, 0, &ErrHandlerValidation{
+			Field: "chunk",
+			Msg:   fmt.Sprintf("must not be larger than %d bytes", models.MaxLogChunkSize),
+		}
+	}
+
+	if offset > size {
+		return nil, 0, &ErrHandlerConflict{
+			Resource: "log chunk",
+			Msg:      fmt.Sprintf("at offset %d leaves a gap, expected offset %d", offset, size),
+		}
+	}
+
+	end := offset + int64(len(data))
+	if end <= size {
+		return tt, size, nil
+	}
+
+	chunk := &models.TrainingTaskLogChunk{
+		TrainingTaskId: tt.ID,
+		Retry:          tt.Retries,
+		Offset:         size,
+		Size:           end - size,
+		Data:           data[size-offset:],
+	}
+	created, err := qs.TrainingTaskLogChunk.CreateIfAbsent(chunk)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot store log chunk: %w", err)
+	}
+	if !created {
+		// a chunk at the same offset was stored concurrently, e.g. by the
+		// original of a resent request, the rest is appended after it
+		if err := qs.checkStoredLogChunk(chunk); err != nil {
+			return nil, 0, err
+		}
+		return qs.AppendLog(taskID, offset, data)
+	}
+
+	return tt, end, nil
+}
+
+// checkStoredLogChunk compares the chunk with the one stored at its offset.
+func (qs *QueueService) checkStoredLogChunk(chunk *models.TrainingTaskLogChunk) error {
+	stored, err := qs.TrainingTaskLogChunk.GetFrom(chunk.TrainingTaskId, chunk.Retry, chunk.Offset, 1)
+	if err != nil {
+		return fmt.Errorf("cannot read log chunk: %w", err)
+	}
+	if len(stored) == 0 || stored[0].Offset != chunk.Offset {
+		return fmt.Errorf("log chunk at offset %d of training task %d is missing", chunk.Offset, chunk.TrainingTaskId)
+	}
+
+	overlap := min(len(stored[0].Data), len(chunk.Data))
+	if !bytes.Equal(stored[0].Data[:overlap], chunk.Data[:overlap]) {
+		return &ErrHandlerConflict{
+			Resource: "log chunk",
+			Msg:      fmt.Sprintf("at offset %d differs from the stored one", chunk.Offset),
+		}
+	}
+	return nil
+}
+
+// RecordMetrics stores metrics reported by the machine running the task.
+// Metrics of a cancelled task are dropped, the returned task tells the machine
+// to abort.
+func (qs *QueueService) RecordMetrics(taskID uint, points []MetricPoint) (*models.TrainingTask, error) {
+	tt, err := qs.TrainingTask.GetByID(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tt.Status.IsCancelled() {
+		return tt, nil
+	}
+
+	if !tt.Status.IsRunning() {
+		return nil, &ErrHandlerConflict{
+			Resource: "TrainingTask",
+			Msg:      "is not running, metrics cannot be reported",
+		}
+	}
+
+	metrics, err := metricsFromPoints(tt, points)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := qs.TrainingTaskMetric.Create(metrics); err != nil {
+		return nil, fmt.Errorf("cannot store metrics: %w", err)
 	}
 
 	return tt, nil
 }
 
-func (qs *QueueService) CreateTrainingTaskResult(ttID uint, file multipart.File, handler *multipart.FileHeader, name, description, fileType string) (*models.TrainingTaskResult, error) {
+// RequeueExpiredTasks returns tasks whose machines stopped sending heartbeats
+// back to the queue. A task which lost its lease MaxLostLeases times is marked
+// as failed instead. Tasks whose lease was renewed after they were read are
+// left to their machines.
+func (qs *QueueService) RequeueExpiredTasks() error {
+	now := time.Now()
+	tts, err := qs.TrainingTask.GetWithExpiredLease(now)
+	if err != nil {
+		return err
+	}
+
+	for i := range tts {
+		tt := &tts[i]
+		from := tt.Status
+		lostMachineId := tt.TrainingMachineId
+		tt.LostLeases++
+		if qs.Config.MaxLostLeases > 0 && tt.LostLeases >= qs.Config.MaxLostLeases {
+			log.Printf("lease of training task %d expired (%d lost), marking it as failed", tt.ID, tt.LostLeases)
+			err := qs.failTask(tt, models.TaskFailure{
+				Code:    models.FailureCodeLeaseExpired,
+				Message: fmt.Sprintf("training machine stopped sending heartbeats %d times", tt.LostLeases),
+			}, models.StatusSourceQueue, &now)
+			if err != nil && !errors.Is(err, errTaskStatusChanged) {
+				return fmt.Errorf("cannot fail training task %d: %w", tt.ID, err)
+			}
+			continue
+		}
+
+		requeued, err := qs.TrainingTask.UpdateExpired(tt.ID, from, now, map[string]interface{}{
+			"status":              models.Queued,
+			"training_machine_id": nil,
+			"lease_expires_at":    nil,
+			"assigned_at":         nil,
+			"lost_leases":         tt.LostLeases,
+		})
+		if err != nil {
+			return fmt.Errorf("cannot requeue training task %d: %w", tt.ID, err)
+		}
+		if !requeued {
+			continue
+		}
+		tt.LeaseExpiresAt = nil
+		tt.TrainingMachineId = nil
+		tt.Status = models.Queued
+		tt.AssignedAt = nil
+
+		log.Printf("lease of training task %d expired (%d lost), moved to %s", tt.ID, tt.LostLeases, tt.Status)
+		qs.Notifier.Notify()
+		err = recordStatusChange(qs.RepositoryContext, &models.TrainingTaskStatusEvent{
+			TrainingTaskId:    tt.ID,
+			FromStatus:        &from,
+			ToStatus:          tt.Status,
+			Source:            models.StatusSourceQueue,
+			TrainingMachineId: lostMachineId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FailOverrunningTasks is the watchdog of hanging trainers, it fails running
+// tasks whose current attempt exceeded the maximum wall time.
+func (qs *QueueService) FailOverrunningTasks() error {
+	tts, err := qs.TrainingTask.GetRunning()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range tts {
+		tt := &tts[i]
+		limit := qs.MaxWallTime(tt)
+		if limit <= 0 || tt.AssignedAt == nil || now.Sub(*tt.AssignedAt) <= limit {
+			continue
+		}
+
+		log.Printf("training task %d exceeded its maximum wall time of %s, marking it as failed", tt.ID, limit)
+		err := qs.failTask(tt, models.TaskFailure{
+			Code:    models.FailureCodeWallTimeExceeded,
+			Message: fmt.Sprintf("attempt exceeded the maximum wall time of %s", limit),
+		}, models.StatusSourceQueue, nil)
+		if err != nil && !errors.Is(err, errTaskStatusChanged) {
+			return fmt.Errorf("cannot fail training task %d: %w", tt.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// ExpireUploads deletes resumable uploads which received no chunk within
+// the configured TTL together with their partial files.
+func (qs *QueueService) ExpireUploads() error {
+	if qs.Config.UploadTTLHours == 0 {
+		return nil
+	}
+
+	before := time.Now().Add(-time.Duration(qs.Config.UploadTTLHours) * time.Hour)
+	uploads, err := qs.TrainingTaskUpload.GetStale(before)
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		// the upload may receive a chunk or be finalized in the meantime,
+		// its partial file is removed only once the upload is deleted
+		deleted, err := qs.TrainingTaskUpload.DeleteStale(upload.ID, before)
+		if err != nil {
+			return fmt.Errorf("cannot delete upload %d: %w", upload.ID, err)
+		}
+		if !deleted {
+			continue
+		}
+		log.Printf("upload %d of training task %d expired, deleted it", upload.ID, upload.TrainingTaskId)
+		if err := qs.FileService.RemovePartialFile(upload.PartialPath); err != nil {
+			return fmt.Errorf("cannot remove partial file of upload %d: %w", upload.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// RunMaintenance periodically performs queue housekeeping until ctx is done.
+func (qs *QueueService) RunMaintenance(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		log.Println("queue maintenance disabled: non-positive interval")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := qs.RequeueExpiredTasks(); err != nil {
+				log.Printf("queue maintenance error: %s", err.Error())
+			}
+			if err := qs.FailOverrunningTasks(); err != nil {
+				log.Printf("queue maintenance error: %s", err.Error())
+			}
+			if err := qs.ExpireUploads(); err != nil {
+				log.Printf("queue maintenance error: %s", err.Error())
+			}
+		}
+	}
+}
+
+// CreateTrainingTaskResult stores a result file sent in one request.
+// The optional expectedSHA256 is the digest of the file computed by the machine.
+func (qs *QueueService) CreateTrainingTaskResult(ttID uint, file multipart.File, handler *multipart.FileHeader, name, description, fileType, expectedSHA256 string) (*models.TrainingTaskResult, error) {
 	tt, err := qs.TrainingTask.GetByID(ttID)
 	if err != nil {
 		return nil, errors.New("training task does not exist")
 	}
 
-	fileModel, err := qs.FileService.SaveFile(file, handler)
+	if expectedSHA256 != "" && !sha256Regex.MatchString(expectedSHA256) {
+		return nil, &ErrHandlerValidation{Field: "sha256", Msg: "must be a hex encoded SHA-256 digest"}
+	}
+
+	fileModel, err := qs.FileService.SaveFile(file, handler, expectedSHA256)
 	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			return nil, &ErrHandlerValidation{Field: "sha256", Msg: "does not match the uploaded file"}
+		}
 		return nil, fmt.Errorf("error saving file: %w", err)
 	}
 
@@ -102,6 +1017,7 @@ func (qs *QueueService) CreateTrainingTaskResult(ttID uint, file multipart.File,
 		Description:    description,
 		Type:           models.TrainingTaskResultType(fileTypeUint),
 		TrainingTaskId: tt.ID,
+		Retry:          tt.Retries,
 	}
 
 	err = qs.TrainingTaskResult.Create(ttr)
@@ -111,3 +1027,238 @@ func (qs *QueueService) CreateTrainingTaskResult(ttID uint, file multipart.File,
 
 	return ttr, nil
 }
+
+var errUploadNotFound = NewErrHandlerNotFound("TrainingTaskUpload")
+
+var errUploadExpired = &ErrHandlerGone{
+	Resource: "TrainingTaskUpload",
+	Msg:      "expired, start a new upload",
+}
+
+var sha256Regex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// InitiateUpload starts a resumable upload of a training task result.
+func (qs *QueueService) InitiateUpload(ttID uint, upload *models.TrainingTaskUpload) error {
+	tt, err := qs.TrainingTask.GetByID(ttID)
+	if err != nil {
+		return errTaskNotFound
+	}
+
+	if upload.Name == "" {
+		return &ErrHandlerValidation{Field: "Name", Msg: errMsgMissing}
+	}
+	if upload.FileName == "" {
+		return &ErrHandlerValidation{Field: "FileName", Msg: errMsgMissing}
+	}
+	if upload.Size <= 0 {
+		return &ErrHandlerValidation{Field: "Size", Msg: "must be positive"}
+	}
+	if upload.Type > models.Onnx {
+		return &ErrHandlerValidation{Field: "Type", Msg: "is not a known result type"}
+	}
+
+	partialPath, err := qs.FileService.CreatePartialFile()
+	if err != nil {
+		return fmt.Errorf("cannot create partial file: %w", err)
+	}
+
+	upload.TrainingTaskId = tt.ID
+	upload.Retry = tt.Retries
+	upload.Received = 0
+	upload.PartialPath = partialPath
+
+	if err := qs.TrainingTaskUpload.Create(upload); err != nil {
+		return fmt.Errorf("cannot create upload: %w", err)
+	}
+
+	return nil
+}
+
+// GetUpload returns the upload of the task, its Received field is the offset
+// of the next chunk.
+func (qs *QueueService) GetUpload(ttID, uploadID uint) (*models.TrainingTaskUpload, error) {
+	upload, err := qs.TrainingTaskUpload.GetByID(uploadID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errUploadNotFound
+		}
+		return nil, err
+	}
+
+	if upload.TrainingTaskId != ttID {
+		return nil, errUploadNotFound
+	}
+
+	return upload, nil
+}
+
+// WriteUploadChunk streams a chunk read from r into the upload at the offset.
+// Bytes stored before a dropped connection are kept, so the machine can resume
+// from the offset returned by GetUpload.
+func (qs *QueueService) WriteUploadChunk(ttID, uploadID uint, offset int64, r io.Reader) (*models.TrainingTaskUpload, error) {
+	upload, err := qs.GetUpload(ttID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, &ErrHandlerValidation{Field: "offset", Msg: "must not be negative"}
+	}
+	if offset > upload.Received {
+		return nil, &ErrHandlerConflict{
+			Resource: "upload chunk",
+			Msg:      fmt.Sprintf("at offset %d leaves a gap, expected offset %d", offset, upload.Received),
+		}
+	}
+
+	written, writeErr := qs.FileService.WritePartialFile(upload.PartialPath, offset, io.LimitReader(r, upload.Size-offset))
+
+	// a resent chunk may be written concurrently, the offset only grows
+	end := offset + written
+	for end > upload.Received {
+		updated, err := qs.TrainingTaskUpload.UpdateReceived(upload.ID, upload.Received, end)
+		if err != nil {
+			return nil, fmt.Errorf("cannot update upload: %w", err)
+		}
+		if updated {
+			upload.Received = end
+			break
+		}
+
+		upload, err = qs.GetUpload(ttID, uploadID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if writeErr != nil {
+		return nil, fmt.Errorf("cannot store upload chunk: %w", writeErr)
+	}
+
+	// anything left in the chunk lies beyond the declared size
+	if n, _ := r.Read(make([]byte, 1)); n > 0 {
+		return nil, &ErrHandlerValidation{
+			Field: "chunk",
+			Msg:   fmt.Sprintf("exceeds the declared size of %d bytes", upload.Size),
+		}
+	}
+
+	return upload, nil
+}
+
+// FinalizeUpload verifies the SHA-256 checksum of the complete upload and
+// turns it into a training task result.
+func (qs *QueueService) FinalizeUpload(ttID, uploadID uint, checksum string) (*models.TrainingTaskResult, error) {
+	upload, err := qs.GetUpload(ttID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sha256Regex.MatchString(checksum) {
+		return nil, &ErrHandlerValidation{Field: "SHA256", Msg: "must be a hex encoded SHA-256 digest"}
+	}
+
+	if !upload.IsComplete() {
+		return nil, &ErrHandlerConflict{
+			Resource: "TrainingTaskUpload",
+			Msg:      fmt.Sprintf("is incomplete, received %d of %d bytes", upload.Received, upload.Size),
+		}
+	}
+
+	// keeps the upload from expiring while its file is being finalized
+	touched, err := qs.TrainingTaskUpload.Touch(upload.ID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot update upload: %w", err)
+	}
+	if !touched {
+		return nil, errUploadExpired
+	}
+
+	fileModel, err := qs.FileService.FinalizePartialFile(upload.PartialPath, upload.FileName, checksum)
+	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			return nil, &ErrHandlerValidation{Field: "SHA256", Msg: "does not match the uploaded file"}
+		}
+		return nil, fmt.Errorf("error saving file: %w", err)
+	}
+
+	ttr := &models.TrainingTaskResult{
+		File:           *fileModel,
+		Name:           upload.Name,
+		Description:    upload.Description,
+		Type:           upload.Type,
+		TrainingTaskId: upload.TrainingTaskId,
+		Retry:          upload.Retry,
+	}
+
+	if err := qs.TrainingTaskResult.Create(ttr); err != nil {
+		return nil, fmt.Errorf("error during task result creation: %w", err)
+	}
+
+	if err := qs.TrainingTaskUpload.Delete(upload.ID); err != nil {
+		return nil, fmt.Errorf("cannot delete finalized upload: %w", err)
+	}
+
+	return ttr, nil
+}
+
+var errParentResultNotFound = NewErrHandlerNotFound("parent TrainingTaskResult")
+
+// GetParentResults lists result files of the final attempts of the task's
+// parents, which the task consumes.
+func (qs *QueueService) GetParentResults(ttID uint) ([]ParentResult, error) {
+	parents, err := qs.TrainingTaskDependency.GetParents(ttID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query parents of training task %d: %w", ttID, err)
+	}
+
+	parentResults := []ParentResult{}
+	for _, parent := range parents {
+		results, err := qs.TrainingTaskResult.GetAll(parent.ID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot query results of training task %d: %w", parent.ID, err)
+		}
+
+		for _, result := range resultsOfAttempt(results, parent.Retries) {
+			parentResults = append(parentResults, ParentResult{
+				ID:             result.ID,
+				TrainingTaskId: parent.ID,
+				Name:           result.Name,
+				Type:           result.Type,
+				FileName:       result.File.Name,
+				Size:           result.File.Size,
+				SHA256:         result.File.SHA256,
+			})
+		}
+	}
+
+	return parentResults, nil
+}
+
+// OpenParentResult opens the result file of a parent task, results of other
+// tasks are not accessible to the task's machine.
+func (qs *QueueService) OpenParentResult(ttID, resultID uint) (*models.TrainingTaskResult, io.ReadCloser, func(io.ReadCloser), error) {
+	result, err := qs.TrainingTaskResult.GetByID(resultID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil, errParentResultNotFound
+		}
+		return nil, nil, nil, err
+	}
+
+	parents, err := qs.TrainingTaskDependency.GetParents(ttID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot query parents of training task %d: %w", ttID, err)
+	}
+
+	if !slices.ContainsFunc(parents, func(parent models.TrainingTask) bool { return parent.ID == result.TrainingTaskId }) {
+		return nil, nil, nil, errParentResultNotFound
+	}
+
+	f, closeFile, err := qs.FileService.OpenFile(result.File.Path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot open result file: %w", err)
+	}
+
+	return result, f, closeFile, nil
+}
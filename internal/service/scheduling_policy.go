@@ -0,0 +1,140 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/config"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+)
+
+// SchedulingPolicy decides in which order queued tasks are offered to
+// a training machine asking for work.
+type SchedulingPolicy interface {
+	Order(tasks []models.TrainingTask, now time.Time) ([]models.TrainingTask, error)
+}
+
+const (
+	FIFOPolicyName      = "fifo"
+	PriorityPolicyName  = "priority"
+	FairSharePolicyName = "fair-share"
+)
+
+func NewSchedulingPolicy(cfg config.QueueConfig, ttRepo repository.TrainingTaskRepository) SchedulingPolicy {
+	switch cfg.SchedulingPolicy {
+	case "", FIFOPolicyName:
+		return &FIFOPolicy{}
+	case PriorityPolicyName:
+		return &PriorityAgingPolicy{
+			AgingInterval: time.Duration(cfg.PriorityAgingMinutes) * time.Minute,
+		}
+	case FairSharePolicyName:
+		return &FairSharePolicy{
+			TrainingTask: ttRepo,
+			Window:       time.Duration(cfg.FairShareWindowHours) * time.Hour,
+		}
+	default:
+		log.Fatalf("unknown scheduling policy: %s", cfg.SchedulingPolicy)
+		return nil
+	}
+}
+
+func compareCreatedAt(a, b *models.TrainingTask) int {
+	return a.CreatedAt.Compare(b.CreatedAt)
+}
+
+// FIFOPolicy offers the oldest task first.
+type FIFOPolicy struct{}
+
+func (p *FIFOPolicy) Order(tasks []models.TrainingTask, now time.Time) ([]models.TrainingTask, error) {
+	ordered := slices.Clone(tasks)
+	slices.SortStableFunc(ordered, func(a, b models.TrainingTask) int {
+		return compareCreatedAt(&a, &b)
+	})
+	return ordered, nil
+}
+
+// PriorityAgingPolicy offers tasks with the highest priority first. Priority of
+// a waiting task grows by one every AgingInterval, so low priority tasks are
+// not starved forever.
+type PriorityAgingPolicy struct {
+	AgingInterval time.Duration
+}
+
+func (p *PriorityAgingPolicy) effectivePriority(tt *models.TrainingTask, now time.Time) int {
+	if p.AgingInterval <= 0 {
+		return tt.Priority
+	}
+	return tt.Priority + int(now.Sub(tt.CreatedAt)/p.AgingInterval)
+}
+
+func (p *PriorityAgingPolicy) Order(tasks []models.TrainingTask, now time.Time) ([]models.TrainingTask, error) {
+	ordered := slices.Clone(tasks)
+	slices.SortStableFunc(ordered, func(a, b models.TrainingTask) int {
+		if diff := p.effectivePriority(&b, now) - p.effectivePriority(&a, now); diff != 0 {
+			return diff
+		}
+		return compareCreatedAt(&a, &b)
+	})
+	return ordered, nil
+}
+
+// FairSharePolicy offers tasks of users who used the least machine time within
+// the Window first. Within a single user tasks are ordered by priority.
+type FairSharePolicy struct {
+	TrainingTask repository.TrainingTaskRepository
+	Window       time.Duration
+}
+
+// usage returns machine time consumed by every user within the window.
+func (p *FairSharePolicy) usage(now time.Time) (map[uint]time.Duration, error) {
+	since := now.Add(-p.Window)
+	assigned, err := p.TrainingTask.GetAssignedSince(since)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute users' machine time: %w", err)
+	}
+
+	usage := make(map[uint]time.Duration)
+	for _, tt := range assigned {
+		start := *tt.AssignedAt
+		if start.Before(since) {
+			start = since
+		}
+
+		end := now
+		if tt.FinishedAt != nil && tt.FinishedAt.Before(now) {
+			end = *tt.FinishedAt
+		}
+
+		if end.After(start) {
+			usage[tt.UserId] += end.Sub(start)
+		}
+	}
+
+	return usage, nil
+}
+
+func (p *FairSharePolicy) Order(tasks []models.TrainingTask, now time.Time) ([]models.TrainingTask, error) {
+	usage, err := p.usage(now)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := slices.Clone(tasks)
+	slices.SortStableFunc(ordered, func(a, b models.TrainingTask) int {
+		if usageA, usageB := usage[a.UserId], usage[b.UserId]; usageA != usageB {
+			if usageA < usageB {
+				return -1
+			}
+			return 1
+		}
+		if diff := b.Priority - a.Priority; diff != 0 {
+			return diff
+		}
+		return compareCreatedAt(&a, &b)
+	})
+	return ordered, nil
+}
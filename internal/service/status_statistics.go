@@ -0,0 +1,136 @@
+package service
+
+import (
+	"slices"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+// StatusTimelineEntry is a status event together with the time the task
+// spent in the entered status.
+type StatusTimelineEntry struct {
+	models.TrainingTaskStatusEvent
+	Duration time.Duration
+	// Ongoing is set when the task is still in the status
+	Ongoing bool
+}
+
+// buildTimeline expects events of a single task in chronological order.
+func buildTimeline(events []models.TrainingTaskStatusEvent, now time.Time) []StatusTimelineEntry {
+	timeline := make([]StatusTimelineEntry, len(events))
+	for i, event := range events {
+		timeline[i].TrainingTaskStatusEvent = event
+		if i+1 < len(events) {
+			timeline[i].Duration = events[i+1].CreatedAt.Sub(event.CreatedAt)
+		} else if len(event.ToStatus.NextStatuses()) > 0 {
+			timeline[i].Duration = now.Sub(event.CreatedAt)
+			timeline[i].Ongoing = true
+		}
+	}
+	return timeline
+}
+
+// timedStatuses are statuses in which tasks spend measurable time, the others
+// are final.
+var timedStatuses = []models.TrainingTaskStatus{
+	models.Queued,
+	models.Training,
+	models.Benchmarking,
+}
+
+// StateDurationStats aggregates time spent by tasks in a single status.
+type StateDurationStats struct {
+	Status models.TrainingTaskStatus
+	Count  int
+	Mean   time.Duration
+	Median time.Duration
+	P90    time.Duration
+	Max    time.Duration
+}
+
+type DailyStateDurationStats struct {
+	Day    time.Time
+	States []StateDurationStats
+}
+
+// TimeInStateStats holds time-in-state statistics of status periods which
+// started and ended within the window, in total and split by the day the
+// status was entered.
+type TimeInStateStats struct {
+	Since   time.Time
+	Overall []StateDurationStats
+	Daily   []DailyStateDurationStats
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+func newStateDurationStats(status models.TrainingTaskStatus, durations []time.Duration) StateDurationStats {
+	stats := StateDurationStats{
+		Status: status,
+		Count:  len(durations),
+	}
+	if len(durations) == 0 {
+		return stats
+	}
+
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+
+	stats.Mean = sum / time.Duration(len(sorted))
+	stats.Median = percentile(sorted, 0.5)
+	stats.P90 = percentile(sorted, 0.9)
+	stats.Max = sorted[len(sorted)-1]
+	return stats
+}
+
+// computeTimeInStateStats expects events recorded since the given time, ordered
+// by task and then chronologically.
+func computeTimeInStateStats(events []models.TrainingTaskStatusEvent, since, now time.Time) *TimeInStateStats {
+	firstDay := since.UTC().Truncate(24 * time.Hour)
+	days := int(now.UTC().Sub(firstDay)/(24*time.Hour)) + 1
+
+	overall := make(map[models.TrainingTaskStatus][]time.Duration)
+	daily := make([]map[models.TrainingTaskStatus][]time.Duration, days)
+	for i := range daily {
+		daily[i] = make(map[models.TrainingTaskStatus][]time.Duration)
+	}
+
+	for i := 0; i+1 < len(events); i++ {
+		entered, left := events[i], events[i+1]
+		if entered.TrainingTaskId != left.TrainingTaskId || !slices.Contains(timedStatuses, entered.ToStatus) {
+			continue
+		}
+
+		duration := left.CreatedAt.Sub(entered.CreatedAt)
+		overall[entered.ToStatus] = append(overall[entered.ToStatus], duration)
+
+		day := int(entered.CreatedAt.UTC().Sub(firstDay) / (24 * time.Hour))
+		if day >= 0 && day < days {
+			daily[day][entered.ToStatus] = append(daily[day][entered.ToStatus], duration)
+		}
+	}
+
+	stats := &TimeInStateStats{Since: since}
+	for _, status := range timedStatuses {
+		stats.Overall = append(stats.Overall, newStateDurationStats(status, overall[status]))
+	}
+
+	for i, durations := range daily {
+		dayStats := DailyStateDurationStats{Day: firstDay.Add(time.Duration(i) * 24 * time.Hour)}
+		for _, status := range timedStatuses {
+			dayStats.States = append(dayStats.States, newStateDurationStats(status, durations[status]))
+		}
+		stats.Daily = append(stats.Daily, dayStats)
+	}
+
+	return stats
+}
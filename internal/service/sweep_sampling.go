@@ -0,0 +1,172 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+const maxSweepTasks = 100
+
+// sweepDimension is a swept field discretized into levels from min to max
+// by step, bool fields have the levels false and true.
+type sweepDimension struct {
+	Name     string
+	Type     string
+	Min      float64
+	Step     float64
+	Levels   int
+	decimals int
+}
+
+func newSweepDimension(name string, spec NNConfigField) (*sweepDimension, error) {
+	if spec.Type == "bool" {
+		return &sweepDimension{Name: name, Type: spec.Type, Levels: 2}, nil
+	}
+	if spec.Type != "uint" && spec.Type != "int" && spec.Type != "float64" {
+		return nil, fmt.Errorf("field %s of type %s cannot be swept", name, spec.Type)
+	}
+
+	minValue, okMin := toFloat(spec.Min)
+	maxValue, okMax := toFloat(spec.Max)
+	step, okStep := toFloat(spec.Step)
+	if !okMin || !okMax || !okStep || step <= 0 || maxValue < minValue {
+		return nil, fmt.Errorf("field %s has no valid min, max and step", name)
+	}
+
+	// tolerance of float steps, e.g. (1.0 - 0.0) / 0.01
+	levels := int(math.Floor((maxValue-minValue)/step+1e-9)) + 1
+	decimals := 0
+	if _, fraction, found := strings.Cut(strconv.FormatFloat(step, 'f', -1, 64), "."); found {
+		decimals = len(fraction)
+	}
+
+	return &sweepDimension{
+		Name:     name,
+		Type:     spec.Type,
+		Min:      minValue,
+		Step:     step,
+		Levels:   levels,
+		decimals: decimals,
+	}, nil
+}
+
+// value returns the configuration value of the level.
+func (d *sweepDimension) value(level int) interface{} {
+	if d.Type == "bool" {
+		return level == 1
+	}
+
+	v := d.Min + float64(level)*d.Step
+	if d.Type == "float64" {
+		rounded, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'f', d.decimals, 64), 64)
+		return rounded
+	}
+	return int64(math.Round(v))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// sweepTaskCount returns the number of tasks the sweep creates, grid counts
+// above maxSweepTasks are not computed exactly.
+func sweepTaskCount(method string, dims []*sweepDimension, samples uint) int {
+	if method != models.SweepGrid {
+		return int(samples)
+	}
+	count := 1
+	for _, dim := range dims {
+		count *= min(int(samples), dim.Levels)
+		if count > maxSweepTasks {
+			break
+		}
+	}
+	return count
+}
+
+// sampleSweep returns level indices of every task, one per dimension.
+func sampleSweep(method string, dims []*sweepDimension, samples uint, seed int64) [][]int {
+	switch method {
+	case models.SweepGrid:
+		return sampleGrid(dims, int(samples))
+	case models.SweepRandom:
+		return sampleRandom(dims, int(samples), rand.New(rand.NewSource(seed)))
+	default:
+		return sampleLatinHypercube(dims, int(samples), rand.New(rand.NewSource(seed)))
+	}
+}
+
+// sampleGrid takes n evenly spaced levels of every dimension and returns
+// their cartesian product. Dimensions with less than n levels use all of them.
+func sampleGrid(dims []*sweepDimension, n int) [][]int {
+	points := [][]int{{}}
+	for _, dim := range dims {
+		var levels []int
+		for i := 0; i < n; i++ {
+			level := 0
+			if n > 1 {
+				level = int(math.Round(float64(i) * float64(dim.Levels-1) / float64(n-1)))
+			}
+			if len(levels) == 0 || levels[len(levels)-1] != level {
+				levels = append(levels, level)
+			}
+		}
+
+		next := make([][]int, 0, len(points)*len(levels))
+		for _, point := range points {
+			for _, level := range levels {
+				next = append(next, append(append([]int{}, point...), level))
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+func sampleRandom(dims []*sweepDimension, n int, rng *rand.Rand) [][]int {
+	points := make([][]int, n)
+	for i := range points {
+		points[i] = make([]int, len(dims))
+		for j, dim := range dims {
+			points[i][j] = rng.Intn(dim.Levels)
+		}
+	}
+	return points
+}
+
+// sampleLatinHypercube splits every dimension into n equally probable strata
+// and places exactly one sample into each stratum of every dimension.
+func sampleLatinHypercube(dims []*sweepDimension, n int, rng *rand.Rand) [][]int {
+	points := make([][]int, n)
+	for i := range points {
+		points[i] = make([]int, len(dims))
+	}
+	for j, dim := range dims {
+		strata := rng.Perm(n)
+		for i := range points {
+			u := (float64(strata[i]) + rng.Float64()) / float64(n)
+			points[i][j] = min(int(u*float64(dim.Levels)), dim.Levels-1)
+		}
+	}
+	return points
+}
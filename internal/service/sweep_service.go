@@ -0,0 +1,312 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"gorm.io/gorm"
+)
+
+// SweepRankEntry is a task of the sweep with values of the swept fields and
+// the last value of the ranking metric, nil when it was not reported yet.
+type SweepRankEntry struct {
+	// Rank is 1-based, zero when the task has not reported the metric
+	Rank         uint
+	TrainingTask models.TrainingTask
+	Params       map[string]interface{}
+	Value        *float64
+}
+
+// SweepRanking orders tasks of the sweep by a reported metric, tasks which
+// did not report it are listed last.
+type SweepRanking struct {
+	Sweep    *models.Sweep
+	Metric   string
+	Maximize bool
+	// Metrics lists names of metrics reported by tasks of the sweep
+	Metrics []string
+	Entries []SweepRankEntry
+}
+
+type SweepHelpers struct {
+	TrainingDatasets []models.TrainingDataset
+	FieldConfigs     NNFieldConfigs
+	MaxTasks         uint
+}
+
+type ISweepService interface {
+	Create(sweep *models.Sweep) error
+	GetAll(loggedUserId uint, userScoped bool) ([]models.Sweep, error)
+	GetHelpers(loggedUserId uint) (*SweepHelpers, error)
+	GetRanking(id uint, metric string, maximize *bool) (*SweepRanking, error)
+}
+
+type SweepService struct {
+	*repository.RepositoryContext
+	NNArch   INNArchService
+	Notifier *TaskNotifier
+}
+
+func NewSweepService(repo *repository.RepositoryContext, nnArch INNArchService, notifier *TaskNotifier) *SweepService {
+	return &SweepService{
+		RepositoryContext: repo,
+		NNArch:            nnArch,
+		Notifier:          notifier,
+	}
+}
+
+var errSweepNotFound = NewErrHandlerNotFound("Sweep")
+
+// Create samples configurations of the swept fields and creates the sweep
+// together with one queued training task per sample.
+func (s *SweepService) Create(sweep *models.Sweep) error {
+	dims, err := s.validate(sweep)
+	if err != nil {
+		return err
+	}
+
+	base, ok := sweep.Configuration.(map[string]interface{})
+	if !ok {
+		base = map[string]interface{}{}
+	}
+	sweep.Configuration = base
+
+	sweep.TrainingTasks = nil
+	for i, point := range sampleSweep(sweep.Method, dims, sweep.Samples, sweep.Seed) {
+		configuration := make(map[string]interface{}, len(base)+len(dims))
+		for field, value := range base {
+			configuration[field] = value
+		}
+		for j, dim := range dims {
+			configuration[dim.Name] = dim.value(point[j])
+		}
+
+		sweep.TrainingTasks = append(sweep.TrainingTasks, models.TrainingTask{
+			Name:              fmt.Sprintf("%s-%d", sweep.Name, i+1),
+			Status:            models.Queued,
+			UserId:            sweep.UserId,
+			TrainingDatasetId: sweep.TrainingDatasetId,
+			Configuration:     configuration,
+		})
+	}
+
+	err = s.Sweep.Create(sweep)
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &ErrHandlerValidation{
+				Field: "Name",
+				Msg:   errMsgNotUnique,
+			}
+		}
+		return errInternalServerError
+	}
+
+	for _, tt := range sweep.TrainingTasks {
+		err = recordStatusChange(s.RepositoryContext, &models.TrainingTaskStatusEvent{
+			TrainingTaskId: tt.ID,
+			ToStatus:       tt.Status,
+			Source:         models.StatusSourceUser,
+			UserId:         &sweep.UserId,
+		})
+		if err != nil {
+			log.Print(err.Error())
+			return errInternalServerError
+		}
+	}
+
+	s.Notifier.Notify()
+	return nil
+}
+
+func (s *SweepService) validate(sweep *models.Sweep) ([]*sweepDimension, error) {
+	if strings.TrimSpace(sweep.Name) == "" {
+		return nil, &ErrHandlerValidation{
+			Field: "Name",
+			Msg:   "must not be empty",
+		}
+	}
+
+	if !slices.Contains(models.SweepMethods, sweep.Method) {
+		return nil, &ErrHandlerValidation{
+			Field: "Method",
+			Msg:   fmt.Sprintf("must be one of: %s", strings.Join(models.SweepMethods, ", ")),
+		}
+	}
+
+	if sweep.Samples == 0 {
+		return nil, &ErrHandlerValidation{
+			Field: "Samples",
+			Msg:   "must be positive",
+		}
+	}
+
+	sweep.Metric = strings.TrimSpace(sweep.Metric)
+	if len(sweep.Metric) > maxMetricNameLength || !metricNameRegex.MatchString(sweep.Metric) {
+		return nil, &ErrHandlerValidation{
+			Field: "Metric",
+			Msg:   fmt.Sprintf("must have between 1 and %d letters, digits, '_', '.', '-' or '/'", maxMetricNameLength),
+		}
+	}
+
+	fieldConfigs := s.NNArch.GetFieldConfigs()
+	var fields []string
+	var dims []*sweepDimension
+	for _, field := range sweep.Fields {
+		if slices.Contains(fields, field) {
+			continue
+		}
+		spec, ok := fieldConfigs[field]
+		if !ok {
+			return nil, &ErrHandlerValidation{
+				Field: "Fields",
+				Msg:   fmt.Sprintf("unknown field %s", field),
+			}
+		}
+		dim, err := newSweepDimension(field, spec)
+		if err != nil {
+			return nil, &ErrHandlerValidation{
+				Field: "Fields",
+				Msg:   err.Error(),
+			}
+		}
+		fields = append(fields, field)
+		dims = append(dims, dim)
+	}
+	if len(dims) == 0 {
+		return nil, &ErrHandlerValidation{
+			Field: "Fields",
+			Msg:   "at least one field must be swept",
+		}
+	}
+	sweep.Fields = fields
+
+	if count := sweepTaskCount(sweep.Method, dims, sweep.Samples); count > maxSweepTasks {
+		return nil, &ErrHandlerValidation{
+			Field: "Samples",
+			Msg:   fmt.Sprintf("sweep would create more than %d tasks", maxSweepTasks),
+		}
+	}
+
+	_, err := s.TrainingDataset.GetByID(sweep.TrainingDatasetId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &ErrHandlerValidation{
+				Field: "TrainingDatasetId",
+				Msg:   "training dataset does not exist",
+			}
+		}
+		return nil, errInternalServerError
+	}
+
+	return dims, nil
+}
+
+func (s *SweepService) GetAll(loggedUserId uint, userScoped bool) ([]models.Sweep, error) {
+	var sweeps []models.Sweep
+	var err error
+
+	if userScoped {
+		sweeps, err = s.Sweep.GetAllUser(loggedUserId)
+	} else {
+		sweeps, err = s.Sweep.GetAll()
+	}
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	return sweeps, nil
+}
+
+func (s *SweepService) GetHelpers(loggedUserId uint) (*SweepHelpers, error) {
+	trainingDatasets, err := s.TrainingDataset.GetAllUser(loggedUserId)
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	return &SweepHelpers{
+		TrainingDatasets: trainingDatasets,
+		FieldConfigs:     s.NNArch.GetFieldConfigs(),
+		MaxTasks:         maxSweepTasks,
+	}, nil
+}
+
+// GetRanking ranks tasks of the sweep by the last reported value of
+// the metric in their current attempt. Empty metric and nil maximize
+// fall back to the sweep defaults.
+func (s *SweepService) GetRanking(id uint, metric string, maximize *bool) (*SweepRanking, error) {
+	sweep, err := s.Sweep.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errSweepNotFound
+		}
+		return nil, errInternalServerError
+	}
+
+	ranking := &SweepRanking{
+		Sweep:    sweep,
+		Metric:   sweep.Metric,
+		Maximize: sweep.Maximize,
+	}
+	if metric != "" {
+		ranking.Metric = metric
+	}
+	if maximize != nil {
+		ranking.Maximize = *maximize
+	}
+
+	names := make(map[string]struct{})
+	for _, tt := range sweep.TrainingTasks {
+		metrics, err := s.TrainingTaskMetric.GetAll(tt.ID, tt.Retries)
+		if err != nil {
+			return nil, errInternalServerError
+		}
+
+		entry := SweepRankEntry{
+			TrainingTask: tt,
+			Params:       make(map[string]interface{}, len(sweep.Fields)),
+		}
+		if configuration, ok := tt.Configuration.(map[string]interface{}); ok {
+			for _, field := range sweep.Fields {
+				entry.Params[field] = configuration[field]
+			}
+		}
+		// metrics are ordered by step, the last one is the latest
+		for _, m := range metrics {
+			names[m.Name] = struct{}{}
+			if m.Name == ranking.Metric {
+				value := m.Value
+				entry.Value = &value
+			}
+		}
+		ranking.Entries = append(ranking.Entries, entry)
+	}
+
+	for name := range names {
+		ranking.Metrics = append(ranking.Metrics, name)
+	}
+	sort.Strings(ranking.Metrics)
+
+	sort.SliceStable(ranking.Entries, func(i, j int) bool {
+		a, b := ranking.Entries[i].Value, ranking.Entries[j].Value
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		if ranking.Maximize {
+			return *a > *b
+		}
+		return *a < *b
+	})
+	for i := range ranking.Entries {
+		if ranking.Entries[i].Value != nil {
+			ranking.Entries[i].Rank = uint(i + 1)
+		}
+	}
+
+	return ranking, nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"gorm.io/gorm"
+)
+
+// TaskDependencies is the neighbourhood of the task in the dependency graph.
+type TaskDependencies struct {
+	Parents  []models.TrainingTask
+	Children []models.TrainingTask
+}
+
+// IsWaiting reports whether some parent task is not completed yet.
+func (d *TaskDependencies) IsWaiting() bool {
+	for _, parent := range d.Parents {
+		if !parent.Status.IsCompleted() {
+			return true
+		}
+	}
+	return false
+}
+
+// ParentResult is a result file of a parent task, which the child task can
+// download while it runs.
+type ParentResult struct {
+	ID             uint
+	TrainingTaskId uint
+	Name           string
+	Type           models.TrainingTaskResultType
+	FileName       string
+	Size           uint64
+	SHA256         string
+}
+
+// validateDependencies checks that parent tasks exist and may still complete.
+// Parents must exist before the child is created, so the graph cannot have
+// cycles.
+func validateDependencies(repo *repository.RepositoryContext, deps []models.TrainingTaskDependency) error {
+	seen := make(map[uint]bool, len(deps))
+	for i := range deps {
+		deps[i].ID = 0
+		deps[i].TrainingTaskId = 0
+
+		parentId := deps[i].ParentId
+		if seen[parentId] {
+			return &ErrHandlerValidation{
+				Field: "DependsOn",
+				Msg:   fmt.Sprintf("task %d is listed more than once", parentId),
+			}
+		}
+		seen[parentId] = true
+
+		parent, err := repo.TrainingTask.GetByID(parentId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &ErrHandlerValidation{
+					Field: "DependsOn",
+					Msg:   fmt.Sprintf("task %d does not exist", parentId),
+				}
+			}
+			return errInternalServerError
+		}
+
+		if parent.Status.IsFailed() || parent.Status.IsCancelled() {
+			return &ErrHandlerValidation{
+				Field: "DependsOn",
+				Msg:   fmt.Sprintf("task %q is %s and will never complete", parent.Name, parent.Status),
+			}
+		}
+	}
+	return nil
+}
+
+// failDependentTasks fails queued descendants of the task which failed for
+// good or was cancelled, they would wait for it forever otherwise.
+func failDependentTasks(repo *repository.RepositoryContext, parent *models.TrainingTask) error {
+	children, err := repo.TrainingTaskDependency.GetChildren(parent.ID)
+	if err != nil {
+		return fmt.Errorf("cannot query children of training task %d: %w", parent.ID, err)
+	}
+
+	for i := range children {
+		child := &children[i]
+		if child.Status != models.Queued {
+			continue
+		}
+
+		// the child may be cancelled or claimed in the meantime, only a still
+		// queued one is failed
+		now := time.Now()
+		failure := models.TaskFailure{
+			Code:    models.FailureCodeDependencyFailed,
+			Message: fmt.Sprintf("parent task %q is %s", parent.Name, parent.Status),
+		}
+		updated, err := repo.TrainingTask.UpdateStatus(child.ID, models.Queued, map[string]interface{}{
+			"status":              models.Failed,
+			"retry_at":            nil,
+			"finished_at":         now,
+			"failure_code":        failure.Code,
+			"failure_message":     failure.Message,
+			"failure_log_excerpt": failure.LogExcerpt,
+		})
+		if err != nil {
+			return fmt.Errorf("cannot fail training task %d: %w", child.ID, err)
+		}
+		if !updated {
+			continue
+		}
+		child.Status = models.Failed
+		child.RetryAt = nil
+		child.FinishedAt = &now
+		child.Failure = failure
+
+		from := models.Queued
+		err = recordStatusChange(repo, &models.TrainingTaskStatusEvent{
+			TrainingTaskId: child.ID,
+			FromStatus:     &from,
+			ToStatus:       models.Failed,
+			Source:         models.StatusSourceQueue,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := failDependentTasks(repo, child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+const (
+	maxFailureCodeLength    = 64
+	maxFailureMessageLength = 4 << 10
+	maxLogExcerptLength     = 64 << 10
+)
+
+var failureCodeRegex = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// normalizeFailure validates the failure code and cuts too long message and
+// log excerpt. The end of the log is kept as it usually holds the error.
+func normalizeFailure(failure *models.TaskFailure) (*models.TaskFailure, error) {
+	if failure == nil {
+		return nil, nil
+	}
+
+	normalized := models.TaskFailure{
+		Code:       strings.TrimSpace(failure.Code),
+		Message:    strings.TrimSpace(failure.Message),
+		LogExcerpt: failure.LogExcerpt,
+	}
+
+	if normalized.Code != "" && (len(normalized.Code) > maxFailureCodeLength || !failureCodeRegex.MatchString(normalized.Code)) {
+		return nil, &ErrHandlerValidation{
+			Field: "ErrorCode",
+			Msg:   fmt.Sprintf("must have at most %d letters, digits, '_', '.' or '-'", maxFailureCodeLength),
+		}
+	}
+
+	if len(normalized.Message) > maxFailureMessageLength {
+		normalized.Message = normalized.Message[:maxFailureMessageLength]
+	}
+
+	if len(normalized.LogExcerpt) > maxLogExcerptLength {
+		normalized.LogExcerpt = normalized.LogExcerpt[len(normalized.LogExcerpt)-maxLogExcerptLength:]
+	}
+
+	// cutting may split multi-byte characters, which database would reject
+	normalized.Message = strings.ToValidUTF8(normalized.Message, "")
+	normalized.LogExcerpt = strings.ToValidUTF8(normalized.LogExcerpt, "")
+
+	return &normalized, nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+const (
+	// logTailSize is the size of the log end shown when the viewer is opened.
+	logTailSize = 64 << 10
+	// maxLogReadSize limits the log data returned to the viewer at once.
+	maxLogReadSize   = 256 << 10
+	maxLogReadChunks = 1000
+)
+
+// TrainingTaskLog is a part of the log streamed during the current attempt
+// of the task, starting at the offset requested by the viewer.
+type TrainingTaskLog struct {
+	TrainingTask *models.TrainingTask
+	Data         string
+	// Offset is the position in the log right after Data
+	Offset int64
+	// Truncated is set when the beginning of the log was skipped
+	Truncated bool
+	// More is set when the log continues after Offset
+	More bool
+}
+
+// joinLogChunks concatenates chunks ordered by offset starting at the given
+// offset. The result is cut to at most maxLogReadSize bytes on a UTF-8
+// character boundary.
+func joinLogChunks(chunks []models.TrainingTaskLogChunk, offset int64) []byte {
+	var buf bytes.Buffer
+	for _, chunk := range chunks {
+		start := int64(0)
+		if offset > chunk.Offset {
+			start = offset - chunk.Offset
+		}
+		if start >= int64(len(chunk.Data)) {
+			continue
+		}
+		buf.Write(chunk.Data[start:])
+		if buf.Len() >= maxLogReadSize {
+			break
+		}
+	}
+
+	data := buf.Bytes()
+	if len(data) > maxLogReadSize {
+		end := maxLogReadSize
+		for end > maxLogReadSize-utf8.UTFMax && !utf8.RuneStart(data[end]) {
+			end--
+		}
+		data = data[:end]
+	}
+	return data
+}
+
+// skipToRuneStart returns the number of leading bytes of data which belong to
+// a character started before the data.
+func skipToRuneStart(data []byte) int {
+	skip := 0
+	for skip < len(data) && skip < utf8.UTFMax && !utf8.RuneStart(data[skip]) {
+		skip++
+	}
+	return skip
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+)
+
+// MetricPoint holds values of metrics reported by the training machine at
+// a single training step, e.g. {"loss": 0.3, "val_loss": 0.4, "lr": 0.001}.
+type MetricPoint struct {
+	Step   uint
+	Epoch  *uint
+	Values map[string]float64
+}
+
+const (
+	maxMetricPointsPerReport = 1000
+	maxMetricNameLength      = 64
+	maxChartPoints           = 500
+	chartWidth               = 300
+	chartHeight              = 100
+)
+
+var metricNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.\-/]+$`)
+
+// metricsFromPoints validates reported points and flattens them into rows
+// of the current task attempt.
+func metricsFromPoints(tt *models.TrainingTask, points []MetricPoint) ([]models.TrainingTaskMetric, error) {
+	if len(points) == 0 || len(points) > maxMetricPointsPerReport {
+		return nil, &ErrHandlerValidation{
+			Field: "Points",
+			Msg:   fmt.Sprintf("must contain between 1 and %d points", maxMetricPointsPerReport),
+		}
+	}
+
+	var metrics []models.TrainingTaskMetric
+	for _, point := range points {
+		names := make([]string, 0, len(point.Values))
+		for name := range point.Values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value := point.Values[name]
+			name := strings.TrimSpace(name)
+			if len(name) > maxMetricNameLength || !metricNameRegex.MatchString(name) {
+				return nil, &ErrHandlerValidation{
+					Field: "Values",
+					Msg:   fmt.Sprintf("metric names must have at most %d letters, digits, '_', '.', '-' or '/'", maxMetricNameLength),
+				}
+			}
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, &ErrHandlerValidation{
+					Field: "Values",
+					Msg:   fmt.Sprintf("metric %s must be a finite number", name),
+				}
+			}
+
+			metrics = append(metrics, models.TrainingTaskMetric{
+				TrainingTaskId: tt.ID,
+				Retry:          tt.Retries,
+				Step:           point.Step,
+				Epoch:          point.Epoch,
+				Name:           name,
+				Value:          value,
+			})
+		}
+	}
+
+	return metrics, nil
+}
+
+// MetricChart is a single metric series prepared for rendering as an SVG
+// polyline in a chartWidth x chartHeight view box.
+type MetricChart struct {
+	Name      string
+	Count     int
+	Last      float64
+	Min       float64
+	Max       float64
+	FirstStep uint
+	LastStep  uint
+	Points    string
+}
+
+// buildMetricCharts groups metrics ordered by step into one chart per metric
+// name. Long series are downsampled, keeping the last point.
+func buildMetricCharts(metrics []models.TrainingTaskMetric) []MetricChart {
+	series := make(map[string][]models.TrainingTaskMetric)
+	for _, metric := range metrics {
+		series[metric.Name] = append(series[metric.Name], metric)
+	}
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	charts := make([]MetricChart, 0, len(names))
+	for _, name := range names {
+		charts = append(charts, buildMetricChart(name, series[name]))
+	}
+	return charts
+}
+
+// chartValue is a point of a chart series, Step is its x coordinate.
+type chartValue struct {
+	Step  uint
+	Value float64
+}
+
+func buildMetricChart(name string, metrics []models.TrainingTaskMetric) MetricChart {
+	values := make([]chartValue, len(metrics))
+	for i, metric := range metrics {
+		values[i] = chartValue{Step: metric.Step, Value: metric.Value}
+	}
+	return buildChart(name, values)
+}
+
+func buildChart(name string, values []chartValue) MetricChart {
+	chart := MetricChart{
+		Name:      name,
+		Count:     len(values),
+		Last:      values[len(values)-1].Value,
+		Min:       values[0].Value,
+		Max:       values[0].Value,
+		FirstStep: values[0].Step,
+		LastStep:  values[len(values)-1].Step,
+	}
+	for _, v := range values {
+		chart.Min = math.Min(chart.Min, v.Value)
+		chart.Max = math.Max(chart.Max, v.Value)
+	}
+
+	stride := (len(values) + maxChartPoints - 1) / maxChartPoints
+	points := make([]string, 0, maxChartPoints+1)
+	for i := 0; i < len(values); i += stride {
+		points = append(points, chart.svgPoint(values[i]))
+	}
+	if (len(values)-1)%stride != 0 {
+		points = append(points, chart.svgPoint(values[len(values)-1]))
+	}
+	chart.Points = strings.Join(points, " ")
+
+	return chart
+}
+
+func (c *MetricChart) svgPoint(v chartValue) string {
+	x := float64(chartWidth) / 2
+	if c.LastStep > c.FirstStep {
+		x = float64(v.Step-c.FirstStep) / float64(c.LastStep-c.FirstStep) * chartWidth
+	}
+	y := float64(chartHeight) / 2
+	if c.Max > c.Min {
+		y = chartHeight - (v.Value-c.Min)/(c.Max-c.Min)*chartHeight
+	}
+	return fmt.Sprintf("%.1f,%.1f", x, y)
+}
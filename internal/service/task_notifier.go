@@ -0,0 +1,39 @@
+package service
+
+import "sync"
+
+// TaskNotifier wakes up training machines waiting for a task whenever
+// a training task is queued. A nil notifier never notifies.
+type TaskNotifier struct {
+	mu      sync.Mutex
+	waiters chan struct{}
+}
+
+func NewTaskNotifier() *TaskNotifier {
+	return &TaskNotifier{
+		waiters: make(chan struct{}),
+	}
+}
+
+// Wait returns a channel which is closed by the next Notify call.
+func (n *TaskNotifier) Wait() <-chan struct{} {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.waiters
+}
+
+// Notify wakes up all current waiters.
+func (n *TaskNotifier) Notify() {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.waiters)
+	n.waiters = make(chan struct{})
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+)
+
+// machineReportedStatuses can be set by the training machine itself, the rest
+// of transitions is performed by the queue or by the CCDB upload.
+var machineReportedStatuses = []models.TrainingTaskStatus{
+	models.Training,
+	models.Benchmarking,
+	models.Completed,
+	models.Failed,
+}
+
+func machineNextStatuses(current models.TrainingTaskStatus) []models.TrainingTaskStatus {
+	var allowed []models.TrainingTaskStatus
+	for _, next := range current.NextStatuses() {
+		if slices.Contains(machineReportedStatuses, next) {
+			allowed = append(allowed, next)
+		}
+	}
+	return allowed
+}
+
+func errIllegalTransition(from, to models.TrainingTaskStatus, allowed []models.TrainingTaskStatus) *ErrHandlerConflict {
+	names := make([]string, 0, len(allowed))
+	for _, status := range allowed {
+		names = append(names, status.String())
+	}
+
+	allowedMsg := "none"
+	if len(names) > 0 {
+		allowedMsg = strings.Join(names, ", ")
+	}
+
+	return &ErrHandlerConflict{
+		Resource: "TrainingTask",
+		Msg:      fmt.Sprintf("cannot change status from %s to %s, allowed next statuses: %s", from, to, allowedMsg),
+	}
+}
+
+// recordStatusChange stores the accepted transition in the task's status
+// history. FromStatus is nil for a newly created task.
+func recordStatusChange(repo *repository.RepositoryContext, event *models.TrainingTaskStatusEvent) error {
+	if err := repo.TrainingTaskStatusEvent.Create(event); err != nil {
+		return fmt.Errorf("cannot record status change of training task %d: %w", event.TrainingTaskId, err)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"errors"
+	"time"
 
+	"github.com/mytkom/AliceTraINT/internal/config"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
 	"github.com/mytkom/AliceTraINT/internal/db/repository"
 	"gorm.io/gorm"
@@ -12,24 +14,43 @@ type ITrainingMachineService interface {
 	Create(tm *models.TrainingMachine) (string, error)
 	GetAll(loggedUserId uint, userScoped bool) ([]models.TrainingMachine, error)
 	GetByID(id uint) (*models.TrainingMachine, error)
+	GetActivity(tm *models.TrainingMachine) (*MachineActivity, error)
+	GetTelemetry(id uint) (*MachineTelemetry, error)
+	SetState(loggedUserId uint, id uint, state models.TrainingMachineState) (*MachineActivity, error)
+	RotateSecret(loggedUserId uint, id uint) (*models.TrainingMachine, string, error)
+	RevokePreviousSecret(loggedUserId uint, id uint) error
+	SetCertificateSubject(loggedUserId uint, id uint, subject string) error
 	Delete(loggedUserId uint, id uint) error
 }
 
 type TrainingMachineService struct {
 	*repository.RepositoryContext
 	Hasher Hasher
+	Config config.MachineAuthConfig
 }
 
-func NewTrainingMachineService(repo *repository.RepositoryContext, hasher Hasher) *TrainingMachineService {
+func NewTrainingMachineService(repo *repository.RepositoryContext, hasher Hasher, cfg config.MachineAuthConfig) *TrainingMachineService {
 	return &TrainingMachineService{
 		RepositoryContext: repo,
 		Hasher:            hasher,
+		Config:            cfg,
 	}
 }
 
 var errMachineNotFound = NewErrHandlerNotFound("TrainingMachine")
 
+var errSecretChanged = &ErrHandlerConflict{
+	Resource: "TrainingMachine",
+	Msg:      "secret key was changed in the meantime, reload the page and try again",
+}
+
 func (s *TrainingMachineService) Create(tm *models.TrainingMachine) (string, error) {
+	var err error
+	tm.CertificateSubject, err = ParseCertificateSubject(tm.CertificateSubject)
+	if err != nil {
+		return "", err
+	}
+
 	secretKey, err := s.Hasher.GenerateKey()
 	if err != nil {
 		return "", err
@@ -87,6 +108,159 @@ func (s *TrainingMachineService) GetByID(id uint) (*models.TrainingMachine, erro
 	return tm, nil
 }
 
+func (s *TrainingMachineService) GetActivity(tm *models.TrainingMachine) (*MachineActivity, error) {
+	return machineActivity(s.RepositoryContext, tm)
+}
+
+// GetTelemetry returns health of the machine and charts of its recent
+// telemetry reports.
+func (s *TrainingMachineService) GetTelemetry(id uint) (*MachineTelemetry, error) {
+	tm, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	from := now.Add(-telemetryRetention)
+	reports, err := s.TrainingMachineTelemetry.GetSince(tm.ID, from)
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	telemetry := &MachineTelemetry{
+		TrainingMachine: tm,
+		Health:          tm.Health(now),
+		From:            from,
+		To:              now,
+		Charts:          buildTelemetryCharts(reports),
+	}
+	if len(reports) > 0 {
+		telemetry.Latest = &reports[len(reports)-1]
+		telemetry.From = reports[0].CreatedAt
+	}
+	return telemetry, nil
+}
+
+// SetState drains, disables or activates the machine, only its owner may
+// change it.
+func (s *TrainingMachineService) SetState(loggedUserId uint, id uint, state models.TrainingMachineState) (*MachineActivity, error) {
+	tm, err := s.getOwned(loggedUserId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return setMachineState(s.RepositoryContext, tm, state)
+}
+
+func (s *TrainingMachineService) getOwned(loggedUserId uint, id uint) (*models.TrainingMachine, error) {
+	tm, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if tm.UserId != loggedUserId {
+		return nil, errMachineNotFound
+	}
+
+	return tm, nil
+}
+
+// RotateSecret generates a new secret key of the machine and returns it, the
+// replaced key stays valid for the configured grace period. A key replaced
+// by an earlier rotation is revoked.
+func (s *TrainingMachineService) RotateSecret(loggedUserId uint, id uint) (*models.TrainingMachine, string, error) {
+	tm, err := s.getOwned(loggedUserId, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secretKey, err := s.Hasher.GenerateKey()
+	if err != nil {
+		return nil, "", errInternalServerError
+	}
+	secretKeyHashed, err := s.Hasher.HashKey(secretKey)
+	if err != nil {
+		return nil, "", errInternalServerError
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(s.Config.SecretGraceMinutes) * time.Minute)
+	// the key is replaced only if it was not rotated in the meantime, its
+	// last use is copied by the database so a concurrent use is not lost
+	rotated, err := s.TrainingMachine.UpdateColumns(tm.ID, map[string]interface{}{
+		"secret_key_hashed": tm.SecretKeyHashed,
+	}, map[string]interface{}{
+		"previous_secret_key_hashed":       tm.SecretKeyHashed,
+		"previous_secret_key_expires_at":   expiresAt,
+		"previous_secret_key_last_used_at": gorm.Expr("\"secret_key_last_used_at\""),
+		"secret_key_hashed":                secretKeyHashed,
+		"secret_key_rotated_at":            now,
+		"secret_key_last_used_at":          nil,
+	})
+	if err != nil {
+		return nil, "", errInternalServerError
+	}
+	if !rotated {
+		return nil, "", errSecretChanged
+	}
+
+	tm.PreviousSecretKeyHashed = tm.SecretKeyHashed
+	tm.PreviousSecretKeyExpiresAt = &expiresAt
+	tm.PreviousSecretKeyLastUsedAt = tm.SecretKeyLastUsedAt
+	tm.SecretKeyHashed = secretKeyHashed
+	tm.SecretKeyRotatedAt = &now
+	tm.SecretKeyLastUsedAt = nil
+
+	return tm, secretKey, nil
+}
+
+// RevokePreviousSecret ends the grace period of the key replaced by the last
+// rotation, e.g. when it leaked.
+func (s *TrainingMachineService) RevokePreviousSecret(loggedUserId uint, id uint) error {
+	tm, err := s.getOwned(loggedUserId, id)
+	if err != nil {
+		return err
+	}
+	if tm.PreviousSecretKeyHashed == "" {
+		return nil
+	}
+
+	revoked, err := s.TrainingMachine.UpdateColumns(tm.ID, map[string]interface{}{
+		"previous_secret_key_hashed": tm.PreviousSecretKeyHashed,
+	}, map[string]interface{}{
+		"previous_secret_key_hashed":     "",
+		"previous_secret_key_expires_at": nil,
+	})
+	if err != nil {
+		return errInternalServerError
+	}
+	if !revoked {
+		return errSecretChanged
+	}
+
+	return nil
+}
+
+// SetCertificateSubject binds the machine to a grid certificate, its secret
+// keys are not accepted while it is bound. Empty subject unbinds it.
+func (s *TrainingMachineService) SetCertificateSubject(loggedUserId uint, id uint, subject string) error {
+	subject, err := ParseCertificateSubject(subject)
+	if err != nil {
+		return err
+	}
+
+	tm, err := s.getOwned(loggedUserId, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.TrainingMachine.UpdateColumns(tm.ID, nil, map[string]interface{}{"certificate_subject": subject})
+	if err != nil {
+		return errInternalServerError
+	}
+
+	return nil
+}
+
 func (s *TrainingMachineService) Delete(loggedUserId uint, id uint) error {
 	err := s.TrainingMachine.Delete(loggedUserId, id)
 	if err != nil {
@@ -3,10 +3,12 @@ package service
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"regexp"
 	"slices"
 	"strconv"
+	"time"
 
 	"github.com/mytkom/AliceTraINT/internal/ccdb"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
@@ -20,19 +22,61 @@ type TrainingTaskWithResults struct {
 	ImageFiles   []models.TrainingTaskResult
 	OnnxFiles    []models.TrainingTaskResult
 	LogFiles     []models.TrainingTaskResult
+	Scheduling   *SchedulingDiagnosis
+	Timeline     []StatusTimelineEntry
+	Dependencies *TaskDependencies
+	// PreviousAttempts lists failed attempts which were retried, oldest first
+	PreviousAttempts []AttemptWithResults
+}
+
+// AttemptWithResults is an archived attempt with the result files it produced.
+type AttemptWithResults struct {
+	Attempt    models.TrainingTaskAttempt
+	ImageFiles []models.TrainingTaskResult
+	OnnxFiles  []models.TrainingTaskResult
+	LogFiles   []models.TrainingTaskResult
+}
+
+// TrainingTaskMetricCharts holds charts of metrics reported during
+// the current attempt of the task.
+type TrainingTaskMetricCharts struct {
+	TrainingTask *models.TrainingTask
+	Charts       []MetricChart
+}
+
+type MachineBlockers struct {
+	TrainingMachine models.TrainingMachine
+	Reasons         []string
+}
+
+// SchedulingDiagnosis explains which training machines can pick up
+// a queued task and why the other ones cannot.
+type SchedulingDiagnosis struct {
+	EligibleMachines []models.TrainingMachine
+	BlockedMachines  []MachineBlockers
 }
 
 type TrainingTaskHelpers struct {
 	TrainingDatasets []models.TrainingDataset
-	FieldConfigs     NNFieldConfigs
+	TrainingMachines []models.TrainingMachine
+	// ParentCandidates are tasks of the user which may still complete
+	ParentCandidates   []models.TrainingTask
+	FieldConfigs       NNFieldConfigs
+	RetryPolicy        NNRetryPolicy
+	MaxWallTimeSeconds uint
 }
 
 type ITrainingTaskService interface {
 	Create(tm *models.TrainingTask) error
-	GetAll(loggedUserId uint, userScoped bool) ([]models.TrainingTask, error)
+	GetAll(loggedUserId uint, userScoped bool, filter repository.TrainingTaskFilter) ([]models.TrainingTask, error)
+	GetFailureCodes() ([]string, error)
 	GetHelpers(loggedUserId uint) (*TrainingTaskHelpers, error)
 	GetByID(id uint) (*TrainingTaskWithResults, error)
-	UploadOnnxResults(id uint) error
+	GetTimeInStateStats(days uint) (*TimeInStateStats, error)
+	GetMetricCharts(id uint) (*TrainingTaskMetricCharts, error)
+	GetLog(id uint, offset *int64) (*TrainingTaskLog, error)
+	UploadOnnxResults(id uint, loggedUserId uint) error
+	Cancel(id uint, loggedUserId uint) error
 }
 
 type TrainingTaskService struct {
@@ -41,16 +85,18 @@ type TrainingTaskService struct {
 	JAliEnService IJAliEnService
 	FileService   IFileService
 	NNArch        INNArchService
+	Notifier      *TaskNotifier
 	PeriodRegex   *regexp.Regexp
 }
 
-func NewTrainingTaskService(repo *repository.RepositoryContext, ccdbService ICCDBService, jalienService IJAliEnService, fileService IFileService, nnArch INNArchService) *TrainingTaskService {
+func NewTrainingTaskService(repo *repository.RepositoryContext, ccdbService ICCDBService, jalienService IJAliEnService, fileService IFileService, nnArch INNArchService, notifier *TaskNotifier) *TrainingTaskService {
 	return &TrainingTaskService{
 		RepositoryContext: repo,
 		CCDBService:       ccdbService,
 		JAliEnService:     jalienService,
 		FileService:       fileService,
 		NNArch:            nnArch,
+		Notifier:          notifier,
 		PeriodRegex:       regexp.MustCompile(`(/alice/sim/\d{4}/LHC[a-z0-9A-Z\_].+(/\d+)?)/\d+/AOD/\d+`),
 	}
 }
@@ -58,8 +104,45 @@ func NewTrainingTaskService(repo *repository.RepositoryContext, ccdbService ICCD
 var errTaskNotFound = NewErrHandlerNotFound("TrainingTask")
 
 func (s *TrainingTaskService) Create(tt *models.TrainingTask) error {
-	// Status must start with Queued
+	// Status must start with Queued and queue bookkeeping cannot be set by user
 	tt.Status = models.Queued
+	tt.TrainingMachineId = nil
+	tt.LeaseExpiresAt = nil
+	tt.LostLeases = 0
+	tt.AssignedAt = nil
+	tt.FinishedAt = nil
+	tt.Failure = models.TaskFailure{}
+	tt.Retries = 0
+	tt.RetryAt = nil
+	tt.SweepId = nil
+
+	if tt.Priority < models.MinTaskPriority || tt.Priority > models.MaxTaskPriority {
+		return &ErrHandlerValidation{
+			Field: "Priority",
+			Msg:   fmt.Sprintf("must be between %d and %d", models.MinTaskPriority, models.MaxTaskPriority),
+		}
+	}
+
+	if err := ValidateRequirements(tt.Requirements); err != nil {
+		return err
+	}
+
+	if err := validateDependencies(s.RepositoryContext, tt.Dependencies); err != nil {
+		return err
+	}
+
+	if tt.PinnedTrainingMachineId != nil {
+		_, err := s.TrainingMachine.GetByID(*tt.PinnedTrainingMachineId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &ErrHandlerValidation{
+					Field: "PinnedTrainingMachineId",
+					Msg:   "training machine does not exist",
+				}
+			}
+			return errInternalServerError
+		}
+	}
 
 	err := s.TrainingTask.Create(tt)
 	if err != nil {
@@ -73,20 +156,32 @@ func (s *TrainingTaskService) Create(tt *models.TrainingTask) error {
 		}
 	}
 
+	err = recordStatusChange(s.RepositoryContext, &models.TrainingTaskStatusEvent{
+		TrainingTaskId: tt.ID,
+		ToStatus:       tt.Status,
+		Source:         models.StatusSourceUser,
+		UserId:         &tt.UserId,
+	})
+	if err != nil {
+		log.Print(err.Error())
+		return errInternalServerError
+	}
+
+	s.Notifier.Notify()
 	return nil
 }
 
-func (s *TrainingTaskService) GetAll(loggedUserId uint, userScoped bool) ([]models.TrainingTask, error) {
+func (s *TrainingTaskService) GetAll(loggedUserId uint, userScoped bool, filter repository.TrainingTaskFilter) ([]models.TrainingTask, error) {
 	var trainingTasks []models.TrainingTask
 	var err error
 
 	if userScoped {
-		trainingTasks, err = s.TrainingTask.GetAllUser(loggedUserId)
+		trainingTasks, err = s.TrainingTask.GetAllUser(loggedUserId, filter)
 		if err != nil {
 			return nil, errInternalServerError
 		}
 	} else {
-		trainingTasks, err = s.TrainingTask.GetAll()
+		trainingTasks, err = s.TrainingTask.GetAll(filter)
 		if err != nil {
 			return nil, errInternalServerError
 		}
@@ -95,15 +190,41 @@ func (s *TrainingTaskService) GetAll(loggedUserId uint, userScoped bool) ([]mode
 	return trainingTasks, nil
 }
 
+func (s *TrainingTaskService) GetFailureCodes() ([]string, error) {
+	codes, err := s.TrainingTask.GetFailureCodes()
+	if err != nil {
+		return nil, errInternalServerError
+	}
+	return codes, nil
+}
+
 func (s *TrainingTaskService) GetHelpers(loggedUserId uint) (*TrainingTaskHelpers, error) {
 	trainingDatasets, err := s.TrainingDataset.GetAllUser(loggedUserId)
 	if err != nil {
 		return nil, errInternalServerError
 	}
 
+	trainingMachines, err := s.TrainingMachine.GetAll()
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	trainingTasks, err := s.TrainingTask.GetAllUser(loggedUserId, repository.TrainingTaskFilter{})
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	parentCandidates := slices.DeleteFunc(trainingTasks, func(tt models.TrainingTask) bool {
+		return tt.Status.IsFailed() || tt.Status.IsCancelled()
+	})
+
 	return &TrainingTaskHelpers{
-		TrainingDatasets: trainingDatasets,
-		FieldConfigs:     s.NNArch.GetFieldConfigs(),
+		TrainingDatasets:   trainingDatasets,
+		TrainingMachines:   trainingMachines,
+		ParentCandidates:   parentCandidates,
+		FieldConfigs:       s.NNArch.GetFieldConfigs(),
+		RetryPolicy:        s.NNArch.GetRetryPolicy(),
+		MaxWallTimeSeconds: s.NNArch.GetMaxWallTimeSeconds(),
 	}, nil
 }
 
@@ -141,15 +262,198 @@ func (s *TrainingTaskService) GetByID(id uint) (*TrainingTaskWithResults, error)
 		}
 	}
 
+	var scheduling *SchedulingDiagnosis
+	if trainingTask.Status == models.Queued {
+		scheduling, err = s.diagnoseScheduling(trainingTask)
+		if err != nil {
+			return nil, errInternalServerError
+		}
+	}
+
+	events, err := s.TrainingTaskStatusEvent.GetAll(trainingTask.ID)
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	parents, err := s.TrainingTaskDependency.GetParents(trainingTask.ID)
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	children, err := s.TrainingTaskDependency.GetChildren(trainingTask.ID)
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	var previousAttempts []AttemptWithResults
+	if trainingTask.Retries > 0 {
+		previousAttempts, err = s.getPreviousAttempts(trainingTask.ID)
+		if err != nil {
+			return nil, errInternalServerError
+		}
+	}
+
 	return &TrainingTaskWithResults{
+		TrainingTask:     trainingTask,
+		ImageFiles:       resultsOfAttempt(imageFiles, trainingTask.Retries),
+		OnnxFiles:        resultsOfAttempt(onnxFiles, trainingTask.Retries),
+		LogFiles:         resultsOfAttempt(logFiles, trainingTask.Retries),
+		Scheduling:       scheduling,
+		Timeline:         buildTimeline(events, time.Now()),
+		Dependencies:     &TaskDependencies{Parents: parents, Children: children},
+		PreviousAttempts: previousAttempts,
+	}, nil
+}
+
+func resultsOfAttempt(results []models.TrainingTaskResult, retry uint) []models.TrainingTaskResult {
+	if results == nil {
+		return nil
+	}
+
+	filtered := make([]models.TrainingTaskResult, 0, len(results))
+	for _, result := range results {
+		if result.Retry == retry {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+func (s *TrainingTaskService) getPreviousAttempts(ttId uint) ([]AttemptWithResults, error) {
+	attempts, err := s.TrainingTaskAttempt.GetAll(ttId)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := s.TrainingTaskResult.GetAll(ttId)
+	if err != nil {
+		return nil, err
+	}
+
+	previousAttempts := make([]AttemptWithResults, 0, len(attempts))
+	for _, attempt := range attempts {
+		attemptResults := AttemptWithResults{Attempt: attempt}
+		for _, result := range resultsOfAttempt(results, attempt.Retry) {
+			switch result.Type {
+			case models.Image:
+				attemptResults.ImageFiles = append(attemptResults.ImageFiles, result)
+			case models.Onnx:
+				attemptResults.OnnxFiles = append(attemptResults.OnnxFiles, result)
+			case models.Log:
+				attemptResults.LogFiles = append(attemptResults.LogFiles, result)
+			}
+		}
+		previousAttempts = append(previousAttempts, attemptResults)
+	}
+
+	return previousAttempts, nil
+}
+
+// GetTimeInStateStats aggregates how long tasks spent in the queue, training
+// and benchmarking during the last days.
+func (s *TrainingTaskService) GetTimeInStateStats(days uint) (*TimeInStateStats, error) {
+	now := time.Now()
+	since := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -int(days)+1)
+
+	events, err := s.TrainingTaskStatusEvent.GetSince(since)
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	return computeTimeInStateStats(events, since, now), nil
+}
+
+func (s *TrainingTaskService) GetMetricCharts(id uint) (*TrainingTaskMetricCharts, error) {
+	trainingTask, err := s.TrainingTask.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errTaskNotFound
+		} else {
+			return nil, errInternalServerError
+		}
+	}
+
+	metrics, err := s.TrainingTaskMetric.GetAll(trainingTask.ID, trainingTask.Retries)
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	return &TrainingTaskMetricCharts{
 		TrainingTask: trainingTask,
-		ImageFiles:   imageFiles,
-		OnnxFiles:    onnxFiles,
-		LogFiles:     logFiles,
+		Charts:       buildMetricCharts(metrics),
 	}, nil
 }
 
-func (s *TrainingTaskService) UploadOnnxResults(id uint) error {
+// GetLog returns the log of the current task attempt streamed after
+// the offset, or its end when offset is nil.
+func (s *TrainingTaskService) GetLog(id uint, offset *int64) (*TrainingTaskLog, error) {
+	trainingTask, err := s.TrainingTask.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errTaskNotFound
+		} else {
+			return nil, errInternalServerError
+		}
+	}
+
+	size, err := s.TrainingTaskLogChunk.GetSize(trainingTask.ID, trainingTask.Retries)
+	if err != nil {
+		return nil, errInternalServerError
+	}
+
+	log := &TrainingTaskLog{TrainingTask: trainingTask}
+	var from int64
+	if offset != nil {
+		from = min(*offset, size)
+	} else if size > logTailSize {
+		from = size - logTailSize
+		log.Truncated = true
+	}
+
+	if from < size {
+		chunks, err := s.TrainingTaskLogChunk.GetFrom(trainingTask.ID, trainingTask.Retries, from, maxLogReadChunks)
+		if err != nil {
+			return nil, errInternalServerError
+		}
+
+		data := joinLogChunks(chunks, from)
+		if log.Truncated {
+			skip := skipToRuneStart(data)
+			data = data[skip:]
+			from += int64(skip)
+		}
+		log.Data = string(data)
+		from += int64(len(data))
+	}
+
+	log.Offset = from
+	log.More = from < size
+
+	return log, nil
+}
+
+func (s *TrainingTaskService) diagnoseScheduling(tt *models.TrainingTask) (*SchedulingDiagnosis, error) {
+	tms, err := s.TrainingMachine.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	diagnosis := &SchedulingDiagnosis{}
+	for _, tm := range tms {
+		if reasons := machineBlockers(tt, &tm); len(reasons) > 0 {
+			diagnosis.BlockedMachines = append(diagnosis.BlockedMachines, MachineBlockers{
+				TrainingMachine: tm,
+				Reasons:         reasons,
+			})
+		} else {
+			diagnosis.EligibleMachines = append(diagnosis.EligibleMachines, tm)
+		}
+	}
+
+	return diagnosis, nil
+}
+
+func (s *TrainingTaskService) UploadOnnxResults(id uint, loggedUserId uint) error {
 	trainingTask, err := s.TrainingTask.GetByID(id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -159,7 +463,7 @@ func (s *TrainingTaskService) UploadOnnxResults(id uint) error {
 		}
 	}
 
-	if trainingTask.Status < models.Completed {
+	if !trainingTask.Status.IsCompleted() {
 		return &ErrHandlerValidation{
 			Field: "Status",
 			Msg:   "must be completed or uploaded",
@@ -203,7 +507,7 @@ func (s *TrainingTaskService) UploadOnnxResults(id uint) error {
 		initialized = true
 	}
 
-	mappedOnnxFiles, err := s.filterOnnxFiles(trainingTask.ID)
+	mappedOnnxFiles, err := s.filterOnnxFiles(trainingTask)
 	if err != nil {
 		return err
 	}
@@ -214,11 +518,94 @@ func (s *TrainingTaskService) UploadOnnxResults(id uint) error {
 		}
 	}
 
+	from := trainingTask.Status
 	trainingTask.Status = models.Uploaded
 	if err := s.TrainingTask.Update(trainingTask); err != nil {
 		return errInternalServerError
 	}
 
+	// results may be uploaded again, but only the first upload is a transition
+	if from.CanTransitionTo(models.Uploaded) {
+		err := recordStatusChange(s.RepositoryContext, &models.TrainingTaskStatusEvent{
+			TrainingTaskId: trainingTask.ID,
+			FromStatus:     &from,
+			ToStatus:       models.Uploaded,
+			Source:         models.StatusSourceUser,
+			UserId:         &loggedUserId,
+		})
+		if err != nil {
+			log.Print(err.Error())
+			return errInternalServerError
+		}
+	}
+
+	return nil
+}
+
+// Cancel stops a queued or running task of the user. The training machine
+// holding the task stays assigned, so it can learn about the cancellation
+// from its next status report or heartbeat and abort the training.
+func (s *TrainingTaskService) Cancel(id uint, loggedUserId uint) error {
+	trainingTask, err := s.TrainingTask.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errTaskNotFound
+		} else {
+			return errInternalServerError
+		}
+	}
+	if trainingTask.UserId != loggedUserId {
+		return errTaskNotFound
+	}
+
+	from := trainingTask.Status
+	if !from.CanTransitionTo(models.Cancelled) {
+		return &ErrHandlerConflict{
+			Resource: "TrainingTask",
+			Msg:      fmt.Sprintf("cannot be cancelled in %s status", from),
+		}
+	}
+
+	// the machine may change the status concurrently, the task is cancelled
+	// only if it is still in the status checked above
+	now := time.Now()
+	cancelled, err := s.TrainingTask.UpdateStatus(trainingTask.ID, from, map[string]interface{}{
+		"status":           models.Cancelled,
+		"lease_expires_at": nil,
+		"retry_at":         nil,
+		"finished_at":      now,
+	})
+	if err != nil {
+		return errInternalServerError
+	}
+	if !cancelled {
+		return &ErrHandlerConflict{
+			Resource: "TrainingTask",
+			Msg:      "status was changed in the meantime, reload the page and try again",
+		}
+	}
+	trainingTask.Status = models.Cancelled
+	trainingTask.LeaseExpiresAt = nil
+	trainingTask.RetryAt = nil
+	trainingTask.FinishedAt = &now
+
+	err = recordStatusChange(s.RepositoryContext, &models.TrainingTaskStatusEvent{
+		TrainingTaskId: trainingTask.ID,
+		FromStatus:     &from,
+		ToStatus:       models.Cancelled,
+		Source:         models.StatusSourceUser,
+		UserId:         &loggedUserId,
+	})
+	if err != nil {
+		log.Print(err.Error())
+		return errInternalServerError
+	}
+
+	if err := failDependentTasks(s.RepositoryContext, trainingTask); err != nil {
+		log.Print(err.Error())
+		return errInternalServerError
+	}
+
 	return nil
 }
 
@@ -316,13 +703,15 @@ func (s *TrainingTaskService) getRunInfoRange(smallestRun, greatestRun uint64) (
 	return firstRunInfo, lastRunInfo, nil
 }
 
-func (s *TrainingTaskService) filterOnnxFiles(ttId uint) (map[string]*models.TrainingTaskResult, error) {
+// filterOnnxFiles maps expected ONNX files of the current attempt to their upload names.
+func (s *TrainingTaskService) filterOnnxFiles(tt *models.TrainingTask) (map[string]*models.TrainingTaskResult, error) {
 	expectedOnnxFilenames := s.NNArch.GetExpectedResults().Onnx
 	mappedResults := make(map[string]*models.TrainingTaskResult, len(expectedOnnxFilenames))
-	onnxFiles, err := s.TrainingTaskResult.GetByType(ttId, models.Onnx)
+	onnxFiles, err := s.TrainingTaskResult.GetByType(tt.ID, models.Onnx)
 	if err != nil {
 		return nil, err
 	}
+	onnxFiles = resultsOfAttempt(onnxFiles, tt.Retries)
 
 	for localName, expectedName := range expectedOnnxFilenames {
 		found := false
@@ -343,6 +732,8 @@ func (s *TrainingTaskService) filterOnnxFiles(ttId uint) (map[string]*models.Tra
 	return mappedResults, nil
 }
 
+// uploadOnnxFile streams the file to CCDB and verifies its checksum on the
+// way, the upload fails before completion when the file was modified.
 func (s *TrainingTaskService) uploadOnnxFile(sor, eor uint64, onnxFile *models.TrainingTaskResult, uploadFilename string) error {
 	f, closeFile, err := s.FileService.OpenFile(onnxFile.File.Path)
 	if err != nil {
@@ -350,7 +741,20 @@ func (s *TrainingTaskService) uploadOnnxFile(sor, eor uint64, onnxFile *models.T
 	}
 	defer closeFile(f)
 
-	if err := s.CCDBService.UploadFile(sor, eor, uploadFilename, f); err != nil {
+	var r io.Reader = f
+	if onnxFile.File.SHA256 != "" {
+		r = newChecksumReader(f, onnxFile.File.SHA256)
+	} else {
+		log.Printf("file %s stored without checksum, skipping its verification", onnxFile.File.Path)
+	}
+
+	if err := s.CCDBService.UploadFile(sor, eor, uploadFilename, r); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			return &ErrHandlerConflict{
+				Resource: fmt.Sprintf("TrainingTask's result file: %s", onnxFile.Name),
+				Msg:      "does not match its SHA-256 checksum, it was modified after upload",
+			}
+		}
 		return handleCCDBError(err)
 	}
 
@@ -0,0 +1,155 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats collects request counts per endpoint, assignment latencies and
+// duplicate assignments. Latencies and duplicates are guarded by the
+// simulator mutex.
+type stats struct {
+	mu       sync.Mutex
+	requests map[string]int
+	errors   map[string]int
+
+	latencies  []time.Duration
+	duplicates int
+}
+
+func newStats() *stats {
+	return &stats{
+		requests: make(map[string]int),
+		errors:   make(map[string]int),
+	}
+}
+
+// call runs the request counting it under the endpoint name. Requests
+// interrupted by the end of the simulation are not counted.
+func (st *stats) call(ctx context.Context, endpoint string, fn func() error) error {
+	err := fn()
+	if ctx.Err() != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.requests[endpoint]++
+	if err != nil {
+		st.errors[endpoint]++
+	}
+	return err
+}
+
+type EndpointReport struct {
+	Endpoint string
+	Requests int
+	Errors   int
+}
+
+func (e EndpointReport) ErrorRate() float64 {
+	if e.Requests == 0 {
+		return 0
+	}
+	return float64(e.Errors) / float64(e.Requests)
+}
+
+type Report struct {
+	Machines             int
+	Tasks                int
+	FinishedTasks        int
+	Duration             time.Duration
+	Assignments          int
+	DuplicateAssignments int
+	// latency from queueing, or requeueing, of a task to its assignment
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+	LatencyMax time.Duration
+	Endpoints  []EndpointReport
+}
+
+func (st *stats) report(cfg Config, finished int, duration time.Duration) *Report {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	r := &Report{
+		Machines:             cfg.Machines,
+		Tasks:                cfg.Tasks,
+		FinishedTasks:        finished,
+		Duration:             duration,
+		Assignments:          len(st.latencies),
+		DuplicateAssignments: st.duplicates,
+	}
+
+	latencies := append([]time.Duration(nil), st.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.LatencyP50 = percentile(latencies, 0.5)
+	r.LatencyP95 = percentile(latencies, 0.95)
+	r.LatencyMax = percentile(latencies, 1)
+
+	for endpoint, requests := range st.requests {
+		r.Endpoints = append(r.Endpoints, EndpointReport{
+			Endpoint: endpoint,
+			Requests: requests,
+			Errors:   st.errors[endpoint],
+		})
+	}
+	sort.Slice(r.Endpoints, func(i, j int) bool { return r.Endpoints[i].Endpoint < r.Endpoints[j].Endpoint })
+
+	return r
+}
+
+// percentile expects sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// Throughput is the number of finished tasks per minute.
+func (r *Report) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.FinishedTasks) / r.Duration.Minutes()
+}
+
+func (r *Report) Requests() (requests, errors int) {
+	for _, e := range r.Endpoints {
+		requests += e.Requests
+		errors += e.Errors
+	}
+	return requests, errors
+}
+
+func (r *Report) Print(w io.Writer) error {
+	requests, errors := r.Requests()
+	errorRate := 0.0
+	if requests > 0 {
+		errorRate = float64(errors) / float64(requests)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "machines\t%d\n", r.Machines)
+	fmt.Fprintf(tw, "finished tasks\t%d/%d\n", r.FinishedTasks, r.Tasks)
+	fmt.Fprintf(tw, "duration\t%s\n", r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(tw, "throughput\t%.1f tasks/min\n", r.Throughput())
+	fmt.Fprintf(tw, "assignments\t%d\n", r.Assignments)
+	fmt.Fprintf(tw, "duplicate assignments\t%d\n", r.DuplicateAssignments)
+	fmt.Fprintf(tw, "assignment latency\tp50 %s, p95 %s, max %s\n",
+		r.LatencyP50.Round(time.Millisecond), r.LatencyP95.Round(time.Millisecond), r.LatencyMax.Round(time.Millisecond))
+	fmt.Fprintf(tw, "requests\t%d, %d errors (%.2f%%)\n", requests, errors, 100*errorRate)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "endpoint\trequests\terrors\terror rate")
+	for _, e := range r.Endpoints {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\n", e.Endpoint, e.Requests, e.Errors, 100*e.ErrorRate())
+	}
+	return tw.Flush()
+}
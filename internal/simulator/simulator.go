@@ -0,0 +1,364 @@
+// Package simulator drives the queue endpoints with many virtual training
+// machines to check how the queue behaves under load.
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/agent"
+	"github.com/mytkom/AliceTraINT/internal/config"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/mytkom/AliceTraINT/internal/jalien"
+	"github.com/mytkom/AliceTraINT/internal/service"
+)
+
+const statusAttempts = 3
+
+type Config struct {
+	Machines int
+	Tasks    int
+	// training of every task takes a random duration from the range
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// FailureRate and UploadRate are probabilities of a task attempt failing
+	// and of a completed task uploading a result of UploadSize bytes
+	FailureRate       float64
+	UploadRate        float64
+	UploadSize        int64
+	PollWait          time.Duration
+	HeartbeatInterval time.Duration
+	Seed              int64
+}
+
+// Simulator registers virtual training machines and queues tasks for them,
+// then every machine leases and processes tasks like a real agent.
+type Simulator struct {
+	repo   *repository.RepositoryContext
+	hasher service.Hasher
+	url    string
+	cfg    Config
+
+	mu       sync.Mutex
+	queuedAt map[uint]time.Time
+	holders  map[uint]uint
+	finished map[uint]bool
+	done     chan struct{}
+	stats    *stats
+}
+
+func New(repo *repository.RepositoryContext, hasher service.Hasher, baseURL string, cfg Config) *Simulator {
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 10 * time.Second
+	}
+	if cfg.MaxDuration < cfg.MinDuration {
+		cfg.MaxDuration = cfg.MinDuration
+	}
+	return &Simulator{
+		repo:     repo,
+		hasher:   hasher,
+		url:      baseURL,
+		cfg:      cfg,
+		queuedAt: make(map[uint]time.Time),
+		holders:  make(map[uint]uint),
+		finished: make(map[uint]bool),
+		done:     make(chan struct{}),
+		stats:    newStats(),
+	}
+}
+
+type virtualMachine struct {
+	client *agent.Client
+	rand   *rand.Rand
+}
+
+// Run seeds the machines and tasks and processes the tasks until all of them
+// are finished or the context is done.
+func (s *Simulator) Run(ctx context.Context) (*Report, error) {
+	machines, err := s.seed()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, vm := range machines {
+		wg.Add(1)
+		go func(vm *virtualMachine) {
+			defer wg.Done()
+			s.runMachine(ctx, vm)
+		}(vm)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats.report(s.cfg, len(s.finished), time.Since(start)), nil
+}
+
+// seed registers the machines and queues the tasks, names are suffixed with
+// the run timestamp so that repeated runs can share the database.
+func (s *Simulator) seed() ([]*virtualMachine, error) {
+	runID := time.Now().UnixNano()
+
+	user := &models.User{
+		CernPersonId: fmt.Sprintf("traint-sim-%d", runID),
+		Username:     "traint-sim",
+		Email:        fmt.Sprintf("traint-sim-%d@localhost", runID),
+	}
+	if err := s.repo.User.Create(user); err != nil {
+		return nil, fmt.Errorf("cannot create user: %w", err)
+	}
+
+	td := &models.TrainingDataset{
+		Name:     fmt.Sprintf("traint-sim-%d", runID),
+		AODFiles: []jalien.AODFile{{Name: "AO2D.root", Path: "/alice/sim/traint-sim/AO2D.root"}},
+		UserId:   user.ID,
+	}
+	if err := s.repo.TrainingDataset.Create(td); err != nil {
+		return nil, fmt.Errorf("cannot create training dataset: %w", err)
+	}
+
+	tmService := service.NewTrainingMachineService(s.repo, s.hasher, config.MachineAuthConfig{})
+	machines := make([]*virtualMachine, s.cfg.Machines)
+	for i := range machines {
+		tm := &models.TrainingMachine{
+			Name:   fmt.Sprintf("traint-sim-%d-%d", runID, i),
+			UserId: user.ID,
+		}
+		secret, err := tmService.Create(tm)
+		if err != nil {
+			return nil, fmt.Errorf("cannot register training machine: %w", err)
+		}
+		machines[i] = &virtualMachine{
+			client: agent.NewClient(s.url, tm.ID, secret),
+			rand:   rand.New(rand.NewSource(s.cfg.Seed + int64(i))),
+		}
+	}
+
+	now := time.Now()
+	for i := 0; i < s.cfg.Tasks; i++ {
+		tt := &models.TrainingTask{
+			Name:              fmt.Sprintf("traint-sim-%d", i),
+			UserId:            user.ID,
+			TrainingDatasetId: td.ID,
+			Status:            models.Queued,
+			Configuration:     map[string]interface{}{"simulated": true},
+		}
+		if err := s.repo.TrainingTask.Create(tt); err != nil {
+			return nil, fmt.Errorf("cannot queue training task: %w", err)
+		}
+		s.queuedAt[tt.ID] = now
+	}
+	if s.cfg.Tasks == 0 {
+		close(s.done)
+	}
+
+	return machines, nil
+}
+
+func (s *Simulator) runMachine(ctx context.Context, vm *virtualMachine) {
+	for ctx.Err() == nil {
+		var task *agent.Task
+		err := s.stats.call(ctx, "query", func() (err error) {
+			task, err = vm.client.QueryTask(ctx, s.cfg.PollWait)
+			return err
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil || task == nil {
+			// do not hammer the server when it fails or when long polling
+			// is disabled
+			if (err != nil || s.cfg.PollWait == 0) && !sleep(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+
+		if s.assigned(task.ID, vm.client.MachineID) {
+			s.processTask(ctx, vm, task)
+		}
+	}
+}
+
+// assigned records the assignment latency and detects tasks held by two
+// machines at once or assigned again after they finished. Tasks of other
+// runs sharing the database are ignored.
+func (s *Simulator) assigned(taskID, machineID uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queuedAt, ok := s.queuedAt[taskID]
+	if !ok {
+		return false
+	}
+
+	if _, held := s.holders[taskID]; held || s.finished[taskID] {
+		s.stats.duplicates++
+	}
+	s.holders[taskID] = machineID
+	s.stats.latencies = append(s.stats.latencies, time.Since(queuedAt))
+	return true
+}
+
+func (s *Simulator) released(taskID uint, finished bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.holders, taskID)
+	if !finished {
+		// a retried task is back in the queue
+		s.queuedAt[taskID] = time.Now()
+		return
+	}
+
+	if s.finished[taskID] {
+		return
+	}
+	s.finished[taskID] = true
+	if len(s.finished) == s.cfg.Tasks {
+		close(s.done)
+	}
+}
+
+func (s *Simulator) processTask(ctx context.Context, vm *virtualMachine, task *agent.Task) {
+	// a task whose status cannot be reported is left to the lease expiration,
+	// the queue requeues it then
+	abort, err := s.updateStatus(ctx, vm, task.ID, models.Training, nil)
+	if err != nil || abort {
+		s.released(task.ID, abort)
+		return
+	}
+
+	duration := s.cfg.MinDuration
+	if spread := s.cfg.MaxDuration - s.cfg.MinDuration; spread > 0 {
+		duration += time.Duration(vm.rand.Int63n(int64(spread)))
+	}
+	aborted, ok := s.train(ctx, vm, task.ID, duration)
+	if !ok || aborted {
+		s.released(task.ID, aborted)
+		return
+	}
+
+	if vm.rand.Float64() < s.cfg.FailureRate {
+		failure := &models.TaskFailure{Code: "simulated_failure", Message: "failure injected by the simulator"}
+		if _, err := s.updateStatus(ctx, vm, task.ID, models.Failed, failure); err != nil {
+			s.released(task.ID, false)
+			return
+		}
+		s.released(task.ID, s.isFinished(task.ID))
+		return
+	}
+
+	abort, err = s.updateStatus(ctx, vm, task.ID, models.Benchmarking, nil)
+	if err != nil || abort {
+		s.released(task.ID, abort)
+		return
+	}
+	if vm.rand.Float64() < s.cfg.UploadRate {
+		_ = s.stats.call(ctx, "upload", func() error {
+			return s.upload(ctx, vm, task.ID)
+		})
+	}
+	abort, err = s.updateStatus(ctx, vm, task.ID, models.Completed, nil)
+	s.released(task.ID, err == nil || abort)
+}
+
+// train waits for the duration while renewing the lease, ok is false when
+// the context is done.
+func (s *Simulator) train(ctx context.Context, vm *virtualMachine, taskID uint, duration time.Duration) (aborted, ok bool) {
+	deadline := time.NewTimer(duration)
+	defer deadline.Stop()
+	ticker := time.NewTicker(s.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, false
+		case <-deadline.C:
+			return false, true
+		case <-ticker.C:
+			err := s.stats.call(ctx, "heartbeat", func() (err error) {
+				aborted, err = vm.client.Heartbeat(ctx, taskID)
+				return err
+			})
+			if err == nil && aborted {
+				return true, true
+			}
+		}
+	}
+}
+
+// updateStatus reports the status, retrying failed requests like an agent
+// would, and returns whether the task was aborted.
+func (s *Simulator) updateStatus(ctx context.Context, vm *virtualMachine, taskID uint, status models.TrainingTaskStatus, failure *models.TaskFailure) (bool, error) {
+	var abort bool
+	var err error
+	for attempt := 0; attempt < statusAttempts; attempt++ {
+		err = s.stats.call(ctx, "status", func() (err error) {
+			abort, err = vm.client.UpdateStatus(ctx, taskID, status, failure)
+			return err
+		})
+		if err == nil || !sleep(ctx, time.Second) {
+			break
+		}
+	}
+	return abort, err
+}
+
+// isFinished tells whether a failed task stays failed or was requeued.
+func (s *Simulator) isFinished(taskID uint) bool {
+	tt, err := s.repo.TrainingTask.GetByID(taskID)
+	if err != nil {
+		return true
+	}
+	return tt.Status != models.Queued
+}
+
+func (s *Simulator) upload(ctx context.Context, vm *virtualMachine, taskID uint) error {
+	data := make([]byte, s.cfg.UploadSize)
+	vm.rand.Read(data)
+	checksum := sha256.Sum256(data)
+
+	upload, err := vm.client.InitiateUpload(ctx, taskID, "simulated.onnx", "simulated.onnx", models.Onnx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	upload, err = vm.client.WriteUploadChunk(ctx, taskID, upload.ID, 0, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if upload.Offset != upload.Size {
+		return errors.New("upload is incomplete")
+	}
+	return vm.client.FinalizeUpload(ctx, taskID, upload.ID, hex.EncodeToString(checksum[:]))
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"time"
 )
 
 func FormatSizePretty(bytes uint64) string {
@@ -26,3 +27,27 @@ func FormatSizePretty(bytes uint64) string {
 		return fmt.Sprintf("%d bytes", bytes)
 	}
 }
+
+// FormatDurationPretty formats duration using at most two most significant
+// units, e.g. "2d 3h", "1h 5m" or "42s".
+func FormatDurationPretty(d time.Duration) string {
+	if d < time.Second {
+		return "0s"
+	}
+
+	days := d / (24 * time.Hour)
+	hours := (d % (24 * time.Hour)) / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+	seconds := (d % time.Minute) / time.Second
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm %ds", minutes, seconds)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
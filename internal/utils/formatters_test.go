@@ -2,6 +2,7 @@ package utils
 
 import (
 	"testing"
+	"time"
 )
 
 func TestFormatSizePretty(t *testing.T) {
@@ -26,3 +27,26 @@ func TestFormatSizePretty(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatDurationPretty(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    time.Duration
+		expected string
+	}{
+		{"Below second case", 300 * time.Millisecond, "0s"},
+		{"Seconds case", 42 * time.Second, "42s"},
+		{"Minutes case", 5*time.Minute + 3*time.Second, "5m 3s"},
+		{"Hours case", time.Hour + 5*time.Minute + 59*time.Second, "1h 5m"},
+		{"Days case", 51 * time.Hour, "2d 3h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := FormatDurationPretty(tt.input)
+			if result != tt.expected {
+				t.Errorf("FormatDurationPretty(%s) = %q; want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import "html/template"
 func BaseTemplate() *template.Template {
 	return template.Must(template.New("").Funcs(template.FuncMap{
 		"formatFileSizePretty": FormatSizePretty,
+		"formatDuration":       FormatDurationPretty,
 		"isImage":              IsImage,
 		"isText":               IsText,
 		"safeHTML":             SafeHTML,
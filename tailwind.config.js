@@ -16,6 +16,7 @@ module.exports = {
     'bg-green-400',
     'bg-red-400',
     'bg-gray-400',
+    'bg-gray-500',
   ],
 }
 
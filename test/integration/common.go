@@ -70,13 +70,16 @@ func mockRouter(db *gorm.DB, cfg *config.Config) *IntegrationTestUtils {
 		},
 	})
 	fileService := service.NewMockFileService()
+	taskNotifier := service.NewTaskNotifier()
 
 	// handlers' routes
 	handler.InitLandingRoutes(mux, env)
 	handler.InitTrainingDatasetRoutes(mux, env, jalienService, nil)
-	handler.InitTrainingTaskRoutes(mux, env, ccdbService, jalienService, fileService, nnArch)
+	handler.InitTrainingTaskRoutes(mux, env, ccdbService, jalienService, fileService, nnArch, taskNotifier)
 	handler.InitTrainingMachineRoutes(mux, env, hasher)
-	handler.InitQueueRoutes(mux, env, fileService, hasher)
+	queueService := service.NewQueueService(fileService, env.RepositoryContext, hasher, env.Queue, service.NewMachineSessions(env.MachineAuth), nnArch, taskNotifier)
+	handler.InitQueueRoutes(mux, env, queueService)
+	handler.InitSweepRoutes(mux, env, nnArch, taskNotifier)
 
 	return &IntegrationTestUtils{
 		Env:    env,
@@ -93,8 +96,12 @@ func mockRouter(db *gorm.DB, cfg *config.Config) *IntegrationTestUtils {
 }
 
 func setupIntegrationTest(t *testing.T) (*IntegrationTestUtils, func()) {
+	return setupIntegrationTestWithDB(t, sqlite.Open(":memory:"))
+}
+
+func setupIntegrationTestWithDB(t *testing.T, dialector gorm.Dialector) (*IntegrationTestUtils, func()) {
 	t.Parallel()
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{TranslateError: true})
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
 	if err != nil {
 		t.Fatalf("failed to connect to database: %v", err)
 	}
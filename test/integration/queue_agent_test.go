@@ -0,0 +1,176 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/agent"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/sqlite"
+)
+
+// setupAgentTest serves the router over HTTP, the agent sends heartbeats and
+// logs concurrently, so the database is shared by all connections.
+func setupAgentTest(t *testing.T, trainer string) (*IntegrationTestUtils, *models.TrainingTask, *agent.Agent, func()) {
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=10000", filepath.Join(t.TempDir(), "agent.db"))
+	ut, cleanup := setupIntegrationTestWithDB(t, sqlite.Open(dsn))
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Configuration = map[string]interface{}{"learning_rate": 0.001}
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	server := httptest.NewServer(ut.Router)
+	a := agent.New(agent.NewClient(server.URL, tm.ID, tm.SecretKeyHashed), agent.Config{
+		WorkDir:           t.TempDir(),
+		TrainerCommand:    trainer,
+		HeartbeatInterval: 20 * time.Millisecond,
+		LogFlushInterval:  10 * time.Millisecond,
+		Once:              true,
+	})
+
+	return ut, tt, a, func() {
+		server.Close()
+		cleanup()
+	}
+}
+
+func dummyTrainer(t *testing.T) string {
+	path, err := filepath.Abs("test/integration/testdata/dummy_trainer.sh")
+	assert.NoError(t, err)
+	return "sh " + path
+}
+
+func expectAgentUpload(ut *IntegrationTestUtils, partialPath, fileName, content string) {
+	checksum := sha256.Sum256([]byte(content))
+
+	ut.FileService.On("CreatePartialFile").Return(partialPath, nil).Once()
+	ut.FileService.On("WritePartialFile", partialPath, int64(0), mock.Anything).
+		Run(func(args mock.Arguments) {
+			_, _ = io.Copy(io.Discard, args.Get(2).(io.Reader))
+		}).
+		Return(int64(len(content)), nil)
+	ut.FileService.On("FinalizePartialFile", partialPath, fileName, hex.EncodeToString(checksum[:])).
+		Return(&models.File{Name: fileName, Path: "/data/" + fileName, Size: uint64(len(content))}, nil)
+}
+
+func TestAgent_RunTask_Success(t *testing.T) {
+	ut, tt, a, cleanup := setupAgentTest(t, dummyTrainer(t))
+	defer cleanup()
+
+	trainerLog := fmt.Sprintf("task %d\naod files: 2\n", tt.ID)
+	expectAgentUpload(ut, "data/partial/onnx", "local_file.onnx", "onnx-model")
+	expectAgentUpload(ut, "data/partial/log", "trainer.log", trainerLog)
+
+	assert.NoError(t, a.Run(context.Background()))
+
+	task, err := ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Completed, task.Status)
+
+	events, err := ut.TrainingTaskStatusEvent.GetAll(tt.ID)
+	assert.NoError(t, err)
+	statuses := make([]models.TrainingTaskStatus, 0, len(events))
+	for _, e := range events {
+		statuses = append(statuses, e.ToStatus)
+	}
+	assert.Equal(t, []models.TrainingTaskStatus{models.Training, models.Benchmarking, models.Completed}, statuses)
+
+	onnx, err := ut.TrainingTaskResult.GetByType(tt.ID, models.Onnx)
+	assert.NoError(t, err)
+	assert.Len(t, onnx, 1)
+	assert.Equal(t, "local_file.onnx", onnx[0].Name)
+
+	logs, err := ut.TrainingTaskResult.GetByType(tt.ID, models.Log)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 1)
+
+	size, err := ut.TrainingTaskLogChunk.GetSize(tt.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(trainerLog)), size)
+
+	ut.FileService.AssertExpectations(t)
+}
+
+func TestAgent_RunTask_TrainerFailed(t *testing.T) {
+	ut, tt, a, cleanup := setupAgentTest(t, "echo 'loss is NaN'; exit 3")
+	defer cleanup()
+
+	assert.NoError(t, a.Run(context.Background()))
+
+	task, err := ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Failed, task.Status)
+	assert.Equal(t, "trainer_failed", task.Failure.Code)
+	assert.Equal(t, "exit status 3", task.Failure.Message)
+	assert.Equal(t, "loss is NaN\n", task.Failure.LogExcerpt)
+
+	results, err := ut.TrainingTaskResult.GetAll(tt.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestAgent_RunTask_WallTimeExceeded(t *testing.T) {
+	ut, tt, a, cleanup := setupAgentTest(t, "echo started; sleep 30")
+	defer cleanup()
+
+	tt.MaxWallTimeSeconds = 1
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	start := time.Now()
+	assert.NoError(t, a.Run(context.Background()))
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	task, err := ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Failed, task.Status)
+	assert.Equal(t, models.FailureCodeWallTimeExceeded, task.Failure.Code)
+	assert.Equal(t, "started\n", task.Failure.LogExcerpt)
+}
+
+func TestAgent_RunTask_ParentResults(t *testing.T) {
+	ut, parent, a, cleanup := setupAgentTest(t, `echo reused; cp "$TRAINT_PARENTS_DIR"/task-*/model.onnx local_file.onnx`)
+	defer cleanup()
+
+	content := "parent-model"
+	checksum := sha256.Sum256([]byte(content))
+	parent.Status = models.Completed
+	assert.NoError(t, ut.TrainingTask.Update(parent))
+	assert.NoError(t, ut.TrainingTaskResult.Create(&models.TrainingTaskResult{
+		Name:           "model",
+		Type:           models.Onnx,
+		TrainingTaskId: parent.ID,
+		File:           models.File{Name: "model.onnx", Path: "/data/model.onnx", Size: uint64(len(content)), SHA256: hex.EncodeToString(checksum[:])},
+	}))
+	ut.FileService.On("OpenFile", "/data/model.onnx").
+		Return(io.NopCloser(strings.NewReader(content)), func(io.ReadCloser) {}, nil)
+
+	child := &models.TrainingTask{
+		Name:              "Child task",
+		UserId:            parent.UserId,
+		TrainingDatasetId: parent.TrainingDatasetId,
+		Status:            models.Queued,
+		Configuration:     "",
+		Dependencies:      []models.TrainingTaskDependency{{ParentId: parent.ID}},
+	}
+	assert.NoError(t, ut.TrainingTask.Create(child))
+
+	expectAgentUpload(ut, "data/partial/onnx", "local_file.onnx", content)
+	expectAgentUpload(ut, "data/partial/log", "trainer.log", "reused\n")
+
+	assert.NoError(t, a.Run(context.Background()))
+
+	task, err := ut.TrainingTask.GetByID(child.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Completed, task.Status)
+	ut.FileService.AssertExpectations(t)
+}
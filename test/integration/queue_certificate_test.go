@@ -0,0 +1,196 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/agent"
+	"github.com/mytkom/AliceTraINT/internal/config"
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+)
+
+// localCA stands in for a grid CA, its certificate is the only one in dir.
+type localCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+var oidDomainComponent = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
+
+// gridSubject returns the subject /DC=ch/DC=cern/OU=<ou>/CN=<cn>.
+func gridSubject(t *testing.T, ou, cn string) []byte {
+	raw, err := asn1.Marshal(pkix.RDNSequence{
+		{{Type: oidDomainComponent, Value: "ch"}},
+		{{Type: oidDomainComponent, Value: "cern"}},
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: ou}},
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: cn}},
+	})
+	assert.NoError(t, err)
+	return raw
+}
+
+func newLocalCA(t *testing.T) *localCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		RawSubject:            gridSubject(t, "Certification Authorities", "Local Test CA"),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	ca := &localCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, filepath.Join(ca.dir, "local-ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a certificate signed by the CA and its key to a temporary
+// directory, server certificates are valid for 127.0.0.1.
+func (ca *localCA) issue(t *testing.T, subject []byte, server bool) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		RawSubject:   subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDer)
+	return certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+}
+
+func TestQueueHandler_MachineCertificate(t *testing.T) {
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=10000", filepath.Join(t.TempDir(), "tls.db"))
+	ut, cleanup := setupIntegrationTestWithDB(t, sqlite.Open(dsn))
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tm.CertificateSubject = "/DC=ch/DC=cern/OU=computers/CN=node1.cern.ch"
+	assert.NoError(t, ut.TrainingMachine.Update(tm))
+
+	ca := newLocalCA(t)
+	serverCert, serverKey := ca.issue(t, gridSubject(t, "computers", "alicetraint.cern.ch"), true)
+	tlsConfig, err := service.NewMachineTLSConfig(config.MachineAuthConfig{
+		TLSCertPath: serverCert,
+		TLSKeyPath:  serverKey,
+	}, ca.dir)
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(ut.Router)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	ctx := context.Background()
+
+	// machine with the bound certificate
+	cert, key := ca.issue(t, gridSubject(t, "computers", "node1.cern.ch"), false)
+	client, err := agent.NewCertificateClient(server.URL, tm.ID, cert, key, ca.dir)
+	assert.NoError(t, err)
+
+	state, err := client.GetState(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "active", state.State)
+	task, err := client.QueryTask(ctx, 0)
+	assert.NoError(t, err)
+	if assert.NotNil(t, task) {
+		assert.Equal(t, tt.ID, task.ID)
+	}
+
+	// valid certificate of another subject
+	cert, key = ca.issue(t, gridSubject(t, "computers", "node2.cern.ch"), false)
+	client, err = agent.NewCertificateClient(server.URL, tm.ID, cert, key, ca.dir)
+	assert.NoError(t, err)
+
+	_, err = client.GetState(ctx)
+	var apiErr *agent.APIError
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	}
+
+	// certificate issued by an unknown CA fails the handshake
+	cert, key = newLocalCA(t).issue(t, gridSubject(t, "computers", "node1.cern.ch"), false)
+	client, err = agent.NewCertificateClient(server.URL, tm.ID, cert, key, ca.dir)
+	assert.NoError(t, err)
+
+	_, err = client.GetState(ctx)
+	assert.Error(t, err)
+
+	// secret key is not accepted while the machine is bound
+	client = agent.NewClient(server.URL, tm.ID, tm.SecretKeyHashed)
+	client.HTTP = server.Client()
+
+	_, err = client.GetState(ctx)
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	}
+}
+
+func TestNewMachineTLSConfig_NoGridCAs(t *testing.T) {
+	for _, dir := range []string{"/cvmfs/alice.cern.ch/etc/grid-security/certificates", "/etc/grid-security/certificates"} {
+		if _, err := os.Stat(dir); err == nil {
+			t.Skipf("grid CAs are installed in %s", dir)
+		}
+	}
+	// no grid CAs in the home directory and no git to fetch them
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PATH", "")
+
+	ca := newLocalCA(t)
+	serverCert, serverKey := ca.issue(t, gridSubject(t, "computers", "alicetraint.cern.ch"), true)
+
+	// public CAs of the system pool must not be trusted instead
+	_, err := service.NewMachineTLSConfig(config.MachineAuthConfig{
+		TLSCertPath: serverCert,
+		TLSKeyPath:  serverKey,
+	}, t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/jalien"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/sqlite"
+)
+
+func TestQueueHandler_QueryTask_ConcurrentMachines(t *testing.T) {
+	// shared on-disk database, so that every connection of the pool sees the same queue
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=10000", filepath.Join(t.TempDir(), "queue.db"))
+	ut, cleanup := setupIntegrationTestWithDB(t, sqlite.Open(dsn))
+	defer cleanup()
+
+	const machinesCount = 40
+	const tasksCount = 60
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	td := &models.TrainingDataset{
+		Name:     "Concurrent Dataset",
+		AODFiles: []jalien.AODFile{{Name: "AO2D.root", Path: "/alice/sim/2024/LHC24b1b/0/567454/AOD/002/AO2D.root"}},
+		UserId:   user.ID,
+	}
+	assert.NoError(t, ut.TrainingDataset.Create(td))
+
+	machines := make([]*models.TrainingMachine, machinesCount)
+	for i := range machines {
+		machines[i] = &models.TrainingMachine{
+			Name:            fmt.Sprintf("machine-%d", i),
+			UserId:          user.ID,
+			SecretKeyHashed: fmt.Sprintf("secret-%d", i),
+		}
+		assert.NoError(t, ut.TrainingMachine.Create(machines[i]))
+	}
+	ut.Hasher.On("VerifyKey", mock.Anything, mock.Anything).Return(true, nil)
+
+	for i := 0; i < tasksCount; i++ {
+		assert.NoError(t, ut.TrainingTask.Create(&models.TrainingTask{
+			Name:              fmt.Sprintf("task-%d", i),
+			UserId:            user.ID,
+			TrainingDatasetId: td.ID,
+			Status:            models.Queued,
+		}))
+	}
+
+	var mu sync.Mutex
+	assignments := make(map[uint][]uint)
+	var wg sync.WaitGroup
+	wg.Add(machinesCount)
+
+	for _, tm := range machines {
+		go func(tm *models.TrainingMachine) {
+			defer wg.Done()
+			for {
+				req := newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task", tm.ID), nil, tm.SecretKeyHashed)
+				rr := httptest.NewRecorder()
+				ut.Router.ServeHTTP(rr, req)
+
+				if rr.Code == http.StatusNotFound {
+					return
+				}
+				if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+					return
+				}
+
+				var resp queryTaskResponse
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+				mu.Lock()
+				assignments[resp.ID] = append(assignments[resp.ID], tm.ID)
+				mu.Unlock()
+			}
+		}(tm)
+	}
+	wg.Wait()
+
+	assert.Len(t, assignments, tasksCount)
+	for taskId, machineIds := range assignments {
+		assert.Len(t, machineIds, 1, "task %d assigned to machines %v", taskId, machineIds)
+
+		tt, err := ut.TrainingTask.GetByID(taskId)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Training, tt.Status)
+		assert.Equal(t, machineIds[0], *tt.TrainingMachineId)
+	}
+}
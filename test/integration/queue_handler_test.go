@@ -5,14 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mytkom/AliceTraINT/internal/config"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
 	"github.com/mytkom/AliceTraINT/internal/jalien"
+	"github.com/mytkom/AliceTraINT/internal/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -60,6 +65,8 @@ func TestQueueHandler_UpdateStatus_Success(t *testing.T) {
 	defer cleanup()
 
 	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
 
 	body, err := json.Marshal(map[string]uint{"Status": uint(models.Benchmarking)})
 	assert.NoError(t, err)
@@ -70,12 +77,133 @@ func TestQueueHandler_UpdateStatus_Success(t *testing.T) {
 	ut.Router.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
-	assert.Empty(t, rr.Body.String())
+	assert.JSONEq(t, `{"Abort":false}`, rr.Body.String())
+
+	events, err := ut.TrainingTaskStatusEvent.GetAll(tt.ID)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, models.Training, *events[0].FromStatus)
+	assert.Equal(t, models.Benchmarking, events[0].ToStatus)
+	assert.False(t, events[0].CreatedAt.IsZero())
+}
+
+func TestQueueHandler_UpdateStatus_FailureReason(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	body, err := json.Marshal(map[string]interface{}{
+		"Status":       models.Failed,
+		"ErrorCode":    "oom",
+		"ErrorMessage": "CUDA out of memory",
+		"LogExcerpt":   "epoch 3/10\nRuntimeError: CUDA out of memory",
+	})
+	assert.NoError(t, err)
+
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/status", tt.ID), body, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	failedTask, err := ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Failed, failedTask.Status)
+	assert.Equal(t, "oom", failedTask.Failure.Code)
+	assert.Equal(t, "CUDA out of memory", failedTask.Failure.Message)
+	assert.Contains(t, failedTask.Failure.LogExcerpt, "RuntimeError")
+
+	showReq, err := http.NewRequest("GET", fmt.Sprintf("/training-tasks/%d", tt.ID), nil)
+	assert.NoError(t, err)
+	rr = addSessionCookie(t, ut.Auth, showReq, tt.UserId)
+	ut.Router.ServeHTTP(rr, showReq)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Failure reason")
+	assert.Contains(t, rr.Body.String(), "RuntimeError: CUDA out of memory")
+}
+
+func TestQueueHandler_UpdateStatus_FailureRetried(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	ut.NNArch.RetryPolicy = service.NNRetryPolicy{MaxAttempts: 2, BackoffSeconds: 30}
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	tt.TrainingMachineId = &tm.ID
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	body, err := json.Marshal(map[string]interface{}{
+		"Status":       models.Failed,
+		"ErrorCode":    "disk_full",
+		"ErrorMessage": "no space left on device",
+	})
+	assert.NoError(t, err)
+
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/status", tt.ID), body, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	retriedTask, err := ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Queued, retriedTask.Status)
+	assert.Equal(t, uint(1), retriedTask.Retries)
+	assert.NotNil(t, retriedTask.RetryAt)
+	assert.Empty(t, retriedTask.Failure.Code)
+
+	attempts, err := ut.TrainingTaskAttempt.GetAll(tt.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, attempts, 1) {
+		assert.Equal(t, "disk_full", attempts[0].Failure.Code)
+		assert.Equal(t, tm.Name, attempts[0].TrainingMachine.Name)
+	}
+
+	showReq, err := http.NewRequest("GET", fmt.Sprintf("/training-tasks/%d", tt.ID), nil)
+	assert.NoError(t, err)
+	rr = addSessionCookie(t, ut.Auth, showReq, tt.UserId)
+	ut.Router.ServeHTTP(rr, showReq)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Previous Attempts")
+	assert.Contains(t, rr.Body.String(), "no space left on device")
+	assert.Contains(t, rr.Body.String(), "Attempt 2 will not start before")
+}
+
+func TestQueueHandler_UpdateStatus_IllegalTransition(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Completed
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	body, err := json.Marshal(map[string]uint{"Status": uint(models.Uploaded)})
+	assert.NoError(t, err)
+
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/status", tt.ID), body, tm.SecretKeyHashed)
+
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "cannot change status from Completed to Uploaded, allowed next statuses: none")
+
+	events, err := ut.TrainingTaskStatusEvent.GetAll(tt.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
 }
 
 type queryTaskResponse struct {
-	ID       uint
-	AODFiles []jalien.AODFile
+	ID                 uint
+	AODFiles           []jalien.AODFile
+	MaxWallTimeSeconds uint
+	ParentResults      []service.ParentResult
+	ExpectedOnnx       []string
 }
 
 func TestQueueHandler_QueryTask_Success(t *testing.T) {
@@ -103,6 +231,364 @@ func TestQueueHandler_QueryTask_Success(t *testing.T) {
 	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
 	assert.Equal(t, tt.ID, resp.ID)
 	assert.True(t, reflect.DeepEqual(resp.AODFiles, tt.TrainingDataset.AODFiles))
+	assert.Equal(t, []string{"local_file.onnx"}, resp.ExpectedOnnx)
+}
+
+func TestQueueHandler_QueryTask_MaxWallTime(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	ut.NNArch.MaxWallTimeSeconds = 3600
+
+	overridden := &models.TrainingTask{
+		Name:               "Task with own wall time",
+		TrainingDatasetId:  tt.TrainingDatasetId,
+		Status:             models.Queued,
+		UserId:             tt.UserId,
+		MaxWallTimeSeconds: 600,
+	}
+	assert.NoError(t, ut.TrainingTask.Create(overridden))
+
+	for _, expected := range []uint{3600, 600} {
+		req := newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task", tm.ID), nil, tm.SecretKeyHashed)
+		rr := httptest.NewRecorder()
+		ut.Router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var resp queryTaskResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, expected, resp.MaxWallTimeSeconds)
+	}
+}
+
+func TestQueueHandler_QueryTask_LongPollTimeout(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Completed
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	req := newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task?wait=1", tm.ID), nil, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	req = newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task?wait=soon", tm.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestQueueHandler_QueryTask_LongPollAvailable(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+
+	req := newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task?wait=30", tm.ID), nil, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	var resp queryTaskResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, tt.ID, resp.ID)
+}
+
+func TestQueueHandler_QueryTask_UnsatisfiedRequirements(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Requirements = []string{"gpu_mem_gb>=24"}
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	req := newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task", tm.ID), nil, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	body, err := json.Marshal(map[string]map[string]string{"Labels": {"gpu_mem_gb": "40"}})
+	assert.NoError(t, err)
+	req = newRequest(t, "PUT", fmt.Sprintf("/training-machines/%d/labels", tm.ID), body, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req = newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task", tm.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp queryTaskResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, tt.ID, resp.ID)
+}
+
+func TestQueueHandler_AssignTaskToMachine_PastCandidateLimit(t *testing.T) {
+	policies := map[string]config.QueueConfig{
+		"fifo":           {SchedulingPolicy: service.FIFOPolicyName},
+		"priority":       {SchedulingPolicy: service.PriorityPolicyName},
+		"priority aging": {SchedulingPolicy: service.PriorityPolicyName, PriorityAgingMinutes: 60},
+		"fair-share":     {SchedulingPolicy: service.FairSharePolicyName, FairShareWindowHours: 24},
+	}
+	for name, queueConfig := range policies {
+		t.Run(name, func(t *testing.T) {
+			ut, cleanup := setupIntegrationTest(t)
+			defer cleanup()
+
+			user, tt, tm := setupTestUserAndTask(t, ut)
+			queueConfig.LeaseSeconds = 60
+			queueConfig.CandidateLimit = 3
+			qs := service.NewQueueService(nil, ut.RepositoryContext, ut.Hasher, queueConfig, service.NewMachineSessions(ut.Config.MachineAuth), nil, service.NewTaskNotifier())
+
+			// the oldest tasks fill more than a page and need a GPU
+			tt.Requirements = []string{"gpu=a100"}
+			assert.NoError(t, ut.TrainingTask.Update(tt))
+			for i := 0; i < 4; i++ {
+				assert.NoError(t, ut.TrainingTask.Create(&models.TrainingTask{
+					Name:              fmt.Sprintf("GPU task %d", i),
+					UserId:            user.ID,
+					TrainingDatasetId: tt.TrainingDatasetId,
+					Status:            models.Queued,
+					Requirements:      []string{"gpu=a100"},
+				}))
+			}
+			cpuTask := &models.TrainingTask{
+				Name:              "CPU task",
+				UserId:            user.ID,
+				TrainingDatasetId: tt.TrainingDatasetId,
+				Status:            models.Queued,
+			}
+			assert.NoError(t, ut.TrainingTask.Create(cpuTask))
+
+			assigned, err := qs.AssignTaskToMachine(tm)
+			assert.NoError(t, err)
+			if assert.NotNil(t, assigned) {
+				assert.Equal(t, cpuTask.ID, assigned.ID)
+			}
+		})
+	}
+}
+
+type machineStateResponse struct {
+	State          string
+	Idle           bool
+	Drained        bool
+	RunningTaskIds []uint
+}
+
+func setMachineState(t *testing.T, ut *IntegrationTestUtils, tm *models.TrainingMachine, state string) (int, machineStateResponse) {
+	body, err := json.Marshal(map[string]string{"State": state})
+	assert.NoError(t, err)
+	req := newRequest(t, "PUT", fmt.Sprintf("/training-machines/%d/state", tm.ID), body, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	var resp machineStateResponse
+	if rr.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	}
+	return rr.Code, resp
+}
+
+func TestQueueHandler_DrainMachine(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+
+	req := newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task", tm.ID), nil, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	code, state := setMachineState(t, ut, tm, "draining")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, machineStateResponse{State: "draining", RunningTaskIds: []uint{tt.ID}}, state)
+
+	queuedTrainingTask := &models.TrainingTask{
+		Name:              "Queued training task",
+		TrainingDatasetId: tt.TrainingDatasetId,
+		Status:            models.Queued,
+		UserId:            tt.UserId,
+		Configuration:     "",
+	}
+	assert.NoError(t, ut.TrainingTask.Create(queuedTrainingTask))
+
+	req = newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task?wait=1", tm.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "training machine is draining")
+
+	// the running task is finished
+	for _, status := range []models.TrainingTaskStatus{models.Benchmarking, models.Completed} {
+		body, err := json.Marshal(map[string]uint{"Status": uint(status)})
+		assert.NoError(t, err)
+		req = newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/status", tt.ID), body, tm.SecretKeyHashed)
+		rr = httptest.NewRecorder()
+		ut.Router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	req = newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/state", tm.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"State":"draining","Idle":true,"Drained":true,"RunningTaskIds":[]}`, rr.Body.String())
+
+	code, _ = setMachineState(t, ut, tm, "rebooting")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+
+	code, state = setMachineState(t, ut, tm, "active")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "active", state.State)
+
+	req = newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task", tm.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp queryTaskResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, queuedTrainingTask.ID, resp.ID)
+}
+
+func TestQueueHandler_DrainNotUndoneByMachineWrites(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user, _, tm := setupTestUserAndTask(t, ut)
+	qs := service.NewQueueService(nil, ut.RepositoryContext, ut.Hasher, ut.Config.Queue, service.NewMachineSessions(ut.Config.MachineAuth), nil, service.NewTaskNotifier())
+
+	// the machine read its row before the owner drained it
+	stale, err := ut.TrainingMachine.GetByID(tm.ID)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/training-machines/%d/state", tm.ID), strings.NewReader(`{"state":"draining"}`))
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	assert.NoError(t, qs.UpdateMachineLabels(stale, map[string]string{"gpu": "a100"}))
+	assert.NoError(t, qs.RecordTelemetry(stale, &models.TrainingMachineTelemetry{CPUPercent: 10}))
+
+	tm, err = ut.TrainingMachine.GetByID(tm.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.MachineDraining, tm.State)
+	assert.Equal(t, map[string]string{"gpu": "a100"}, tm.Labels)
+}
+
+func TestQueueHandler_NoClaimAfterDrain(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	qs := service.NewQueueService(nil, ut.RepositoryContext, ut.Hasher, ut.Config.Queue, service.NewMachineSessions(ut.Config.MachineAuth), nil, service.NewTaskNotifier())
+
+	// the polling machine was loaded before it got drained
+	stale, err := ut.TrainingMachine.GetByID(tm.ID)
+	assert.NoError(t, err)
+	code, _ := setMachineState(t, ut, tm, "draining")
+	assert.Equal(t, http.StatusOK, code)
+
+	_, err = qs.AssignTaskToMachine(stale)
+	assert.Error(t, err)
+
+	tt, err = ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Queued, tt.Status)
+}
+
+func TestQueueHandler_RequeueExpiredTasks(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user, tt, tm := setupTestUserAndTask(t, ut)
+	qs := service.NewQueueService(nil, ut.RepositoryContext, ut.Hasher, ut.Config.Queue, service.NewMachineSessions(ut.Config.MachineAuth), nil, service.NewTaskNotifier())
+
+	// a running task without a lease is never renewed
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+	leased := &models.TrainingTask{
+		Name:              "leased",
+		UserId:            user.ID,
+		TrainingDatasetId: tt.TrainingDatasetId,
+		TrainingMachineId: &tm.ID,
+		Status:            models.Training,
+	}
+	assert.NoError(t, ut.TrainingTask.Create(leased))
+	_, err := qs.RenewLease(leased.ID)
+	assert.NoError(t, err)
+
+	assert.NoError(t, qs.RequeueExpiredTasks())
+
+	tt, err = ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Queued, tt.Status)
+	assert.Nil(t, tt.TrainingMachineId)
+	assert.Equal(t, uint(1), tt.LostLeases)
+	leased, err = ut.TrainingTask.GetByID(leased.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Training, leased.Status)
+}
+
+func TestQueueHandler_MachineSession(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, _, tm := setupTestUserAndTask(t, ut)
+
+	req := newRequest(t, "POST", fmt.Sprintf("/training-machines/%d/session", tm.ID), nil, "wrong-secret")
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req = newRequest(t, "POST", fmt.Sprintf("/training-machines/%d/session", tm.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var session struct {
+		Token     string
+		ExpiresAt time.Time
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &session))
+	assert.True(t, session.ExpiresAt.After(time.Now()))
+
+	for _, tc := range []struct {
+		token string
+		code  int
+	}{
+		{session.Token, http.StatusOK},
+		{session.Token + "x", http.StatusUnauthorized},
+	} {
+		req = newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/state", tm.ID), nil, "")
+		req.Header.Del("Secret-Id")
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rr = httptest.NewRecorder()
+		ut.Router.ServeHTTP(rr, req)
+		assert.Equal(t, tc.code, rr.Code)
+	}
+
+	// the session is bound to the machine it was issued to
+	req = newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/state", tm.ID+1), nil, "")
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
 }
 
 func TestQueueHandler_CreateTrainingTaskResult_Success(t *testing.T) {
@@ -117,7 +603,7 @@ func TestQueueHandler_CreateTrainingTaskResult_Success(t *testing.T) {
 		Description: "some local file",
 		File:        models.File{Name: "file.txt", Path: "./file.txt", Size: 432},
 	}
-	ut.FileService.On("SaveFile", mock.Anything, mock.Anything).Return(&ttr.File, nil)
+	ut.FileService.On("SaveFile", mock.Anything, mock.Anything, mock.Anything).Return(&ttr.File, nil)
 
 	buf, mw := prepareMultipartData(t, map[string]string{
 		"name":        ttr.Name,
@@ -140,6 +626,390 @@ func TestQueueHandler_CreateTrainingTaskResult_Success(t *testing.T) {
 	assert.Equal(t, ttr.File.Path, resTtr.File.Path)
 }
 
+func TestQueueHandler_CreateTrainingTaskResult_ChecksumMismatch(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+
+	checksum := strings.Repeat("ab", 32)
+	ut.FileService.On("SaveFile", mock.Anything, mock.Anything, checksum).Return(nil, service.ErrChecksumMismatch)
+
+	buf, mw := prepareMultipartData(t, map[string]string{
+		"name":        "Local file",
+		"description": "some local file",
+		"file-type":   fmt.Sprintf("%d", uint(models.Onnx)),
+		"sha256":      checksum,
+	}, "file", "file.txt", []byte("Test file"))
+
+	req := newRequestWithMultipart(t, "POST", fmt.Sprintf("/training-tasks/%d/training-task-results", tt.ID), &buf, mw.FormDataContentType(), tm.SecretKeyHashed)
+
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "sha256 does not match the uploaded file")
+
+	results, err := ut.TrainingTaskResult.GetAll(tt.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestQueueHandler_ResumableUpload(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+
+	checksum := strings.Repeat("ab", 32)
+	file := &models.File{Name: "model.onnx", Path: "/data/model.onnx", Size: 10}
+	var stored bytes.Buffer
+	ut.FileService.On("CreatePartialFile").Return("data/partial/partial-1", nil)
+	for offset, written := range map[int64]int64{0: 5, 3: 4, 7: 3} {
+		ut.FileService.On("WritePartialFile", "data/partial/partial-1", offset, mock.Anything).
+			Run(func(args mock.Arguments) {
+				stored.Truncate(int(args.Get(1).(int64)))
+				_, err := io.Copy(&stored, args.Get(2).(io.Reader))
+				assert.NoError(t, err)
+			}).
+			Return(written, nil)
+	}
+	ut.FileService.On("FinalizePartialFile", "data/partial/partial-1", "model.onnx", checksum).Return(file, nil)
+
+	type uploadState struct {
+		ID     uint
+		Offset int64
+		Size   int64
+	}
+	var state uploadState
+
+	body := []byte(`{"Name":"model","Type":2,"FileName":"model.onnx","Size":10}`)
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/uploads", tt.ID), body, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &state))
+	assert.Equal(t, uploadState{ID: state.ID, Offset: 0, Size: 10}, state)
+
+	uploadURL := fmt.Sprintf("/training-tasks/%d/uploads/%d", tt.ID, state.ID)
+	for _, chunk := range []struct {
+		offset int64
+		data   string
+	}{{0, "01234"}, {3, "3456"}, {7, "789"}} {
+		req = newRequest(t, "PUT", fmt.Sprintf("%s?offset=%d", uploadURL, chunk.offset), []byte(chunk.data), tm.SecretKeyHashed)
+		rr = httptest.NewRecorder()
+		ut.Router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	req = newRequest(t, "GET", uploadURL, nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &state))
+	assert.Equal(t, int64(10), state.Offset)
+	assert.Equal(t, "0123456789", stored.String())
+
+	body = []byte(fmt.Sprintf(`{"SHA256":"%s"}`, checksum))
+	req = newRequest(t, "POST", uploadURL+"/finalize", body, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var ttr models.TrainingTaskResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ttr))
+	assert.Equal(t, "model", ttr.Name)
+	assert.Equal(t, models.Onnx, ttr.Type)
+	assert.Equal(t, file.Path, ttr.File.Path)
+
+	req = newRequest(t, "GET", uploadURL, nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestQueueHandler_ResumableUpload_Gap(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	upload := &models.TrainingTaskUpload{TrainingTaskId: tt.ID, Name: "model", FileName: "model.onnx", Size: 10, Received: 4}
+	assert.NoError(t, ut.TrainingTaskUpload.Create(upload))
+
+	req := newRequest(t, "PUT", fmt.Sprintf("/training-tasks/%d/uploads/%d?offset=6", tt.ID, upload.ID), []byte("6789"), tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "expected offset 4")
+}
+
+func TestQueueHandler_Heartbeat_Success(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/heartbeat", tt.ID), nil, tm.SecretKeyHashed)
+
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var resp struct {
+		LeaseExpiresAt *time.Time
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.NotNil(t, resp.LeaseExpiresAt)
+	assert.True(t, resp.LeaseExpiresAt.After(time.Now()))
+}
+
+func TestQueueHandler_Heartbeat_NotRunning(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/heartbeat", tt.ID), nil, tm.SecretKeyHashed)
+
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestQueueHandler_Cancelled_Abort(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	cancelReq, err := http.NewRequest("POST", fmt.Sprintf("/training-tasks/%d/cancel", tt.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(cancelReq)
+	rr := addSessionCookie(t, ut.Auth, cancelReq, tt.UserId)
+	ut.Router.ServeHTTP(rr, cancelReq)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/heartbeat", tt.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp struct {
+		LeaseExpiresAt *time.Time
+		Abort          bool
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.True(t, resp.Abort)
+	assert.Nil(t, resp.LeaseExpiresAt)
+
+	body, err := json.Marshal(map[string]uint{"Status": uint(models.Benchmarking)})
+	assert.NoError(t, err)
+	req = newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/status", tt.ID), body, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"Abort":true}`, rr.Body.String())
+
+	cancelledTask, err := ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cancelled, cancelledTask.Status)
+	assert.NotNil(t, cancelledTask.FinishedAt)
+
+	events, err := ut.TrainingTaskStatusEvent.GetAll(tt.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, models.Cancelled, events[0].ToStatus)
+		assert.Equal(t, models.StatusSourceUser, events[0].Source)
+	}
+}
+
+func TestQueueHandler_ReportMetrics_Success(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	body := []byte(`{"Points":[{"Step":10,"Epoch":1,"Values":{"loss":0.5,"lr":0.001}},{"Step":20,"Values":{"loss":0.4}}]}`)
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/metrics", tt.ID), body, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"Abort":false}`, rr.Body.String())
+
+	metrics, err := ut.TrainingTaskMetric.GetAll(tt.ID, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, metrics, 3) {
+		assert.Equal(t, "loss", metrics[0].Name)
+		assert.Equal(t, uint(1), *metrics[0].Epoch)
+		assert.Equal(t, uint(20), metrics[2].Step)
+		assert.Equal(t, 0.4, metrics[2].Value)
+	}
+}
+
+func TestQueueHandler_ReportMetrics_InvalidName(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	body := []byte(`{"Points":[{"Step":10,"Values":{"bad name!":0.5}}]}`)
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/metrics", tt.ID), body, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+}
+
+func TestQueueHandler_ReportMetrics_NotRunning(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+
+	body := []byte(`{"Points":[{"Step":10,"Values":{"loss":0.5}}]}`)
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/metrics", tt.ID), body, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestQueueHandler_ReportTelemetry(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user, _, tm := setupTestUserAndTask(t, ut)
+
+	for _, body := range []string{`{"CPUPercent":150}`, `{"DiskFreeBytes":18446744073709551615}`} {
+		req := newRequest(t, "POST", fmt.Sprintf("/training-machines/%d/telemetry", tm.ID), []byte(body), tm.SecretKeyHashed)
+		rr := httptest.NewRecorder()
+		ut.Router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	for _, cpu := range []float64{20, 60} {
+		body := []byte(fmt.Sprintf(`{"CPUPercent":%g,"GPUPercent":90,"MemoryUsedBytes":1073741824,"MemoryTotalBytes":4294967296,"DiskFreeBytes":53687091200,"AgentVersion":"v1.2.0","DriverVersion":"550.54.15"}`, cpu))
+		req := newRequest(t, "POST", fmt.Sprintf("/training-machines/%d/telemetry", tm.ID), body, tm.SecretKeyHashed)
+		rr := httptest.NewRecorder()
+		ut.Router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	reports, err := ut.TrainingMachineTelemetry.GetSince(tm.ID, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, 60.0, reports[1].CPUPercent)
+		assert.Equal(t, 90.0, *reports[1].GPUPercent)
+	}
+	tm, err = ut.TrainingMachine.GetByID(tm.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, tm.LastTelemetryAt)
+	assert.Equal(t, "v1.2.0", tm.AgentVersion)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-machines/%d/telemetry", tm.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	for _, expected := range []string{"online", "v1.2.0", "550.54.15", "CPU %", "GPU %", "Memory %", "Free disk GB", "50.00 GB"} {
+		assert.Contains(t, rr.Body.String(), expected)
+	}
+}
+
+func TestQueueHandler_AppendLog_Success(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	chunks := []struct {
+		offset int64
+		data   string
+	}{
+		{0, "epoch 1\n"},
+		// resent after a lost response, partially overlapping
+		{4, "h 1\nepoch 2\n"},
+		{16, "epoch 3\n"},
+	}
+	var resp struct {
+		Offset int64
+		Abort  bool
+	}
+	for _, chunk := range chunks {
+		req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/logs?offset=%d", tt.ID, chunk.offset), []byte(chunk.data), tm.SecretKeyHashed)
+		rr := httptest.NewRecorder()
+		ut.Router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, chunk.offset+int64(len(chunk.data)), resp.Offset)
+		assert.False(t, resp.Abort)
+	}
+
+	logChunks, err := ut.TrainingTaskLogChunk.GetFrom(tt.ID, 0, 0, 10)
+	assert.NoError(t, err)
+	var log string
+	for _, chunk := range logChunks {
+		log += string(chunk.Data)
+	}
+	assert.Equal(t, "epoch 1\nepoch 2\nepoch 3\n", log)
+}
+
+func TestQueueHandler_AppendLog_DuplicateChunk(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, _ := setupTestUserAndTask(t, ut)
+
+	// a resent chunk stored concurrently with the original one hits the
+	// unique index of the task attempt and offset
+	for _, expected := range []bool{true, false} {
+		created, err := ut.TrainingTaskLogChunk.CreateIfAbsent(&models.TrainingTaskLogChunk{
+			TrainingTaskId: tt.ID,
+			Size:           8,
+			Data:           []byte("epoch 1\n"),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, created)
+	}
+}
+
+func TestQueueHandler_AppendLog_Gap(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/logs?offset=10", tt.ID), []byte("epoch 2\n"), tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "expected offset 0")
+}
+
 func TestQueueHandler_UpdateStatus_Unauthorized(t *testing.T) {
 	ut, cleanup := setupIntegrationTest(t)
 	defer cleanup()
@@ -228,3 +1098,108 @@ func prepareMultipartData(t *testing.T, fields map[string]string, fileField, fil
 	assert.NoError(t, mw.Close())
 	return buf, mw
 }
+
+func TestQueueHandler_QueryTask_WaitsForParents(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, parent, tm := setupTestUserAndTask(t, ut)
+	child := &models.TrainingTask{
+		Name:              "Child task",
+		TrainingDatasetId: parent.TrainingDatasetId,
+		Status:            models.Queued,
+		UserId:            parent.UserId,
+		Configuration:     "",
+		Dependencies:      []models.TrainingTaskDependency{{ParentId: parent.ID}},
+	}
+	assert.NoError(t, ut.TrainingTask.Create(child))
+
+	queryTask := func() *httptest.ResponseRecorder {
+		req := newRequest(t, "GET", fmt.Sprintf("/training-machines/%d/training-task", tm.ID), nil, tm.SecretKeyHashed)
+		rr := httptest.NewRecorder()
+		ut.Router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the parent is assigned first, the child waits until it completes
+	rr := queryTask()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp queryTaskResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, parent.ID, resp.ID)
+	assert.Empty(t, resp.ParentResults)
+
+	assert.Equal(t, http.StatusNotFound, queryTask().Code)
+
+	parent, err := ut.TrainingTask.GetByID(parent.ID)
+	assert.NoError(t, err)
+	parent.Status = models.Completed
+	assert.NoError(t, ut.TrainingTask.Update(parent))
+	result := &models.TrainingTaskResult{
+		Name:           "model",
+		Type:           models.Onnx,
+		TrainingTaskId: parent.ID,
+		File:           models.File{Name: "model.onnx", Path: "/data/model.onnx", Size: 5},
+	}
+	assert.NoError(t, ut.TrainingTaskResult.Create(result))
+
+	rr = queryTask()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	resp = queryTaskResponse{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, child.ID, resp.ID)
+	assert.Equal(t, []service.ParentResult{{
+		ID:             result.ID,
+		TrainingTaskId: parent.ID,
+		Name:           "model",
+		Type:           models.Onnx,
+		FileName:       "model.onnx",
+		Size:           5,
+	}}, resp.ParentResults)
+
+	ut.FileService.On("OpenFile", "/data/model.onnx").
+		Return(io.NopCloser(strings.NewReader("model")), func(io.ReadCloser) {}, nil)
+
+	req := newRequest(t, "GET", fmt.Sprintf("/training-tasks/%d/parent-results/%d", child.ID, result.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "model", rr.Body.String())
+
+	// results are available to children of the task only
+	req = newRequest(t, "GET", fmt.Sprintf("/training-tasks/%d/parent-results/%d", parent.ID, result.ID), nil, tm.SecretKeyHashed)
+	rr = httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestQueueHandler_UpdateStatus_FailureFailsDependentTasks(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, parent, tm := setupTestUserAndTask(t, ut)
+	parent.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(parent))
+
+	child := &models.TrainingTask{
+		Name:              "Child task",
+		TrainingDatasetId: parent.TrainingDatasetId,
+		Status:            models.Queued,
+		UserId:            parent.UserId,
+		Configuration:     "",
+		Dependencies:      []models.TrainingTaskDependency{{ParentId: parent.ID}},
+	}
+	assert.NoError(t, ut.TrainingTask.Create(child))
+
+	body, err := json.Marshal(map[string]any{"Status": models.Failed, "ErrorCode": "oom"})
+	assert.NoError(t, err)
+	req := newRequest(t, "POST", fmt.Sprintf("/training-tasks/%d/status", parent.ID), body, tm.SecretKeyHashed)
+	rr := httptest.NewRecorder()
+	ut.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	child, err = ut.TrainingTask.GetByID(child.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Failed, child.Status)
+	assert.Equal(t, models.FailureCodeDependencyFailed, child.Failure.Code)
+}
@@ -0,0 +1,73 @@
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/mytkom/AliceTraINT/internal/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/sqlite"
+)
+
+func TestSimulator_Run(t *testing.T) {
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=10000", filepath.Join(t.TempDir(), "simulator.db"))
+	ut, cleanup := setupIntegrationTestWithDB(t, sqlite.Open(dsn))
+	defer cleanup()
+
+	const uploadSize = 128
+	ut.Hasher.On("VerifyKey", mock.Anything, mock.Anything).Return(true, nil)
+	ut.FileService.On("CreatePartialFile").Return("data/partial/simulated", nil)
+	ut.FileService.On("WritePartialFile", "data/partial/simulated", int64(0), mock.Anything).
+		Run(func(args mock.Arguments) {
+			_, _ = io.Copy(io.Discard, args.Get(2).(io.Reader))
+		}).
+		Return(int64(uploadSize), nil)
+	ut.FileService.On("FinalizePartialFile", "data/partial/simulated", "simulated.onnx", mock.Anything).
+		Return(&models.File{Name: "simulated.onnx", Path: "/data/simulated.onnx", Size: uploadSize}, nil)
+
+	server := httptest.NewServer(ut.Router)
+	defer server.Close()
+
+	sim := simulator.New(ut.RepositoryContext, service.NewArgon2Hasher(), server.URL, simulator.Config{
+		Machines:          5,
+		Tasks:             12,
+		MinDuration:       10 * time.Millisecond,
+		MaxDuration:       30 * time.Millisecond,
+		FailureRate:       0.3,
+		UploadRate:        0.5,
+		UploadSize:        uploadSize,
+		PollWait:          time.Second,
+		HeartbeatInterval: 10 * time.Millisecond,
+		Seed:              1,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	report, err := sim.Run(ctx)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 5, report.Machines)
+	assert.Equal(t, 12, report.FinishedTasks)
+	assert.Equal(t, 12, report.Assignments)
+	assert.Zero(t, report.DuplicateAssignments)
+	requests, errors := report.Requests()
+	assert.Positive(t, requests)
+	assert.Zero(t, errors)
+
+	completedStatus := models.Completed
+	completed, err := ut.TrainingTask.GetAll(repository.TrainingTaskFilter{Status: &completedStatus})
+	assert.NoError(t, err)
+	failed, err := ut.TrainingTask.GetAll(repository.TrainingTaskFilter{FailureCode: "simulated_failure"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, failed)
+	assert.Equal(t, 12, len(completed)+len(failed))
+}
@@ -0,0 +1,133 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/jalien"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepHandler_New(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	req, err := http.NewRequest("GET", "/sweeps/new", nil)
+	assert.NoError(t, err)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<option value="fieldName">Full field name (128..1024 by 1)</option>`)
+}
+
+func TestSweepHandler_Create_RankByMetric(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	td := models.TrainingDataset{Name: "Unique Dataset Name", AODFiles: []jalien.AODFile{}, UserId: user.ID}
+	assert.NoError(t, ut.TrainingDataset.Create(&td))
+
+	body, err := json.Marshal(map[string]any{
+		"name":              "bs-sweep",
+		"trainingDatasetId": td.ID,
+		"fields":            "fieldName",
+		"method":            models.SweepGrid,
+		"samples":           3,
+		"seed":              0,
+		"metric":            "val_loss",
+		"order":             "min",
+		"configuration":     map[string]any{"fieldName": 512},
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/sweeps", bytes.NewReader(body))
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "/sweeps/1", rr.Header().Get("HX-Redirect"))
+
+	sweep, err := ut.Sweep.GetByID(1)
+	assert.NoError(t, err)
+	assert.Len(t, sweep.TrainingTasks, 3)
+	for i, tt := range sweep.TrainingTasks {
+		assert.Equal(t, fmt.Sprintf("bs-sweep-%d", i+1), tt.Name)
+		assert.Equal(t, models.Queued, tt.Status)
+		assert.Equal(t, sweep.ID, *tt.SweepId)
+	}
+	assert.Equal(t, 128.0, sweep.TrainingTasks[0].Configuration.(map[string]any)["fieldName"])
+	assert.Equal(t, 1024.0, sweep.TrainingTasks[2].Configuration.(map[string]any)["fieldName"])
+
+	for i, value := range []float64{0.4, 0.1} {
+		assert.NoError(t, ut.TrainingTaskMetric.Create([]models.TrainingTaskMetric{
+			{TrainingTaskId: sweep.TrainingTasks[i].ID, Step: 1, Name: "val_loss", Value: value},
+		}))
+	}
+
+	for order, expected := range map[string][]string{
+		"min": {"bs-sweep-2", "bs-sweep-1", "bs-sweep-3"},
+		"max": {"bs-sweep-1", "bs-sweep-2", "bs-sweep-3"},
+	} {
+		req, err = http.NewRequest("GET", "/sweeps/1?metric=val_loss&order="+order, nil)
+		assert.NoError(t, err)
+		rr = addSessionCookie(t, ut.Auth, req, user.ID)
+
+		ut.Router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		page := rr.Body.String()
+		first := strings.Index(page, expected[0]+"<")
+		second := strings.Index(page, expected[1]+"<")
+		third := strings.Index(page, expected[2]+"<")
+		assert.True(t, first >= 0 && first < second && second < third, "order %s", order)
+	}
+}
+
+func TestSweepHandler_Create_TooManyTasks(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	td := models.TrainingDataset{Name: "Unique Dataset Name", AODFiles: []jalien.AODFile{}, UserId: user.ID}
+	assert.NoError(t, ut.TrainingDataset.Create(&td))
+
+	body, err := json.Marshal(map[string]any{
+		"name":              "bs-sweep",
+		"trainingDatasetId": td.ID,
+		"fields":            []string{"fieldName"},
+		"method":            models.SweepRandom,
+		"samples":           1000,
+		"seed":              1,
+		"metric":            "val_loss",
+		"configuration":     map[string]any{},
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/sweeps", bytes.NewReader(body))
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Samples sweep would create more than 100 tasks")
+}
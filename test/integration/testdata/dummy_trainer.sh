@@ -0,0 +1,11 @@
+#!/bin/sh
+# Dummy trainer for the reference agent, it checks the files prepared for the
+# task and writes the ONNX result expected by the test architecture.
+set -e
+
+test -s "$TRAINT_CONFIG"
+test -f "$TRAINT_AOD_LIST"
+
+echo "task $TRAINT_TASK_ID"
+echo "aod files: $(wc -l < "$TRAINT_AOD_LIST" | tr -d ' ')"
+printf 'onnx-model' > local_file.onnx
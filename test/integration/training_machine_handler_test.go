@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -156,6 +157,159 @@ func TestTrainingMachineHandler_Delete(t *testing.T) {
 	assert.Nil(t, deletedMachine)
 }
 
+func TestTrainingMachineHandler_UpdateState(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	owner := &models.User{CernPersonId: "12345", Username: "user1", Email: "1@gmail.com"}
+	assert.NoError(t, ut.User.Create(owner))
+	other := &models.User{CernPersonId: "54321", Username: "user2", Email: "2@gmail.com"}
+	assert.NoError(t, ut.User.Create(other))
+
+	trainingMachine := &models.TrainingMachine{
+		Name:            "Machine 1",
+		UserId:          owner.ID,
+		SecretKeyHashed: "secret",
+	}
+	assert.NoError(t, ut.TrainingMachine.Create(trainingMachine))
+
+	for _, tc := range []struct {
+		user     *models.User
+		state    string
+		code     int
+		expected models.TrainingMachineState
+	}{
+		{other, "disabled", http.StatusNotFound, models.MachineActive},
+		{owner, "paused", http.StatusUnprocessableEntity, models.MachineActive},
+		{owner, "disabled", http.StatusOK, models.MachineDisabled},
+	} {
+		req, err := http.NewRequest("POST", fmt.Sprintf("/training-machines/%d/state", trainingMachine.ID), strings.NewReader(fmt.Sprintf(`{"state":%q}`, tc.state)))
+		assert.NoError(t, err)
+		HTMXReq(req)
+		rr := addSessionCookie(t, ut.Auth, req, tc.user.ID)
+
+		ut.Router.ServeHTTP(rr, req)
+
+		assert.Equal(t, tc.code, rr.Code)
+		tm, err := ut.TrainingMachine.GetByID(trainingMachine.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, tm.State)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-machines/%d", trainingMachine.ID), nil)
+	assert.NoError(t, err)
+	rr := addSessionCookie(t, ut.Auth, req, owner.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "None, the machine is idle")
+	assert.Contains(t, rr.Body.String(), "Activate")
+}
+
+func TestTrainingMachineHandler_RotateSecret(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	trainingMachine := &models.TrainingMachine{
+		Name:            "Machine 1",
+		UserId:          user.ID,
+		SecretKeyHashed: "hashed_old",
+	}
+	assert.NoError(t, ut.TrainingMachine.Create(trainingMachine))
+	ut.Hasher.On("GenerateKey").Return("new_secret", nil)
+	ut.Hasher.On("HashKey", "new_secret").Return("hashed_new", nil)
+
+	showMachine := func() string {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/training-machines/%d", trainingMachine.ID), nil)
+		assert.NoError(t, err)
+		rr := addSessionCookie(t, ut.Auth, req, user.ID)
+		ut.Router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+	assert.NotContains(t, showMachine(), "Previous secret key")
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/training-machines/%d/secret", trainingMachine.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "new_secret")
+	assert.Contains(t, rr.Body.String(), "The previous SecretKey stays valid until")
+
+	tm, err := ut.TrainingMachine.GetByID(trainingMachine.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "hashed_new", tm.SecretKeyHashed)
+	assert.Equal(t, "hashed_old", tm.PreviousSecretKeyHashed)
+	assert.Contains(t, showMachine(), "Previous secret key")
+
+	req, err = http.NewRequest("DELETE", fmt.Sprintf("/training-machines/%d/secret/previous", trainingMachine.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr = addSessionCookie(t, ut.Auth, req, user.ID)
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	tm, err = ut.TrainingMachine.GetByID(trainingMachine.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, tm.PreviousSecretKeyHashed)
+	assert.NotContains(t, showMachine(), "Previous secret key")
+}
+
+func TestTrainingMachineHandler_UpdateCertificate(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	trainingMachine := &models.TrainingMachine{
+		Name:            "Machine 1",
+		UserId:          user.ID,
+		SecretKeyHashed: "secret",
+	}
+	assert.NoError(t, ut.TrainingMachine.Create(trainingMachine))
+
+	for _, tc := range []struct {
+		subject  string
+		code     int
+		expected string
+	}{
+		{"CN=node1.cern.ch,OU=computers", http.StatusUnprocessableEntity, ""},
+		{" /DC=ch/DC=cern/OU=computers/CN=node1.cern.ch ", http.StatusOK, "/DC=ch/DC=cern/OU=computers/CN=node1.cern.ch"},
+	} {
+		body, err := json.Marshal(map[string]string{"subject": tc.subject})
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", fmt.Sprintf("/training-machines/%d/certificate", trainingMachine.ID), bytes.NewReader(body))
+		assert.NoError(t, err)
+		HTMXReq(req)
+		rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+		ut.Router.ServeHTTP(rr, req)
+
+		assert.Equal(t, tc.code, rr.Code)
+		tm, err := ut.TrainingMachine.GetByID(trainingMachine.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, tm.CertificateSubject)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-machines/%d", trainingMachine.ID), nil)
+	assert.NoError(t, err)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "the machine authenticates with its certificate")
+	assert.NotContains(t, rr.Body.String(), "Rotate")
+}
+
 func TestTrainingMachineHandler_Index_Unauthorized(t *testing.T) {
 	testUnauthorized(t, "GET", "/training-machines", nil)
 }
@@ -6,11 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/mytkom/AliceTraINT/internal/ccdb"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
 	"github.com/mytkom/AliceTraINT/internal/jalien"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -96,6 +98,47 @@ func TestTrainingTaskHandler_List(t *testing.T) {
 	assert.Contains(t, responseBody, trainingTask2.Name)
 }
 
+func TestTrainingTaskHandler_List_FilterByFailureCode(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, _ := setupTestUserAndTask(t, ut)
+	tt.Status = models.Failed
+	tt.Failure = models.TaskFailure{Code: "oom", Message: "CUDA out of memory"}
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	otherTask := &models.TrainingTask{
+		Name:              "Other failed task",
+		UserId:            tt.UserId,
+		TrainingDatasetId: tt.TrainingDatasetId,
+		Status:            models.Failed,
+		Failure:           models.TaskFailure{Code: "disk_full"},
+		Configuration:     "",
+	}
+	assert.NoError(t, ut.TrainingTask.Create(otherTask))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-tasks/list?status=%d&failureCode=oom", models.Failed), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), tt.Name)
+	assert.NotContains(t, rr.Body.String(), otherTask.Name)
+
+	req, err = http.NewRequest("GET", "/training-tasks", nil)
+	assert.NoError(t, err)
+	rr = addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<option value="disk_full">`)
+	assert.Contains(t, rr.Body.String(), `<option value="oom">`)
+}
+
 func TestTrainingTaskHandler_Show(t *testing.T) {
 	ut, cleanup := setupIntegrationTest(t)
 	defer cleanup()
@@ -147,6 +190,96 @@ func TestTrainingTaskHandler_Show(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), trainingTask.Name)
 }
 
+func TestTrainingTaskHandler_Show_Timeline(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, tm := setupTestUserAndTask(t, ut)
+	queued := models.Queued
+	assert.NoError(t, ut.TrainingTaskStatusEvent.Create(&models.TrainingTaskStatusEvent{
+		TrainingTaskId: tt.ID,
+		ToStatus:       models.Queued,
+		Source:         models.StatusSourceUser,
+		UserId:         &tt.UserId,
+	}))
+	assert.NoError(t, ut.TrainingTaskStatusEvent.Create(&models.TrainingTaskStatusEvent{
+		TrainingTaskId:    tt.ID,
+		FromStatus:        &queued,
+		ToStatus:          models.Training,
+		Source:            models.StatusSourceMachine,
+		TrainingMachineId: &tm.ID,
+	}))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-tasks/%d", tt.ID), nil)
+	assert.NoError(t, err)
+	rr := addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Status Timeline")
+	assert.Contains(t, rr.Body.String(), "Queued &rarr; Training")
+	assert.Contains(t, rr.Body.String(), tm.Name)
+}
+
+func TestTrainingTaskHandler_Show_FileChecksum(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, _ := setupTestUserAndTask(t, ut)
+	tt.Status = models.Completed
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+	checksum := "0123456789ab" + strings.Repeat("f", 52)
+	assert.NoError(t, ut.TrainingTaskResult.Create(&models.TrainingTaskResult{
+		Name:           "local_file.onnx",
+		Type:           models.Onnx,
+		TrainingTaskId: tt.ID,
+		File:           models.File{Name: "model.onnx", Path: "/data/model.onnx", Size: 10, SHA256: checksum},
+	}))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-tasks/%d", tt.ID), nil)
+	assert.NoError(t, err)
+	rr := addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf(`title="SHA-256: %s">sha256:0123456789ab<`, checksum))
+}
+
+func TestTrainingTaskHandler_Stats(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	req, err := http.NewRequest("GET", "/training-tasks/stats?days=7", nil)
+	assert.NoError(t, err)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Time in state since")
+	assert.Contains(t, rr.Body.String(), "Benchmarking")
+}
+
+func TestTrainingTaskHandler_Stats_InvalidDays(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	req, err := http.NewRequest("GET", "/training-tasks/stats?days=1000", nil)
+	assert.NoError(t, err)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+}
+
 func TestTrainingTaskHandler_New(t *testing.T) {
 	ut, cleanup := setupIntegrationTest(t)
 	defer cleanup()
@@ -245,12 +378,60 @@ func TestTrainingTaskHandler_Create_Success(t *testing.T) {
 	responseBody := rr.Body.String()
 	assert.Empty(t, responseBody)
 
-	tts, err := ut.TrainingTask.GetAll()
+	tts, err := ut.TrainingTask.GetAll(repository.TrainingTaskFilter{})
 	assert.NoError(t, err)
 	assert.Len(t, tts, 1)
 	assert.Equal(t, models.Queued, tts[0].Status)
 }
 
+func TestTrainingTaskHandler_Create_Pinned(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	td := models.TrainingDataset{Name: "Unique Dataset Name", AODFiles: []jalien.AODFile{}, UserId: user.ID}
+	assert.NoError(t, ut.TrainingDataset.Create(&td))
+
+	tm := models.TrainingMachine{Name: "Debug Machine", UserId: user.ID}
+	assert.NoError(t, ut.TrainingMachine.Create(&tm))
+
+	// payload as sent by the form: select value is a number, pool is a plain string
+	for name, pinned := range map[string]any{"Pinned": tm.ID, "NotPinned": ""} {
+		body, err := json.Marshal(map[string]any{
+			"name":                    name,
+			"trainingDatasetId":       td.ID,
+			"pinnedTrainingMachineId": pinned,
+			"machinePool":             "debug",
+			"requirements":            "",
+			"configuration":           map[string]any{},
+		})
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/training-tasks", bytes.NewReader(body))
+		assert.NoError(t, err)
+		HTMXReq(req)
+		rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+		ut.Router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+	}
+
+	tts, err := ut.TrainingTask.GetAll(repository.TrainingTaskFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, tts, 2)
+	for _, tt := range tts {
+		assert.Equal(t, "debug", tt.MachinePool)
+		if tt.Name == "Pinned" {
+			assert.Equal(t, &tm.ID, tt.PinnedTrainingMachineId)
+		} else {
+			assert.Nil(t, tt.PinnedTrainingMachineId)
+		}
+	}
+}
+
 func prepareUploadToCCDB(t *testing.T, ut *IntegrationTestUtils, user *models.User, withOnnxFile bool) *models.TrainingTask {
 	td := models.TrainingDataset{
 		Name: "Unique Dataset Name",
@@ -427,3 +608,217 @@ func TestTrainingTaskHandler_Create_Unauthorized(t *testing.T) {
 func TestTrainingTaskHandler_UploadToCCDB_Unauthorized(t *testing.T) {
 	testUnauthorized(t, "POST", "/training-tasks/1/upload-to-ccdb", nil)
 }
+
+func TestTrainingTaskHandler_Cancel_Queued(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, _ := setupTestUserAndTask(t, ut)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/training-tasks/%d/cancel", tt.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	cancelledTask, err := ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cancelled, cancelledTask.Status)
+}
+
+func TestTrainingTaskHandler_Cancel_NotOwner(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, _ := setupTestUserAndTask(t, ut)
+	otherUser := &models.User{CernPersonId: "123456", Username: "user12", Email: "2@gmail.com"}
+	assert.NoError(t, ut.User.Create(otherUser))
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/training-tasks/%d/cancel", tt.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, otherUser.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	task, err := ut.TrainingTask.GetByID(tt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Queued, task.Status)
+}
+
+func TestTrainingTaskHandler_Cancel_Completed(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, _ := setupTestUserAndTask(t, ut)
+	tt.Status = models.Completed
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/training-tasks/%d/cancel", tt.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "cannot be cancelled in Completed status")
+}
+
+func TestTrainingTaskHandler_Metrics(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, _ := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+	assert.NoError(t, ut.TrainingTaskMetric.Create([]models.TrainingTaskMetric{
+		{TrainingTaskId: tt.ID, Step: 0, Name: "val_loss", Value: 1.0},
+		{TrainingTaskId: tt.ID, Step: 100, Name: "val_loss", Value: 0.25},
+	}))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-tasks/%d/metrics", tt.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "val_loss")
+	assert.Contains(t, rr.Body.String(), `points="0.0,0.0 300.0,100.0"`)
+	assert.Contains(t, rr.Body.String(), `hx-trigger="every 10s"`)
+}
+
+func TestTrainingTaskHandler_Logs(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	_, tt, _ := setupTestUserAndTask(t, ut)
+	tt.Status = models.Training
+	assert.NoError(t, ut.TrainingTask.Update(tt))
+	assert.NoError(t, ut.TrainingTaskLogChunk.Create(&models.TrainingTaskLogChunk{
+		TrainingTaskId: tt.ID, Offset: 0, Size: 8, Data: []byte("epoch 1\n"),
+	}))
+	assert.NoError(t, ut.TrainingTaskLogChunk.Create(&models.TrainingTaskLogChunk{
+		TrainingTaskId: tt.ID, Offset: 8, Size: 15, Data: []byte("<b>epoch 2</b>\n"),
+	}))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-tasks/%d/logs", tt.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "epoch 1\n&lt;b&gt;epoch 2&lt;/b&gt;\n</pre>")
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf("/training-tasks/%d/logs?offset=23", tt.ID))
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("/training-tasks/%d/logs?offset=8", tt.ID), nil)
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr = addSessionCookie(t, ut.Auth, req, tt.UserId)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf(`hx-swap-oob="beforeend:#log-output-%d">&lt;b&gt;epoch 2&lt;/b&gt;`, tt.ID))
+	assert.NotContains(t, rr.Body.String(), "epoch 1")
+}
+
+func TestTrainingTaskHandler_Create_Dependencies(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	td := models.TrainingDataset{Name: "Unique Dataset Name", AODFiles: []jalien.AODFile{}, UserId: user.ID}
+	assert.NoError(t, ut.TrainingDataset.Create(&td))
+
+	parents := []*models.TrainingTask{
+		{Name: "Preprocessing", UserId: user.ID, TrainingDatasetId: td.ID, Status: models.Completed, Configuration: ""},
+		{Name: "Pretraining", UserId: user.ID, TrainingDatasetId: td.ID, Status: models.Queued, Configuration: ""},
+	}
+	for _, parent := range parents {
+		assert.NoError(t, ut.TrainingTask.Create(parent))
+	}
+
+	// a multiple select sends a single value or an array of values
+	for name, dependsOn := range map[string]any{
+		"Single":   fmt.Sprint(parents[0].ID),
+		"Multiple": []any{parents[0].ID, fmt.Sprint(parents[1].ID)},
+	} {
+		body, err := json.Marshal(map[string]any{
+			"name":              name,
+			"trainingDatasetId": td.ID,
+			"dependsOn":         dependsOn,
+			"configuration":     map[string]any{},
+		})
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/training-tasks", bytes.NewReader(body))
+		assert.NoError(t, err)
+		HTMXReq(req)
+		rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+		ut.Router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+	}
+
+	children, err := ut.TrainingTaskDependency.GetChildren(parents[0].ID)
+	assert.NoError(t, err)
+	assert.Len(t, children, 2)
+
+	waiting := children[0]
+	if waiting.Name != "Multiple" {
+		waiting = children[1]
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("/training-tasks/%d", waiting.ID), nil)
+	assert.NoError(t, err)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Task Graph")
+	assert.Contains(t, rr.Body.String(), "Preprocessing (Completed)")
+	assert.Contains(t, rr.Body.String(), "Pretraining (Queued)")
+	assert.Contains(t, rr.Body.String(), "Waiting for parent tasks to complete")
+}
+
+func TestTrainingTaskHandler_Create_MissingDependency(t *testing.T) {
+	ut, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	user := &models.User{CernPersonId: "12345", Username: "user1"}
+	assert.NoError(t, ut.User.Create(user))
+
+	td := models.TrainingDataset{Name: "Unique Dataset Name", AODFiles: []jalien.AODFile{}, UserId: user.ID}
+	assert.NoError(t, ut.TrainingDataset.Create(&td))
+
+	body, err := json.Marshal(map[string]any{
+		"name":              "Orphan",
+		"trainingDatasetId": td.ID,
+		"dependsOn":         []any{"42"},
+		"configuration":     map[string]any{},
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/training-tasks", bytes.NewReader(body))
+	assert.NoError(t, err)
+	HTMXReq(req)
+	rr := addSessionCookie(t, ut.Auth, req, user.ID)
+
+	ut.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	tts, err := ut.TrainingTask.GetAll(repository.TrainingTaskFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, tts)
+}
@@ -0,0 +1,60 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepRepository_GetByID(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sweepRepo := repository.NewSweepRepository(db)
+
+	mock.ExpectQuery(`SELECT (.+) FROM "sweeps" LEFT JOIN "users" (.+) WHERE "sweeps"."id" = (.+)`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "training_dataset_id", "method", "fields", "metric"}).
+			AddRow(1, "bs-sweep", 1, models.SweepGrid, `["bs"]`, "val_loss"))
+	mock.ExpectQuery(`SELECT (.+) FROM "training_datasets" WHERE "training_datasets"."id" = (.+)`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "LHC24f3"))
+	mock.ExpectQuery(`SELECT (.+) FROM "training_tasks" WHERE "training_tasks"."sweep_id" = (.+) ORDER BY "training_tasks"."id" asc`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "sweep_id", "configuration"}).
+			AddRow(1, "bs-sweep-1", 1, `{"bs":128}`).
+			AddRow(2, "bs-sweep-2", 1, `{"bs":1024}`))
+
+	sweep, err := sweepRepo.GetByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "bs-sweep", sweep.Name)
+	assert.Equal(t, []string{"bs"}, sweep.Fields)
+	assert.Equal(t, "LHC24f3", sweep.TrainingDataset.Name)
+	assert.Len(t, sweep.TrainingTasks, 2)
+	assert.Equal(t, "bs-sweep-2", sweep.TrainingTasks[1].Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSweepRepository_GetAllUser(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sweepRepo := repository.NewSweepRepository(db)
+
+	mock.ExpectQuery(`SELECT (.+) FROM "sweeps" LEFT JOIN "users" (.+) WHERE "sweeps"."user_id" = (.+) ORDER BY "sweeps"."created_at" desc`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "user_id", "training_dataset_id"}).
+			AddRow(2, "dropout-sweep", 2, 1))
+	mock.ExpectQuery(`SELECT (.+) FROM "training_datasets" WHERE "training_datasets"."id" = (.+)`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "LHC24f3"))
+
+	sweeps, err := sweepRepo.GetAllUser(2)
+	assert.NoError(t, err)
+	assert.Len(t, sweeps, 1)
+	assert.Equal(t, "dropout-sweep", sweeps[0].Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
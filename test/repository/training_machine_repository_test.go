@@ -25,7 +25,7 @@ func TestTrainingMachineRepository_Create(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectQuery(`INSERT INTO "training_machines" (.+) RETURNING "id"`).
-		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingMachine.Name, trainingMachine.LastActivityAt, trainingMachine.SecretKeyHashed, trainingMachine.UserId).
+		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingMachine.Name, trainingMachine.LastActivityAt, trainingMachine.SecretKeyHashed, trainingMachine.UserId, nil, "", models.MachineActive, nil, nil, "", nil, nil, "", nil, "", "").
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 
@@ -124,10 +124,44 @@ func TestTrainingMachineRepository_Update(t *testing.T) {
 	}
 	mock.ExpectBegin()
 	mock.ExpectQuery(`INSERT INTO "training_machines" (.+) RETURNING "id"`).
-		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingMachine.Name, trainingMachine.LastActivityAt, trainingMachine.SecretKeyHashed, trainingMachine.UserId).
+		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingMachine.Name, trainingMachine.LastActivityAt, trainingMachine.SecretKeyHashed, trainingMachine.UserId, nil, "", models.MachineActive, nil, nil, "", nil, nil, "", nil, "", "").
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 	err := trainingMachineRepo.Update(trainingMachine)
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestTrainingMachineRepository_UpdateColumns(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	trainingMachineRepo := repository.NewTrainingMachineRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "training_machines" SET "secret_key_hashed"=\$1,"updated_at"=\$2 WHERE "id" = \$3 AND "secret_key_hashed" = \$4 AND "training_machines"."deleted_at" IS NULL`).
+		WithArgs("hashed_new", AnyTime(), 1, "hashed_old").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	updated, err := trainingMachineRepo.UpdateColumns(1, map[string]interface{}{"secret_key_hashed": "hashed_old"}, map[string]interface{}{"secret_key_hashed": "hashed_new"})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingMachineRepository_Touch(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	trainingMachineRepo := repository.NewTrainingMachineRepository(db)
+
+	// only the timestamp is written, UpdatedAt is kept
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "training_machines" SET "last_activity_at"=\$1 WHERE "id" = \$2 AND "training_machines"."deleted_at" IS NULL`).
+		WithArgs(AnyTime(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := trainingMachineRepo.Touch(1, map[string]interface{}{"last_activity_at": time.Now()})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,81 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainingMachineTelemetryRepository_Create(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	telemetryRepo := repository.NewTrainingMachineTelemetryRepository(db)
+
+	gpu := 75.0
+	telemetry := &models.TrainingMachineTelemetry{
+		TrainingMachineId: 1,
+		CPUPercent:        40,
+		GPUPercent:        &gpu,
+		MemoryUsedBytes:   8 << 30,
+		MemoryTotalBytes:  32 << 30,
+		DiskFreeBytes:     100 << 30,
+		AgentVersion:      "v1.0.0",
+		DriverVersion:     "550.54.15",
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "training_machine_telemetries" (.+) RETURNING "id"`).
+		WithArgs(AnyTime(), AnyTime(), nil, uint(1), 40.0, gpu, uint64(8<<30), uint64(32<<30), uint64(100<<30), "v1.0.0", "550.54.15").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := telemetryRepo.Create(telemetry)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), telemetry.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingMachineTelemetryRepository_GetSince(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	telemetryRepo := repository.NewTrainingMachineTelemetryRepository(db)
+
+	since := time.Now().Add(-time.Hour)
+	rows := sqlmock.NewRows([]string{"id", "training_machine_id", "cpu_percent", "disk_free_bytes"}).
+		AddRow(1, 1, 10.0, 200).
+		AddRow(2, 1, 90.0, 100)
+	mock.ExpectQuery(`SELECT (.+) FROM "training_machine_telemetries" WHERE \("training_machine_id" = (.+) AND "created_at" > (.+)\) (.+) ORDER BY "created_at" asc, "id" asc`).
+		WithArgs(1, since).
+		WillReturnRows(rows)
+
+	telemetry, err := telemetryRepo.GetSince(1, since)
+	assert.NoError(t, err)
+	assert.Len(t, telemetry, 2)
+	assert.Equal(t, 90.0, telemetry[1].CPUPercent)
+	assert.Nil(t, telemetry[1].GPUPercent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingMachineTelemetryRepository_DeleteBefore(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	telemetryRepo := repository.NewTrainingMachineTelemetryRepository(db)
+
+	before := time.Now().Add(-24 * time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "training_machine_telemetries" WHERE "training_machine_id" = (.+) AND "created_at" < (.+)`).
+		WithArgs(1, before).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	err := telemetryRepo.DeleteBefore(1, before)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,60 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainingTaskAttemptRepository_Create(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	attemptRepo := repository.NewTrainingTaskAttemptRepository(db)
+
+	tmId := uint(2)
+	finishedAt := time.Now()
+	attempt := &models.TrainingTaskAttempt{
+		TrainingTaskId:    1,
+		Retry:             0,
+		TrainingMachineId: &tmId,
+		FinishedAt:        &finishedAt,
+		Failure:           models.TaskFailure{Code: "oom", Message: "killed"},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "training_task_attempts" (.+) RETURNING "id"`).
+		WithArgs(AnyTime(), AnyTime(), AnyTime(), attempt.TrainingTaskId, attempt.Retry, tmId, nil, AnyTime(), "oom", "killed", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := attemptRepo.Create(attempt)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskAttemptRepository_GetAll(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	attemptRepo := repository.NewTrainingTaskAttemptRepository(db)
+
+	rows := sqlmock.NewRows([]string{"id", "training_task_id", "retry", "failure_code", "TrainingMachine__id", "TrainingMachine__name"}).
+		AddRow(1, 1, 0, "oom", 2, "tm1").
+		AddRow(2, 1, 1, "lease_expired", nil, nil)
+	mock.ExpectQuery(`SELECT (.+) FROM "training_task_attempts" LEFT JOIN "training_machines" "TrainingMachine" (.+) WHERE "training_task_attempts"."training_task_id" = (.+) ORDER BY "training_task_attempts"."retry" asc`).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	attempts, err := attemptRepo.GetAll(1)
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, "oom", attempts[0].Failure.Code)
+	assert.Equal(t, "tm1", attempts[0].TrainingMachine.Name)
+	assert.Equal(t, uint(1), attempts[1].Retry)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
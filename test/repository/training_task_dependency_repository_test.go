@@ -0,0 +1,50 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainingTaskDependencyRepository_GetParents(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	dependencyRepo := repository.NewTrainingTaskDependencyRepository(db)
+
+	rows := sqlmock.NewRows([]string{"id", "name", "status"}).
+		AddRow(1, "preprocessing", models.Completed).
+		AddRow(2, "pretraining", models.Training)
+	mock.ExpectQuery(`SELECT "training_tasks"."id",(.+) FROM "training_tasks" JOIN "training_task_dependencies" ON "training_task_dependencies"."parent_id" = "training_tasks"."id" (.+) WHERE "training_task_dependencies"."training_task_id" = (.+) ORDER BY "training_tasks"."created_at" asc`).
+		WithArgs(3).
+		WillReturnRows(rows)
+
+	parents, err := dependencyRepo.GetParents(3)
+	assert.NoError(t, err)
+	assert.Len(t, parents, 2)
+	assert.Equal(t, "preprocessing", parents[0].Name)
+	assert.Equal(t, models.Training, parents[1].Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskDependencyRepository_GetChildren(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	dependencyRepo := repository.NewTrainingTaskDependencyRepository(db)
+
+	rows := sqlmock.NewRows([]string{"id", "name", "status"}).
+		AddRow(3, "finetuning", models.Queued)
+	mock.ExpectQuery(`SELECT "training_tasks"."id",(.+) FROM "training_tasks" JOIN "training_task_dependencies" ON "training_task_dependencies"."training_task_id" = "training_tasks"."id" (.+) WHERE "training_task_dependencies"."parent_id" = (.+) ORDER BY "training_tasks"."created_at" asc`).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	children, err := dependencyRepo.GetChildren(1)
+	assert.NoError(t, err)
+	assert.Len(t, children, 1)
+	assert.Equal(t, "finetuning", children[0].Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
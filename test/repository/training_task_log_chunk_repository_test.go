@@ -0,0 +1,98 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainingTaskLogChunkRepository_Create(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logChunkRepo := repository.NewTrainingTaskLogChunkRepository(db)
+
+	chunk := &models.TrainingTaskLogChunk{
+		TrainingTaskId: 1,
+		Retry:          0,
+		Offset:         6,
+		Size:           6,
+		Data:           []byte("world\n"),
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "training_task_log_chunks" (.+) RETURNING "id"`).
+		WithArgs(AnyTime(), AnyTime(), nil, chunk.TrainingTaskId, chunk.Retry, chunk.Offset, chunk.Size, chunk.Data).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := logChunkRepo.Create(chunk)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskLogChunkRepository_CreateIfAbsent(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logChunkRepo := repository.NewTrainingTaskLogChunkRepository(db)
+
+	chunk := &models.TrainingTaskLogChunk{
+		TrainingTaskId: 1,
+		Retry:          0,
+		Offset:         6,
+		Size:           6,
+		Data:           []byte("world\n"),
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "training_task_log_chunks" (.+) ON CONFLICT DO NOTHING RETURNING "id"`).
+		WithArgs(AnyTime(), AnyTime(), nil, chunk.TrainingTaskId, chunk.Retry, chunk.Offset, chunk.Size, chunk.Data).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	created, err := logChunkRepo.CreateIfAbsent(chunk)
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskLogChunkRepository_GetSize(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logChunkRepo := repository.NewTrainingTaskLogChunkRepository(db)
+
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\("offset" \+ "size"\), 0\) FROM "training_task_log_chunks" WHERE \("training_task_id" = (.+) AND "retry" = (.+)\)`).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(12))
+
+	size, err := logChunkRepo.GetSize(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskLogChunkRepository_GetFrom(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logChunkRepo := repository.NewTrainingTaskLogChunkRepository(db)
+
+	rows := sqlmock.NewRows([]string{"id", "training_task_id", "retry", "offset", "size", "data"}).
+		AddRow(1, 1, 0, 0, 6, []byte("hello\n")).
+		AddRow(2, 1, 0, 6, 6, []byte("world\n"))
+	mock.ExpectQuery(`SELECT (.+) FROM "training_task_log_chunks" WHERE \("training_task_id" = (.+) AND "retry" = (.+) AND "offset" \+ "size" > (.+)\) (.+) ORDER BY "offset" asc LIMIT (.+)`).
+		WithArgs(1, 0, 3, 100).
+		WillReturnRows(rows)
+
+	chunks, err := logChunkRepo.GetFrom(1, 0, 3, 100)
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 2)
+	assert.Equal(t, int64(6), chunks[1].Offset)
+	assert.Equal(t, []byte("world\n"), chunks[1].Data)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
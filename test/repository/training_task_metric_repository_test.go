@@ -0,0 +1,57 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainingTaskMetricRepository_Create(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	metricRepo := repository.NewTrainingTaskMetricRepository(db)
+
+	epoch := uint(1)
+	metrics := []models.TrainingTaskMetric{
+		{TrainingTaskId: 1, Retry: 0, Step: 10, Epoch: &epoch, Name: "loss", Value: 0.5},
+		{TrainingTaskId: 1, Retry: 0, Step: 10, Epoch: &epoch, Name: "val_loss", Value: 0.6},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "training_task_metrics" (.+) RETURNING "id"`).
+		WithArgs(
+			AnyTime(), AnyTime(), nil, uint(1), uint(0), uint(10), epoch, "loss", 0.5,
+			AnyTime(), AnyTime(), nil, uint(1), uint(0), uint(10), epoch, "val_loss", 0.6,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+
+	err := metricRepo.Create(metrics)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskMetricRepository_GetAll(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	metricRepo := repository.NewTrainingTaskMetricRepository(db)
+
+	rows := sqlmock.NewRows([]string{"id", "training_task_id", "retry", "step", "name", "value"}).
+		AddRow(1, 1, 2, 10, "loss", 0.5).
+		AddRow(2, 1, 2, 20, "loss", 0.4)
+	mock.ExpectQuery(`SELECT (.+) FROM "training_task_metrics" WHERE \("training_task_id" = (.+) AND "retry" = (.+)\) (.+) ORDER BY "step" asc, "id" asc`).
+		WithArgs(1, 2).
+		WillReturnRows(rows)
+
+	metrics, err := metricRepo.GetAll(1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, uint(20), metrics[1].Step)
+	assert.Equal(t, 0.4, metrics[1].Value)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
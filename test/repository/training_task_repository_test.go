@@ -3,6 +3,7 @@ package repository_test
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
@@ -51,7 +52,7 @@ func TestTrainingTaskRepository_Create(t *testing.T) {
 		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingDataset.Name, marshalAODFiles(t, trainingDataset), 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectQuery(`INSERT INTO "training_tasks" (.+) RETURNING "id"`).
-		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingTask.Name, trainingTask.Status, 1, 1, nil, marshalTrainingTaskConfig(t, trainingTask)).
+		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingTask.Name, trainingTask.Status, 1, 1, nil, marshalTrainingTaskConfig(t, trainingTask), nil, 0, 0, nil, nil, nil, nil, "", "", "", "", 0, 0, 0, 0, nil, nil).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 
@@ -111,7 +112,7 @@ func TestTrainingTaskRepository_GetAll(t *testing.T) {
 	mock.ExpectQuery("SELECT (.*) FROM \"training_datasets\"").
 		WillReturnRows(datasetRows)
 
-	trainingTasks, err := trainingTaskRepo.GetAll()
+	trainingTasks, err := trainingTaskRepo.GetAll(repository.TrainingTaskFilter{})
 	assert.NoError(t, err)
 	assert.Len(t, trainingTasks, 2)
 	assert.Equal(t, trainingTasks[0].Name, "LHC24b1b undersampling")
@@ -119,6 +120,41 @@ func TestTrainingTaskRepository_GetAll(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestTrainingTaskRepository_GetAll_Filtered(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	trainingTaskRepo := repository.NewTrainingTaskRepository(db)
+	failed := models.Failed
+
+	taskRows := sqlmock.NewRows([]string{"id", "name", "status", "failure_code"}).
+		AddRow(1, "LHC24b1b", models.Failed, "oom")
+	mock.ExpectQuery(`SELECT (.*) FROM "training_tasks" LEFT JOIN "users" (.*) WHERE "training_tasks"."status" = \$1 AND "training_tasks"."failure_code" = \$2 (.*) ORDER BY "training_tasks"."created_at" desc`).
+		WithArgs(failed, "oom").
+		WillReturnRows(taskRows)
+
+	trainingTasks, err := trainingTaskRepo.GetAll(repository.TrainingTaskFilter{Status: &failed, FailureCode: "oom"})
+	assert.NoError(t, err)
+	assert.Len(t, trainingTasks, 1)
+	assert.Equal(t, "oom", trainingTasks[0].Failure.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskRepository_GetFailureCodes(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	trainingTaskRepo := repository.NewTrainingTaskRepository(db)
+
+	mock.ExpectQuery(`SELECT DISTINCT "failure_code" FROM "training_tasks" WHERE "failure_code" <> '' (.*) ORDER BY "failure_code"`).
+		WillReturnRows(sqlmock.NewRows([]string{"failure_code"}).AddRow("lease_expired").AddRow("oom"))
+
+	codes, err := trainingTaskRepo.GetFailureCodes()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lease_expired", "oom"}, codes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestTrainingTaskRepository_GetById(t *testing.T) {
 	db, mock, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -172,10 +208,116 @@ func TestTrainingTaskRepository_Update(t *testing.T) {
 	}
 	mock.ExpectBegin()
 	mock.ExpectQuery(`INSERT INTO "training_tasks" (.+) RETURNING "id"`).
-		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingTask.Name, trainingTask.Status, 1, 1, nil, marshalTrainingTaskConfig(t, trainingTask)).
+		WithArgs(AnyTime(), AnyTime(), AnyTime(), trainingTask.Name, trainingTask.Status, 1, 1, nil, marshalTrainingTaskConfig(t, trainingTask), nil, 0, 0, nil, nil, nil, nil, "", "", "", "", 0, 0, 0, 0, nil, nil).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 	err := trainingTaskRepo.Update(trainingTask)
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestTrainingTaskRepository_GetQueued(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	trainingTaskRepo := repository.NewTrainingTaskRepository(db)
+	now := time.Now()
+
+	mock.ExpectQuery(`SELECT \* FROM "training_tasks" WHERE "status" = \$1 AND NOT EXISTS (.+) AND \("pinned_training_machine_id" IS NULL OR "pinned_training_machine_id" = \$4\) AND \("retry_at" IS NULL OR "retry_at" <= \$5\) AND "training_tasks"."deleted_at" IS NULL ORDER BY "training_tasks"."priority" desc,"training_tasks"."created_at" asc,"training_tasks"."id" asc LIMIT \$6 OFFSET \$7`).
+		WithArgs(models.Queued, models.Completed, models.Uploaded, 2, now, 10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	tasks, err := trainingTaskRepo.GetQueued(repository.QueuedFilter{TrainingMachineId: 2, Now: now, ByPriority: true, Limit: 10, Offset: 20})
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskRepository_UpdateStatus(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	trainingTaskRepo := repository.NewTrainingTaskRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "training_tasks" SET "status"=\$1,"updated_at"=\$2 WHERE \("id" = \$3 AND "status" = \$4\) AND "training_tasks"."deleted_at" IS NULL`).
+		WithArgs(models.Cancelled, AnyTime(), 1, models.Training).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	updated, err := trainingTaskRepo.UpdateStatus(1, models.Training, map[string]interface{}{"status": models.Cancelled})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskRepository_UpdateExpired(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	trainingTaskRepo := repository.NewTrainingTaskRepository(db)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "training_tasks" SET "status"=\$1,"updated_at"=\$2 WHERE \("id" = \$3 AND "status" = \$4\) AND \("lease_expires_at" < \$5 OR "lease_expires_at" IS NULL\) AND "training_tasks"."deleted_at" IS NULL`).
+		WithArgs(models.Queued, AnyTime(), 1, models.Training, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	updated, err := trainingTaskRepo.UpdateExpired(1, models.Training, now, map[string]interface{}{"status": models.Queued})
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskRepository_Claim(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	trainingTaskRepo := repository.NewTrainingTaskRepository(db)
+	leaseExpiresAt := time.Now().Add(time.Minute)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "id" FROM "training_tasks" WHERE \("id" = \$1 AND "status" = \$2\) (.*) FOR UPDATE SKIP LOCKED`).
+		WithArgs(1, models.Queued).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "training_tasks" SET (.+) WHERE \("id" = \$(.+) AND "status" = \$(.+)\) AND \(EXISTS \(SELECT 1 FROM "training_machines" WHERE "training_machines"."id" = \$8 AND "training_machines"."state" = \$9 (.+)\)\)`).
+		WithArgs(AnyTime(), leaseExpiresAt, models.Training, 2, AnyTime(), 1, models.Queued, 2, models.MachineActive).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	claimed, err := trainingTaskRepo.Claim(1, 2, leaseExpiresAt)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskRepository_Claim_AlreadyLocked(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	trainingTaskRepo := repository.NewTrainingTaskRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "id" FROM "training_tasks" (.*) FOR UPDATE SKIP LOCKED`).
+		WithArgs(1, models.Queued).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	claimed, err := trainingTaskRepo.Claim(1, 2, time.Now())
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskRepository_GetRunning(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	trainingTaskRepo := repository.NewTrainingTaskRepository(db)
+
+	mock.ExpectQuery(`SELECT \* FROM "training_tasks" WHERE "status" IN \(\$1,\$2\) (.*)`).
+		WithArgs(models.Training, models.Benchmarking).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, models.Training).AddRow(2, models.Benchmarking))
+
+	tasks, err := trainingTaskRepo.GetRunning()
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, models.Benchmarking, tasks[1].Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
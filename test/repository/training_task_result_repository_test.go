@@ -25,7 +25,7 @@ func TestTrainingTaskResultRepository_Create(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectQuery(`INSERT INTO "training_task_results" (.+) RETURNING "id"`).
-		WithArgs(AnyTime(), AnyTime(), AnyTime(), ttr.Name, ttr.Type, ttr.Description, ttr.FileId, ttr.TrainingTaskId).
+		WithArgs(AnyTime(), AnyTime(), AnyTime(), ttr.Name, ttr.Type, ttr.Description, ttr.FileId, ttr.TrainingTaskId, ttr.Retry).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 
@@ -0,0 +1,81 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainingTaskStatusEventRepository_Create(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	statusEventRepo := repository.NewTrainingTaskStatusEventRepository(db)
+
+	from := models.Queued
+	tmId := uint(2)
+	event := &models.TrainingTaskStatusEvent{
+		TrainingTaskId:    1,
+		FromStatus:        &from,
+		ToStatus:          models.Training,
+		Source:            models.StatusSourceMachine,
+		TrainingMachineId: &tmId,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "training_task_status_events" (.+) RETURNING "id"`).
+		WithArgs(AnyTime(), AnyTime(), AnyTime(), event.TrainingTaskId, int64(models.Queued), int64(models.Training), event.Source, nil, tmId).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := statusEventRepo.Create(event)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskStatusEventRepository_GetAll(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	statusEventRepo := repository.NewTrainingTaskStatusEventRepository(db)
+
+	rows := sqlmock.NewRows([]string{"id", "training_task_id", "from_status", "to_status", "source", "User__id", "User__username"}).
+		AddRow(1, 1, nil, int64(models.Queued), models.StatusSourceUser, 1, "user1").
+		AddRow(2, 1, int64(models.Queued), int64(models.Training), models.StatusSourceMachine, nil, nil)
+	mock.ExpectQuery(`SELECT (.+) FROM "training_task_status_events" LEFT JOIN "users" "User" (.+) LEFT JOIN "training_machines" "TrainingMachine" (.+) WHERE "training_task_status_events"."training_task_id" = (.+) ORDER BY "training_task_status_events"."created_at" asc, "training_task_status_events"."id" asc`).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	events, err := statusEventRepo.GetAll(1)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Nil(t, events[0].FromStatus)
+	assert.Equal(t, "user1", events[0].User.Username)
+	assert.Equal(t, models.Queued, *events[1].FromStatus)
+	assert.Equal(t, models.Training, events[1].ToStatus)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskStatusEventRepository_GetSince(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	statusEventRepo := repository.NewTrainingTaskStatusEventRepository(db)
+	since := time.Now().Add(-24 * time.Hour)
+
+	rows := sqlmock.NewRows([]string{"id", "training_task_id", "from_status", "to_status"}).
+		AddRow(1, 1, nil, int64(models.Queued)).
+		AddRow(2, 1, int64(models.Queued), int64(models.Training))
+	mock.ExpectQuery(`SELECT (.+) FROM "training_task_status_events" WHERE "created_at" >= (.+) ORDER BY "training_task_id" asc, "created_at" asc, "id" asc`).
+		WithArgs(since).
+		WillReturnRows(rows)
+
+	events, err := statusEventRepo.GetSince(since)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,129 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainingTaskUploadRepository_Create(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	uploadRepo := repository.NewTrainingTaskUploadRepository(db)
+
+	upload := &models.TrainingTaskUpload{
+		TrainingTaskId: 1,
+		Name:           "model",
+		Type:           models.Onnx,
+		FileName:       "model.onnx",
+		Size:           1 << 32,
+		PartialPath:    "data/partial/partial-1",
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "training_task_uploads" (.+) RETURNING "id"`).
+		WithArgs(AnyTime(), AnyTime(), nil, upload.TrainingTaskId, upload.Retry, upload.Name, upload.Description, upload.Type, upload.FileName, upload.Size, upload.Received, upload.PartialPath).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := uploadRepo.Create(upload)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskUploadRepository_GetByID(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	uploadRepo := repository.NewTrainingTaskUploadRepository(db)
+
+	rows := sqlmock.NewRows([]string{"id", "training_task_id", "file_name", "size", "received"}).
+		AddRow(1, 1, "model.onnx", 10, 4)
+	mock.ExpectQuery(`SELECT (.+) FROM "training_task_uploads" WHERE "training_task_uploads"."id" = (.+)`).
+		WithArgs(1, 1).
+		WillReturnRows(rows)
+
+	upload, err := uploadRepo.GetByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), upload.Received)
+	assert.False(t, upload.IsComplete())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskUploadRepository_GetStale(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	uploadRepo := repository.NewTrainingTaskUploadRepository(db)
+	before := time.Now().Add(-24 * time.Hour)
+
+	rows := sqlmock.NewRows([]string{"id", "partial_path"}).AddRow(1, "data/partial/partial-1")
+	mock.ExpectQuery(`SELECT \* FROM "training_task_uploads" WHERE "updated_at" < \$1 AND "training_task_uploads"."deleted_at" IS NULL`).
+		WithArgs(before).
+		WillReturnRows(rows)
+
+	uploads, err := uploadRepo.GetStale(before)
+	assert.NoError(t, err)
+	assert.Len(t, uploads, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskUploadRepository_UpdateReceived(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	uploadRepo := repository.NewTrainingTaskUploadRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "training_task_uploads" SET "received"=\$1,"updated_at"=\$2 WHERE \("id" = \$3 AND "received" = \$4\) AND "training_task_uploads"."deleted_at" IS NULL`).
+		WithArgs(60, AnyTime(), 1, 40).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	updated, err := uploadRepo.UpdateReceived(1, 40, 60)
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskUploadRepository_Touch(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	uploadRepo := repository.NewTrainingTaskUploadRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "training_task_uploads" SET "updated_at"=\$1 WHERE "id" = \$2 AND "training_task_uploads"."deleted_at" IS NULL`).
+		WithArgs(AnyTime(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	touched, err := uploadRepo.Touch(1)
+	assert.NoError(t, err)
+	assert.True(t, touched)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrainingTaskUploadRepository_DeleteStale(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	uploadRepo := repository.NewTrainingTaskUploadRepository(db)
+	before := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "training_task_uploads" SET "deleted_at"=\$1 WHERE "updated_at" < \$2 AND "training_task_uploads"."id" = \$3 AND "training_task_uploads"."deleted_at" IS NULL`).
+		WithArgs(AnyTime(), before, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	deleted, err := uploadRepo.DeleteStale(1, before)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
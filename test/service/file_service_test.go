@@ -0,0 +1,116 @@
+package service_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalFileService_PartialFile(t *testing.T) {
+	// Arrange
+	fileService := service.NewLocalFileService(t.TempDir())
+	content := "model weights"
+	digest := sha256.Sum256([]byte(content))
+
+	// Act
+	path, err := fileService.CreatePartialFile()
+	assert.NoError(t, err)
+
+	written, err := fileService.WritePartialFile(path, 0, strings.NewReader("model wei"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), written)
+
+	// resumed from an earlier offset after a dropped connection
+	written, err = fileService.WritePartialFile(path, 6, strings.NewReader("weights"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), written)
+
+	file, err := fileService.FinalizePartialFile(path, "model.onnx", hex.EncodeToString(digest[:]))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "model.onnx", file.Name)
+	assert.Equal(t, uint64(len(content)), file.Size)
+	assert.Equal(t, hex.EncodeToString(digest[:]), file.SHA256)
+	assert.True(t, strings.HasSuffix(file.Path, ".onnx"))
+	stored, err := os.ReadFile(strings.TrimPrefix(file.Path, "/"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(stored))
+	_, err = os.Stat(path)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestLocalFileService_PartialFile_ChecksumMismatch(t *testing.T) {
+	// Arrange
+	fileService := service.NewLocalFileService(t.TempDir())
+	digest := sha256.Sum256([]byte("other content"))
+
+	path, err := fileService.CreatePartialFile()
+	assert.NoError(t, err)
+	_, err = fileService.WritePartialFile(path, 0, strings.NewReader("model weights"))
+	assert.NoError(t, err)
+
+	// Act
+	_, err = fileService.FinalizePartialFile(path, "model.onnx", hex.EncodeToString(digest[:]))
+
+	// Assert
+	assert.ErrorIs(t, err, service.ErrChecksumMismatch)
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+type stringFile struct {
+	*strings.Reader
+}
+
+func (f stringFile) Close() error { return nil }
+
+func TestLocalFileService_SaveFile_Checksum(t *testing.T) {
+	content := "training log"
+	digest := sha256.Sum256([]byte(content))
+	otherDigest := sha256.Sum256([]byte("other log"))
+
+	tests := []struct {
+		name     string
+		expected string
+		err      error
+	}{
+		{"no expected checksum", "", nil},
+		{"matching checksum", strings.ToUpper(hex.EncodeToString(digest[:])), nil},
+		{"checksum mismatch", hex.EncodeToString(otherDigest[:]), service.ErrChecksumMismatch},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			basePath := t.TempDir()
+			fileService := service.NewLocalFileService(basePath)
+			header := &multipart.FileHeader{Filename: "train.log", Size: int64(len(content))}
+
+			// Act
+			file, err := fileService.SaveFile(stringFile{strings.NewReader(content)}, header, tc.expected)
+
+			// Assert
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				// rejected file is not kept
+				stored, _ := filepath.Glob(filepath.Join(basePath, "*", "upload-train-*.log"))
+				assert.Empty(t, stored)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, hex.EncodeToString(digest[:]), file.SHA256)
+			stored, err := os.ReadFile(strings.TrimPrefix(file.Path, "/"))
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(stored))
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequirement(t *testing.T) {
+	req, err := service.ParseRequirement(" gpu_mem_gb >= 24 ")
+	assert.NoError(t, err)
+	assert.Equal(t, &service.Requirement{Label: "gpu_mem_gb", Operator: ">=", Value: "24"}, req)
+
+	_, err = service.ParseRequirement("gpu_mem_gb")
+	assert.Error(t, err)
+
+	_, err = service.ParseRequirement("site>cern")
+	assert.Error(t, err)
+}
+
+func TestRequirement_SatisfiedBy(t *testing.T) {
+	labels := map[string]string{"gpu_mem_gb": "24", "cuda": "12.0", "site": "cern"}
+
+	tests := []struct {
+		expr      string
+		satisfied bool
+	}{
+		{"gpu_mem_gb>=24", true},
+		{"gpu_mem_gb>24", false},
+		{"gpu_mem_gb<=32", true},
+		{"gpu_mem_gb<16", false},
+		{"cuda=12", true},
+		{"cuda!=12", false},
+		{"site=cern", true},
+		{"site!=cern", false},
+		{"site=gsi", false},
+		{"ram_gb>=64", false},
+		{"ram_gb!=64", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			req, err := service.ParseRequirement(tt.expr)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.satisfied, req.SatisfiedBy(labels))
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := service.ParseLabels("gpu_mem_gb=24, cuda=12\nsite = cern")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"gpu_mem_gb": "24", "cuda": "12", "site": "cern"}, labels)
+
+	_, err = service.ParseLabels("gpu_mem_gb")
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/config"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestMachineSessions_Verify(t *testing.T) {
+	// Arrange
+	sessions := service.NewMachineSessions(config.MachineAuthConfig{SessionTTLMinutes: 15, SessionSigningKey: "test"})
+	now := time.Now()
+	session := sessions.Issue(1, "hashed_secret", now)
+	tampered := strings.Replace(session.Token, "1.", "2.", 1)
+	otherKey := service.NewMachineSessions(config.MachineAuthConfig{SessionTTLMinutes: 15, SessionSigningKey: "other"})
+
+	// Act
+	fingerprint, err := sessions.Verify(session.Token, 1, now)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotEmpty(t, fingerprint)
+	assert.NotContains(t, session.Token, "hashed_secret")
+	assert.WithinDuration(t, now.Add(15*time.Minute), session.ExpiresAt, time.Second)
+
+	_, err = sessions.Verify(session.Token, 2, now)
+	assert.Error(t, err, "token of another machine")
+	_, err = sessions.Verify(tampered, 2, now)
+	assert.Error(t, err, "tampered token")
+	_, err = sessions.Verify(session.Token, 1, now.Add(16*time.Minute))
+	assert.Error(t, err, "expired token")
+	_, err = otherKey.Verify(session.Token, 1, now)
+	assert.Error(t, err, "token signed by another key")
+	_, err = sessions.Verify("garbage", 1, now)
+	assert.Error(t, err)
+}
+
+func TestQueueService_MachineSession(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	trainingMachine := &models.TrainingMachine{SecretKeyHashed: "hashed_secret", Model: gorm.Model{ID: tmID}}
+
+	ut.TMRepo.On("GetByID", tmID).Return(trainingMachine, nil)
+	ut.TMRepo.On("Touch", tmID, mock.Anything).Return(nil)
+	ut.Hasher.On("VerifyKey", "secret", "hashed_secret").Return(true, nil)
+
+	// Act
+	session, err := queueService.CreateMachineSession("secret", tmID)
+	assert.NoError(t, err)
+	result, err := queueService.AuthorizeMachineSession(session.Token, tmID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, trainingMachine, result)
+	assert.NotNil(t, result.SecretKeyLastUsedAt)
+	// key derivation runs only when the session is created
+	ut.Hasher.AssertNumberOfCalls(t, "VerifyKey", 1)
+	// activity recorded by the exchange is recent enough
+	ut.TMRepo.AssertNumberOfCalls(t, "Touch", 1)
+}
+
+func TestQueueService_MachineSession_KeyRotated(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	trainingMachine := &models.TrainingMachine{SecretKeyHashed: "hashed_old", Model: gorm.Model{ID: tmID}}
+
+	ut.TMRepo.On("GetByID", tmID).Return(trainingMachine, nil)
+	ut.TMRepo.On("Touch", tmID, mock.Anything).Return(nil)
+	ut.Hasher.On("VerifyKey", "secret", "hashed_old").Return(true, nil)
+
+	session, err := queueService.CreateMachineSession("secret", tmID)
+	assert.NoError(t, err)
+
+	// Act
+	expiresAt := time.Now().Add(time.Hour)
+	trainingMachine.SecretKeyHashed = "hashed_new"
+	trainingMachine.PreviousSecretKeyHashed = "hashed_old"
+	trainingMachine.PreviousSecretKeyExpiresAt = &expiresAt
+	_, errGrace := queueService.AuthorizeMachineSession(session.Token, tmID)
+	trainingMachine.PreviousSecretKeyHashed = ""
+	trainingMachine.PreviousSecretKeyExpiresAt = nil
+	_, errRevoked := queueService.AuthorizeMachineSession(session.Token, tmID)
+
+	// Assert
+	assert.NoError(t, errGrace)
+	assert.NotNil(t, trainingMachine.PreviousSecretKeyLastUsedAt)
+	assert.Error(t, errRevoked)
+}
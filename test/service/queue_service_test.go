@@ -1,9 +1,15 @@
 package service_test
 
 import (
+	"context"
 	"errors"
+	"io"
+	"math"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mytkom/AliceTraINT/internal/config"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
 	"github.com/mytkom/AliceTraINT/internal/db/repository"
 	"github.com/mytkom/AliceTraINT/internal/service"
@@ -16,29 +22,69 @@ type queueServiceTestUtils struct {
 	TTRepo      *repository.MockTrainingTaskRepository
 	TTRRepo     *repository.MockTrainingTaskResultRepository
 	TMRepo      *repository.MockTrainingMachineRepository
+	TMTRepo     *repository.MockTrainingMachineTelemetryRepository
+	TSERepo     *repository.MockTrainingTaskStatusEventRepository
+	TTARepo     *repository.MockTrainingTaskAttemptRepository
+	TTMRepo     *repository.MockTrainingTaskMetricRepository
+	TTLCRepo    *repository.MockTrainingTaskLogChunkRepository
+	TTURepo     *repository.MockTrainingTaskUploadRepository
+	TTDRepo     *repository.MockTrainingTaskDependencyRepository
 	FileService *service.MockFileService
 	Hasher      *service.MockHasher
+	NNArch      *service.NNArchServiceInMemory
 }
 
 func newQueueService() (*service.QueueService, *queueServiceTestUtils) {
 	mockHasher := service.NewMockHasher()
 	mockTaskRepo := repository.NewMockTrainingTaskRepository()
 	mockMachineRepo := repository.NewMockTrainingMachineRepository()
+	mockTelemetryRepo := repository.NewMockTrainingMachineTelemetryRepository()
 	mockTaskResultRepo := repository.NewMockTrainingTaskResultRepository()
+	mockStatusEventRepo := repository.NewMockTrainingTaskStatusEventRepository()
+	mockStatusEventRepo.On("Create", mock.AnythingOfType("*models.TrainingTaskStatusEvent")).Return(nil)
+	mockAttemptRepo := repository.NewMockTrainingTaskAttemptRepository()
+	mockMetricRepo := repository.NewMockTrainingTaskMetricRepository()
+	mockLogChunkRepo := repository.NewMockTrainingTaskLogChunkRepository()
+	mockUploadRepo := repository.NewMockTrainingTaskUploadRepository()
+	mockDependencyRepo := repository.NewMockTrainingTaskDependencyRepository()
+	mockDependencyRepo.On("GetChildren", mock.Anything).Return([]models.TrainingTask{}, nil)
 	mockFileService := service.NewMockFileService()
+	nnArch := service.NewNNArchServiceInMemory(&service.NNFieldConfigs{}, &service.NNExpectedResults{})
 
 	repoContext := &repository.RepositoryContext{
-		TrainingTask:       mockTaskRepo,
-		TrainingMachine:    mockMachineRepo,
-		TrainingTaskResult: mockTaskResultRepo,
+		TrainingTask:             mockTaskRepo,
+		TrainingMachine:          mockMachineRepo,
+		TrainingMachineTelemetry: mockTelemetryRepo,
+		TrainingTaskResult:       mockTaskResultRepo,
+		TrainingTaskStatusEvent:  mockStatusEventRepo,
+		TrainingTaskAttempt:      mockAttemptRepo,
+		TrainingTaskMetric:       mockMetricRepo,
+		TrainingTaskLogChunk:     mockLogChunkRepo,
+		TrainingTaskUpload:       mockUploadRepo,
+		TrainingTaskDependency:   mockDependencyRepo,
 	}
 
-	return service.NewQueueService(mockFileService, repoContext, mockHasher), &queueServiceTestUtils{
+	queueConfig := config.QueueConfig{
+		LeaseSeconds:           60,
+		MaxLostLeases:          2,
+		LongPollMaxSeconds:     5,
+		LongPollRecheckSeconds: 5,
+	}
+
+	return service.NewQueueService(mockFileService, repoContext, mockHasher, queueConfig, service.NewMachineSessions(config.MachineAuthConfig{SessionTTLMinutes: 15, SessionSigningKey: "test"}), nnArch, service.NewTaskNotifier()), &queueServiceTestUtils{
 		TTRepo:      mockTaskRepo,
 		TTRRepo:     mockTaskResultRepo,
 		TMRepo:      mockMachineRepo,
+		TMTRepo:     mockTelemetryRepo,
+		TSERepo:     mockStatusEventRepo,
+		TTARepo:     mockAttemptRepo,
+		TTMRepo:     mockMetricRepo,
+		TTLCRepo:    mockLogChunkRepo,
+		TTURepo:     mockUploadRepo,
+		TTDRepo:     mockDependencyRepo,
 		FileService: mockFileService,
 		Hasher:      mockHasher,
+		NNArch:      nnArch,
 	}
 }
 
@@ -50,16 +96,267 @@ func TestQueueService_UpdateTrainingTaskStatus_Success(t *testing.T) {
 	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Queued}
 
 	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
-	ut.TTRepo.On("Update", mock.AnythingOfType("*models.TrainingTask")).Return(nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Queued, mock.Anything).Return(true, nil)
 
 	// Act
-	err := queueService.UpdateTrainingTaskStatus(taskID, newStatus)
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, newStatus, nil)
 
 	// Assert
 	assert.NoError(t, err)
 	assert.Equal(t, newStatus, mockTask.Status)
 	ut.TTRepo.AssertCalled(t, "GetByID", taskID)
-	ut.TTRepo.AssertCalled(t, "Update", mockTask)
+	ut.TTRepo.AssertCalled(t, "UpdateStatus", taskID, models.Queued, mock.MatchedBy(func(columns map[string]interface{}) bool {
+		return columns["status"] == newStatus && columns["lease_expires_at"] != nil
+	}))
+	ut.TSERepo.AssertCalled(t, "Create", mock.MatchedBy(func(event *models.TrainingTaskStatusEvent) bool {
+		return event.TrainingTaskId == taskID && *event.FromStatus == models.Queued && event.ToStatus == newStatus
+	}))
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_IllegalTransition(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, models.Uploaded, nil)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	assert.EqualError(t, err, "TrainingTask cannot change status from Training to Uploaded, allowed next statuses: Benchmarking, Failed")
+	assert.Equal(t, models.Training, mockTask.Status)
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+	ut.TSERepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_Failure(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+	longLog := strings.Repeat("x", 70<<10) + "CUDA out of memory"
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Training, mock.Anything).Return(true, nil)
+	ut.TTRepo.On("Update", mockTask).Return(nil)
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, models.Failed, &models.TaskFailure{
+		Code:       " oom ",
+		Message:    "trainer killed",
+		LogExcerpt: longLog,
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Failed, mockTask.Status)
+	assert.Equal(t, "oom", mockTask.Failure.Code)
+	assert.Equal(t, "trainer killed", mockTask.Failure.Message)
+	assert.Len(t, mockTask.Failure.LogExcerpt, 64<<10)
+	assert.True(t, strings.HasSuffix(mockTask.Failure.LogExcerpt, "CUDA out of memory"))
+	assert.NotNil(t, mockTask.FinishedAt)
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_FailureRetried(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	ut.NNArch.RetryPolicy = service.NNRetryPolicy{MaxAttempts: 3, BackoffSeconds: 60}
+	taskID := uint(1)
+	tmID := uint(2)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training, TrainingMachineId: &tmID, Retries: 1}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Training, mock.Anything).Return(true, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Failed, mock.Anything).Return(true, nil)
+	ut.TTARepo.On("Create", mock.AnythingOfType("*models.TrainingTaskAttempt")).Return(nil)
+
+	// Act
+	before := time.Now()
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, models.Failed, &models.TaskFailure{Code: "disk_full"})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Queued, mockTask.Status)
+	assert.Equal(t, uint(2), mockTask.Retries)
+	assert.Nil(t, mockTask.TrainingMachineId)
+	assert.Nil(t, mockTask.FinishedAt)
+	assert.Empty(t, mockTask.Failure.Code)
+	if assert.NotNil(t, mockTask.RetryAt) {
+		assert.WithinDuration(t, before.Add(2*time.Minute), *mockTask.RetryAt, 5*time.Second)
+	}
+	ut.TTARepo.AssertCalled(t, "Create", mock.MatchedBy(func(attempt *models.TrainingTaskAttempt) bool {
+		return attempt.TrainingTaskId == taskID && attempt.Retry == 1 && attempt.Failure.Code == "disk_full" &&
+			*attempt.TrainingMachineId == tmID && attempt.FinishedAt != nil
+	}))
+	ut.TSERepo.AssertCalled(t, "Create", mock.MatchedBy(func(event *models.TrainingTaskStatusEvent) bool {
+		return *event.FromStatus == models.Training && event.ToStatus == models.Failed && event.Source == models.StatusSourceMachine
+	}))
+	ut.TSERepo.AssertCalled(t, "Create", mock.MatchedBy(func(event *models.TrainingTaskStatusEvent) bool {
+		return *event.FromStatus == models.Failed && event.ToStatus == models.Queued && event.Source == models.StatusSourceQueue
+	}))
+	ut.TTRepo.AssertCalled(t, "UpdateStatus", taskID, models.Failed, mock.MatchedBy(func(columns map[string]interface{}) bool {
+		return columns["status"] == models.Queued && columns["retries"] == uint(2) && columns["training_machine_id"] == nil
+	}))
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_FailureRetryLostRace(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	ut.NNArch.RetryPolicy = service.NNRetryPolicy{MaxAttempts: 3}
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Training, mock.Anything).Return(true, nil)
+	// the failed task was changed before it was requeued
+	ut.TTRepo.On("UpdateStatus", taskID, models.Failed, mock.Anything).Return(false, nil)
+
+	// Act
+	tt, err := queueService.UpdateTrainingTaskStatus(taskID, models.Failed, &models.TaskFailure{Code: "oom"})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Failed, tt.Status)
+	assert.Zero(t, tt.Retries)
+	ut.TTARepo.AssertNotCalled(t, "Create", mock.Anything)
+	ut.TSERepo.AssertNotCalled(t, "Create", mock.MatchedBy(func(event *models.TrainingTaskStatusEvent) bool {
+		return event.ToStatus == models.Queued
+	}))
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_FailureRetryNotifies(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	ut.NNArch.RetryPolicy = service.NNRetryPolicy{MaxAttempts: 3}
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Training, mock.Anything).Return(true, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Failed, mock.Anything).Return(true, nil)
+	ut.TTARepo.On("Create", mock.AnythingOfType("*models.TrainingTaskAttempt")).Return(nil)
+	notified := queueService.Notifier.Wait()
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, models.Failed, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Error("waiting machines were not notified about the requeued task")
+	}
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_RetriesExhausted(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	ut.NNArch.RetryPolicy = service.NNRetryPolicy{MaxAttempts: 3, BackoffSeconds: 60}
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Benchmarking, MaxAttempts: 2, Retries: 1}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Benchmarking, mock.Anything).Return(true, nil)
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, models.Failed, &models.TaskFailure{Code: "oom"})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Failed, mockTask.Status)
+	assert.Equal(t, uint(1), mockTask.Retries)
+	assert.Equal(t, "oom", mockTask.Failure.Code)
+	assert.Nil(t, mockTask.RetryAt)
+	ut.TTARepo.AssertNotCalled(t, "Create", mock.Anything)
+	ut.TTRepo.AssertCalled(t, "UpdateStatus", taskID, models.Benchmarking, mock.MatchedBy(func(columns map[string]interface{}) bool {
+		return columns["status"] == models.Failed && columns["failure_code"] == "oom"
+	}))
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_FailureWithoutFailedStatus(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(1, models.Completed, &models.TaskFailure{Code: "oom"})
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerValidation{}, err)
+	ut.TTRepo.AssertNotCalled(t, "GetByID", mock.Anything)
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_InvalidFailureCode(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(1, models.Failed, &models.TaskFailure{Code: "disk full!"})
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerValidation{}, err)
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_Repeated(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Benchmarking}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Benchmarking, mock.Anything).Return(true, nil)
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, models.Benchmarking, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotNil(t, mockTask.LeaseExpiresAt)
+	ut.TSERepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_Cancelled(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Cancelled}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+
+	// Act
+	tt, err := queueService.UpdateTrainingTaskStatus(taskID, models.Completed, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cancelled, tt.Status)
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+	ut.TSERepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_CancelledConcurrently(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	running := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+	cancelled := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Cancelled}
+
+	// the task is cancelled between reading and writing its status
+	ut.TTRepo.On("GetByID", taskID).Return(running, nil).Once()
+	ut.TTRepo.On("GetByID", taskID).Return(cancelled, nil).Once()
+	ut.TTRepo.On("UpdateStatus", taskID, models.Training, mock.Anything).Return(false, nil)
+
+	// Act
+	tt, err := queueService.UpdateTrainingTaskStatus(taskID, models.Benchmarking, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cancelled, tt.Status)
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+	ut.TSERepo.AssertNotCalled(t, "Create", mock.Anything)
 }
 
 func TestQueueService_UpdateTrainingTaskStatus_TaskNotFound(t *testing.T) {
@@ -71,7 +368,7 @@ func TestQueueService_UpdateTrainingTaskStatus_TaskNotFound(t *testing.T) {
 	ut.TTRepo.On("GetByID", taskID).Return(nil, errors.New("task not found"))
 
 	// Act
-	err := queueService.UpdateTrainingTaskStatus(taskID, newStatus)
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, newStatus, nil)
 
 	// Assert
 	assert.Error(t, err)
@@ -89,7 +386,7 @@ func TestQueueService_AuthorizeTrainingMachine_Success(t *testing.T) {
 	trainingMachine := &models.TrainingMachine{SecretKeyHashed: hashedSecret, Model: gorm.Model{ID: tmID}}
 
 	ut.TMRepo.On("GetByID", tmID).Return(trainingMachine, nil)
-	ut.TMRepo.On("Update", trainingMachine).Return(nil)
+	ut.TMRepo.On("Touch", tmID, mock.Anything).Return(nil)
 	ut.Hasher.On("VerifyKey", secretID, hashedSecret).Return(true, nil)
 
 	// Act
@@ -125,133 +422,1239 @@ func TestQueueService_AuthorizeTrainingMachine_Failure(t *testing.T) {
 	ut.Hasher.AssertCalled(t, "VerifyKey", secretID, hashedSecret)
 }
 
+func TestQueueService_AuthorizeTrainingMachine_PreviousSecret(t *testing.T) {
+	testCases := []struct {
+		name      string
+		expiresIn time.Duration
+		ok        bool
+	}{
+		{"within grace period", time.Hour, true},
+		{"grace period over", -time.Minute, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			queueService, ut := newQueueService()
+			tmID := uint(1)
+			expiresAt := time.Now().Add(tc.expiresIn)
+			trainingMachine := &models.TrainingMachine{
+				Model:                      gorm.Model{ID: tmID},
+				SecretKeyHashed:            "hashed_new",
+				PreviousSecretKeyHashed:    "hashed_old",
+				PreviousSecretKeyExpiresAt: &expiresAt,
+			}
+
+			ut.TMRepo.On("GetByID", tmID).Return(trainingMachine, nil)
+			ut.TMRepo.On("Touch", tmID, mock.Anything).Return(nil)
+			ut.Hasher.On("VerifyKey", "old_secret", "hashed_new").Return(false, nil)
+			ut.Hasher.On("VerifyKey", "old_secret", "hashed_old").Return(true, nil)
+
+			// Act
+			result, err := queueService.AuthorizeTrainingMachine("old_secret", tmID)
+
+			// Assert
+			if tc.ok {
+				assert.NoError(t, err)
+				assert.NotNil(t, result.PreviousSecretKeyLastUsedAt)
+				assert.Nil(t, result.SecretKeyLastUsedAt)
+			} else {
+				assert.EqualError(t, err, "authorization failure")
+				ut.Hasher.AssertNotCalled(t, "VerifyKey", "old_secret", "hashed_old")
+			}
+		})
+	}
+}
+
+func TestQueueService_AuthorizeMachineCertificate(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	subject := "/DC=ch/DC=cern/OU=computers/CN=node1.cern.ch"
+	trainingMachine := &models.TrainingMachine{SecretKeyHashed: "hashed_secret", CertificateSubject: subject, Model: gorm.Model{ID: tmID}}
+
+	ut.TMRepo.On("GetByID", tmID).Return(trainingMachine, nil)
+	ut.TMRepo.On("Touch", tmID, mock.Anything).Return(nil)
+
+	// Act
+	result, err := queueService.AuthorizeMachineCertificate(subject, tmID)
+	_, errOther := queueService.AuthorizeMachineCertificate("/DC=ch/DC=cern/OU=computers/CN=node2.cern.ch", tmID)
+	_, errSecret := queueService.AuthorizeTrainingMachine("secret", tmID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, trainingMachine, result)
+	assert.Nil(t, result.SecretKeyLastUsedAt)
+	assert.Error(t, errOther)
+	assert.Error(t, errSecret)
+	ut.Hasher.AssertNotCalled(t, "VerifyKey", mock.Anything, mock.Anything)
+}
+
 func TestQueueService_AssignTaskToMachine_Success(t *testing.T) {
 	// Arrange
 	queueService, ut := newQueueService()
 	tmID := uint(1)
-	mockTask := &models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Queued}
+	queuedTask := models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Queued}
+	claimedTask := &models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Training, TrainingMachineId: &tmID}
 
-	ut.TTRepo.On("GetFirstQueued").Return(mockTask, nil)
-	ut.TTRepo.On("Update", mockTask).Return(nil)
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{queuedTask}, nil)
+	ut.TTRepo.On("Claim", queuedTask.ID, tmID, mock.AnythingOfType("time.Time")).Return(true, nil)
+	ut.TTRepo.On("GetByID", queuedTask.ID).Return(claimedTask, nil)
 
 	// Act
-	task, err := queueService.AssignTaskToMachine(tmID)
+	task, err := queueService.AssignTaskToMachine(&models.TrainingMachine{Model: gorm.Model{ID: tmID}})
 
 	// Assert
 	assert.NoError(t, err)
-	assert.Equal(t, task, mockTask)
-	assert.Equal(t, tmID, *mockTask.TrainingMachineId)
-	assert.Equal(t, models.Training, mockTask.Status)
-	ut.TTRepo.AssertCalled(t, "GetFirstQueued")
-	ut.TTRepo.AssertCalled(t, "Update", mockTask)
+	assert.Equal(t, claimedTask, task)
+	ut.TTRepo.AssertCalled(t, "GetQueued", mock.Anything)
+	ut.TTRepo.AssertCalled(t, "Claim", queuedTask.ID, tmID, mock.AnythingOfType("time.Time"))
+}
+
+func TestQueueService_AssignTaskToMachine_LeaseExpiration(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	queuedTask := models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Queued}
+	claimedTask := &models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Training, TrainingMachineId: &tmID}
+	before := time.Now()
+
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{queuedTask}, nil)
+	ut.TTRepo.On("Claim", queuedTask.ID, tmID, mock.MatchedBy(func(leaseExpiresAt time.Time) bool {
+		return leaseExpiresAt.After(before.Add(59 * time.Second))
+	})).Return(true, nil)
+	ut.TTRepo.On("GetByID", queuedTask.ID).Return(claimedTask, nil)
+
+	// Act
+	_, err := queueService.AssignTaskToMachine(&models.TrainingMachine{Model: gorm.Model{ID: tmID}})
+
+	// Assert
+	assert.NoError(t, err)
+}
+
+func TestQueueService_AssignTaskToMachine_SkipsClaimedTask(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	queuedTasks := []models.TrainingTask{
+		{Model: gorm.Model{ID: 1}, Status: models.Queued},
+		{Model: gorm.Model{ID: 2}, Status: models.Queued},
+	}
+	claimedTask := &models.TrainingTask{Model: gorm.Model{ID: 2}, Status: models.Training, TrainingMachineId: &tmID}
+
+	ut.TTRepo.On("GetQueued", mock.Anything).Return(queuedTasks, nil)
+	ut.TTRepo.On("Claim", uint(1), tmID, mock.AnythingOfType("time.Time")).Return(false, nil)
+	ut.TTRepo.On("Claim", uint(2), tmID, mock.AnythingOfType("time.Time")).Return(true, nil)
+	ut.TTRepo.On("GetByID", uint(2)).Return(claimedTask, nil)
+
+	// Act
+	task, err := queueService.AssignTaskToMachine(&models.TrainingMachine{Model: gorm.Model{ID: tmID}})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, claimedTask, task)
+	ut.TTRepo.AssertNotCalled(t, "GetByID", uint(1))
+}
+
+func TestQueueService_AssignTaskToMachine_RespectsPinning(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	otherTmID := uint(2)
+	queuedTasks := []models.TrainingTask{
+		{Model: gorm.Model{ID: 1}, Status: models.Queued, PinnedTrainingMachineId: &otherTmID},
+		{Model: gorm.Model{ID: 2}, Status: models.Queued, MachinePool: "gpu-a100"},
+		{Model: gorm.Model{ID: 3}, Status: models.Queued, MachinePool: "cern", PinnedTrainingMachineId: &tmID},
+	}
+	claimedTask := &models.TrainingTask{Model: gorm.Model{ID: 3}, Status: models.Training, TrainingMachineId: &tmID}
+
+	ut.TTRepo.On("GetQueued", mock.Anything).Return(queuedTasks, nil)
+	ut.TTRepo.On("Claim", uint(3), tmID, mock.AnythingOfType("time.Time")).Return(true, nil)
+	ut.TTRepo.On("GetByID", uint(3)).Return(claimedTask, nil)
+
+	// Act
+	task, err := queueService.AssignTaskToMachine(&models.TrainingMachine{Model: gorm.Model{ID: tmID}, Pool: "cern"})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, claimedTask, task)
+	ut.TTRepo.AssertNotCalled(t, "Claim", uint(1), mock.Anything, mock.Anything)
+	ut.TTRepo.AssertNotCalled(t, "Claim", uint(2), mock.Anything, mock.Anything)
+}
+
+func TestQueueService_AssignTaskToMachine_CandidateFilter(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	queueService.Config.CandidateLimit = 50
+	tmID := uint(1)
+	queuedTask := models.TrainingTask{Model: gorm.Model{ID: 2}, Status: models.Queued}
+	claimedTask := &models.TrainingTask{Model: gorm.Model{ID: 2}, Status: models.Training, TrainingMachineId: &tmID}
+
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{queuedTask}, nil)
+	ut.TTRepo.On("Claim", queuedTask.ID, tmID, mock.AnythingOfType("time.Time")).Return(true, nil)
+	ut.TTRepo.On("GetByID", queuedTask.ID).Return(claimedTask, nil)
+
+	// Act
+	before := time.Now()
+	task, err := queueService.AssignTaskToMachine(&models.TrainingMachine{Model: gorm.Model{ID: tmID}})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, claimedTask, task)
+	// pinned tasks, retry backoff and ordering are left to the database
+	ut.TTRepo.AssertCalled(t, "GetQueued", mock.MatchedBy(func(filter repository.QueuedFilter) bool {
+		return filter.TrainingMachineId == tmID && !filter.Now.Before(before) && !filter.ByPriority && filter.Limit == 50
+	}))
 }
 
 func TestQueueService_AssignTaskToMachine_NoTask(t *testing.T) {
 	// Arrange
 	queueService, ut := newQueueService()
 
-	ut.TTRepo.On("GetFirstQueued").Return(nil, errors.New("no task to run"))
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{}, nil)
 
 	// Act
-	task, err := queueService.AssignTaskToMachine(1)
+	task, err := queueService.AssignTaskToMachine(&models.TrainingMachine{Model: gorm.Model{ID: 1}})
 
 	// Assert
 	assert.Error(t, err)
 	assert.Nil(t, task)
 	assert.EqualError(t, err, "no task to run")
-	ut.TTRepo.AssertCalled(t, "GetFirstQueued")
-	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+	ut.TTRepo.AssertCalled(t, "GetQueued", mock.Anything)
+	ut.TTRepo.AssertNotCalled(t, "Claim", mock.Anything, mock.Anything, mock.Anything)
 }
 
-func TestQueueService_AssignTaskToMachine_UpdateError(t *testing.T) {
+func TestQueueService_AssignTaskToMachine_Draining(t *testing.T) {
 	// Arrange
 	queueService, ut := newQueueService()
-	tmID := uint(1)
-	mockTask := &models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Queued}
-
-	ut.TTRepo.On("GetFirstQueued").Return(mockTask, nil)
-	ut.TTRepo.On("Update", mockTask).Return(errors.New("update failed"))
 
 	// Act
-	task, err := queueService.AssignTaskToMachine(tmID)
+	task, err := queueService.AssignTaskToMachine(&models.TrainingMachine{Model: gorm.Model{ID: 1}, State: models.MachineDraining})
 
 	// Assert
-	assert.Error(t, err)
 	assert.Nil(t, task)
-	assert.EqualError(t, err, "cannot assign task to machine: update failed")
-	ut.TTRepo.AssertCalled(t, "GetFirstQueued")
-	ut.TTRepo.AssertCalled(t, "Update", mockTask)
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	ut.TTRepo.AssertNotCalled(t, "GetQueued", mock.Anything)
 }
 
-func TestQueueService_CreateTrainingTaskResult_Success(t *testing.T) {
+func TestQueueService_SetMachineState(t *testing.T) {
 	// Arrange
 	queueService, ut := newQueueService()
-	taskID := uint(1)
-	fileName := "test-file.txt"
-	description := "Test description"
-	fileType := "1"
-	mockFileModel := &models.File{Name: fileName, Model: gorm.Model{ID: 1}}
-	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}}
-	mockResult := &models.TrainingTaskResult{
-		Name:           fileName,
-		Description:    description,
-		Type:           models.TrainingTaskResultType(1),
-		TrainingTaskId: taskID,
-		File:           *mockFileModel,
-	}
+	tmID, otherID := uint(1), uint(2)
+	tm := &models.TrainingMachine{Model: gorm.Model{ID: tmID}}
+	runningTask := models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Training, TrainingMachineId: &tmID}
+	otherTask := models.TrainingTask{Model: gorm.Model{ID: 2}, Status: models.Training, TrainingMachineId: &otherID}
 
-	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
-	ut.FileService.On("SaveFile", mock.Anything, mock.Anything).Return(mockFileModel, nil)
-	ut.TTRRepo.On("Create", mock.Anything).Return(nil)
+	ut.TMRepo.On("UpdateColumns", tmID, mock.Anything, map[string]interface{}{"state": models.MachineDraining}).Return(true, nil)
+	ut.TTRepo.On("GetRunning").Return([]models.TrainingTask{runningTask, otherTask}, nil)
 
 	// Act
-	result, err := queueService.CreateTrainingTaskResult(taskID, nil, nil, fileName, description, fileType)
+	activity, err := queueService.SetMachineState(tm, models.MachineDraining)
 
 	// Assert
 	assert.NoError(t, err)
-	assert.Equal(t, mockResult.Name, result.Name)
-	assert.Equal(t, mockResult.Description, result.Description)
-	ut.TTRepo.AssertCalled(t, "GetByID", taskID)
-	ut.FileService.AssertCalled(t, "SaveFile", mock.Anything, mock.Anything)
-	ut.TTRRepo.AssertCalled(t, "Create", mock.Anything)
+	assert.Equal(t, models.MachineDraining, tm.State)
+	assert.Equal(t, []models.TrainingTask{runningTask}, activity.RunningTasks)
+	assert.False(t, activity.IsDrained())
+	ut.TMRepo.AssertNotCalled(t, "Update", mock.Anything)
 }
 
-func TestQueueService_CreateTrainingTaskResult_TaskNotFound(t *testing.T) {
+func TestQueueService_RecordTelemetry(t *testing.T) {
 	// Arrange
 	queueService, ut := newQueueService()
-	taskID := uint(1)
+	tm := &models.TrainingMachine{Model: gorm.Model{ID: 1}}
+	gpu := 80.0
+	telemetry := &models.TrainingMachineTelemetry{
+		CPUPercent:       35,
+		GPUPercent:       &gpu,
+		MemoryUsedBytes:  4 << 30,
+		MemoryTotalBytes: 16 << 30,
+		DiskFreeBytes:    50 << 30,
+		AgentVersion:     " v1.2.0 ",
+		DriverVersion:    "550.54.15",
+	}
 
-	ut.TTRepo.On("GetByID", taskID).Return(nil, errors.New("training task does not exist"))
+	ut.TMTRepo.On("Create", telemetry).Return(nil)
+	ut.TMTRepo.On("DeleteBefore", tm.ID, mock.AnythingOfType("time.Time")).Return(nil)
+	ut.TMRepo.On("Touch", tm.ID, mock.Anything).Return(nil)
 
 	// Act
-	result, err := queueService.CreateTrainingTaskResult(taskID, nil, nil, "test", "desc", "1")
+	err := queueService.RecordTelemetry(tm, telemetry)
 
 	// Assert
-	assert.Error(t, err)
-	assert.Nil(t, result)
-	assert.EqualError(t, err, "training task does not exist")
-	ut.TTRepo.AssertCalled(t, "GetByID", taskID)
-	ut.FileService.AssertNotCalled(t, "SaveFile", mock.Anything, mock.Anything)
-	ut.TTRRepo.AssertNotCalled(t, "Create", mock.Anything)
+	assert.NoError(t, err)
+	assert.Equal(t, tm.ID, telemetry.TrainingMachineId)
+	if assert.NotNil(t, tm.LastTelemetryAt) {
+		assert.Equal(t, telemetry.CreatedAt, *tm.LastTelemetryAt)
+	}
+	assert.Equal(t, "v1.2.0", tm.AgentVersion)
+	assert.Equal(t, "550.54.15", tm.DriverVersion)
+	assert.Equal(t, models.MachineOnline, tm.Health(time.Now()))
+	assert.Equal(t, models.MachineStale, tm.Health(time.Now().Add(5*time.Minute)))
+	assert.Equal(t, models.MachineOffline, tm.Health(time.Now().Add(time.Hour)))
+	ut.TMRepo.AssertNotCalled(t, "Update", mock.Anything)
+	ut.TMTRepo.AssertCalled(t, "DeleteBefore", tm.ID, mock.AnythingOfType("time.Time"))
 }
 
-func TestQueueService_CreateTrainingTaskResult_FileSaveError(t *testing.T) {
+func TestQueueService_RecordTelemetry_Invalid(t *testing.T) {
+	over := 101.0
+	testCases := []struct {
+		name      string
+		telemetry models.TrainingMachineTelemetry
+		field     string
+	}{
+		{"negative CPU", models.TrainingMachineTelemetry{CPUPercent: -1}, "CPUPercent"},
+		{"NaN CPU", models.TrainingMachineTelemetry{CPUPercent: math.NaN()}, "CPUPercent"},
+		{"GPU over 100", models.TrainingMachineTelemetry{GPUPercent: &over}, "GPUPercent"},
+		{"used over total", models.TrainingMachineTelemetry{MemoryUsedBytes: 2, MemoryTotalBytes: 1}, "MemoryUsedBytes"},
+		{"disk over int64", models.TrainingMachineTelemetry{DiskFreeBytes: math.MaxInt64 + 1}, "DiskFreeBytes"},
+		{"long version", models.TrainingMachineTelemetry{AgentVersion: strings.Repeat("v", 65)}, "AgentVersion"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			queueService, ut := newQueueService()
+			tm := &models.TrainingMachine{Model: gorm.Model{ID: 1}}
+
+			// Act
+			err := queueService.RecordTelemetry(tm, &tc.telemetry)
+
+			// Assert
+			if assert.IsType(t, &service.ErrHandlerValidation{}, err) {
+				assert.Equal(t, tc.field, err.(*service.ErrHandlerValidation).Field)
+			}
+			ut.TMTRepo.AssertNotCalled(t, "Create", mock.Anything)
+			assert.Nil(t, tm.LastTelemetryAt)
+		})
+	}
+}
+
+func TestQueueService_AssignTaskToMachine_ClaimError(t *testing.T) {
 	// Arrange
 	queueService, ut := newQueueService()
-	taskID := uint(1)
-	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}}
+	tmID := uint(1)
+	queuedTask := models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Queued}
 
-	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
-	ut.FileService.On("SaveFile", mock.Anything, mock.Anything).Return(nil, errors.New("file save error"))
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{queuedTask}, nil)
+	ut.TTRepo.On("Claim", queuedTask.ID, tmID, mock.AnythingOfType("time.Time")).Return(false, errors.New("update failed"))
 
 	// Act
-	result, err := queueService.CreateTrainingTaskResult(taskID, nil, nil, "test", "desc", "1")
+	task, err := queueService.AssignTaskToMachine(&models.TrainingMachine{Model: gorm.Model{ID: tmID}})
 
 	// Assert
 	assert.Error(t, err)
-	assert.Nil(t, result)
-	assert.EqualError(t, err, "error saving file: file save error")
-	ut.TTRepo.AssertCalled(t, "GetByID", taskID)
-	ut.FileService.AssertCalled(t, "SaveFile", mock.Anything, mock.Anything)
-	ut.TTRRepo.AssertNotCalled(t, "Create", mock.Anything)
+	assert.Nil(t, task)
+	assert.EqualError(t, err, "cannot assign task to machine: update failed")
+	ut.TTRepo.AssertNotCalled(t, "GetByID", mock.Anything)
+}
+
+func TestQueueService_WaitForTask_Notified(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	queuedTask := models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Queued}
+	claimedTask := &models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Training, TrainingMachineId: &tmID}
+
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{}, nil).Once()
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{queuedTask}, nil)
+	ut.TTRepo.On("Claim", queuedTask.ID, tmID, mock.AnythingOfType("time.Time")).Return(true, nil)
+	ut.TTRepo.On("GetByID", queuedTask.ID).Return(claimedTask, nil)
+	ut.TMRepo.On("GetByID", tmID).Return(&models.TrainingMachine{Model: gorm.Model{ID: tmID}}, nil)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		queueService.Notifier.Notify()
+	}()
+
+	// Act
+	start := time.Now()
+	task, err := queueService.WaitForTask(context.Background(), &models.TrainingMachine{Model: gorm.Model{ID: tmID}}, time.Minute)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, claimedTask, task)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	ut.TTRepo.AssertNumberOfCalls(t, "GetQueued", 2)
+}
+
+func TestQueueService_WaitForTask_DrainedWhileWaiting(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	queuedTask := models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Queued}
+
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{}, nil).Once()
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{queuedTask}, nil)
+	ut.TMRepo.On("GetByID", tmID).Return(&models.TrainingMachine{Model: gorm.Model{ID: tmID}, State: models.MachineDraining}, nil)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		queueService.Notifier.Notify()
+	}()
+
+	// Act
+	start := time.Now()
+	task, err := queueService.WaitForTask(context.Background(), &models.TrainingMachine{Model: gorm.Model{ID: tmID}}, time.Minute)
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, task)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	ut.TTRepo.AssertNumberOfCalls(t, "GetQueued", 1)
+	ut.TTRepo.AssertNotCalled(t, "Claim", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestQueueService_WaitForTask_Timeout(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{}, nil)
+
+	// Act
+	start := time.Now()
+	task, err := queueService.WaitForTask(context.Background(), &models.TrainingMachine{Model: gorm.Model{ID: 1}}, 200*time.Millisecond)
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, task)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestQueueService_WaitForTask_ContextCancelled(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	ut.TTRepo.On("GetQueued", mock.Anything).Return([]models.TrainingTask{}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	task, err := queueService.WaitForTask(ctx, &models.TrainingMachine{Model: gorm.Model{ID: 1}}, time.Minute)
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, task)
+	ut.TTRepo.AssertNumberOfCalls(t, "GetQueued", 1)
+}
+
+func TestQueueService_CreateTrainingTaskResult_Success(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	fileName := "test-file.txt"
+	description := "Test description"
+	fileType := "1"
+	mockFileModel := &models.File{Name: fileName, Model: gorm.Model{ID: 1}}
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}}
+	mockResult := &models.TrainingTaskResult{
+		Name:           fileName,
+		Description:    description,
+		Type:           models.TrainingTaskResultType(1),
+		TrainingTaskId: taskID,
+		File:           *mockFileModel,
+	}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.FileService.On("SaveFile", mock.Anything, mock.Anything, mock.Anything).Return(mockFileModel, nil)
+	ut.TTRRepo.On("Create", mock.Anything).Return(nil)
+
+	// Act
+	result, err := queueService.CreateTrainingTaskResult(taskID, nil, nil, fileName, description, fileType, "")
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, mockResult.Name, result.Name)
+	assert.Equal(t, mockResult.Description, result.Description)
+	ut.TTRepo.AssertCalled(t, "GetByID", taskID)
+	ut.FileService.AssertCalled(t, "SaveFile", mock.Anything, mock.Anything, mock.Anything)
+	ut.TTRRepo.AssertCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_CreateTrainingTaskResult_TaskNotFound(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+
+	ut.TTRepo.On("GetByID", taskID).Return(nil, errors.New("training task does not exist"))
+
+	// Act
+	result, err := queueService.CreateTrainingTaskResult(taskID, nil, nil, "test", "desc", "1", "")
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, result)
+	assert.EqualError(t, err, "training task does not exist")
+	ut.TTRepo.AssertCalled(t, "GetByID", taskID)
+	ut.FileService.AssertNotCalled(t, "SaveFile", mock.Anything, mock.Anything, mock.Anything)
+	ut.TTRRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_CreateTrainingTaskResult_FileSaveError(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.FileService.On("SaveFile", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("file save error"))
+
+	// Act
+	result, err := queueService.CreateTrainingTaskResult(taskID, nil, nil, "test", "desc", "1", "")
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, result)
+	assert.EqualError(t, err, "error saving file: file save error")
+	ut.TTRepo.AssertCalled(t, "GetByID", taskID)
+	ut.FileService.AssertCalled(t, "SaveFile", mock.Anything, mock.Anything, mock.Anything)
+	ut.TTRRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_RenewLease_Success(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	oldLease := time.Now().Add(time.Second)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training, LeaseExpiresAt: &oldLease}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Training, mock.Anything).Return(true, nil)
+
+	// Act
+	task, err := queueService.RenewLease(taskID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, task.LeaseExpiresAt.After(oldLease))
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestQueueService_RenewLease_NotRunning(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Queued}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+
+	// Act
+	task, err := queueService.RenewLease(taskID)
+
+	// Assert
+	assert.Nil(t, task)
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestQueueService_RequeueExpiredTasks(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	expired := time.Now().Add(-time.Minute)
+	tasks := []models.TrainingTask{
+		{Model: gorm.Model{ID: 1}, Status: models.Training, TrainingMachineId: &tmID, LeaseExpiresAt: &expired},
+		{Model: gorm.Model{ID: 2}, Status: models.Benchmarking, TrainingMachineId: &tmID, LeaseExpiresAt: &expired, LostLeases: 1},
+	}
+
+	ut.TTRepo.On("GetWithExpiredLease", mock.AnythingOfType("time.Time")).Return(tasks, nil)
+	ut.TTRepo.On("UpdateExpired", mock.Anything, mock.Anything, mock.AnythingOfType("time.Time"), mock.Anything).Return(true, nil)
+
+	// Act
+	err := queueService.RequeueExpiredTasks()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Queued, tasks[0].Status)
+	assert.Equal(t, uint(1), tasks[0].LostLeases)
+	assert.Nil(t, tasks[0].TrainingMachineId)
+	assert.Nil(t, tasks[0].LeaseExpiresAt)
+	assert.Equal(t, models.Failed, tasks[1].Status)
+	assert.Equal(t, uint(2), tasks[1].LostLeases)
+	assert.Equal(t, models.FailureCodeLeaseExpired, tasks[1].Failure.Code)
+	assert.Empty(t, tasks[0].Failure.Code)
+	ut.TTRepo.AssertCalled(t, "UpdateExpired", uint(1), models.Training, mock.AnythingOfType("time.Time"), mock.MatchedBy(func(columns map[string]interface{}) bool {
+		return columns["status"] == models.Queued && columns["lost_leases"] == uint(1)
+	}))
+	ut.TTRepo.AssertCalled(t, "UpdateExpired", uint(2), models.Benchmarking, mock.AnythingOfType("time.Time"), mock.MatchedBy(func(columns map[string]interface{}) bool {
+		return columns["status"] == models.Failed && columns["failure_code"] == models.FailureCodeLeaseExpired
+	}))
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestQueueService_RequeueExpiredTasks_LeaseRenewed(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	tmID := uint(1)
+	expired := time.Now().Add(-time.Minute)
+	tasks := []models.TrainingTask{
+		{Model: gorm.Model{ID: 1}, Status: models.Training, TrainingMachineId: &tmID, LeaseExpiresAt: &expired},
+	}
+
+	// a heartbeat renewed the lease after the task was read
+	ut.TTRepo.On("GetWithExpiredLease", mock.AnythingOfType("time.Time")).Return(tasks, nil)
+	ut.TTRepo.On("UpdateExpired", uint(1), models.Training, mock.AnythingOfType("time.Time"), mock.Anything).Return(false, nil)
+
+	// Act
+	err := queueService.RequeueExpiredTasks()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Training, tasks[0].Status)
+	assert.Equal(t, &tmID, tasks[0].TrainingMachineId)
+	ut.TSERepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_RequeueExpiredTasks_RetryBackoffCapped(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	ut.NNArch.RetryPolicy = service.NNRetryPolicy{MaxAttempts: 5, BackoffSeconds: 60, MaxBackoffSeconds: 90}
+	tmID := uint(1)
+	expired := time.Now().Add(-time.Minute)
+	tasks := []models.TrainingTask{
+		{Model: gorm.Model{ID: 1}, Status: models.Training, TrainingMachineId: &tmID, LeaseExpiresAt: &expired, LostLeases: 1, Retries: 2},
+	}
+
+	ut.TTRepo.On("GetWithExpiredLease", mock.AnythingOfType("time.Time")).Return(tasks, nil)
+	ut.TTRepo.On("UpdateExpired", uint(1), models.Training, mock.AnythingOfType("time.Time"), mock.Anything).Return(true, nil)
+	ut.TTRepo.On("UpdateStatus", uint(1), models.Failed, mock.Anything).Return(true, nil)
+	ut.TTARepo.On("Create", mock.AnythingOfType("*models.TrainingTaskAttempt")).Return(nil)
+
+	// Act
+	before := time.Now()
+	err := queueService.RequeueExpiredTasks()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Queued, tasks[0].Status)
+	assert.Equal(t, uint(3), tasks[0].Retries)
+	assert.Zero(t, tasks[0].LostLeases)
+	if assert.NotNil(t, tasks[0].RetryAt) {
+		assert.WithinDuration(t, before.Add(90*time.Second), *tasks[0].RetryAt, 5*time.Second)
+	}
+	ut.TTARepo.AssertCalled(t, "Create", mock.MatchedBy(func(attempt *models.TrainingTaskAttempt) bool {
+		return attempt.Retry == 2 && attempt.Failure.Code == models.FailureCodeLeaseExpired
+	}))
+}
+
+func TestQueueService_MaxWallTime(t *testing.T) {
+	queueService, ut := newQueueService()
+
+	assert.Zero(t, queueService.MaxWallTime(&models.TrainingTask{}))
+
+	ut.NNArch.MaxWallTimeSeconds = 3600
+	assert.Equal(t, time.Hour, queueService.MaxWallTime(&models.TrainingTask{}))
+	assert.Equal(t, 10*time.Minute, queueService.MaxWallTime(&models.TrainingTask{MaxWallTimeSeconds: 600}))
+}
+
+func TestQueueService_ExpectedOnnx(t *testing.T) {
+	queueService, ut := newQueueService()
+
+	assert.Empty(t, queueService.ExpectedOnnx())
+
+	ut.NNArch.ExpectedResults.Onnx = map[string]string{
+		"propagation.onnx": "propagation",
+		"classifier.onnx":  "classifier",
+	}
+	assert.Equal(t, []string{"classifier.onnx", "propagation.onnx"}, queueService.ExpectedOnnx())
+}
+
+func TestQueueService_FailOverrunningTasks(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	ut.NNArch.MaxWallTimeSeconds = 3600
+	tmID := uint(1)
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	tenMinutesAgo := time.Now().Add(-10 * time.Minute)
+	tasks := []models.TrainingTask{
+		// architecture default exceeded
+		{Model: gorm.Model{ID: 1}, Status: models.Training, TrainingMachineId: &tmID, AssignedAt: &twoHoursAgo},
+		// task override exceeded
+		{Model: gorm.Model{ID: 2}, Status: models.Benchmarking, TrainingMachineId: &tmID, AssignedAt: &tenMinutesAgo, MaxWallTimeSeconds: 300},
+		// within the architecture default
+		{Model: gorm.Model{ID: 3}, Status: models.Training, TrainingMachineId: &tmID, AssignedAt: &tenMinutesAgo},
+		// task override extends the architecture default
+		{Model: gorm.Model{ID: 4}, Status: models.Training, TrainingMachineId: &tmID, AssignedAt: &twoHoursAgo, MaxWallTimeSeconds: 3 * 3600},
+	}
+
+	ut.TTRepo.On("GetRunning").Return(tasks, nil)
+	ut.TTRepo.On("UpdateStatus", mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+
+	// Act
+	err := queueService.FailOverrunningTasks()
+
+	// Assert
+	assert.NoError(t, err)
+	for _, tt := range tasks[:2] {
+		assert.Equal(t, models.Failed, tt.Status)
+		assert.Equal(t, models.FailureCodeWallTimeExceeded, tt.Failure.Code)
+		assert.NotNil(t, tt.FinishedAt)
+	}
+	assert.Contains(t, tasks[1].Failure.Message, "5m0s")
+	for _, tt := range tasks[2:] {
+		assert.True(t, tt.Status.IsRunning())
+		assert.Empty(t, tt.Failure.Code)
+	}
+	ut.TTRepo.AssertNumberOfCalls(t, "UpdateStatus", 2)
+	ut.TSERepo.AssertCalled(t, "Create", mock.MatchedBy(func(event *models.TrainingTaskStatusEvent) bool {
+		return event.TrainingTaskId == 1 && event.ToStatus == models.Failed && event.Source == models.StatusSourceQueue
+	}))
+}
+
+func TestQueueService_FailOverrunningTasks_NoLimit(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	longAgo := time.Now().Add(-30 * 24 * time.Hour)
+	tasks := []models.TrainingTask{
+		{Model: gorm.Model{ID: 1}, Status: models.Training, AssignedAt: &longAgo},
+	}
+
+	ut.TTRepo.On("GetRunning").Return(tasks, nil)
+
+	// Act
+	err := queueService.FailOverrunningTasks()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Training, tasks[0].Status)
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestQueueService_RecordMetrics_Success(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	epoch := uint(2)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training, Retries: 1}
+	points := []service.MetricPoint{
+		{Step: 10, Epoch: &epoch, Values: map[string]float64{"val_loss": 0.6, "loss": 0.5}},
+	}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTMRepo.On("Create", mock.Anything).Return(nil)
+
+	// Act
+	tt, err := queueService.RecordMetrics(taskID, points)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, mockTask, tt)
+	ut.TTMRepo.AssertCalled(t, "Create", []models.TrainingTaskMetric{
+		{TrainingTaskId: taskID, Retry: 1, Step: 10, Epoch: &epoch, Name: "loss", Value: 0.5},
+		{TrainingTaskId: taskID, Retry: 1, Step: 10, Epoch: &epoch, Name: "val_loss", Value: 0.6},
+	})
+}
+
+func TestQueueService_RecordMetrics_InvalidName(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+	points := []service.MetricPoint{
+		{Step: 10, Values: map[string]float64{"loss <script>": 0.5}},
+	}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+
+	// Act
+	_, err := queueService.RecordMetrics(taskID, points)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerValidation{}, err)
+	ut.TTMRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_RecordMetrics_NotRunning(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Completed}
+	points := []service.MetricPoint{
+		{Step: 10, Values: map[string]float64{"loss": 0.5}},
+	}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+
+	// Act
+	_, err := queueService.RecordMetrics(taskID, points)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	ut.TTMRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestQueueService_AppendLog_Success(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training, Retries: 1}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTLCRepo.On("GetSize", taskID, uint(1)).Return(int64(6), nil)
+	ut.TTLCRepo.On("CreateIfAbsent", mock.AnythingOfType("*models.TrainingTaskLogChunk")).Return(true, nil)
+
+	// Act
+	_, size, err := queueService.AppendLog(taskID, 4, []byte("o\nworld\n"))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+	ut.TTLCRepo.AssertCalled(t, "CreateIfAbsent", &models.TrainingTaskLogChunk{
+		TrainingTaskId: taskID,
+		Retry:          1,
+		Offset:         6,
+		Size:           6,
+		Data:           []byte("world\n"),
+	})
+}
+
+func TestQueueService_AppendLog_AlreadyStored(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTLCRepo.On("GetSize", taskID, uint(0)).Return(int64(12), nil)
+
+	// Act
+	_, size, err := queueService.AppendLog(taskID, 6, []byte("world\n"))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+	ut.TTLCRepo.AssertNotCalled(t, "CreateIfAbsent", mock.Anything)
+}
+
+func TestQueueService_AppendLog_ResentConcurrently(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+	stored := models.TrainingTaskLogChunk{TrainingTaskId: taskID, Offset: 6, Size: 6, Data: []byte("world\n")}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	// the original request stored the chunk after the size was read
+	ut.TTLCRepo.On("GetSize", taskID, uint(0)).Return(int64(6), nil).Once()
+	ut.TTLCRepo.On("GetSize", taskID, uint(0)).Return(int64(12), nil)
+	ut.TTLCRepo.On("CreateIfAbsent", mock.AnythingOfType("*models.TrainingTaskLogChunk")).Return(false, nil)
+	ut.TTLCRepo.On("GetFrom", taskID, uint(0), int64(6), 1).Return([]models.TrainingTaskLogChunk{stored}, nil)
+
+	// Act
+	_, size, err := queueService.AppendLog(taskID, 6, []byte("world\n"))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+	ut.TTLCRepo.AssertNumberOfCalls(t, "CreateIfAbsent", 1)
+}
+
+func TestQueueService_AppendLog_DifferentChunkStored(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+	stored := models.TrainingTaskLogChunk{TrainingTaskId: taskID, Offset: 6, Size: 6, Data: []byte("other\n")}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTLCRepo.On("GetSize", taskID, uint(0)).Return(int64(6), nil)
+	ut.TTLCRepo.On("CreateIfAbsent", mock.AnythingOfType("*models.TrainingTaskLogChunk")).Return(false, nil)
+	ut.TTLCRepo.On("GetFrom", taskID, uint(0), int64(6), 1).Return([]models.TrainingTaskLogChunk{stored}, nil)
+
+	// Act
+	_, _, err := queueService.AppendLog(taskID, 6, []byte("world\n"))
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	assert.ErrorContains(t, err, "differs from the stored one")
+}
+
+func TestQueueService_AppendLog_Gap(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTLCRepo.On("GetSize", taskID, uint(0)).Return(int64(6), nil)
+
+	// Act
+	_, _, err := queueService.AppendLog(taskID, 10, []byte("world\n"))
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	assert.ErrorContains(t, err, "expected offset 6")
+	ut.TTLCRepo.AssertNotCalled(t, "CreateIfAbsent", mock.Anything)
+}
+
+func TestQueueService_AppendLog_NotRunning(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Queued}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTLCRepo.On("GetSize", taskID, uint(0)).Return(int64(0), nil)
+
+	// Act
+	_, _, err := queueService.AppendLog(taskID, 0, []byte("hello\n"))
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	ut.TTLCRepo.AssertNotCalled(t, "CreateIfAbsent", mock.Anything)
+}
+
+func TestQueueService_InitiateUpload_Success(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Benchmarking, Retries: 2}
+	upload := &models.TrainingTaskUpload{Name: "model", Type: models.Onnx, FileName: "model.onnx", Size: 1 << 32}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.FileService.On("CreatePartialFile").Return("data/partial/partial-1", nil)
+	ut.TTURepo.On("Create", upload).Return(nil)
+
+	// Act
+	err := queueService.InitiateUpload(taskID, upload)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, taskID, upload.TrainingTaskId)
+	assert.Equal(t, uint(2), upload.Retry)
+	assert.Equal(t, "data/partial/partial-1", upload.PartialPath)
+	assert.Zero(t, upload.Received)
+}
+
+func TestQueueService_InitiateUpload_InvalidSize(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Benchmarking}
+	upload := &models.TrainingTaskUpload{Name: "model", Type: models.Onnx, FileName: "model.onnx"}
+
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+
+	// Act
+	err := queueService.InitiateUpload(taskID, upload)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerValidation{}, err)
+	ut.FileService.AssertNotCalled(t, "CreatePartialFile")
+}
+
+func TestQueueService_WriteUploadChunk_Resumable(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	upload := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, Size: 100, Received: 40, PartialPath: "partial"}
+
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil)
+	ut.TTURepo.On("UpdateReceived", uint(3), int64(40), int64(60)).Return(true, nil)
+	// connection dropped after 20 bytes of the chunk were stored
+	ut.FileService.On("WritePartialFile", "partial", int64(40), mock.Anything).Return(int64(20), io.ErrUnexpectedEOF)
+
+	// Act
+	_, err := queueService.WriteUploadChunk(1, 3, 40, strings.NewReader(strings.Repeat("x", 60)))
+
+	// Assert
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, int64(60), upload.Received)
+	ut.TTURepo.AssertCalled(t, "UpdateReceived", uint(3), int64(40), int64(60))
+}
+
+func TestQueueService_WriteUploadChunk_Concurrent(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	upload := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, Size: 100, Received: 40, PartialPath: "partial"}
+	moved := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, Size: 100, Received: 50, PartialPath: "partial"}
+
+	// a resent chunk moved the offset to 50 while this one was written
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil).Once()
+	ut.TTURepo.On("GetByID", uint(3)).Return(moved, nil).Once()
+	ut.TTURepo.On("UpdateReceived", uint(3), int64(40), int64(60)).Return(false, nil)
+	ut.TTURepo.On("UpdateReceived", uint(3), int64(50), int64(60)).Return(true, nil)
+	ut.FileService.On("WritePartialFile", "partial", int64(40), mock.Anything).
+		Run(func(args mock.Arguments) {
+			_, _ = io.Copy(io.Discard, args.Get(2).(io.Reader))
+		}).
+		Return(int64(20), nil)
+
+	// Act
+	result, err := queueService.WriteUploadChunk(1, 3, 40, strings.NewReader(strings.Repeat("x", 20)))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(60), result.Received)
+}
+
+func TestQueueService_WriteUploadChunk_Gap(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	upload := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, Size: 100, Received: 40}
+
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil)
+
+	// Act
+	_, err := queueService.WriteUploadChunk(1, 3, 50, strings.NewReader("x"))
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	assert.ErrorContains(t, err, "expected offset 40")
+	ut.FileService.AssertNotCalled(t, "WritePartialFile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestQueueService_WriteUploadChunk_ExceedsSize(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	upload := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, Size: 10, Received: 5, PartialPath: "partial"}
+
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil)
+	ut.TTURepo.On("UpdateReceived", uint(3), int64(5), int64(10)).Return(true, nil)
+	ut.FileService.On("WritePartialFile", "partial", int64(5), mock.Anything).
+		Run(func(args mock.Arguments) {
+			_, _ = io.Copy(io.Discard, args.Get(2).(io.Reader))
+		}).
+		Return(int64(5), nil)
+
+	// Act
+	_, err := queueService.WriteUploadChunk(1, 3, 5, strings.NewReader("0123456789"))
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerValidation{}, err)
+	assert.Equal(t, int64(10), upload.Received)
+}
+
+func TestQueueService_WriteUploadChunk_OtherTask(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	upload := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 2, Size: 10}
+
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil)
+
+	// Act
+	_, err := queueService.WriteUploadChunk(1, 3, 0, strings.NewReader("x"))
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerNotFound{}, err)
+}
+
+func TestQueueService_ExpireUploads(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	queueService.Config.UploadTTLHours = 24
+	stale := []models.TrainingTaskUpload{
+		{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, PartialPath: "partial-3"},
+		{Model: gorm.Model{ID: 4}, TrainingTaskId: 2, PartialPath: "partial-4"},
+	}
+	ut.TTURepo.On("GetStale", mock.AnythingOfType("time.Time")).Return(stale, nil)
+	ut.TTURepo.On("DeleteStale", mock.Anything, mock.AnythingOfType("time.Time")).Return(true, nil)
+	ut.FileService.On("RemovePartialFile", mock.Anything).Return(nil)
+
+	// Act
+	before := time.Now()
+	err := queueService.ExpireUploads()
+
+	// Assert
+	assert.NoError(t, err)
+	ut.TTURepo.AssertCalled(t, "GetStale", mock.MatchedBy(func(at time.Time) bool {
+		return at.Sub(before.Add(-24*time.Hour)).Abs() < 5*time.Second
+	}))
+	for _, upload := range stale {
+		ut.FileService.AssertCalled(t, "RemovePartialFile", upload.PartialPath)
+		ut.TTURepo.AssertCalled(t, "DeleteStale", upload.ID, mock.AnythingOfType("time.Time"))
+	}
+}
+
+func TestQueueService_ExpireUploads_UsedConcurrently(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	queueService.Config.UploadTTLHours = 24
+	stale := []models.TrainingTaskUpload{{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, PartialPath: "partial-3"}}
+	ut.TTURepo.On("GetStale", mock.AnythingOfType("time.Time")).Return(stale, nil)
+	// the upload is being finalized
+	ut.TTURepo.On("DeleteStale", uint(3), mock.AnythingOfType("time.Time")).Return(false, nil)
+
+	// Act
+	err := queueService.ExpireUploads()
+
+	// Assert
+	assert.NoError(t, err)
+	ut.FileService.AssertNotCalled(t, "RemovePartialFile", mock.Anything)
+}
+
+func TestQueueService_ExpireUploads_Disabled(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+
+	// Act
+	err := queueService.ExpireUploads()
+
+	// Assert
+	assert.NoError(t, err)
+	ut.TTURepo.AssertNotCalled(t, "GetStale", mock.Anything)
+}
+
+func TestQueueService_FinalizeUpload_Success(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	checksum := strings.Repeat("ab", 32)
+	upload := &models.TrainingTaskUpload{
+		Model: gorm.Model{ID: 3}, TrainingTaskId: 1, Retry: 1, Name: "model", Type: models.Onnx,
+		FileName: "model.onnx", Size: 10, Received: 10, PartialPath: "partial",
+	}
+	file := &models.File{Name: "model.onnx", Path: "/data/model.onnx", Size: 10}
+
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil)
+	ut.TTURepo.On("Touch", uint(3)).Return(true, nil)
+	ut.TTURepo.On("Delete", uint(3)).Return(nil)
+	ut.FileService.On("FinalizePartialFile", "partial", "model.onnx", checksum).Return(file, nil)
+	ut.TTRRepo.On("Create", mock.AnythingOfType("*models.TrainingTaskResult")).Return(nil)
+
+	// Act
+	ttr, err := queueService.FinalizeUpload(1, 3, checksum)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "model", ttr.Name)
+	assert.Equal(t, models.Onnx, ttr.Type)
+	assert.Equal(t, *file, ttr.File)
+	assert.Equal(t, uint(1), ttr.Retry)
+	ut.TTURepo.AssertCalled(t, "Delete", uint(3))
+}
+
+func TestQueueService_FinalizeUpload_Incomplete(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	upload := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, Size: 10, Received: 9}
+
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil)
+
+	// Act
+	_, err := queueService.FinalizeUpload(1, 3, strings.Repeat("ab", 32))
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	ut.FileService.AssertNotCalled(t, "FinalizePartialFile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestQueueService_FinalizeUpload_Expired(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	upload := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, FileName: "model.onnx", Size: 10, Received: 10, PartialPath: "partial"}
+
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil)
+	// the upload expired after it was read
+	ut.TTURepo.On("Touch", uint(3)).Return(false, nil)
+
+	// Act
+	_, err := queueService.FinalizeUpload(1, 3, strings.Repeat("ab", 32))
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerGone{}, err)
+	ut.FileService.AssertNotCalled(t, "FinalizePartialFile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestQueueService_FinalizeUpload_ChecksumMismatch(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	checksum := strings.Repeat("ab", 32)
+	upload := &models.TrainingTaskUpload{Model: gorm.Model{ID: 3}, TrainingTaskId: 1, FileName: "model.onnx", Size: 10, Received: 10, PartialPath: "partial"}
+
+	ut.TTURepo.On("GetByID", uint(3)).Return(upload, nil)
+	ut.TTURepo.On("Touch", uint(3)).Return(true, nil)
+	ut.FileService.On("FinalizePartialFile", "partial", "model.onnx", checksum).Return(nil, service.ErrChecksumMismatch)
+
+	// Act
+	_, err := queueService.FinalizeUpload(1, 3, checksum)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerValidation{}, err)
+	ut.TTRRepo.AssertNotCalled(t, "Create", mock.Anything)
+	ut.TTURepo.AssertNotCalled(t, "Delete", mock.Anything)
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_FailureFailsDependentTasks(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Name: "preprocessing", Status: models.Training}
+	child := models.TrainingTask{Model: gorm.Model{ID: 2}, Name: "training", Status: models.Queued}
+	ut.TTDRepo.ExpectedCalls = nil
+	ut.TTDRepo.On("GetChildren", taskID).Return([]models.TrainingTask{child}, nil)
+	ut.TTDRepo.On("GetChildren", child.ID).Return([]models.TrainingTask{}, nil)
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Training, mock.Anything).Return(true, nil)
+	ut.TTRepo.On("UpdateStatus", child.ID, models.Queued, mock.Anything).Return(true, nil)
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, models.Failed, &models.TaskFailure{Code: "oom"})
+
+	// Assert
+	assert.NoError(t, err)
+	ut.TTRepo.AssertCalled(t, "UpdateStatus", child.ID, models.Queued, mock.MatchedBy(func(columns map[string]interface{}) bool {
+		return columns["status"] == models.Failed && columns["failure_code"] == models.FailureCodeDependencyFailed
+	}))
+}
+
+func TestQueueService_UpdateTrainingTaskStatus_RetriedFailureKeepsDependentTasks(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(1)
+	mockTask := &models.TrainingTask{Model: gorm.Model{ID: taskID}, Status: models.Training, MaxAttempts: 2}
+	ut.TTRepo.On("GetByID", taskID).Return(mockTask, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Training, mock.Anything).Return(true, nil)
+	ut.TTRepo.On("UpdateStatus", taskID, models.Failed, mock.Anything).Return(true, nil)
+	ut.TTARepo.On("Create", mock.AnythingOfType("*models.TrainingTaskAttempt")).Return(nil)
+
+	// Act
+	_, err := queueService.UpdateTrainingTaskStatus(taskID, models.Failed, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Queued, mockTask.Status)
+	ut.TTDRepo.AssertNotCalled(t, "GetChildren", mock.Anything)
+}
+
+func TestQueueService_GetParentResults(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(3)
+	parent := models.TrainingTask{Model: gorm.Model{ID: 1}, Status: models.Completed, Retries: 1}
+	results := []models.TrainingTaskResult{
+		{Model: gorm.Model{ID: 10}, Name: "model", Type: models.Onnx, TrainingTaskId: parent.ID, Retry: 1,
+			File: models.File{Name: "model.onnx", Size: 42, SHA256: strings.Repeat("a", 64)}},
+		{Model: gorm.Model{ID: 9}, Name: "model", Type: models.Onnx, TrainingTaskId: parent.ID, Retry: 0,
+			File: models.File{Name: "model.onnx", Size: 40}},
+	}
+	ut.TTDRepo.On("GetParents", taskID).Return([]models.TrainingTask{parent}, nil)
+	ut.TTRRepo.On("GetAll", parent.ID).Return(results, nil)
+
+	// Act
+	parentResults, err := queueService.GetParentResults(taskID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []service.ParentResult{{
+		ID:             10,
+		TrainingTaskId: parent.ID,
+		Name:           "model",
+		Type:           models.Onnx,
+		FileName:       "model.onnx",
+		Size:           42,
+		SHA256:         strings.Repeat("a", 64),
+	}}, parentResults)
+}
+
+func TestQueueService_OpenParentResult_NotParent(t *testing.T) {
+	// Arrange
+	queueService, ut := newQueueService()
+	taskID := uint(3)
+	result := &models.TrainingTaskResult{Model: gorm.Model{ID: 10}, TrainingTaskId: 2, File: models.File{Path: "/data/model.onnx"}}
+	ut.TTRRepo.On("GetByID", result.ID).Return(result, nil)
+	ut.TTDRepo.On("GetParents", taskID).Return([]models.TrainingTask{{Model: gorm.Model{ID: 1}}}, nil)
+
+	// Act
+	_, _, _, err := queueService.OpenParentResult(taskID, result.ID)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerNotFound{}, err)
+	ut.FileService.AssertNotCalled(t, "OpenFile", mock.Anything)
 }
@@ -0,0 +1,110 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mytkom/AliceTraINT/internal/config"
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func taskIds(tasks []models.TrainingTask) []uint {
+	ids := make([]uint, len(tasks))
+	for i, tt := range tasks {
+		ids[i] = tt.ID
+	}
+	return ids
+}
+
+func queuedTask(id, userId uint, priority int, createdAt time.Time) models.TrainingTask {
+	return models.TrainingTask{
+		Model:    gorm.Model{ID: id, CreatedAt: createdAt},
+		UserId:   userId,
+		Status:   models.Queued,
+		Priority: priority,
+	}
+}
+
+func TestNewSchedulingPolicy(t *testing.T) {
+	ttRepo := repository.NewMockTrainingTaskRepository()
+
+	assert.IsType(t, &service.FIFOPolicy{}, service.NewSchedulingPolicy(config.QueueConfig{}, ttRepo))
+	assert.IsType(t, &service.FIFOPolicy{}, service.NewSchedulingPolicy(config.QueueConfig{SchedulingPolicy: "fifo"}, ttRepo))
+	assert.IsType(t, &service.PriorityAgingPolicy{}, service.NewSchedulingPolicy(config.QueueConfig{SchedulingPolicy: "priority"}, ttRepo))
+	assert.IsType(t, &service.FairSharePolicy{}, service.NewSchedulingPolicy(config.QueueConfig{SchedulingPolicy: "fair-share"}, ttRepo))
+}
+
+func TestFIFOPolicy_Order(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	tasks := []models.TrainingTask{
+		queuedTask(1, 1, 10, now.Add(-time.Minute)),
+		queuedTask(2, 1, 0, now.Add(-time.Hour)),
+	}
+
+	// Act
+	ordered, err := (&service.FIFOPolicy{}).Order(tasks, now)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{2, 1}, taskIds(ordered))
+}
+
+func TestPriorityAgingPolicy_Order(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	policy := &service.PriorityAgingPolicy{AgingInterval: time.Hour}
+	tasks := []models.TrainingTask{
+		queuedTask(1, 1, 0, now.Add(-time.Minute)),
+		queuedTask(2, 1, 2, now.Add(-time.Minute)),
+		queuedTask(3, 1, 0, now.Add(-5*time.Hour)), // aged to priority 5
+		queuedTask(4, 1, 2, now.Add(-2*time.Minute)),
+	}
+
+	// Act
+	ordered, err := policy.Order(tasks, now)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{3, 4, 2, 1}, taskIds(ordered))
+}
+
+func TestFairSharePolicy_Order(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	ttRepo := repository.NewMockTrainingTaskRepository()
+	policy := &service.FairSharePolicy{TrainingTask: ttRepo, Window: 24 * time.Hour}
+
+	longAgo := now.Add(-48 * time.Hour)
+	tenHoursAgo := now.Add(-10 * time.Hour)
+	twoHoursAgo := now.Add(-2 * time.Hour)
+	hourAgo := now.Add(-time.Hour)
+	assigned := []models.TrainingTask{
+		// user 1: 10h in window, still running
+		{UserId: 1, AssignedAt: &tenHoursAgo},
+		// user 2: 1h within window
+		{UserId: 2, AssignedAt: &twoHoursAgo, FinishedAt: &hourAgo},
+		// user 3: 24h counted only from the window start
+		{UserId: 3, AssignedAt: &longAgo},
+	}
+	ttRepo.On("GetAssignedSince", now.Add(-24*time.Hour)).Return(assigned, nil)
+
+	tasks := []models.TrainingTask{
+		queuedTask(1, 1, 0, now.Add(-5*time.Hour)),
+		queuedTask(2, 3, 0, now.Add(-4*time.Hour)),
+		queuedTask(3, 2, 0, now.Add(-3*time.Hour)),
+		queuedTask(4, 2, 5, now.Add(-time.Minute)),
+		queuedTask(5, 4, 0, now.Add(-time.Minute)),
+	}
+
+	// Act
+	ordered, err := policy.Order(tasks, now)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{5, 4, 3, 1, 2}, taskIds(ordered))
+}
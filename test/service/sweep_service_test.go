@@ -0,0 +1,262 @@
+package service_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mytkom/AliceTraINT/internal/db/models"
+	"github.com/mytkom/AliceTraINT/internal/db/repository"
+	"github.com/mytkom/AliceTraINT/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type sweepServiceTestUtils struct {
+	SweepRepo *repository.MockSweepRepository
+	TDRepo    *repository.MockTrainingDatasetRepository
+	TSERepo   *repository.MockTrainingTaskStatusEventRepository
+	TTMRepo   *repository.MockTrainingTaskMetricRepository
+}
+
+func newSweepService() (*service.SweepService, *sweepServiceTestUtils) {
+	sweepRepo := repository.NewMockSweepRepository()
+	sweepRepo.On("Create", mock.AnythingOfType("*models.Sweep")).Run(func(args mock.Arguments) {
+		sweep := args.Get(0).(*models.Sweep)
+		sweep.ID = 1
+		for i := range sweep.TrainingTasks {
+			sweep.TrainingTasks[i].ID = uint(i + 1)
+		}
+	}).Return(nil)
+	tdRepo := repository.NewMockTrainingDatasetRepository()
+	tdRepo.On("GetByID").Return(&models.TrainingDataset{Name: "LHC24f3"}, nil)
+	tseRepo := repository.NewMockTrainingTaskStatusEventRepository()
+	tseRepo.On("Create", mock.AnythingOfType("*models.TrainingTaskStatusEvent")).Return(nil)
+	ttmRepo := repository.NewMockTrainingTaskMetricRepository()
+	nnArch := service.NewNNArchServiceInMemory(&service.NNFieldConfigs{
+		"bs": service.NNConfigField{
+			FullName:     "Batch Size",
+			Type:         "uint",
+			DefaultValue: uint(512),
+			Min:          uint(128),
+			Max:          uint(1024),
+			Step:         uint(1),
+		},
+		"layers": service.NNConfigField{
+			FullName:     "Layers",
+			Type:         "uint",
+			DefaultValue: uint(2),
+			Min:          uint(1),
+			Max:          uint(4),
+			Step:         uint(1),
+		},
+		"dropout": service.NNConfigField{
+			FullName:     "Dropout Rate",
+			Type:         "float64",
+			DefaultValue: 0.1,
+			Min:          0.0,
+			Max:          1.0,
+			Step:         0.01,
+		},
+		"undersample": service.NNConfigField{
+			FullName:     "Undersample",
+			Type:         "bool",
+			DefaultValue: false,
+		},
+		"optimizer": service.NNConfigField{
+			FullName:     "Optimizer",
+			Type:         "string",
+			DefaultValue: "adam",
+		},
+	}, &service.NNExpectedResults{})
+
+	return service.NewSweepService(&repository.RepositoryContext{
+		Sweep:                   sweepRepo,
+		TrainingDataset:         tdRepo,
+		TrainingTaskStatusEvent: tseRepo,
+		TrainingTaskMetric:      ttmRepo,
+	}, nnArch, service.NewTaskNotifier()), &sweepServiceTestUtils{
+		SweepRepo: sweepRepo,
+		TDRepo:    tdRepo,
+		TSERepo:   tseRepo,
+		TTMRepo:   ttmRepo,
+	}
+}
+
+func newSweep(method string, samples uint, fields ...string) *models.Sweep {
+	return &models.Sweep{
+		Name:              "bs-sweep",
+		UserId:            1,
+		TrainingDatasetId: 1,
+		Method:            method,
+		Samples:           samples,
+		Seed:              42,
+		Fields:            fields,
+		Configuration:     map[string]interface{}{"bs": 512, "optimizer": "sgd"},
+		Metric:            "val_loss",
+	}
+}
+
+func taskConfig(tt models.TrainingTask) map[string]interface{} {
+	return tt.Configuration.(map[string]interface{})
+}
+
+func TestSweepService_Create_Grid(t *testing.T) {
+	// Arrange
+	sweepService, ut := newSweepService()
+	sweep := newSweep(models.SweepGrid, 3, "bs", "undersample")
+
+	// Act
+	err := sweepService.Create(sweep)
+
+	// Assert
+	assert.NoError(t, err)
+	ut.SweepRepo.AssertCalled(t, "Create", sweep)
+	ut.TSERepo.AssertNumberOfCalls(t, "Create", 6)
+	assert.Len(t, sweep.TrainingTasks, 6)
+
+	var points [][2]interface{}
+	for i, tt := range sweep.TrainingTasks {
+		assert.Equal(t, models.Queued, tt.Status)
+		assert.Equal(t, sweep.TrainingDatasetId, tt.TrainingDatasetId)
+		assert.Equal(t, fmt.Sprintf("bs-sweep-%d", i+1), tt.Name)
+		assert.Equal(t, "sgd", taskConfig(tt)["optimizer"])
+		points = append(points, [2]interface{}{taskConfig(tt)["bs"], taskConfig(tt)["undersample"]})
+	}
+	assert.Equal(t, [][2]interface{}{
+		{int64(128), false}, {int64(128), true},
+		{int64(576), false}, {int64(576), true},
+		{int64(1024), false}, {int64(1024), true},
+	}, points)
+}
+
+func TestSweepService_Create_LatinHypercube(t *testing.T) {
+	// Arrange
+	sweepService, _ := newSweepService()
+	sweep := newSweep(models.SweepLatinHypercube, 4, "layers", "dropout")
+
+	// Act
+	err := sweepService.Create(sweep)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, sweep.TrainingTasks, 4)
+
+	// every stratum of a field with as many levels as samples holds one task
+	var layers []interface{}
+	for _, tt := range sweep.TrainingTasks {
+		layers = append(layers, taskConfig(tt)["layers"])
+		dropout := taskConfig(tt)["dropout"].(float64)
+		assert.GreaterOrEqual(t, dropout, 0.0)
+		assert.LessOrEqual(t, dropout, 1.0)
+	}
+	assert.ElementsMatch(t, []interface{}{int64(1), int64(2), int64(3), int64(4)}, layers)
+}
+
+func TestSweepService_Create_RandomIsSeeded(t *testing.T) {
+	// Arrange
+	sweepService, _ := newSweepService()
+	first := newSweep(models.SweepRandom, 5, "bs", "dropout")
+	second := newSweep(models.SweepRandom, 5, "bs", "dropout")
+	other := newSweep(models.SweepRandom, 5, "bs", "dropout")
+	other.Seed = 7
+
+	// Act
+	assert.NoError(t, sweepService.Create(first))
+	assert.NoError(t, sweepService.Create(second))
+	assert.NoError(t, sweepService.Create(other))
+
+	// Assert
+	assert.Len(t, first.TrainingTasks, 5)
+	for i := range first.TrainingTasks {
+		assert.Equal(t, first.TrainingTasks[i].Configuration, second.TrainingTasks[i].Configuration)
+	}
+	assert.NotEqual(t, first.TrainingTasks[0].Configuration, other.TrainingTasks[0].Configuration)
+}
+
+func TestSweepService_Create_Invalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		sweep *models.Sweep
+		field string
+	}{
+		{"unknown method", newSweep("bayes", 3, "bs"), "Method"},
+		{"no samples", newSweep(models.SweepRandom, 0, "bs"), "Samples"},
+		{"no fields", newSweep(models.SweepRandom, 3), "Fields"},
+		{"unknown field", newSweep(models.SweepRandom, 3, "lr"), "Fields"},
+		{"not sweepable field", newSweep(models.SweepRandom, 3, "optimizer"), "Fields"},
+		{"too many tasks", newSweep(models.SweepGrid, 20, "bs", "dropout"), "Samples"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			sweepService, ut := newSweepService()
+
+			// Act
+			err := sweepService.Create(tc.sweep)
+
+			// Assert
+			if assert.IsType(t, &service.ErrHandlerValidation{}, err) {
+				assert.Equal(t, tc.field, err.(*service.ErrHandlerValidation).Field)
+			}
+			ut.SweepRepo.AssertNotCalled(t, "Create", mock.Anything)
+		})
+	}
+}
+
+func TestSweepService_GetRanking(t *testing.T) {
+	// Arrange
+	sweepService, ut := newSweepService()
+	sweep := &models.Sweep{
+		Name:     "sweep",
+		Fields:   []string{"bs"},
+		Metric:   "val_loss",
+		Maximize: false,
+		TrainingTasks: []models.TrainingTask{
+			{Name: "sweep-1", Configuration: map[string]interface{}{"bs": 128.0}},
+			{Name: "sweep-2", Configuration: map[string]interface{}{"bs": 512.0}, Retries: 1},
+			{Name: "sweep-3", Configuration: map[string]interface{}{"bs": 1024.0}},
+		},
+	}
+	for i := range sweep.TrainingTasks {
+		sweep.TrainingTasks[i].ID = uint(i + 1)
+	}
+	ut.SweepRepo.On("GetByID", uint(1)).Return(sweep, nil)
+	ut.TTMRepo.On("GetAll", uint(1), uint(0)).Return([]models.TrainingTaskMetric{
+		{Step: 1, Name: "val_loss", Value: 0.5},
+		{Step: 1, Name: "accuracy", Value: 0.7},
+		{Step: 2, Name: "val_loss", Value: 0.3},
+	}, nil)
+	ut.TTMRepo.On("GetAll", uint(2), uint(1)).Return([]models.TrainingTaskMetric{
+		{Step: 1, Name: "val_loss", Value: 0.2},
+		{Step: 1, Name: "accuracy", Value: 0.9},
+	}, nil)
+	ut.TTMRepo.On("GetAll", uint(3), uint(0)).Return([]models.TrainingTaskMetric{}, nil)
+
+	// Act
+	ranking, err := sweepService.GetRanking(1, "", nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "val_loss", ranking.Metric)
+	assert.Equal(t, []string{"accuracy", "val_loss"}, ranking.Metrics)
+	assert.Len(t, ranking.Entries, 3)
+	assert.Equal(t, "sweep-2", ranking.Entries[0].TrainingTask.Name)
+	assert.Equal(t, uint(1), ranking.Entries[0].Rank)
+	assert.Equal(t, 512.0, ranking.Entries[0].Params["bs"])
+	assert.Equal(t, "sweep-1", ranking.Entries[1].TrainingTask.Name)
+	assert.Equal(t, 0.3, *ranking.Entries[1].Value)
+	assert.Equal(t, "sweep-3", ranking.Entries[2].TrainingTask.Name)
+	assert.Equal(t, uint(0), ranking.Entries[2].Rank)
+	assert.Nil(t, ranking.Entries[2].Value)
+
+	// Act
+	maximize := true
+	ranking, err = sweepService.GetRanking(1, "accuracy", &maximize)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "sweep-2", ranking.Entries[0].TrainingTask.Name)
+	assert.Equal(t, "sweep-1", ranking.Entries[1].TrainingTask.Name)
+	assert.Nil(t, ranking.Entries[2].Value)
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mytkom/AliceTraINT/internal/config"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
 	"github.com/mytkom/AliceTraINT/internal/db/repository"
 	"github.com/mytkom/AliceTraINT/internal/service"
@@ -12,19 +13,23 @@ import (
 )
 
 type trainingMachineServiceTestUtils struct {
-	TMRepo *repository.MockTrainingMachineRepository
-	Hasher *service.MockHasher
+	TMRepo  *repository.MockTrainingMachineRepository
+	TMTRepo *repository.MockTrainingMachineTelemetryRepository
+	Hasher  *service.MockHasher
 }
 
 func newTrainingMachineService() (*service.TrainingMachineService, *trainingMachineServiceTestUtils) {
 	tmRepo := repository.NewMockTrainingMachineRepository()
+	tmtRepo := repository.NewMockTrainingMachineTelemetryRepository()
 	hasher := service.NewMockHasher()
 
 	return service.NewTrainingMachineService(&repository.RepositoryContext{
-			TrainingMachine: tmRepo,
-		}, hasher), &trainingMachineServiceTestUtils{
-			TMRepo: tmRepo,
-			Hasher: hasher,
+			TrainingMachine:          tmRepo,
+			TrainingMachineTelemetry: tmtRepo,
+		}, hasher, config.MachineAuthConfig{SecretGraceMinutes: 60}), &trainingMachineServiceTestUtils{
+			TMRepo:  tmRepo,
+			TMTRepo: tmtRepo,
+			Hasher:  hasher,
 		}
 }
 
@@ -96,3 +101,97 @@ func TestTrainingMachineService_Create(t *testing.T) {
 	assert.Equal(t, "secret", secretKey)
 	assert.Equal(t, "secretHashed", tm.SecretKeyHashed)
 }
+
+func TestTrainingMachineService_RotateSecret(t *testing.T) {
+	// Arrange
+	tmService, ut := newTrainingMachineService()
+	lastUsedAt := time.Now().Add(-time.Minute)
+	tm := &models.TrainingMachine{Name: "awm1", UserId: 1, SecretKeyHashed: "hashed_old", SecretKeyLastUsedAt: &lastUsedAt}
+	ut.TMRepo.On("GetByID", uint(1)).Return(tm, nil)
+	ut.TMRepo.On("UpdateColumns", uint(0), map[string]interface{}{"secret_key_hashed": "hashed_old"}, mock.Anything).Return(true, nil)
+	ut.Hasher.On("GenerateKey").Return("new_secret", nil)
+	ut.Hasher.On("HashKey", "new_secret").Return("hashed_new", nil)
+
+	// Act
+	rotated, secretKey, err := tmService.RotateSecret(1, 1)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "new_secret", secretKey)
+	assert.Equal(t, "hashed_new", rotated.SecretKeyHashed)
+	assert.Nil(t, rotated.SecretKeyLastUsedAt)
+	assert.NotNil(t, rotated.SecretKeyRotatedAt)
+	assert.Equal(t, "hashed_old", rotated.PreviousSecretKeyHashed)
+	assert.Equal(t, &lastUsedAt, rotated.PreviousSecretKeyLastUsedAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *rotated.PreviousSecretKeyExpiresAt, time.Minute)
+	assert.True(t, rotated.PreviousSecretKeyValid(time.Now()))
+	ut.TMRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestTrainingMachineService_RotateSecret_Concurrent(t *testing.T) {
+	// Arrange
+	tmService, ut := newTrainingMachineService()
+	tm := &models.TrainingMachine{Name: "awm1", UserId: 1, SecretKeyHashed: "hashed_old"}
+	ut.TMRepo.On("GetByID", uint(1)).Return(tm, nil)
+	ut.TMRepo.On("UpdateColumns", uint(0), mock.Anything, mock.Anything).Return(false, nil)
+	ut.Hasher.On("GenerateKey").Return("new_secret", nil)
+	ut.Hasher.On("HashKey", "new_secret").Return("hashed_new", nil)
+
+	// Act
+	_, _, err := tmService.RotateSecret(1, 1)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	assert.Equal(t, "hashed_old", tm.SecretKeyHashed)
+}
+
+func TestTrainingMachineService_RotateSecret_NotOwner(t *testing.T) {
+	// Arrange
+	tmService, ut := newTrainingMachineService()
+	ut.TMRepo.On("GetByID", uint(1)).Return(&models.TrainingMachine{Name: "awm1", UserId: 2, SecretKeyHashed: "hashed_old"}, nil)
+
+	// Act
+	_, _, err := tmService.RotateSecret(1, 1)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerNotFound{}, err)
+	ut.Hasher.AssertNotCalled(t, "GenerateKey")
+	ut.TMRepo.AssertNotCalled(t, "UpdateColumns", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTrainingMachineService_GetTelemetry(t *testing.T) {
+	// Arrange
+	tmService, ut := newTrainingMachineService()
+	reportedAt := time.Now().Add(-5 * time.Minute)
+	tm := &models.TrainingMachine{Name: "awm1", LastActivityAt: time.Now(), LastTelemetryAt: &reportedAt}
+	tm.ID = 1
+	reports := []models.TrainingMachineTelemetry{
+		{CPUPercent: 10, MemoryUsedBytes: 1 << 30, MemoryTotalBytes: 4 << 30, DiskFreeBytes: 20 << 30},
+		{CPUPercent: 90, MemoryUsedBytes: 3 << 30, MemoryTotalBytes: 4 << 30, DiskFreeBytes: 10 << 30},
+	}
+	reports[0].CreatedAt = reportedAt.Add(-time.Minute)
+	reports[1].CreatedAt = reportedAt
+	ut.TMRepo.On("GetByID", uint(1)).Return(tm, nil)
+	ut.TMTRepo.On("GetSince", uint(1), mock.AnythingOfType("time.Time")).Return(reports, nil)
+
+	// Act
+	telemetry, err := tmService.GetTelemetry(1)
+
+	// Assert
+	assert.NoError(t, err)
+	// telemetry takes precedence over the more recent activity
+	assert.Equal(t, models.MachineStale, telemetry.Health)
+	assert.Equal(t, &reports[1], telemetry.Latest)
+	assert.Equal(t, reports[0].CreatedAt, telemetry.From)
+
+	var names []string
+	for _, chart := range telemetry.Charts {
+		names = append(names, chart.Name)
+	}
+	// machine without a GPU has no GPU chart
+	assert.Equal(t, []string{"CPU %", "Memory %", "Free disk GB"}, names)
+	assert.Equal(t, 90.0, telemetry.Charts[0].Last)
+	assert.Equal(t, "0.0,100.0 300.0,0.0", telemetry.Charts[0].Points)
+	assert.Equal(t, 75.0, telemetry.Charts[1].Last)
+	assert.Equal(t, 10.0, telemetry.Charts[2].Last)
+}
@@ -1,11 +1,15 @@
 package service_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/mytkom/AliceTraINT/internal/ccdb"
 	"github.com/mytkom/AliceTraINT/internal/db/models"
@@ -21,6 +25,12 @@ type trainingTaskServiceTestUtils struct {
 	TTRepo        *repository.MockTrainingTaskRepository
 	TDRepo        *repository.MockTrainingDatasetRepository
 	TTRRepo       *repository.MockTrainingTaskResultRepository
+	TMRepo        *repository.MockTrainingMachineRepository
+	TSERepo       *repository.MockTrainingTaskStatusEventRepository
+	TTARepo       *repository.MockTrainingTaskAttemptRepository
+	TTMRepo       *repository.MockTrainingTaskMetricRepository
+	TTLCRepo      *repository.MockTrainingTaskLogChunkRepository
+	TTDRepo       *repository.MockTrainingTaskDependencyRepository
 	CCDBService   *service.MockCCDBService
 	JAliEnService *service.MockJAliEnService
 	FileService   *service.MockFileService
@@ -31,6 +41,15 @@ func newTrainingTaskService() (*service.TrainingTaskService, *trainingTaskServic
 	ttRepo := repository.NewMockTrainingTaskRepository()
 	tdRepo := repository.NewMockTrainingDatasetRepository()
 	ttrRepo := repository.NewMockTrainingTaskResultRepository()
+	tmRepo := repository.NewMockTrainingMachineRepository()
+	tseRepo := repository.NewMockTrainingTaskStatusEventRepository()
+	tseRepo.On("Create", mock.AnythingOfType("*models.TrainingTaskStatusEvent")).Return(nil)
+	ttaRepo := repository.NewMockTrainingTaskAttemptRepository()
+	ttmRepo := repository.NewMockTrainingTaskMetricRepository()
+	ttlcRepo := repository.NewMockTrainingTaskLogChunkRepository()
+	ttdRepo := repository.NewMockTrainingTaskDependencyRepository()
+	ttdRepo.On("GetParents", mock.Anything).Return([]models.TrainingTask{}, nil)
+	ttdRepo.On("GetChildren", mock.Anything).Return([]models.TrainingTask{}, nil)
 	jalienService := service.NewMockJAliEnService()
 	ccdbService := service.NewMockCCDBService()
 	fileService := service.NewMockFileService()
@@ -51,13 +70,25 @@ func newTrainingTaskService() (*service.TrainingTaskService, *trainingTaskServic
 	})
 
 	return service.NewTrainingTaskService(&repository.RepositoryContext{
-			TrainingTask:       ttRepo,
-			TrainingDataset:    tdRepo,
-			TrainingTaskResult: ttrRepo,
-		}, ccdbService, jalienService, fileService, nnArch), &trainingTaskServiceTestUtils{
+			TrainingTask:            ttRepo,
+			TrainingDataset:         tdRepo,
+			TrainingTaskResult:      ttrRepo,
+			TrainingMachine:         tmRepo,
+			TrainingTaskStatusEvent: tseRepo,
+			TrainingTaskAttempt:     ttaRepo,
+			TrainingTaskMetric:      ttmRepo,
+			TrainingTaskLogChunk:    ttlcRepo,
+			TrainingTaskDependency:  ttdRepo,
+		}, ccdbService, jalienService, fileService, nnArch, service.NewTaskNotifier()), &trainingTaskServiceTestUtils{
 			TTRepo:        ttRepo,
 			TDRepo:        tdRepo,
 			TTRRepo:       ttrRepo,
+			TMRepo:        tmRepo,
+			TSERepo:       tseRepo,
+			TTARepo:       ttaRepo,
+			TTMRepo:       ttmRepo,
+			TTLCRepo:      ttlcRepo,
+			TTDRepo:       ttdRepo,
 			CCDBService:   ccdbService,
 			JAliEnService: jalienService,
 			FileService:   fileService,
@@ -75,15 +106,15 @@ func TestTrainingTaskService_GetAll_Global(t *testing.T) {
 		{Name: "task1", UserId: userId, Status: models.Queued, TrainingMachineId: nil, TrainingDatasetId: tdId, Configuration: ""},
 		{Name: "task2", UserId: userId, Status: models.Benchmarking, TrainingMachineId: &tmId, TrainingDatasetId: tdId, Configuration: ""},
 	}
-	ut.TTRepo.On("GetAll").Return(tts, nil)
+	ut.TTRepo.On("GetAll", repository.TrainingTaskFilter{}).Return(tts, nil)
 
 	// Act
-	tasks, err := ttService.GetAll(userId, false)
+	tasks, err := ttService.GetAll(userId, false, repository.TrainingTaskFilter{})
 
 	// Assert
 	assert.NoError(t, err)
-	ut.TTRepo.AssertCalled(t, "GetAll")
-	ut.TTRepo.AssertNotCalled(t, "GetAllUser", mock.Anything)
+	ut.TTRepo.AssertCalled(t, "GetAll", repository.TrainingTaskFilter{})
+	ut.TTRepo.AssertNotCalled(t, "GetAllUser", mock.Anything, mock.Anything)
 	assert.Equal(t, 2, len(tasks))
 	assert.Equal(t, tts[0].Name, tasks[0].Name)
 	assert.Equal(t, tts[1].Name, tasks[1].Name)
@@ -99,15 +130,15 @@ func TestTrainingTaskService_GetAll_UserScoped(t *testing.T) {
 		{Name: "task1", UserId: userId, Status: models.Queued, TrainingMachineId: nil, TrainingDatasetId: tdId, Configuration: ""},
 		{Name: "task2", UserId: userId, Status: models.Benchmarking, TrainingMachineId: &tmId, TrainingDatasetId: tdId, Configuration: ""},
 	}
-	ut.TTRepo.On("GetAllUser", userId).Return(tts, nil)
+	ut.TTRepo.On("GetAllUser", userId, repository.TrainingTaskFilter{}).Return(tts, nil)
 
 	// Act
-	tasks, err := ttService.GetAll(userId, true)
+	tasks, err := ttService.GetAll(userId, true, repository.TrainingTaskFilter{})
 
 	// Assert
 	assert.NoError(t, err)
 	ut.TTRepo.AssertNotCalled(t, "GetAll")
-	ut.TTRepo.AssertCalled(t, "GetAllUser", userId)
+	ut.TTRepo.AssertCalled(t, "GetAllUser", userId, repository.TrainingTaskFilter{})
 	assert.Equal(t, 2, len(tasks))
 	assert.Equal(t, tts[0].Name, tasks[0].Name)
 	assert.Equal(t, tts[1].Name, tasks[1].Name)
@@ -118,11 +149,13 @@ func TestTrainingTaskService_Create(t *testing.T) {
 	ttService, ut := newTrainingTaskService()
 	userId := uint(1)
 	tdId := uint(1)
+	tmId := uint(3)
 	tt := models.TrainingTask{
 		Name:              "task2",
 		UserId:            userId,
 		Status:            models.Failed, // must be changed to queued
 		TrainingDatasetId: tdId,
+		TrainingMachineId: &tmId, // must be reset
 		Configuration:     "",
 	}
 	ut.TTRepo.On("Create", &tt).Return(nil)
@@ -137,6 +170,26 @@ func TestTrainingTaskService_Create(t *testing.T) {
 	assert.Equal(t, (*uint)(nil), tt.TrainingMachineId)
 }
 
+func TestTrainingTaskService_GetAll_Filtered(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	userId := uint(1)
+	failed := models.Failed
+	filter := repository.TrainingTaskFilter{Status: &failed, FailureCode: "oom"}
+	tts := []models.TrainingTask{
+		{Name: "task1", UserId: userId, Status: models.Failed, Failure: models.TaskFailure{Code: "oom"}},
+	}
+	ut.TTRepo.On("GetAllUser", userId, filter).Return(tts, nil)
+
+	// Act
+	tasks, err := ttService.GetAll(userId, true, filter)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, tts, tasks)
+	ut.TTRepo.AssertCalled(t, "GetAllUser", userId, filter)
+}
+
 func TestTrainingTaskService_GetHelpers(t *testing.T) {
 	// Arrange
 	ttService, ut := newTrainingTaskService()
@@ -145,7 +198,16 @@ func TestTrainingTaskService_GetHelpers(t *testing.T) {
 		{Name: "LHC24b1b", UserId: userId, AODFiles: []jalien.AODFile{}},
 		{Name: "LHC24b1b2", UserId: userId, AODFiles: []jalien.AODFile{}},
 	}
+	tms := []models.TrainingMachine{
+		{Name: "local-gpu", UserId: userId},
+	}
+	tts := []models.TrainingTask{
+		{Name: "completed", UserId: userId, Status: models.Completed},
+		{Name: "cancelled", UserId: userId, Status: models.Cancelled},
+	}
 	ut.TDRepo.On("GetAllUser", userId).Return(tds, nil)
+	ut.TMRepo.On("GetAll").Return(tms, nil)
+	ut.TTRepo.On("GetAllUser", userId, repository.TrainingTaskFilter{}).Return(tts, nil)
 
 	// Act
 	helpers, err := ttService.GetHelpers(userId)
@@ -155,6 +217,8 @@ func TestTrainingTaskService_GetHelpers(t *testing.T) {
 	ut.TDRepo.AssertCalled(t, "GetAllUser", userId)
 	assert.Equal(t, tds[0].Name, helpers.TrainingDatasets[0].Name)
 	assert.Equal(t, tds[1].Name, helpers.TrainingDatasets[1].Name)
+	assert.Equal(t, tms, helpers.TrainingMachines)
+	assert.Equal(t, tts[:1], helpers.ParentCandidates)
 	assert.True(t, reflect.DeepEqual(helpers.FieldConfigs, ut.NNArch.FieldConfigs))
 }
 
@@ -172,8 +236,10 @@ func TestTrainingTaskService_GetByID_Queued(t *testing.T) {
 		Configuration:     "",
 	}
 	ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+	ut.TSERepo.On("GetAll", mock.Anything).Return([]models.TrainingTaskStatusEvent{}, nil)
 	ut.TTRepo.On("GetByType", ttId, models.Onnx).Return([]models.TrainingTaskResult{}, nil)
 	ut.TTRepo.On("GetByType", ttId, models.Image).Return([]models.TrainingTaskResult{}, nil)
+	ut.TMRepo.On("GetAll").Return([]models.TrainingMachine{}, nil)
 
 	// Act
 	ttWithRes, err := ttService.GetByID(ttId)
@@ -188,6 +254,68 @@ func TestTrainingTaskService_GetByID_Queued(t *testing.T) {
 	assert.Equal(t, []models.TrainingTaskResult(nil), ttWithRes.ImageFiles)
 }
 
+func TestTrainingTaskService_GetByID_SchedulingDiagnosis(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := models.TrainingTask{
+		Model:        gorm.Model{ID: ttId},
+		Name:         "task",
+		Status:       models.Queued,
+		Requirements: []string{"gpu_mem_gb>=24", "site=cern"},
+	}
+	tms := []models.TrainingMachine{
+		{Name: "big", Labels: map[string]string{"gpu_mem_gb": "40", "site": "cern"}},
+		{Name: "small", Labels: map[string]string{"gpu_mem_gb": "16", "site": "cern"}},
+		{Name: "unlabeled"},
+	}
+	ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+	ut.TSERepo.On("GetAll", mock.Anything).Return([]models.TrainingTaskStatusEvent{}, nil)
+	ut.TMRepo.On("GetAll").Return(tms, nil)
+
+	// Act
+	ttWithRes, err := ttService.GetByID(ttId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, ttWithRes.Scheduling.EligibleMachines, 1)
+	assert.Equal(t, "big", ttWithRes.Scheduling.EligibleMachines[0].Name)
+	assert.Len(t, ttWithRes.Scheduling.BlockedMachines, 2)
+	assert.Equal(t, []string{"requires gpu_mem_gb>=24, has gpu_mem_gb=16"}, ttWithRes.Scheduling.BlockedMachines[0].Reasons)
+	assert.Len(t, ttWithRes.Scheduling.BlockedMachines[1].Reasons, 2)
+}
+
+func TestTrainingTaskService_Create_InvalidRequirement(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	tt := models.TrainingTask{
+		Name:         "task",
+		Requirements: []string{"gpu_mem_gb>=lots"},
+	}
+
+	// Act
+	err := ttService.Create(&tt)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerValidation{}, err)
+	ut.TTRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestTrainingTaskService_Create_PriorityOutOfRange(t *testing.T) {
+	for _, priority := range []int{models.MinTaskPriority - 1, models.MaxTaskPriority + 1} {
+		// Arrange
+		ttService, ut := newTrainingTaskService()
+		tt := models.TrainingTask{Name: "task", Priority: priority}
+
+		// Act
+		err := ttService.Create(&tt)
+
+		// Assert
+		assert.Equal(t, &service.ErrHandlerValidation{Field: "Priority", Msg: "must be between -10 and 10"}, err)
+		ut.TTRepo.AssertNotCalled(t, "Create", mock.Anything)
+	}
+}
+
 func TestTrainingTaskService_GetByID_Training(t *testing.T) {
 	// Arrange
 	ttService, ut := newTrainingTaskService()
@@ -203,6 +331,7 @@ func TestTrainingTaskService_GetByID_Training(t *testing.T) {
 		Configuration:     "",
 	}
 	ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+	ut.TSERepo.On("GetAll", mock.Anything).Return([]models.TrainingTaskStatusEvent{}, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Onnx).Return([]models.TrainingTaskResult{}, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Image).Return([]models.TrainingTaskResult{}, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Log).Return([]models.TrainingTaskResult{}, nil)
@@ -246,6 +375,7 @@ func TestTrainingTaskService_GetByID_Benchmarking(t *testing.T) {
 		}, TrainingTaskId: ttId},
 	}
 	ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+	ut.TSERepo.On("GetAll", mock.Anything).Return([]models.TrainingTaskStatusEvent{}, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Onnx).Return(onnxFiles, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Image).Return([]models.TrainingTaskResult{}, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Log).Return(logFiles, nil)
@@ -294,6 +424,7 @@ func TestTrainingTaskService_GetByID_Completed(t *testing.T) {
 		}, TrainingTaskId: ttId},
 	}
 	ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+	ut.TSERepo.On("GetAll", mock.Anything).Return([]models.TrainingTaskStatusEvent{}, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Onnx).Return(onnxFiles, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Image).Return(imageFiles, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Log).Return(logFiles, nil)
@@ -342,6 +473,7 @@ func TestTrainingTaskService_GetByID_Uploaded(t *testing.T) {
 		}, TrainingTaskId: ttId},
 	}
 	ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+	ut.TSERepo.On("GetAll", mock.Anything).Return([]models.TrainingTaskStatusEvent{}, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Onnx).Return(onnxFiles, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Image).Return(imageFiles, nil)
 	ut.TTRRepo.On("GetByType", ttId, models.Log).Return(logFiles, nil)
@@ -423,7 +555,7 @@ func TestTrainingTaskService_UploadToCCDB_OnePeriod(t *testing.T) {
 	ut.CCDBService.On("UploadFile", uint64(now-10000), uint64(now+10000), "uploaded_file.onnx", file).Return(nil)
 
 	// Act
-	err := ttService.UploadOnnxResults(ttId)
+	err := ttService.UploadOnnxResults(ttId, userId)
 
 	// Assert
 	assert.NoError(t, err)
@@ -550,7 +682,7 @@ func TestTrainingTaskService_UploadToCCDB_ManyPeriods(t *testing.T) {
 	ut.CCDBService.On("UploadFile", uint64(now-20000), uint64(now+10000), "uploaded_file.onnx", file).Return(nil)
 
 	// Act
-	err := ttService.UploadOnnxResults(ttId)
+	err := ttService.UploadOnnxResults(ttId, userId)
 
 	// Assert
 	assert.NoError(t, err)
@@ -633,7 +765,7 @@ func TestTrainingTaskService_UploadToCCDB_MissingExpectedFile(t *testing.T) {
 	ut.CCDBService.On("UploadFile", uint64(now-10000), uint64(now+10000), "uploaded_file.onnx", file).Return(nil)
 
 	// Act
-	err := ttService.UploadOnnxResults(ttId)
+	err := ttService.UploadOnnxResults(ttId, userId)
 
 	// Assert
 	assert.Error(t, err)
@@ -706,7 +838,7 @@ func TestTrainingTaskService_UploadToCCDB_ErrorReadingFile(t *testing.T) {
 	ut.CCDBService.On("UploadFile", uint64(now-10000), uint64(now+10000), "uploaded_file.onnx", file).Return(nil)
 
 	// Act
-	err := ttService.UploadOnnxResults(ttId)
+	err := ttService.UploadOnnxResults(ttId, userId)
 
 	// Assert
 	assert.Error(t, err)
@@ -720,3 +852,575 @@ func TestTrainingTaskService_UploadToCCDB_ErrorReadingFile(t *testing.T) {
 	ut.CCDBService.AssertCalled(t, "GetRunInformation", uint64(321500))
 	ut.CCDBService.AssertNotCalled(t, "UploadFile", uint64(now-10000), uint64(now+10000), "uploaded_file.onnx", file)
 }
+
+func TestTrainingTaskService_Create_PinnedMachineNotFound(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	tmId := uint(7)
+	tt := models.TrainingTask{
+		Name:                    "task",
+		PinnedTrainingMachineId: &tmId,
+	}
+	ut.TMRepo.On("GetByID", tmId).Return((*models.TrainingMachine)(nil), gorm.ErrRecordNotFound)
+
+	// Act
+	err := ttService.Create(&tt)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerValidation{}, err)
+	ut.TTRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestTrainingTaskService_GetByID_Timeline(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := models.TrainingTask{
+		Model:  gorm.Model{ID: ttId},
+		Name:   "task",
+		Status: models.Training,
+	}
+	queued := models.Queued
+	createdAt := time.Now().Add(-time.Hour)
+	events := []models.TrainingTaskStatusEvent{
+		{Model: gorm.Model{CreatedAt: createdAt}, TrainingTaskId: ttId, ToStatus: models.Queued},
+		{Model: gorm.Model{CreatedAt: createdAt.Add(10 * time.Minute)}, TrainingTaskId: ttId, FromStatus: &queued, ToStatus: models.Training},
+	}
+	ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+	ut.TTRRepo.On("GetByType", ttId, mock.Anything).Return([]models.TrainingTaskResult{}, nil)
+	ut.TSERepo.On("GetAll", ttId).Return(events, nil)
+
+	// Act
+	result, err := ttService.GetByID(ttId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, result.Timeline, 2)
+	assert.Equal(t, 10*time.Minute, result.Timeline[0].Duration)
+	assert.False(t, result.Timeline[0].Ongoing)
+	assert.True(t, result.Timeline[1].Ongoing)
+	assert.InDelta(t, 50*time.Minute, result.Timeline[1].Duration, float64(time.Second))
+}
+
+func TestTrainingTaskService_GetByID_PreviousAttempts(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := models.TrainingTask{
+		Model:   gorm.Model{ID: ttId},
+		Name:    "task",
+		Status:  models.Training,
+		Retries: 1,
+	}
+	oldLog := models.TrainingTaskResult{Name: "train.log", Type: models.Log, TrainingTaskId: ttId, Retry: 0}
+	oldImage := models.TrainingTaskResult{Name: "loss.png", Type: models.Image, TrainingTaskId: ttId, Retry: 0}
+	newLog := models.TrainingTaskResult{Name: "train.log", Type: models.Log, TrainingTaskId: ttId, Retry: 1}
+	attempts := []models.TrainingTaskAttempt{
+		{TrainingTaskId: ttId, Retry: 0, Failure: models.TaskFailure{Code: "oom"}},
+	}
+	ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+	ut.TSERepo.On("GetAll", ttId).Return([]models.TrainingTaskStatusEvent{}, nil)
+	ut.TTRRepo.On("GetByType", ttId, models.Image).Return([]models.TrainingTaskResult{oldImage}, nil)
+	ut.TTRRepo.On("GetByType", ttId, models.Log).Return([]models.TrainingTaskResult{newLog, oldLog}, nil)
+	ut.TTRRepo.On("GetAll", ttId).Return([]models.TrainingTaskResult{newLog, oldLog, oldImage}, nil)
+	ut.TTARepo.On("GetAll", ttId).Return(attempts, nil)
+
+	// Act
+	result, err := ttService.GetByID(ttId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []models.TrainingTaskResult{newLog}, result.LogFiles)
+	assert.Empty(t, result.ImageFiles)
+	assert.Len(t, result.PreviousAttempts, 1)
+	assert.Equal(t, "oom", result.PreviousAttempts[0].Attempt.Failure.Code)
+	assert.Equal(t, []models.TrainingTaskResult{oldLog}, result.PreviousAttempts[0].LogFiles)
+	assert.Equal(t, []models.TrainingTaskResult{oldImage}, result.PreviousAttempts[0].ImageFiles)
+}
+
+func TestTrainingTaskService_GetTimeInStateStats(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	queued := models.Queued
+	training := models.Training
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.Add(-24 * time.Hour)
+	at := func(day time.Time, minutes int) gorm.Model {
+		return gorm.Model{CreatedAt: day.Add(time.Duration(minutes) * time.Minute)}
+	}
+	events := []models.TrainingTaskStatusEvent{
+		// task 1 waited 10 minutes yesterday and trained for 60 minutes
+		{Model: at(yesterday, 0), TrainingTaskId: 1, ToStatus: models.Queued},
+		{Model: at(yesterday, 10), TrainingTaskId: 1, FromStatus: &queued, ToStatus: models.Training},
+		{Model: at(yesterday, 70), TrainingTaskId: 1, FromStatus: &training, ToStatus: models.Failed},
+		// task 2 waited 30 minutes today and is still training
+		{Model: at(today, 0), TrainingTaskId: 2, ToStatus: models.Queued},
+		{Model: at(today, 30), TrainingTaskId: 2, FromStatus: &queued, ToStatus: models.Training},
+	}
+	ut.TSERepo.On("GetSince", yesterday).Return(events, nil)
+
+	// Act
+	stats, err := ttService.GetTimeInStateStats(2)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, yesterday, stats.Since)
+
+	queuedStats := stats.Overall[0]
+	assert.Equal(t, models.Queued, queuedStats.Status)
+	assert.Equal(t, 2, queuedStats.Count)
+	assert.Equal(t, 20*time.Minute, queuedStats.Mean)
+	assert.Equal(t, 30*time.Minute, queuedStats.Max)
+
+	trainingStats := stats.Overall[1]
+	assert.Equal(t, 1, trainingStats.Count)
+	assert.Equal(t, time.Hour, trainingStats.Median)
+
+	assert.Len(t, stats.Daily, 2)
+	assert.Equal(t, 10*time.Minute, stats.Daily[0].States[0].Median)
+	assert.Equal(t, 30*time.Minute, stats.Daily[1].States[0].Median)
+	assert.Equal(t, 0, stats.Daily[1].States[1].Count)
+}
+
+func TestTrainingTaskService_Cancel_Running(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	userId := uint(3)
+	tmId := uint(2)
+	leaseExpiresAt := time.Now().Add(time.Minute)
+	tt := &models.TrainingTask{
+		Model:             gorm.Model{ID: ttId},
+		UserId:            userId,
+		Status:            models.Training,
+		TrainingMachineId: &tmId,
+		LeaseExpiresAt:    &leaseExpiresAt,
+	}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+	ut.TTRepo.On("UpdateStatus", ttId, models.Training, mock.Anything).Return(true, nil)
+
+	// Act
+	err := ttService.Cancel(ttId, userId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cancelled, tt.Status)
+	assert.Equal(t, &tmId, tt.TrainingMachineId)
+	assert.Nil(t, tt.LeaseExpiresAt)
+	assert.NotNil(t, tt.FinishedAt)
+	ut.TSERepo.AssertCalled(t, "Create", mock.MatchedBy(func(event *models.TrainingTaskStatusEvent) bool {
+		return *event.FromStatus == models.Training && event.ToStatus == models.Cancelled && *event.UserId == userId
+	}))
+	ut.TTRepo.AssertCalled(t, "UpdateStatus", ttId, models.Training, mock.MatchedBy(func(columns map[string]interface{}) bool {
+		return columns["status"] == models.Cancelled && columns["lease_expires_at"] == nil
+	}))
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestTrainingTaskService_Cancel_NotOwner(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, UserId: 3, Status: models.Queued}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+
+	// Act
+	err := ttService.Cancel(ttId, 4)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerNotFound{}, err)
+	assert.Equal(t, models.Queued, tt.Status)
+	ut.TTRepo.AssertNotCalled(t, "UpdateStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTrainingTaskService_Cancel_StatusChanged(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, UserId: 1, Status: models.Training}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+	// the machine reported another status after the task was read
+	ut.TTRepo.On("UpdateStatus", ttId, models.Training, mock.Anything).Return(false, nil)
+
+	// Act
+	err := ttService.Cancel(ttId, 1)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	assert.Equal(t, models.Training, tt.Status)
+	ut.TSERepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestTrainingTaskService_Cancel_Finished(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, UserId: 1, Status: models.Uploaded}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+
+	// Act
+	err := ttService.Cancel(ttId, 1)
+
+	// Assert
+	assert.IsType(t, &service.ErrHandlerConflict{}, err)
+	assert.Equal(t, models.Uploaded, tt.Status)
+	ut.TTRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestTrainingTaskService_GetMetricCharts(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, Status: models.Training, Retries: 1}
+	metrics := []models.TrainingTaskMetric{
+		{TrainingTaskId: ttId, Retry: 1, Step: 0, Name: "loss", Value: 1.0},
+		{TrainingTaskId: ttId, Retry: 1, Step: 0, Name: "lr", Value: 0.01},
+		{TrainingTaskId: ttId, Retry: 1, Step: 50, Name: "loss", Value: 0.5},
+		{TrainingTaskId: ttId, Retry: 1, Step: 100, Name: "loss", Value: 0.0},
+	}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+	ut.TTMRepo.On("GetAll", ttId, uint(1)).Return(metrics, nil)
+
+	// Act
+	result, err := ttService.GetMetricCharts(ttId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, tt, result.TrainingTask)
+	if assert.Len(t, result.Charts, 2) {
+		loss := result.Charts[0]
+		assert.Equal(t, "loss", loss.Name)
+		assert.Equal(t, 3, loss.Count)
+		assert.Equal(t, 0.0, loss.Last)
+		assert.Equal(t, 0.0, loss.Min)
+		assert.Equal(t, 1.0, loss.Max)
+		assert.Equal(t, uint(100), loss.LastStep)
+		assert.Equal(t, "0.0,0.0 150.0,50.0 300.0,100.0", loss.Points)
+
+		lr := result.Charts[1]
+		assert.Equal(t, "lr", lr.Name)
+		assert.Equal(t, "150.0,50.0", lr.Points)
+	}
+}
+
+func TestTrainingTaskService_GetMetricCharts_Downsampled(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, Status: models.Completed}
+	var metrics []models.TrainingTaskMetric
+	for step := uint(0); step < 1001; step++ {
+		metrics = append(metrics, models.TrainingTaskMetric{TrainingTaskId: ttId, Step: step, Name: "loss", Value: float64(step)})
+	}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+	ut.TTMRepo.On("GetAll", ttId, uint(0)).Return(metrics, nil)
+
+	// Act
+	result, err := ttService.GetMetricCharts(ttId)
+
+	// Assert
+	assert.NoError(t, err)
+	if assert.Len(t, result.Charts, 1) {
+		points := strings.Fields(result.Charts[0].Points)
+		assert.LessOrEqual(t, len(points), 501)
+		assert.Equal(t, "300.0,0.0", points[len(points)-1])
+		assert.Equal(t, 1001, result.Charts[0].Count)
+	}
+}
+
+func TestTrainingTaskService_GetLog_Offset(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, Status: models.Training}
+	chunks := []models.TrainingTaskLogChunk{
+		{TrainingTaskId: ttId, Offset: 0, Size: 6, Data: []byte("hello\n")},
+		{TrainingTaskId: ttId, Offset: 6, Size: 6, Data: []byte("world\n")},
+	}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+	ut.TTLCRepo.On("GetSize", ttId, uint(0)).Return(int64(12), nil)
+	ut.TTLCRepo.On("GetFrom", ttId, uint(0), int64(3), mock.Anything).Return(chunks, nil)
+
+	// Act
+	offset := int64(3)
+	log, err := ttService.GetLog(ttId, &offset)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "lo\nworld\n", log.Data)
+	assert.Equal(t, int64(12), log.Offset)
+	assert.False(t, log.More)
+	assert.False(t, log.Truncated)
+}
+
+func TestTrainingTaskService_GetLog_Tail(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, Status: models.Completed}
+	// multi-byte characters make the tail start inside of one
+	data := []byte(strings.Repeat("€", 50<<10))
+	size := int64(len(data))
+	chunks := []models.TrainingTaskLogChunk{
+		{TrainingTaskId: ttId, Offset: 0, Size: size, Data: data},
+	}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+	ut.TTLCRepo.On("GetSize", ttId, uint(0)).Return(size, nil)
+	ut.TTLCRepo.On("GetFrom", ttId, uint(0), size-64<<10, mock.Anything).Return(chunks, nil)
+
+	// Act
+	log, err := ttService.GetLog(ttId, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, log.Truncated)
+	assert.True(t, utf8.ValidString(log.Data))
+	assert.Equal(t, size, log.Offset)
+	assert.Equal(t, int64(64<<10-1), int64(len(log.Data)))
+	assert.False(t, log.More)
+}
+
+func TestTrainingTaskService_GetLog_Empty(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(1)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, Status: models.Queued}
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+	ut.TTLCRepo.On("GetSize", ttId, uint(0)).Return(int64(0), nil)
+
+	// Act
+	log, err := ttService.GetLog(ttId, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Empty(t, log.Data)
+	assert.Zero(t, log.Offset)
+	ut.TTLCRepo.AssertNotCalled(t, "GetFrom", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTrainingTaskService_UploadToCCDB_VerifiesChecksum(t *testing.T) {
+	content := "onnx model"
+	digest := sha256.Sum256([]byte(content))
+	otherDigest := sha256.Sum256([]byte("modified onnx model"))
+
+	tests := []struct {
+		name     string
+		checksum string
+		uploaded bool
+	}{
+		{"matching checksum", hex.EncodeToString(digest[:]), true},
+		{"modified file", hex.EncodeToString(otherDigest[:]), false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			ttService, ut := newTrainingTaskService()
+			ttId := uint(1)
+			tt := models.TrainingTask{
+				Model:  gorm.Model{ID: ttId},
+				Status: models.Completed,
+				TrainingDataset: models.TrainingDataset{
+					AODFiles: []jalien.AODFile{
+						{Name: "AO2D.root", Path: "/alice/sim/2024/LHC24f3/0/321321/AOD/002", RunNumber: 321321, LHCPeriod: "LHC24f3", AODNumber: 2},
+					},
+				},
+			}
+			onnxFiles := []models.TrainingTaskResult{
+				{Name: "local_file.onnx", Type: models.Onnx, FileId: 1, File: models.File{
+					Name: "local_file_temp.onnx", Path: "./local_file_temp.onnx", Size: uint64(len(content)), SHA256: tc.checksum,
+				}, TrainingTaskId: ttId},
+			}
+			ut.TTRepo.On("GetByID", ttId).Return(&tt, nil)
+			ut.TTRepo.On("Update", &tt).Return(nil)
+			ut.TTRRepo.On("GetByType", ttId, models.Onnx).Return(onnxFiles, nil)
+			closeFile := func(r io.ReadCloser) { r.Close() }
+			ut.FileService.On("OpenFile", "./local_file_temp.onnx").Return(io.NopCloser(strings.NewReader(content)), closeFile, nil).Once()
+			ut.JAliEnService.On("ListAndParseDirectory", "/alice/sim/2024/LHC24f3/0").Return(&jalien.DirectoryContents{
+				Subdirs: []jalien.Dir{
+					{Name: "321321", Path: "/alice/sim/2024/LHC24f3/0/321321"},
+				},
+			}, nil)
+			ut.CCDBService.On("GetRunInformation", uint64(321321)).Return(&ccdb.RunInformation{
+				RunNumber: 321321,
+				SOR:       1000,
+				EOR:       2000,
+			}, nil)
+			// CCDB fails the upload when the file cannot be read completely
+			upload := ut.CCDBService.On("UploadFile", uint64(1000), uint64(2000), "uploaded_file.onnx", mock.Anything)
+			upload.Run(func(args mock.Arguments) {
+				_, err := io.ReadAll(args.Get(3).(io.Reader))
+				upload.Return(err)
+			})
+
+			// Act
+			err := ttService.UploadOnnxResults(ttId, 1)
+
+			// Assert
+			if tc.uploaded {
+				assert.NoError(t, err)
+				assert.Equal(t, models.Uploaded, tt.Status)
+			} else {
+				assert.IsType(t, &service.ErrHandlerConflict{}, err)
+				assert.Equal(t, models.Completed, tt.Status)
+			}
+			// the file is read only once, while it is streamed to CCDB
+			ut.FileService.AssertNumberOfCalls(t, "OpenFile", 1)
+		})
+	}
+}
+
+func TestTrainingTaskService_Create_Dependencies(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	parentId := uint(4)
+	parent := &models.TrainingTask{Model: gorm.Model{ID: parentId}, Name: "preprocessing", Status: models.Training}
+	tt := models.TrainingTask{
+		Name: "finetuning",
+		Dependencies: []models.TrainingTaskDependency{
+			{Model: gorm.Model{ID: 7}, TrainingTaskId: 9, ParentId: parentId},
+		},
+	}
+	ut.TTRepo.On("GetByID", parentId).Return(parent, nil)
+	ut.TTRepo.On("Create", &tt).Return(nil)
+
+	// Act
+	err := ttService.Create(&tt)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []models.TrainingTaskDependency{{ParentId: parentId}}, tt.Dependencies)
+	ut.TTRepo.AssertCalled(t, "Create", &tt)
+}
+
+func TestTrainingTaskService_Create_InvalidDependencies(t *testing.T) {
+	parentId := uint(4)
+	tests := []struct {
+		name   string
+		parent *models.TrainingTask
+		err    error
+		deps   []models.TrainingTaskDependency
+	}{
+		{
+			name: "not found",
+			err:  gorm.ErrRecordNotFound,
+			deps: []models.TrainingTaskDependency{{ParentId: parentId}},
+		},
+		{
+			name:   "failed parent",
+			parent: &models.TrainingTask{Model: gorm.Model{ID: parentId}, Status: models.Failed},
+			deps:   []models.TrainingTaskDependency{{ParentId: parentId}},
+		},
+		{
+			name:   "cancelled parent",
+			parent: &models.TrainingTask{Model: gorm.Model{ID: parentId}, Status: models.Cancelled},
+			deps:   []models.TrainingTaskDependency{{ParentId: parentId}},
+		},
+		{
+			name:   "duplicated parent",
+			parent: &models.TrainingTask{Model: gorm.Model{ID: parentId}, Status: models.Queued},
+			deps:   []models.TrainingTaskDependency{{ParentId: parentId}, {ParentId: parentId}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			ttService, ut := newTrainingTaskService()
+			ut.TTRepo.On("GetByID", parentId).Return(tc.parent, tc.err)
+			tt := models.TrainingTask{Name: "task", Dependencies: tc.deps}
+
+			// Act
+			err := ttService.Create(&tt)
+
+			// Assert
+			assert.IsType(t, &service.ErrHandlerValidation{}, err)
+			ut.TTRepo.AssertNotCalled(t, "Create", mock.Anything)
+		})
+	}
+}
+
+func TestTrainingTaskService_GetByID_Dependencies(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	ttId := uint(2)
+	tt := &models.TrainingTask{Model: gorm.Model{ID: ttId}, Name: "training", Status: models.Queued}
+	parents := []models.TrainingTask{
+		{Model: gorm.Model{ID: 1}, Name: "preprocessing", Status: models.Completed},
+		{Model: gorm.Model{ID: 3}, Name: "calibration", Status: models.Training},
+	}
+	children := []models.TrainingTask{
+		{Model: gorm.Model{ID: 4}, Name: "finetuning", Status: models.Queued},
+	}
+	ut.TTDRepo.ExpectedCalls = nil
+	ut.TTDRepo.On("GetParents", ttId).Return(parents, nil)
+	ut.TTDRepo.On("GetChildren", ttId).Return(children, nil)
+	ut.TTRepo.On("GetByID", ttId).Return(tt, nil)
+	ut.TSERepo.On("GetAll", ttId).Return([]models.TrainingTaskStatusEvent{}, nil)
+	ut.TMRepo.On("GetAll").Return([]models.TrainingMachine{}, nil)
+
+	// Act
+	ttWithRes, err := ttService.GetByID(ttId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, parents, ttWithRes.Dependencies.Parents)
+	assert.Equal(t, children, ttWithRes.Dependencies.Children)
+	assert.True(t, ttWithRes.Dependencies.IsWaiting())
+
+	parents[1].Status = models.Uploaded
+	assert.False(t, ttWithRes.Dependencies.IsWaiting())
+}
+
+func TestTrainingTaskService_Cancel_FailsDependentTasks(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	tt := &models.TrainingTask{Model: gorm.Model{ID: 1}, Name: "preprocessing", UserId: 1, Status: models.Queued}
+	child := models.TrainingTask{Model: gorm.Model{ID: 2}, Name: "training", Status: models.Queued}
+	grandchild := models.TrainingTask{Model: gorm.Model{ID: 3}, Name: "finetuning", Status: models.Queued}
+	ut.TTDRepo.ExpectedCalls = nil
+	ut.TTDRepo.On("GetChildren", uint(1)).Return([]models.TrainingTask{child}, nil)
+	ut.TTDRepo.On("GetChildren", uint(2)).Return([]models.TrainingTask{grandchild}, nil)
+	ut.TTDRepo.On("GetChildren", uint(3)).Return([]models.TrainingTask{}, nil)
+	ut.TTRepo.On("GetByID", uint(1)).Return(tt, nil)
+	ut.TTRepo.On("UpdateStatus", mock.AnythingOfType("uint"), models.Queued, mock.Anything).Return(true, nil)
+
+	// Act
+	err := ttService.Cancel(1, 1)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cancelled, tt.Status)
+	for _, id := range []uint{2, 3} {
+		ut.TTRepo.AssertCalled(t, "UpdateStatus", id, models.Queued, mock.MatchedBy(func(columns map[string]interface{}) bool {
+			return columns["status"] == models.Failed &&
+				columns["failure_code"] == models.FailureCodeDependencyFailed && columns["finished_at"] != nil
+		}))
+	}
+	ut.TSERepo.AssertCalled(t, "Create", mock.MatchedBy(func(event *models.TrainingTaskStatusEvent) bool {
+		return event.TrainingTaskId == 3 && *event.FromStatus == models.Queued && event.ToStatus == models.Failed
+	}))
+}
+
+func TestTrainingTaskService_Cancel_DependentTaskChangedConcurrently(t *testing.T) {
+	// Arrange
+	ttService, ut := newTrainingTaskService()
+	tt := &models.TrainingTask{Model: gorm.Model{ID: 1}, Name: "preprocessing", UserId: 1, Status: models.Queued}
+	child := models.TrainingTask{Model: gorm.Model{ID: 2}, Name: "training", Status: models.Queued}
+	ut.TTDRepo.ExpectedCalls = nil
+	ut.TTDRepo.On("GetChildren", uint(1)).Return([]models.TrainingTask{child}, nil)
+	ut.TTRepo.On("GetByID", uint(1)).Return(tt, nil)
+	ut.TTRepo.On("UpdateStatus", uint(1), models.Queued, mock.Anything).Return(true, nil)
+	// the child was cancelled by its owner after it had been listed
+	ut.TTRepo.On("UpdateStatus", child.ID, models.Queued, mock.Anything).Return(false, nil)
+
+	// Act
+	err := ttService.Cancel(1, 1)
+
+	// Assert
+	assert.NoError(t, err)
+	ut.TTDRepo.AssertNotCalled(t, "GetChildren", child.ID)
+	ut.TSERepo.AssertNotCalled(t, "Create", mock.MatchedBy(func(event *models.TrainingTaskStatusEvent) bool {
+		return event.TrainingTaskId == child.ID
+	}))
+}